@@ -7,18 +7,24 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"services/cmd/services/cart"
+	"services/cmd/services/coupons"
 	"services/cmd/services/courses"
+	"services/cmd/services/gifts"
 	"services/cmd/services/home"
 	"services/cmd/services/leads"
+	"services/cmd/services/orders"
 	paymentplans "services/cmd/services/payment_plans"
 	"services/cmd/services/payments"
 	"services/cmd/services/reviews"
+	taxrates "services/cmd/services/tax_rates"
 	user "services/cmd/services/users"
 	"services/internal/api"
+	"services/internal/auth"
 	"services/internal/database"
 	"services/internal/middleware"
 	"services/internal/repository"
@@ -66,7 +72,11 @@ func main() {
 	paymentPlanHandler := paymentplans.NewPaymentPlanHandler(logger, db.DB_client)
 	reviewHandler := reviews.NewReviewHandler(logger, db.DB_client)
 	paymentHandler := payments.NewPaymentHandler(logger, db.DB_client)
+	orderHandler := orders.NewOrderHandler(logger, db.DB_client)
 	cartHandler := cart.NewCartHandler(logger, db.DB_client)
+	couponHandler := coupons.NewCouponHandler(logger, db.DB_client)
+	giftHandler := gifts.NewGiftHandler(logger, db.DB_client)
+	taxRateHandler := taxrates.NewTaxRateHandler(logger, db.DB_client)
 	leadHandler := leads.NewLeadHandler(logger, db.DB_client)
 	homeHandler := home.NewHomeHandler(logger, db.DB_client)
 
@@ -91,6 +101,9 @@ func main() {
 	router.HandleFunc("/api/login/email", userHandler.LoginWithEmail).Methods("POST")
 	router.HandleFunc("/api/refresh", userHandler.RefreshToken).Methods("POST")
 	router.HandleFunc("/api/logout", userHandler.Logout).Methods("POST")
+	router.HandleFunc("/api/password/forgot", userHandler.ForgotPassword).Methods("POST")
+	router.HandleFunc("/api/password/reset", userHandler.ResetPassword).Methods("POST")
+	router.HandleFunc("/api/email/verify", userHandler.VerifyEmail).Methods("POST")
 
 	// Public course and review routes
 	router.HandleFunc("/api/courses", courseHandler.ListCourses).Methods("GET")
@@ -99,58 +112,122 @@ func main() {
 	router.HandleFunc("/api/leads", leadHandler.CreateLead).Methods("POST")
 	router.HandleFunc("/api/home-content", homeHandler.GetHomeContent).Methods("GET")
 
+	// Provider webhooks (public, verified by signature)
+	router.HandleFunc("/api/webhooks/paypal", paymentHandler.PayPalWebhook).Methods("POST")
+	router.HandleFunc("/api/webhooks/stripe", paymentHandler.StripeWebhook).Methods("POST")
+
 	// General public routes
 	router.HandleFunc("/api/accepted", func(w http.ResponseWriter, r *http.Request) {
 		api.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "api accepted"})
 	}).Methods("POST")
 
+	// Cart routes (signed in users, or guests identified by X-Cart-Token)
+	carts := router.PathPrefix("/api/cart").Subrouter()
+	carts.Use(authMiddleware.OptionalAuthenticate)
+	carts.HandleFunc("", cartHandler.GetCart).Methods("GET")
+	carts.HandleFunc("/items", cartHandler.AddToCart).Methods("POST")
+	carts.HandleFunc("/items/{id}", cartHandler.RemoveFromCart).Methods("DELETE")
+	carts.HandleFunc("/items/{id}/plan", cartHandler.SetItemPlan).Methods("PUT")
+	carts.HandleFunc("", cartHandler.ClearCart).Methods("DELETE")
+	carts.HandleFunc("/coupons", cartHandler.ApplyCoupon).Methods("POST")
+	carts.HandleFunc("/coupons/{code}", cartHandler.RemoveCoupon).Methods("DELETE")
+
 	// Protected routes (require authentication)
 	protected := router.PathPrefix("/api").Subrouter()
 	protected.Use(authMiddleware.Authenticate)
 
+	// scoped restricts a protected route to tokens carrying the given scope
+	scoped := func(scope string, h http.HandlerFunc) http.Handler {
+		return authMiddleware.RequireScope(scope)(h)
+	}
+
+	// verifiedIf restricts a protected route to users with a verified email
+	// when the given environment variable is true
+	verifiedIf := func(env string, h http.HandlerFunc) http.Handler {
+		if required, _ := strconv.ParseBool(os.Getenv(env)); required {
+			return authMiddleware.RequireVerifiedEmail(h)
+		}
+		return h
+	}
+
 	// User routes (protected)
 	protected.HandleFunc("/user/me", userHandler.GetUser).Methods("GET")
 	protected.HandleFunc("/user/me", userHandler.UpdateUser).Methods("PUT")
 	protected.HandleFunc("/user/me/courses", userHandler.GetUserCourses).Methods("GET")
-
-	// Course routes (protected)
-	protected.HandleFunc("/courses", courseHandler.CreateCourse).Methods("POST")
-	protected.HandleFunc("/courses/{id}", courseHandler.UpdateCourse).Methods("PUT")
-	protected.HandleFunc("/courses/{id}", courseHandler.DeleteCourse).Methods("DELETE")
-
-	// Payment Plan routes (protected)
-	protected.HandleFunc("/payment-plans", paymentPlanHandler.CreatePaymentPlan).Methods("POST")
+	protected.HandleFunc("/user/me/sessions", userHandler.ListSessions).Methods("GET")
+	protected.HandleFunc("/user/me/sessions", userHandler.LogoutEverywhere).Methods("DELETE")
+	protected.HandleFunc("/user/me/sessions/{id}", userHandler.RevokeSession).Methods("DELETE")
+	protected.HandleFunc("/email/verify/resend", userHandler.ResendVerification).Methods("POST")
+	protected.Handle("/admin/users/{id}/unlock", scoped(auth.ScopeUsersUnlock, userHandler.UnlockAccount)).Methods("POST")
+
+	// Course routes (protected, instructors and above)
+	protected.Handle("/courses", scoped(auth.ScopeCoursesWrite, courseHandler.CreateCourse)).Methods("POST")
+	protected.Handle("/courses/{id}", scoped(auth.ScopeCoursesWrite, courseHandler.UpdateCourse)).Methods("PUT")
+	protected.Handle("/courses/{id}", scoped(auth.ScopeCoursesWrite, courseHandler.DeleteCourse)).Methods("DELETE")
+
+	// Payment Plan routes (protected, writes restricted to staff)
+	protected.Handle("/payment-plans", scoped(auth.ScopePaymentPlansWrite, paymentPlanHandler.CreatePaymentPlan)).Methods("POST")
 	protected.HandleFunc("/payment-plans", paymentPlanHandler.ListPaymentPlans).Methods("GET")
 	protected.HandleFunc("/payment-plans/{id}", paymentPlanHandler.GetPaymentPlan).Methods("GET")
-	protected.HandleFunc("/payment-plans/{id}", paymentPlanHandler.UpdatePaymentPlan).Methods("PUT")
-	protected.HandleFunc("/payment-plans/{id}", paymentPlanHandler.DeletePaymentPlan).Methods("DELETE")
+	protected.Handle("/payment-plans/{id}", scoped(auth.ScopePaymentPlansWrite, paymentPlanHandler.UpdatePaymentPlan)).Methods("PUT")
+	protected.Handle("/payment-plans/{id}", scoped(auth.ScopePaymentPlansWrite, paymentPlanHandler.DeletePaymentPlan)).Methods("DELETE")
 
-	// Review routes (protected)
-	protected.HandleFunc("/reviews", reviewHandler.CreateReview).Methods("POST")
+	// Review routes (protected, edits by the author or a moderator, checked in the handler)
+	protected.Handle("/reviews", verifiedIf("REQUIRE_VERIFIED_EMAIL_FOR_REVIEWS", reviewHandler.CreateReview)).Methods("POST")
 	protected.HandleFunc("/reviews/{id}", reviewHandler.GetReview).Methods("GET")
 	protected.HandleFunc("/reviews/{id}", reviewHandler.UpdateReview).Methods("PUT")
 	protected.HandleFunc("/reviews/{id}", reviewHandler.DeleteReview).Methods("DELETE")
 
 	// Checkout routes (protected)
-	protected.HandleFunc("/checkout", paymentHandler.Checkout).Methods("POST")
+	protected.Handle("/checkout", verifiedIf("REQUIRE_VERIFIED_EMAIL_FOR_CHECKOUT", paymentHandler.Checkout)).Methods("POST")
 	protected.HandleFunc("/checkout/capture", paymentHandler.CaptureCheckout).Methods("POST")
 	protected.HandleFunc("/orders/{id}/retry", paymentHandler.RetryOrder).Methods("POST")
-
-	// Payment routes (protected)
-	protected.HandleFunc("/payments", paymentHandler.CreatePayment).Methods("POST")
-	protected.HandleFunc("/payments", paymentHandler.ListPayments).Methods("GET")
-	protected.HandleFunc("/payments/{id}", paymentHandler.GetPayment).Methods("GET")
-	protected.HandleFunc("/payments/{id}", paymentHandler.UpdatePayment).Methods("PUT")
-	protected.HandleFunc("/payments/{id}", paymentHandler.DeletePayment).Methods("DELETE")
-
-	// Cart routes (protected)
-	protected.HandleFunc("/cart", cartHandler.GetCart).Methods("GET")
-	protected.HandleFunc("/cart/items", cartHandler.AddToCart).Methods("POST")
-	protected.HandleFunc("/cart/items/{id}", cartHandler.RemoveFromCart).Methods("DELETE")
-	protected.HandleFunc("/cart", cartHandler.ClearCart).Methods("DELETE")
-
-	// Leads routes (protected - to view inquiries)
-	protected.HandleFunc("/leads", leadHandler.ListLeads).Methods("GET")
+	protected.HandleFunc("/installments/{id}/pay", paymentHandler.PayInstallment).Methods("POST")
+	protected.HandleFunc("/installments/{id}/capture", paymentHandler.CaptureInstallment).Methods("POST")
+
+	// Order history routes (protected, own orders only)
+	protected.HandleFunc("/orders", orderHandler.ListMyOrders).Methods("GET")
+	protected.HandleFunc("/orders/{id}", orderHandler.GetMyOrder).Methods("GET")
+	protected.HandleFunc("/orders/{id}/receipt", orderHandler.GetMyReceipt).Methods("GET")
+	protected.Handle("/admin/orders", scoped(auth.ScopeOrdersRead, orderHandler.ListOrders)).Methods("GET")
+
+	// Refund routes (protected, admin only)
+	protected.Handle("/admin/orders/{id}/refunds", scoped(auth.ScopeRefundsWrite, paymentHandler.RefundOrder)).Methods("POST")
+
+	// Payment routes (protected, back-office only)
+	protected.Handle("/payments", scoped(auth.ScopePaymentsWrite, paymentHandler.CreatePayment)).Methods("POST")
+	protected.Handle("/payments", scoped(auth.ScopePaymentsRead, paymentHandler.ListPayments)).Methods("GET")
+	protected.Handle("/payments/attempts", scoped(auth.ScopePaymentsRead, paymentHandler.ListPaymentAttempts)).Methods("GET")
+	protected.Handle("/payments/{id}", scoped(auth.ScopePaymentsRead, paymentHandler.GetPayment)).Methods("GET")
+	protected.Handle("/payments/{id}", scoped(auth.ScopePaymentsWrite, paymentHandler.UpdatePayment)).Methods("PUT")
+	protected.Handle("/payments/{id}", scoped(auth.ScopePaymentsWrite, paymentHandler.DeletePayment)).Methods("DELETE")
+
+	// Coupon routes (protected, marketing staff)
+	protected.Handle("/admin/coupons", scoped(auth.ScopeCouponsWrite, couponHandler.ListCoupons)).Methods("GET")
+	protected.Handle("/admin/coupons", scoped(auth.ScopeCouponsWrite, couponHandler.CreateCoupon)).Methods("POST")
+	protected.Handle("/admin/coupons/{id}", scoped(auth.ScopeCouponsWrite, couponHandler.GetCoupon)).Methods("GET")
+	protected.Handle("/admin/coupons/{id}", scoped(auth.ScopeCouponsWrite, couponHandler.UpdateCoupon)).Methods("PUT")
+	protected.Handle("/admin/coupons/{id}", scoped(auth.ScopeCouponsWrite, couponHandler.DeleteCoupon)).Methods("DELETE")
+
+	// Gift and enrollment code routes (protected, bulk issue for staff)
+	protected.HandleFunc("/redeem", giftHandler.Redeem).Methods("POST")
+	protected.HandleFunc("/gifts", giftHandler.ListMyGifts).Methods("GET")
+	protected.Handle("/admin/enrollment-codes", scoped(auth.ScopeEnrollmentCodesWrite, giftHandler.IssueCodes)).Methods("POST")
+
+	// Tax rate routes (protected, edits restricted to admins)
+	protected.HandleFunc("/tax-rates", taxRateHandler.ListTaxRates).Methods("GET")
+	protected.Handle("/admin/tax-rates/{province}", scoped(auth.ScopeTaxRatesWrite, taxRateHandler.ReplaceProvinceRates)).Methods("PUT")
+
+	// Leads routes (protected - staff view inquiries)
+	protected.Handle("/leads", scoped(auth.ScopeLeadsRead, leadHandler.ListLeads)).Methods("GET")
+
+	// Move unpaid installments to overdue and suspend access on default
+	go paymentHandler.RunInstallmentBilling(ctx, time.Hour)
+	// Cancel checkouts that were never paid and remind owners of idle carts
+	go paymentHandler.RunOrderExpiry(ctx, 15*time.Minute)
+	go cartHandler.RunCartReminders(ctx, time.Hour)
+	// Drop guest carts nobody came back to
+	go cartHandler.RunGuestCartPurge(ctx, time.Hour)
 
 	globalHandler := middleware.CORSMiddleware(router)
 	runServer(globalHandler, logger)
@@ -0,0 +1,126 @@
+// Command reconcile compares local orders and payments with the payment
+// providers over a date range and reports where they disagree. It is a dry
+// run unless --apply is given, in which case paid orders are fulfilled and
+// orders no provider captured are marked FAILED.
+//
+//	go run ./cmd/reconcile --from 2026-01-01 --to 2026-02-01 [--apply]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"services/cmd/services/payments"
+	"services/internal/database"
+	"services/internal/telemetry"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	fromFlag := flag.String("from", "", "first day to check, YYYY-MM-DD or RFC 3339 (default 30 days ago)")
+	toFlag := flag.String("to", "", "day to stop before, YYYY-MM-DD or RFC 3339 (default now)")
+	apply := flag.Bool("apply", false, "fix statuses and fulfill paid orders instead of only reporting")
+	flag.Parse()
+
+	now := time.Now()
+	from, err := parseTime(*fromFlag, now.AddDate(0, 0, -30))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --from: %v\n", err)
+		os.Exit(2)
+	}
+	to, err := parseTime(*toFlag, now)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid --to: %v\n", err)
+		os.Exit(2)
+	}
+	if !from.Before(to) {
+		fmt.Fprintf(os.Stderr, "--from must be before --to\n")
+		os.Exit(2)
+	}
+
+	if err := godotenv.Load(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: .env file not found\n")
+	}
+
+	os.Exit(run(from, to, *apply))
+}
+
+// run reconciles and returns the exit code: 1 if reconciliation could not
+// run, 3 if drift is left unresolved. Deferred cleanup happens before exit.
+func run(from, to time.Time, apply bool) int {
+	ctx := context.Background()
+
+	logger, shutdown, err := telemetry.InitLogger(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize telemetry: %v\n", err)
+		return 1
+	}
+	defer shutdown()
+
+	db, err := database.ConnectDatabase(ctx, logger)
+	if err != nil {
+		logger.Error("Failed to connect to database", "error", err)
+		return 1
+	}
+	defer db.Close()
+
+	h := payments.NewPaymentHandler(logger, db.DB_client)
+	report, err := h.Reconcile(ctx, from, to, apply)
+	if err != nil {
+		logger.Error("Reconciliation failed", "error", err)
+		return 1
+	}
+	h.WaitForReceipts()
+
+	printReport(os.Stdout, report)
+	if report.Unresolved() > 0 {
+		return 3
+	}
+	return 0
+}
+
+// parseTime accepts a date, taken as midnight UTC, or an RFC 3339 timestamp
+func parseTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func printReport(out io.Writer, report *payments.ReconcileReport) {
+	mode := "dry run, nothing was changed"
+	if report.Applied {
+		mode = "applied"
+	}
+	fmt.Fprintf(out, "Reconciled %d orders created %s to %s (%s)\n\n",
+		report.Orders, report.From.Format(time.RFC3339), report.To.Format(time.RFC3339), mode)
+
+	if len(report.Drifts) == 0 {
+		fmt.Fprintln(out, "No drift found")
+		return
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORDER\tUSER\tLOCAL STATUS\tDRIFT\tPROVIDER\tPROVIDER ID\tDETAIL\tRESULT")
+	for _, d := range report.Drifts {
+		result := "not fixed"
+		switch {
+		case d.Fixed:
+			result = "fixed"
+		case d.FixError != "":
+			result = "fix failed: " + d.FixError
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", d.OrderID, d.UserID, d.LocalStatus, d.Kind, d.Provider, d.ProviderID, d.Detail, result)
+	}
+	_ = w.Flush()
+
+	fmt.Fprintf(out, "\n%d drift found, %d unresolved\n", len(report.Drifts), report.Unresolved())
+}
@@ -0,0 +1,95 @@
+package cart
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"services/internal/auth"
+	"services/internal/models"
+	"services/internal/repository"
+	"time"
+)
+
+// DefaultGuestCartTTL is how long an untouched guest cart is kept, overridden
+// by GUEST_CART_TTL
+const DefaultGuestCartTTL = 30 * 24 * time.Hour
+
+// findCart returns the signed in user's cart, or for anonymous requests the
+// guest cart named by the X-Cart-Token header. A missing or invalid token is
+// treated as no cart.
+func (h *CartHandler) findCart(r *http.Request) (*models.Cart, error) {
+	ctx := r.Context()
+	if userID, ok := ctx.Value(models.UserIDContextKey).(string); ok {
+		return h.cartRepo.GetCartByUserID(ctx, userID)
+	}
+
+	token := r.Header.Get(auth.CartTokenHeader)
+	if token == "" {
+		return nil, repository.ErrCartNotFound
+	}
+	cartID, err := auth.ValidateCartToken(token)
+	if err != nil {
+		return nil, repository.ErrCartNotFound
+	}
+	return h.cartRepo.GetGuestCart(ctx, cartID)
+}
+
+// findOrCreateCart is findCart, creating the cart when there is none. The
+// token of a new guest cart is set on the response.
+func (h *CartHandler) findOrCreateCart(w http.ResponseWriter, r *http.Request) (*models.Cart, error) {
+	cart, err := h.findCart(r)
+	if !errors.Is(err, repository.ErrCartNotFound) {
+		return cart, err
+	}
+
+	ctx := r.Context()
+	cart = &models.Cart{Items: []models.CartItem{}}
+	if userID, ok := ctx.Value(models.UserIDContextKey).(string); ok {
+		cart.UserID = &userID
+	}
+	if err := h.cartRepo.CreateCart(ctx, cart); err != nil {
+		return nil, err
+	}
+
+	if cart.UserID == nil {
+		token, err := auth.GenerateCartToken(cart.ID)
+		if err != nil {
+			return nil, err
+		}
+		w.Header().Set(auth.CartTokenHeader, token)
+	}
+	return cart, nil
+}
+
+// PurgeGuestCarts deletes guest carts nobody has touched for GUEST_CART_TTL
+func (h *CartHandler) PurgeGuestCarts(ctx context.Context, now time.Time) error {
+	idleSince := now.Add(-durationFromEnv("GUEST_CART_TTL", DefaultGuestCartTTL))
+	deleted, err := h.cartRepo.DeleteStaleGuestCarts(ctx, idleSince)
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		h.logger.InfoContext(ctx, "Purged stale guest carts", "count", deleted)
+	}
+	return nil
+}
+
+// RunGuestCartPurge runs PurgeGuestCarts now and then every interval until
+// ctx is cancelled. A run is skipped while another replica is purging.
+func (h *CartHandler) RunGuestCartPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := h.jobs.TryDo(ctx, "guest-cart-purge", func(ctx context.Context) error {
+			return h.PurgeGuestCarts(ctx, time.Now())
+		})
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Guest cart purge failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -7,8 +7,12 @@ import (
 	"log/slog"
 	"net/http"
 	"services/internal/api"
+	"services/internal/coupon"
+	"services/internal/installment"
 	"services/internal/models"
 	"services/internal/repository"
+	"services/internal/service"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
@@ -18,71 +22,81 @@ type CartHandler struct {
 	logger     *slog.Logger
 	cartRepo   repository.CartRepository
 	courseRepo repository.CourseRepository
+	couponRepo repository.CouponRepository
+	planRepo   repository.PaymentPlanRepository
+	mailer     ReminderMailer
+	jobs       repository.JobLocker
 }
 
 func NewCartHandler(logger *slog.Logger, db *gorm.DB) *CartHandler {
 	cartRepo := repository.NewPostgresCartRepository(db)
 	courseRepo := repository.NewPostgresCourseRepository(db)
+	couponRepo := repository.NewPostgresCouponRepository(db)
+	planRepo := repository.NewPostgresPaymentPlanRepository(db)
 	return &CartHandler{
 		logger:     logger,
 		cartRepo:   cartRepo,
 		courseRepo: courseRepo,
+		couponRepo: couponRepo,
+		planRepo:   planRepo,
+		mailer:     service.NewNotificationService(logger, repository.NewPostgresSettingsRepository(db)),
+		jobs:       repository.NewPostgresJobLocker(db),
 	}
 }
 
-// GetCart retrieves the user's cart
+// GetCart retrieves the user's cart, or the guest cart named by the
+// X-Cart-Token header
 func (h *CartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	// Get user ID from context (set by auth middleware)
-	userID, ok := ctx.Value(models.UserIDContextKey).(string)
-	if !ok {
-		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
+	_, signedIn := ctx.Value(models.UserIDContextKey).(string)
 
-	cart, err := h.cartRepo.GetCartByUserID(ctx, userID)
+	cart, err := h.findCart(r)
 	if err != nil {
-		if errors.Is(err, repository.ErrCartNotFound) {
+		if !errors.Is(err, repository.ErrCartNotFound) {
+			h.logger.ErrorContext(ctx, "Error getting cart", "error", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "Failed to get cart")
+			return
+		}
+		if signedIn {
 			// Create a new cart if one doesn't exist
-			newCart := &models.Cart{
-				UserID: userID,
-				Items:  []models.CartItem{},
-			}
-			if err := h.cartRepo.CreateCart(ctx, newCart); err != nil {
+			cart, err = h.findOrCreateCart(w, r)
+			if err != nil {
 				h.logger.ErrorContext(ctx, "Error creating cart", "error", err)
 				api.RespondWithError(w, http.StatusInternalServerError, "Failed to create cart")
 				return
 			}
-			cart = newCart
 		} else {
-			h.logger.ErrorContext(ctx, "Error getting cart", "error", err)
-			api.RespondWithError(w, http.StatusInternalServerError, "Failed to get cart")
-			return
+			// Guests only get a cart once they add something to it
+			cart = &models.Cart{Items: []models.CartItem{}}
 		}
 	}
 
-	// Calculate total
-	total, err := h.cartRepo.GetCartTotal(ctx, cart.ID)
-	if err != nil {
-		h.logger.ErrorContext(ctx, "Error calculating cart total", "error", err)
+	// Calculate total after coupons
+	quote := &coupon.Quote{}
+	if cart.ID != "" {
+		quote, err = h.cartRepo.GetCartQuote(ctx, cart.ID)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Error calculating cart total", "error", err)
+			quote = &coupon.Quote{}
+		}
 	}
 
 	response := map[string]interface{}{
-		"cart":  cart,
-		"total": total,
+		"cart":             cart,
+		"subtotal":         quote.Subtotal,
+		"discount":         quote.Discount,
+		"total":            quote.Total,
+		"coupons":          quote.Applied,
+		"rejected_coupons": quote.Rejected,
 	}
 
 	api.RespondWithJSON(w, http.StatusOK, response)
 }
 
-// AddToCart adds a course to the user's cart
+// AddToCart adds a course to the cart, creating a guest cart for anonymous
+// visitors who do not have one yet
 func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	userID, ok := ctx.Value(models.UserIDContextKey).(string)
-	if !ok {
-		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -92,7 +106,8 @@ func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 	defer func() { _ = r.Body.Close() }()
 
 	var req struct {
-		CourseID string `json:"course_id"`
+		CourseID      string `json:"course_id"`
+		PaymentPlanID *uint  `json:"payment_plan_id"` // Optional, pays for the course in installments
 	}
 
 	if err := json.Unmarshal(body, &req); err != nil {
@@ -101,21 +116,11 @@ func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get or create cart
-	cart, err := h.cartRepo.GetCartByUserID(ctx, userID)
+	cart, err := h.findOrCreateCart(w, r)
 	if err != nil {
-		if errors.Is(err, repository.ErrCartNotFound) {
-			newCart := &models.Cart{UserID: userID}
-			if err := h.cartRepo.CreateCart(ctx, newCart); err != nil {
-				h.logger.ErrorContext(ctx, "Error creating cart", "error", err)
-				api.RespondWithError(w, http.StatusInternalServerError, "Failed to create cart")
-				return
-			}
-			cart = newCart
-		} else {
-			h.logger.ErrorContext(ctx, "Error getting cart", "error", err)
-			api.RespondWithError(w, http.StatusInternalServerError, "Failed to get cart")
-			return
-		}
+		h.logger.ErrorContext(ctx, "Error getting cart", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get cart")
+		return
 	}
 
 	// Get course details to get the price
@@ -130,10 +135,14 @@ func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Calculate price (with discount if applicable)
-	price := course.Price
-	if course.Discount > 0 {
-		price = course.Price * (1 - course.Discount/100)
+	// Calculate price (with discount if applicable, or the plan's price)
+	price := course.Price.PercentOff(course.Discount)
+	if req.PaymentPlanID != nil {
+		plan, ok := h.planFor(w, r, course.ID, *req.PaymentPlanID)
+		if !ok {
+			return
+		}
+		price = installment.Price(plan)
 	}
 
 	// Check if course already in cart
@@ -145,9 +154,10 @@ func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 
 	// Add new item to cart
 	cartItem := &models.CartItem{
-		CartID:   cart.ID,
-		CourseID: req.CourseID,
-		Price:    price,
+		CartID:        cart.ID,
+		CourseID:      req.CourseID,
+		Price:         price,
+		PaymentPlanID: req.PaymentPlanID,
 	}
 
 	if err := h.cartRepo.AddItemToCart(ctx, cartItem); err != nil {
@@ -163,11 +173,6 @@ func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 // RemoveFromCart removes an item from the cart
 func (h *CartHandler) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	userID, ok := ctx.Value(models.UserIDContextKey).(string)
-	if !ok {
-		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
-		return
-	}
 
 	vars := mux.Vars(r)
 	itemID := vars["id"]
@@ -184,10 +189,10 @@ func (h *CartHandler) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify the cart belongs to the user
-	cart, err := h.cartRepo.GetCartByUserID(ctx, userID)
+	// Verify the item is in the caller's cart
+	cart, err := h.findCart(r)
 	if err != nil || cart.ID != cartItem.CartID {
-		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		api.RespondWithError(w, http.StatusNotFound, "Cart item not found")
 		return
 	}
 
@@ -201,16 +206,87 @@ func (h *CartHandler) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// ClearCart removes all items from the user's cart
-func (h *CartHandler) ClearCart(w http.ResponseWriter, r *http.Request) {
+// SetItemPlan switches a cart item to a payment plan for its course, or back
+// to paying in full when payment_plan_id is null
+func (h *CartHandler) SetItemPlan(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	userID, ok := ctx.Value(models.UserIDContextKey).(string)
-	if !ok {
-		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+
+	var req struct {
+		PaymentPlanID *uint `json:"payment_plan_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
 
-	cart, err := h.cartRepo.GetCartByUserID(ctx, userID)
+	itemID := mux.Vars(r)["id"]
+	cartItem, err := h.cartRepo.GetCartItem(ctx, itemID)
+	if err != nil {
+		if errors.Is(err, repository.ErrCartItemNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Cart item not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Error getting cart item", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get cart item")
+		return
+	}
+
+	cart, err := h.findCart(r)
+	if err != nil || cart.ID != cartItem.CartID {
+		api.RespondWithError(w, http.StatusNotFound, "Cart item not found")
+		return
+	}
+
+	price := cartItem.Course.Price.PercentOff(cartItem.Course.Discount)
+	if req.PaymentPlanID != nil {
+		plan, ok := h.planFor(w, r, cartItem.CourseID, *req.PaymentPlanID)
+		if !ok {
+			return
+		}
+		price = installment.Price(plan)
+	}
+
+	if err := h.cartRepo.SetItemPlan(ctx, itemID, req.PaymentPlanID, price); err != nil {
+		h.logger.ErrorContext(ctx, "Error setting cart item plan", "cart_item_id", itemID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to update cart item")
+		return
+	}
+	cartItem.PaymentPlanID = req.PaymentPlanID
+	cartItem.Price = price
+
+	api.RespondWithJSON(w, http.StatusOK, cartItem)
+}
+
+// planFor loads a payment plan and checks it can be used to buy the course.
+// It writes the error response and returns false when it cannot.
+func (h *CartHandler) planFor(w http.ResponseWriter, r *http.Request, courseID string, planID uint) (*models.PaymentPlan, bool) {
+	ctx := r.Context()
+	plan, err := h.planRepo.FindByID(ctx, strconv.FormatUint(uint64(planID), 10))
+	if err != nil {
+		if errors.Is(err, repository.ErrPaymentPlanNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Payment plan not found")
+			return nil, false
+		}
+		h.logger.ErrorContext(ctx, "Error getting payment plan", "payment_plan_id", planID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get payment plan")
+		return nil, false
+	}
+	if plan.CourseID != courseID {
+		api.RespondWithError(w, http.StatusBadRequest, "Payment plan is not for this course")
+		return nil, false
+	}
+	if err := installment.Validate(plan); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Payment plan cannot be used: "+err.Error())
+		return nil, false
+	}
+	return plan, true
+}
+
+// ClearCart removes all items from the cart
+func (h *CartHandler) ClearCart(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	cart, err := h.findCart(r)
 	if err != nil {
 		if errors.Is(err, repository.ErrCartNotFound) {
 			api.RespondWithError(w, http.StatusNotFound, "Cart not found")
@@ -229,3 +305,105 @@ func (h *CartHandler) ClearCart(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// ApplyCoupon applies a promo code to the cart. The code is rejected
+// if it does not currently discount the cart.
+func (h *CartHandler) ApplyCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || coupon.NormalizeCode(req.Code) == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "Coupon code is required")
+		return
+	}
+
+	c, err := h.couponRepo.FindByCode(ctx, req.Code)
+	if err != nil {
+		if errors.Is(err, repository.ErrCouponNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Coupon not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Error getting coupon", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get coupon")
+		return
+	}
+
+	cart, err := h.findCart(r)
+	if err != nil {
+		if errors.Is(err, repository.ErrCartNotFound) {
+			api.RespondWithError(w, http.StatusBadRequest, "Cart is empty")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Error getting cart", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get cart")
+		return
+	}
+	for _, applied := range cart.Coupons {
+		if applied.CouponID == c.ID {
+			api.RespondWithError(w, http.StatusConflict, "Coupon already applied")
+			return
+		}
+	}
+
+	if err := h.cartRepo.AddCouponToCart(ctx, &models.CartCoupon{CartID: cart.ID, CouponID: c.ID}); err != nil {
+		h.logger.ErrorContext(ctx, "Error adding coupon to cart", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to apply coupon")
+		return
+	}
+
+	// Price the cart with the coupon on it and take it back off if it does
+	// not apply, so the cart only ever holds coupons that discount it
+	quote, err := h.cartRepo.GetCartQuote(ctx, cart.ID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Error calculating cart total", "error", err)
+		_ = h.cartRepo.RemoveCouponFromCart(ctx, cart.ID, c.ID)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to apply coupon")
+		return
+	}
+	for _, rejected := range quote.Rejected {
+		if rejected.CouponID == c.ID {
+			if err := h.cartRepo.RemoveCouponFromCart(ctx, cart.ID, c.ID); err != nil {
+				h.logger.ErrorContext(ctx, "Error removing rejected coupon", "coupon_id", c.ID, "error", err)
+			}
+			api.RespondWithError(w, http.StatusBadRequest, "Coupon cannot be applied: "+rejected.Reason)
+			return
+		}
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, quote)
+}
+
+// RemoveCoupon takes a promo code off the cart
+func (h *CartHandler) RemoveCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	code := coupon.NormalizeCode(mux.Vars(r)["code"])
+
+	cart, err := h.findCart(r)
+	if err != nil {
+		if errors.Is(err, repository.ErrCartNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Coupon not applied to cart")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Error getting cart", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get cart")
+		return
+	}
+
+	for _, applied := range cart.Coupons {
+		if applied.Coupon.Code != code {
+			continue
+		}
+		if err := h.cartRepo.RemoveCouponFromCart(ctx, cart.ID, applied.CouponID); err != nil && !errors.Is(err, repository.ErrCartCouponNotFound) {
+			h.logger.ErrorContext(ctx, "Error removing coupon from cart", "error", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "Failed to remove coupon")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	api.RespondWithError(w, http.StatusNotFound, "Coupon not applied to cart")
+}
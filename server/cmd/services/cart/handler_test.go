@@ -4,13 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"services/internal/auth"
+	"services/internal/coupon"
 	"services/internal/models"
+	"services/internal/money"
 	"services/internal/repository"
 	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
 )
 
 // ===================== Mock Repositories =====================
@@ -20,6 +27,9 @@ type mockCartRepo struct {
 	cartItems []*models.CartItem
 	createErr error
 	addErr    error
+	coupons   *mockCouponRepo // Looked up when a coupon is added to the cart
+	idleCarts []*models.Cart  // Searched by ClaimAbandoned
+	guestCart *models.Cart    // Returned by GetGuestCart, set by CreateCart for guests
 }
 
 func (m *mockCartRepo) GetCartByUserID(ctx context.Context, userID string) (*models.Cart, error) {
@@ -28,9 +38,24 @@ func (m *mockCartRepo) GetCartByUserID(ctx context.Context, userID string) (*mod
 	}
 	return m.cart, nil
 }
-func (m *mockCartRepo) CreateCart(ctx context.Context, cart *models.Cart) error { return m.createErr }
-func (m *mockCartRepo) ClearCart(ctx context.Context, cartID string) error      { return nil }
-func (m *mockCartRepo) DeleteCart(ctx context.Context, cartID string) error     { return nil }
+func (m *mockCartRepo) GetGuestCart(ctx context.Context, cartID string) (*models.Cart, error) {
+	if m.guestCart == nil || m.guestCart.ID != cartID {
+		return nil, repository.ErrCartNotFound
+	}
+	return m.guestCart, nil
+}
+func (m *mockCartRepo) CreateCart(ctx context.Context, cart *models.Cart) error {
+	if m.createErr != nil {
+		return m.createErr
+	}
+	if cart.UserID == nil {
+		cart.ID = "guest-cart-1"
+		m.guestCart = cart
+	}
+	return nil
+}
+func (m *mockCartRepo) ClearCart(ctx context.Context, cartID string) error  { return nil }
+func (m *mockCartRepo) DeleteCart(ctx context.Context, cartID string) error { return nil }
 
 func (m *mockCartRepo) AddItemToCart(ctx context.Context, cartItem *models.CartItem) error {
 	if m.addErr != nil {
@@ -45,6 +70,9 @@ func (m *mockCartRepo) GetCartItem(ctx context.Context, cartItemID string) (*mod
 func (m *mockCartRepo) UpdateCartItem(ctx context.Context, cartItem *models.CartItem) error {
 	return nil
 }
+func (m *mockCartRepo) SetItemPlan(ctx context.Context, cartItemID string, planID *uint, price money.Amount) error {
+	return nil
+}
 func (m *mockCartRepo) RemoveItemFromCart(ctx context.Context, cartItemID string) error { return nil }
 func (m *mockCartRepo) GetCartItemByCourseID(ctx context.Context, cartID, courseID string) (*models.CartItem, error) {
 	for _, item := range m.cartItems {
@@ -54,7 +82,99 @@ func (m *mockCartRepo) GetCartItemByCourseID(ctx context.Context, cartID, course
 	}
 	return nil, repository.ErrCartItemNotFound
 }
-func (m *mockCartRepo) GetCartTotal(ctx context.Context, cartID string) (float64, error) { return 0, nil }
+func (m *mockCartRepo) AddCouponToCart(ctx context.Context, cartCoupon *models.CartCoupon) error {
+	for _, c := range m.coupons.coupons {
+		if c.ID == cartCoupon.CouponID {
+			cartCoupon.Coupon = *c
+		}
+	}
+	m.cart.Coupons = append(m.cart.Coupons, *cartCoupon)
+	return nil
+}
+func (m *mockCartRepo) RemoveCouponFromCart(ctx context.Context, cartID, couponID string) error {
+	for i, cc := range m.cart.Coupons {
+		if cc.CouponID == couponID {
+			m.cart.Coupons = append(m.cart.Coupons[:i], m.cart.Coupons[i+1:]...)
+			return nil
+		}
+	}
+	return repository.ErrCartCouponNotFound
+}
+func (m *mockCartRepo) GetCartTotal(ctx context.Context, cartID string) (money.Amount, error) { return 0, nil }
+func (m *mockCartRepo) GetCartQuote(ctx context.Context, cartID string) (*coupon.Quote, error) {
+	cart := m.cart
+	if m.guestCart != nil && m.guestCart.ID == cartID {
+		cart = m.guestCart
+	}
+	var candidates []coupon.Candidate
+	for i := range cart.Coupons {
+		candidates = append(candidates, coupon.Candidate{Coupon: &cart.Coupons[i].Coupon})
+	}
+	quote := coupon.Price(cart.Items, candidates, time.Now())
+	return &quote, nil
+}
+
+// ClaimAbandoned applies the same rules as the Postgres query to idleCarts
+func (m *mockCartRepo) ClaimAbandoned(ctx context.Context, idleSince, remindedBefore, now time.Time) ([]*models.Cart, error) {
+	var result []*models.Cart
+	for _, c := range m.idleCarts {
+		var lastActivity time.Time
+		for _, item := range c.Items {
+			if item.UpdatedAt.After(lastActivity) {
+				lastActivity = item.UpdatedAt
+			}
+		}
+		if len(c.Items) == 0 || !lastActivity.Before(idleSince) {
+			continue
+		}
+		if c.ReminderSentAt == nil || (c.ReminderSentAt.Before(lastActivity) && c.ReminderSentAt.Before(remindedBefore)) {
+			c.ReminderSentAt = &now
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockCartRepo) MergeGuestCart(ctx context.Context, guestCartID, userID string) (int64, error) {
+	return 0, nil
+}
+func (m *mockCartRepo) DeleteStaleGuestCarts(ctx context.Context, idleSince time.Time) (int64, error) {
+	if m.guestCart == nil || !m.guestCart.UpdatedAt.Before(idleSince) {
+		return 0, nil
+	}
+	m.guestCart = nil
+	return 1, nil
+}
+
+type mockCouponRepo struct {
+	coupons []*models.Coupon
+}
+
+func (m *mockCouponRepo) Create(ctx context.Context, c *models.Coupon) error { return nil }
+func (m *mockCouponRepo) FindByID(ctx context.Context, id string) (*models.Coupon, error) {
+	return nil, repository.ErrCouponNotFound
+}
+func (m *mockCouponRepo) FindByIDForUpdate(ctx context.Context, id string) (*models.Coupon, error) {
+	return nil, repository.ErrCouponNotFound
+}
+func (m *mockCouponRepo) FindByCode(ctx context.Context, code string) (*models.Coupon, error) {
+	for _, c := range m.coupons {
+		if c.Code == coupon.NormalizeCode(code) {
+			return c, nil
+		}
+	}
+	return nil, repository.ErrCouponNotFound
+}
+func (m *mockCouponRepo) FindAll(ctx context.Context) ([]*models.Coupon, error) { return nil, nil }
+func (m *mockCouponRepo) Update(ctx context.Context, c *models.Coupon) error    { return nil }
+func (m *mockCouponRepo) Delete(ctx context.Context, id string) error           { return nil }
+func (m *mockCouponRepo) CountRedemptions(ctx context.Context, couponID, userID string) (coupon.Usage, error) {
+	return coupon.Usage{}, nil
+}
+func (m *mockCouponRepo) CreateRedemption(ctx context.Context, redemption *models.CouponRedemption) error {
+	return nil
+}
+func (m *mockCouponRepo) ReleaseRedemptions(ctx context.Context, orderID string) error { return nil }
 
 // mockCourseRepo
 type mockCourseRepo struct {
@@ -77,17 +197,65 @@ func (m *mockCourseRepo) FindByInstructorID(ctx context.Context, instructorID st
 func (m *mockCourseRepo) Update(ctx context.Context, course *models.Course) error { return nil }
 func (m *mockCourseRepo) Delete(ctx context.Context, id string) error               { return nil }
 
+// mockPlanRepo
+type mockPlanRepo struct {
+	plans []*models.PaymentPlan
+}
+
+func (m *mockPlanRepo) Create(ctx context.Context, plan *models.PaymentPlan) error { return nil }
+func (m *mockPlanRepo) FindByID(ctx context.Context, id string) (*models.PaymentPlan, error) {
+	for _, p := range m.plans {
+		if fmt.Sprint(p.ID) == id {
+			return p, nil
+		}
+	}
+	return nil, repository.ErrPaymentPlanNotFound
+}
+func (m *mockPlanRepo) FindAll(ctx context.Context) ([]*models.PaymentPlan, error) { return nil, nil }
+func (m *mockPlanRepo) Update(ctx context.Context, plan *models.PaymentPlan) error { return nil }
+func (m *mockPlanRepo) Delete(ctx context.Context, id string) error                { return nil }
+func (m *mockPlanRepo) FindSessionLimit(ctx context.Context, userID string) (int, error) {
+	return 0, nil
+}
+
+type sentReminder struct {
+	to, link string
+	courses  []string
+}
+
+type mockMailer struct {
+	sent []sentReminder
+}
+
+func (m *mockMailer) SendCartReminder(ctx context.Context, to, name, link string, courses []string) error {
+	m.sent = append(m.sent, sentReminder{to: to, link: link, courses: courses})
+	return nil
+}
+
 // ===================== Helper =====================
 
 func contextWithUserID(userID string) context.Context {
 	return context.WithValue(context.Background(), models.UserIDContextKey, userID)
 }
 
+func userID(id string) *string {
+	return &id
+}
+
 func newTestHandler(cartRepo *mockCartRepo, courseRepo *mockCourseRepo) *CartHandler {
+	if cartRepo.coupons == nil {
+		cartRepo.coupons = &mockCouponRepo{}
+	}
 	return &CartHandler{
 		logger:     slog.New(slog.NewTextHandler(os.Stdout, nil)),
 		cartRepo:   cartRepo,
 		courseRepo: courseRepo,
+		couponRepo: cartRepo.coupons,
+		planRepo: &mockPlanRepo{plans: []*models.PaymentPlan{
+			{ID: 1, CourseID: "course-1", Amount: 3000, Installments: 9, Discount: 10},
+			{ID: 2, CourseID: "course-2", Amount: 3000, Installments: 9},
+		}},
+		mailer: &mockMailer{},
 	}
 }
 
@@ -95,11 +263,11 @@ func newTestHandler(cartRepo *mockCartRepo, courseRepo *mockCourseRepo) *CartHan
 
 func TestAddToCart_SuccessNewItem(t *testing.T) {
 	cartRepo := &mockCartRepo{
-		cart: &models.Cart{ID: "cart-1", UserID: "user-1"},
+		cart: &models.Cart{ID: "cart-1", UserID: userID("user-1")},
 	}
 	courseRepo := &mockCourseRepo{
 		courses: []*models.Course{
-			{ID: "course-1", Price: 100},
+			{ID: "course-1", Price: 10000},
 		},
 	}
 	h := newTestHandler(cartRepo, courseRepo)
@@ -120,16 +288,46 @@ func TestAddToCart_SuccessNewItem(t *testing.T) {
 	}
 }
 
+func TestAddToCart_DiscountIsExact(t *testing.T) {
+	cartRepo := &mockCartRepo{
+		cart: &models.Cart{ID: "cart-1", UserID: userID("user-1")},
+	}
+	courseRepo := &mockCourseRepo{
+		courses: []*models.Course{
+			{ID: "course-1", Price: 4999, Discount: 15},
+		},
+	}
+	h := newTestHandler(cartRepo, courseRepo)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"course_id": "course-1",
+	})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/cart/items", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.AddToCart(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 Created, got %d", rr.Code)
+	}
+	// 15% off 49.99 is 7.4985, which rounds to 7.50
+	if got := cartRepo.cartItems[0].Price; got != 4249 {
+		t.Errorf("expected price 42.49, got %s", got)
+	}
+	if !bytes.Contains(rr.Body.Bytes(), []byte(`"price":42.49`)) {
+		t.Errorf("expected price encoded as 42.49, got %s", rr.Body.String())
+	}
+}
+
 func TestAddToCart_AlreadyExists(t *testing.T) {
 	cartRepo := &mockCartRepo{
-		cart: &models.Cart{ID: "cart-1", UserID: "user-1"},
+		cart: &models.Cart{ID: "cart-1", UserID: userID("user-1")},
 		cartItems: []*models.CartItem{
-			{ID: "item-1", CartID: "cart-1", CourseID: "course-1", Price: 100},
+			{ID: "item-1", CartID: "cart-1", CourseID: "course-1", Price: 10000},
 		},
 	}
 	courseRepo := &mockCourseRepo{
 		courses: []*models.Course{
-			{ID: "course-1", Price: 100},
+			{ID: "course-1", Price: 10000},
 		},
 	}
 	h := newTestHandler(cartRepo, courseRepo)
@@ -149,3 +347,314 @@ func TestAddToCart_AlreadyExists(t *testing.T) {
 		t.Errorf("expected still only 1 item in cart, got %d", len(cartRepo.cartItems))
 	}
 }
+
+func TestAddToCart_WithPaymentPlan(t *testing.T) {
+	cartRepo := &mockCartRepo{
+		cart: &models.Cart{ID: "cart-1", UserID: userID("user-1")},
+	}
+	courseRepo := &mockCourseRepo{
+		courses: []*models.Course{
+			{ID: "course-1", Price: 29900},
+		},
+	}
+	h := newTestHandler(cartRepo, courseRepo)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"course_id":       "course-1",
+		"payment_plan_id": 1,
+	})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/cart/items", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.AddToCart(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 Created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// 9 x 30.00 less the plan's 10% discount
+	item := cartRepo.cartItems[0]
+	if item.Price != 24300 || item.PaymentPlanID == nil || *item.PaymentPlanID != 1 {
+		t.Errorf("expected the item on plan 1 at 243.00, got plan %v at %s", item.PaymentPlanID, item.Price)
+	}
+}
+
+func TestAddToCart_PaymentPlanForOtherCourse(t *testing.T) {
+	cartRepo := &mockCartRepo{
+		cart: &models.Cart{ID: "cart-1", UserID: userID("user-1")},
+	}
+	courseRepo := &mockCourseRepo{
+		courses: []*models.Course{
+			{ID: "course-1", Price: 29900},
+		},
+	}
+	h := newTestHandler(cartRepo, courseRepo)
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"course_id":       "course-1",
+		"payment_plan_id": 2,
+	})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/cart/items", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.AddToCart(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for another course's plan, got %d", rr.Code)
+	}
+	if len(cartRepo.cartItems) != 0 {
+		t.Errorf("expected nothing added, got %d items", len(cartRepo.cartItems))
+	}
+}
+
+// ===================== Coupon Tests =====================
+
+// cartWithCoupons is a cart holding one 100.00 course, with coupons that can
+// be applied to it
+func cartWithCoupons(coupons ...*models.Coupon) *mockCartRepo {
+	return &mockCartRepo{
+		cart: &models.Cart{
+			ID:     "cart-1",
+			UserID: userID("user-1"),
+			Items:  []models.CartItem{{ID: "item-1", CartID: "cart-1", CourseID: "course-1", Price: 10000}},
+		},
+		coupons: &mockCouponRepo{coupons: coupons},
+	}
+}
+
+func applyCoupon(h *CartHandler, code string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"code": code})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/cart/coupons", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.ApplyCoupon(rr, req)
+	return rr
+}
+
+func TestApplyCoupon_Success(t *testing.T) {
+	cartRepo := cartWithCoupons(&models.Coupon{ID: "coupon-1", Code: "SAVE20", Type: coupon.TypePercent, PercentOff: 20, Active: true})
+	h := newTestHandler(cartRepo, &mockCourseRepo{})
+
+	rr := applyCoupon(h, " save20 ")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var quote coupon.Quote
+	if err := json.Unmarshal(rr.Body.Bytes(), &quote); err != nil {
+		t.Fatalf("decode quote: %v", err)
+	}
+	if quote.Subtotal != 10000 || quote.Discount != 2000 || quote.Total != 8000 {
+		t.Errorf("expected 100.00 - 20.00 = 80.00, got %s - %s = %s", quote.Subtotal, quote.Discount, quote.Total)
+	}
+	if len(cartRepo.cart.Coupons) != 1 {
+		t.Errorf("expected the coupon to stay on the cart, got %d coupons", len(cartRepo.cart.Coupons))
+	}
+}
+
+func TestApplyCoupon_UnknownCode(t *testing.T) {
+	h := newTestHandler(cartWithCoupons(), &mockCourseRepo{})
+
+	if rr := applyCoupon(h, "NOPE"); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown code, got %d", rr.Code)
+	}
+}
+
+func TestApplyCoupon_MinimumSpendNotMet(t *testing.T) {
+	cartRepo := cartWithCoupons(&models.Coupon{ID: "coupon-1", Code: "BIG", Type: coupon.TypeFixed, AmountOff: 5000, MinSubtotal: 20000, Active: true})
+	h := newTestHandler(cartRepo, &mockCourseRepo{})
+
+	if rr := applyCoupon(h, "BIG"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 below the minimum spend, got %d", rr.Code)
+	}
+	if len(cartRepo.cart.Coupons) != 0 {
+		t.Errorf("expected the rejected coupon to be taken off the cart, got %d coupons", len(cartRepo.cart.Coupons))
+	}
+}
+
+func TestApplyCoupon_NotStackable(t *testing.T) {
+	cartRepo := cartWithCoupons(
+		&models.Coupon{ID: "coupon-1", Code: "FIRST", Type: coupon.TypePercent, PercentOff: 10, Active: true},
+		&models.Coupon{ID: "coupon-2", Code: "SECOND", Type: coupon.TypePercent, PercentOff: 10, Active: true},
+	)
+	h := newTestHandler(cartRepo, &mockCourseRepo{})
+
+	if rr := applyCoupon(h, "FIRST"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for the first coupon, got %d", rr.Code)
+	}
+	if rr := applyCoupon(h, "SECOND"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 when combining non-stackable coupons, got %d", rr.Code)
+	}
+	if rr := applyCoupon(h, "FIRST"); rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 when applying a coupon twice, got %d", rr.Code)
+	}
+}
+
+func TestRemoveCoupon(t *testing.T) {
+	cartRepo := cartWithCoupons(&models.Coupon{ID: "coupon-1", Code: "SAVE20", Type: coupon.TypePercent, PercentOff: 20, Active: true})
+	h := newTestHandler(cartRepo, &mockCourseRepo{})
+	if rr := applyCoupon(h, "SAVE20"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "DELETE", "/api/cart/coupons/save20", nil)
+	req = mux.SetURLVars(req, map[string]string{"code": "save20"})
+	rr := httptest.NewRecorder()
+	h.RemoveCoupon(rr, req)
+
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rr.Code)
+	}
+	if len(cartRepo.cart.Coupons) != 0 {
+		t.Errorf("expected no coupons left, got %d", len(cartRepo.cart.Coupons))
+	}
+}
+
+// ===================== Cart Reminder Tests =====================
+
+func TestSendCartReminders_OncePerIdlePeriod(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	idle := &models.Cart{
+		ID: "cart-1", UserID: userID("user-1"), User: models.User{Name: "Jo", Email: "jo@example.com"},
+		Items: []models.CartItem{{CourseID: "course-1", Course: models.Course{Name: "French A1"}, UpdatedAt: now.Add(-30 * time.Hour)}},
+	}
+	recent := &models.Cart{
+		ID: "cart-2", UserID: userID("user-2"), User: models.User{Email: "sam@example.com"},
+		Items: []models.CartItem{{CourseID: "course-1", UpdatedAt: now.Add(-time.Hour)}},
+	}
+	cartRepo := &mockCartRepo{idleCarts: []*models.Cart{idle, recent}}
+	h := newTestHandler(cartRepo, &mockCourseRepo{})
+	t.Setenv("FRONTEND_URL", "https://a1.example")
+
+	if err := h.SendCartReminders(context.Background(), now); err != nil {
+		t.Fatalf("SendCartReminders() = %v", err)
+	}
+	sent := h.mailer.(*mockMailer).sent
+	if len(sent) != 1 || sent[0].to != "jo@example.com" || sent[0].link != "https://a1.example/cart" || sent[0].courses[0] != "French A1" {
+		t.Fatalf("expected one reminder for the idle cart, got %+v", sent)
+	}
+
+	// Still idle the next day, and well within the window
+	if err := h.SendCartReminders(context.Background(), now.Add(24*time.Hour)); err != nil {
+		t.Fatalf("SendCartReminders() = %v", err)
+	}
+	if got := remindersTo(h, "jo@example.com"); got != 1 {
+		t.Errorf("expected no second reminder in the same idle period, got %d", got)
+	}
+
+	// Touched again after the reminder and idle past a full window
+	idle.Items[0].UpdatedAt = now.Add(time.Hour)
+	if err := h.SendCartReminders(context.Background(), now.Add(8*24*time.Hour)); err != nil {
+		t.Fatalf("SendCartReminders() = %v", err)
+	}
+	if got := remindersTo(h, "jo@example.com"); got != 2 {
+		t.Errorf("expected a new reminder for a new idle period, got %d", got)
+	}
+}
+
+func remindersTo(h *CartHandler, email string) int {
+	count := 0
+	for _, s := range h.mailer.(*mockMailer).sent {
+		if s.to == email {
+			count++
+		}
+	}
+	return count
+}
+
+// ===================== Guest Cart Tests =====================
+
+func TestAddToCart_GuestGetsCartToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	cartRepo := &mockCartRepo{}
+	h := newTestHandler(cartRepo, &mockCourseRepo{courses: []*models.Course{{ID: "course-1", Price: 10000}}})
+
+	body, _ := json.Marshal(map[string]interface{}{"course_id": "course-1"})
+	req := httptest.NewRequest("POST", "/api/cart/items", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.AddToCart(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 Created, got %d: %s", rr.Code, rr.Body.String())
+	}
+	token := rr.Header().Get(auth.CartTokenHeader)
+	cartID, err := auth.ValidateCartToken(token)
+	if err != nil || cartID != "guest-cart-1" {
+		t.Fatalf("expected a token for the new guest cart, got %q (%v)", token, err)
+	}
+	if cartRepo.guestCart == nil || cartRepo.guestCart.UserID != nil {
+		t.Fatalf("expected a guest cart without a user, got %+v", cartRepo.guestCart)
+	}
+
+	// The token finds the same cart on the next request
+	req = httptest.NewRequest("POST", "/api/cart/items", bytes.NewBuffer(body))
+	req.Header.Set(auth.CartTokenHeader, token)
+	rr = httptest.NewRecorder()
+	h.AddToCart(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 OK for a course already in the guest cart, got %d", rr.Code)
+	}
+	if rr.Header().Get(auth.CartTokenHeader) != "" {
+		t.Error("expected no new token for an existing guest cart")
+	}
+}
+
+func TestGetCart_GuestToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	cartRepo := &mockCartRepo{guestCart: &models.Cart{ID: "guest-cart-1"}}
+	h := newTestHandler(cartRepo, &mockCourseRepo{})
+	token, _ := auth.GenerateCartToken("guest-cart-1")
+
+	tests := []struct {
+		name   string
+		token  string
+		cartID string
+	}{
+		{name: "valid token", token: token, cartID: "guest-cart-1"},
+		{name: "no token", token: "", cartID: ""},
+		{name: "forged token", token: "guest-cart-1.forged", cartID: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/cart", nil)
+			if tt.token != "" {
+				req.Header.Set(auth.CartTokenHeader, tt.token)
+			}
+			rr := httptest.NewRecorder()
+			h.GetCart(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200 OK, got %d", rr.Code)
+			}
+			var resp struct {
+				Cart models.Cart `json:"cart"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Cart.ID != tt.cartID {
+				t.Errorf("expected cart %q, got %q", tt.cartID, resp.Cart.ID)
+			}
+			if rr.Header().Get(auth.CartTokenHeader) != "" {
+				t.Error("expected viewing the cart not to create a guest cart")
+			}
+		})
+	}
+}
+
+func TestPurgeGuestCarts(t *testing.T) {
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	t.Setenv("GUEST_CART_TTL", "720h")
+	cartRepo := &mockCartRepo{guestCart: &models.Cart{ID: "guest-cart-1", UpdatedAt: now.Add(-29 * 24 * time.Hour)}}
+	h := newTestHandler(cartRepo, &mockCourseRepo{})
+
+	if err := h.PurgeGuestCarts(context.Background(), now); err != nil {
+		t.Fatalf("PurgeGuestCarts() = %v", err)
+	}
+	if cartRepo.guestCart == nil {
+		t.Fatal("expected a guest cart within the TTL to be kept")
+	}
+
+	if err := h.PurgeGuestCarts(context.Background(), now.Add(2*24*time.Hour)); err != nil {
+		t.Fatalf("PurgeGuestCarts() = %v", err)
+	}
+	if cartRepo.guestCart != nil {
+		t.Error("expected a guest cart past the TTL to be purged")
+	}
+}
@@ -0,0 +1,84 @@
+package cart
+
+import (
+	"context"
+	"os"
+	"services/internal/paypal"
+	"time"
+)
+
+// Defaults for abandoned cart reminders, overridden by CART_REMINDER_AFTER and
+// CART_REMINDER_WINDOW (Go durations such as 48h)
+const (
+	DefaultReminderAfter  = 24 * time.Hour
+	DefaultReminderWindow = 7 * 24 * time.Hour
+)
+
+// ReminderMailer sends abandoned cart reminders so it can be mocked in tests
+type ReminderMailer interface {
+	SendCartReminder(ctx context.Context, to, name, link string, courses []string) error
+}
+
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
+// checkoutLink is where a reminder sends the buyer back to
+func checkoutLink() string {
+	return paypal.FrontendURL() + "/cart"
+}
+
+// SendCartReminders emails the owners of carts that have been idle for
+// CART_REMINDER_AFTER. A cart gets one reminder per idle period, and no more
+// than one per CART_REMINDER_WINDOW. Carts are claimed before the email goes
+// out, so overlapping runs never remind twice; a failed email is not retried
+// until the next window.
+func (h *CartHandler) SendCartReminders(ctx context.Context, now time.Time) error {
+	idleSince := now.Add(-durationFromEnv("CART_REMINDER_AFTER", DefaultReminderAfter))
+	remindedBefore := now.Add(-durationFromEnv("CART_REMINDER_WINDOW", DefaultReminderWindow))
+
+	carts, err := h.cartRepo.ClaimAbandoned(ctx, idleSince, remindedBefore, now)
+	if err != nil {
+		return err
+	}
+
+	link := checkoutLink()
+	for _, cart := range carts {
+		if cart.User.Email == "" || len(cart.Items) == 0 {
+			continue
+		}
+		courses := make([]string, 0, len(cart.Items))
+		for _, item := range cart.Items {
+			courses = append(courses, item.Course.Name)
+		}
+
+		if err := h.mailer.SendCartReminder(ctx, cart.User.Email, cart.User.Name, link, courses); err != nil {
+			h.logger.WarnContext(ctx, "Failed to send cart reminder", "user_id", cart.User.ID, "cart_id", cart.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// RunCartReminders runs SendCartReminders now and then every interval until
+// ctx is cancelled. A run is skipped while another replica is sending.
+func (h *CartHandler) RunCartReminders(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := h.jobs.TryDo(ctx, "cart-reminders", func(ctx context.Context) error {
+			return h.SendCartReminders(ctx, time.Now())
+		})
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Cart reminder run failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package coupons
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"services/internal/api"
+	"services/internal/coupon"
+	"services/internal/models"
+	"services/internal/repository"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type CouponHandler struct {
+	logger *slog.Logger
+	repo   repository.CouponRepository
+}
+
+func NewCouponHandler(logger *slog.Logger, db *gorm.DB) *CouponHandler {
+	repo := repository.NewPostgresCouponRepository(db)
+	return &CouponHandler{
+		logger: logger,
+		repo:   repo,
+	}
+}
+
+// decodeCoupon reads a coupon from the request body, normalises its code and
+// validates it. New coupons are active unless the body says otherwise.
+func decodeCoupon(w http.ResponseWriter, r *http.Request) (*models.Coupon, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Failed to read request body")
+		return nil, false
+	}
+	defer func() { _ = r.Body.Close() }()
+
+	c := models.Coupon{Active: true}
+	if err := json.Unmarshal(body, &c); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid JSON format")
+		return nil, false
+	}
+	c.Code = coupon.NormalizeCode(c.Code)
+	if err := coupon.Validate(&c); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return &c, true
+}
+
+// CreateCoupon creates a promo code
+func (h *CouponHandler) CreateCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	c, ok := decodeCoupon(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.repo.FindByCode(ctx, c.Code); err == nil {
+		api.RespondWithError(w, http.StatusConflict, "Coupon code already exists")
+		return
+	} else if !errors.Is(err, repository.ErrCouponNotFound) {
+		h.logger.ErrorContext(ctx, "Error checking coupon code", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create coupon")
+		return
+	}
+
+	if err := h.repo.Create(ctx, c); err != nil {
+		h.logger.ErrorContext(ctx, "Error creating coupon", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create coupon")
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Created coupon", "coupon_id", c.ID, "code", c.Code)
+	api.RespondWithJSON(w, http.StatusCreated, c)
+}
+
+// GetCoupon returns a single coupon
+func (h *CouponHandler) GetCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	c, err := h.repo.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrCouponNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Coupon not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Error getting coupon", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get coupon")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, c)
+}
+
+// ListCoupons returns every coupon, newest first
+func (h *CouponHandler) ListCoupons(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	list, err := h.repo.FindAll(ctx)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Error listing coupons", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to list coupons")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, list)
+}
+
+// UpdateCoupon replaces a coupon's definition. Redemptions already made are
+// kept and still count towards the new limits.
+func (h *CouponHandler) UpdateCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	c, ok := decodeCoupon(w, r)
+	if !ok {
+		return
+	}
+	c.ID = id
+
+	if existing, err := h.repo.FindByCode(ctx, c.Code); err == nil && existing.ID != id {
+		api.RespondWithError(w, http.StatusConflict, "Coupon code already exists")
+		return
+	}
+
+	if err := h.repo.Update(ctx, c); err != nil {
+		if errors.Is(err, repository.ErrCouponNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Coupon not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Error updating coupon", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to update coupon")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, c)
+}
+
+// DeleteCoupon removes a coupon. Carts holding it stop being discounted by it;
+// orders keep their discount lines.
+func (h *CouponHandler) DeleteCoupon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+
+	if err := h.repo.Delete(ctx, id); err != nil {
+		if errors.Is(err, repository.ErrCouponNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Coupon not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Error deleting coupon", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to delete coupon")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,247 @@
+package gifts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"services/internal/api"
+	"services/internal/enrollment"
+	"services/internal/models"
+	"services/internal/repository"
+	"services/internal/service"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxIssuedCodes caps how many complimentary codes one request can issue
+const maxIssuedCodes = 500
+
+// GiftMailer emails enrollment codes to their recipients so it can be mocked
+// in tests
+type GiftMailer interface {
+	SendGift(ctx context.Context, to, name, from, message string, courses []service.GiftedCourse) error
+}
+
+type GiftHandler struct {
+	logger     *slog.Logger
+	codeRepo   repository.EnrollmentCodeRepository
+	courseRepo repository.CourseRepository
+	mailer     GiftMailer
+}
+
+func NewGiftHandler(logger *slog.Logger, db *gorm.DB) *GiftHandler {
+	return &GiftHandler{
+		logger:     logger,
+		codeRepo:   repository.NewPostgresEnrollmentCodeRepository(db),
+		courseRepo: repository.NewPostgresCourseRepository(db),
+		mailer:     service.NewNotificationService(logger, repository.NewPostgresSettingsRepository(db)),
+	}
+}
+
+// Gift is what a buyer sees of a code they paid for. Who redeemed it is not
+// shown, only whether and when it was.
+type Gift struct {
+	Code           string     `json:"code"`
+	CourseID       string     `json:"course_id"`
+	CourseName     string     `json:"course_name"`
+	OrderID        string     `json:"order_id"`
+	RecipientEmail string     `json:"recipient_email"`
+	RecipientName  string     `json:"recipient_name,omitempty"`
+	Redeemed       bool       `json:"redeemed"`
+	RedeemedAt     *time.Time `json:"redeemed_at,omitempty"`
+	Revoked        bool       `json:"revoked"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// Redeem enrolls the caller in the course of an enrollment code and uses the
+// code up
+func (h *GiftHandler) Redeem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || enrollment.NormalizeCode(req.Code) == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "Code is required")
+		return
+	}
+
+	code, err := h.codeRepo.Redeem(ctx, req.Code, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrEnrollmentCodeNotFound):
+			api.RespondWithError(w, http.StatusNotFound, "Code not found")
+		case errors.Is(err, repository.ErrEnrollmentCodeRedeemed):
+			api.RespondWithError(w, http.StatusConflict, "Code has already been redeemed")
+		case errors.Is(err, repository.ErrAlreadyEnrolled):
+			// The code is left unused so it can be passed on
+			api.RespondWithError(w, http.StatusConflict, "You are already enrolled in this course")
+		default:
+			h.logger.ErrorContext(ctx, "Failed to redeem enrollment code", "user_id", userID, "error", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "Failed to redeem code")
+		}
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Redeemed enrollment code", "user_id", userID, "code_id", code.ID, "course_id", code.CourseID, "kind", code.Kind)
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{
+		"status":    "success",
+		"course_id": code.CourseID,
+	})
+}
+
+// ListMyGifts returns the gift codes the caller bought, newest first, and
+// whether each has been redeemed
+func (h *GiftHandler) ListMyGifts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	codes, err := h.codeRepo.FindByIssuer(ctx, userID, models.EnrollmentCodeGift)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to list gifts", "user_id", userID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to list gifts")
+		return
+	}
+
+	gifts := make([]Gift, 0, len(codes))
+	for _, code := range codes {
+		gift := Gift{
+			Code:           code.Code,
+			CourseID:       code.CourseID,
+			CourseName:     code.Course.Name,
+			RecipientEmail: code.RecipientEmail,
+			RecipientName:  code.RecipientName,
+			Redeemed:       code.RedeemedAt != nil,
+			RedeemedAt:     code.RedeemedAt,
+			Revoked:        code.RevokedAt != nil,
+			CreatedAt:      code.CreatedAt,
+		}
+		if code.OrderID != nil {
+			gift.OrderID = *code.OrderID
+		}
+		gifts = append(gifts, gift)
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, gifts)
+}
+
+type recipient struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// IssueCodes issues complimentary enrollment codes for a course. Either
+// quantity codes are returned to hand out, or one code is emailed to each of
+// the recipients.
+func (h *GiftHandler) IssueCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req struct {
+		CourseID   string      `json:"course_id"`
+		Quantity   int         `json:"quantity"`
+		Recipients []recipient `json:"recipients"`
+		Message    string      `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+	if req.Quantity > 0 && len(req.Recipients) > 0 {
+		api.RespondWithError(w, http.StatusBadRequest, "Give either quantity or recipients, not both")
+		return
+	}
+	count := req.Quantity + len(req.Recipients)
+	if count <= 0 || count > maxIssuedCodes {
+		api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Between 1 and %d codes can be issued at once", maxIssuedCodes))
+		return
+	}
+	for i := range req.Recipients {
+		rec := &req.Recipients[i]
+		rec.Email = strings.TrimSpace(rec.Email)
+		rec.Name = strings.TrimSpace(rec.Name)
+		if addr, err := mail.ParseAddress(rec.Email); err != nil || addr.Address != rec.Email {
+			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid recipient email %q", rec.Email))
+			return
+		}
+	}
+
+	course, err := h.courseRepo.FindByID(ctx, req.CourseID)
+	if err != nil {
+		if errors.Is(err, repository.ErrCourseNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Course not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Error getting course", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get course")
+		return
+	}
+
+	codes := make([]*models.EnrollmentCode, 0, count)
+	for i := range count {
+		value, err := enrollment.NewCode()
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Failed to generate enrollment code", "error", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "Failed to issue codes")
+			return
+		}
+		code := &models.EnrollmentCode{
+			Code:       value,
+			Kind:       models.EnrollmentCodeComplimentary,
+			CourseID:   course.ID,
+			IssuedByID: userID,
+			Message:    strings.TrimSpace(req.Message),
+		}
+		if i < len(req.Recipients) {
+			code.RecipientEmail = req.Recipients[i].Email
+			code.RecipientName = req.Recipients[i].Name
+		}
+		codes = append(codes, code)
+	}
+
+	if err := h.codeRepo.Create(ctx, codes); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to store enrollment codes", "course_id", course.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to issue codes")
+		return
+	}
+	h.logger.InfoContext(ctx, "Issued complimentary enrollment codes", "user_id", userID, "course_id", course.ID, "count", count)
+
+	if len(req.Recipients) > 0 {
+		go h.emailCodes(context.WithoutCancel(ctx), course, codes)
+	}
+
+	api.RespondWithJSON(w, http.StatusCreated, codes)
+}
+
+// emailCodes sends each addressed code to its recipient. Failures are only
+// logged, the codes are in the issuer's response either way.
+func (h *GiftHandler) emailCodes(ctx context.Context, course *models.Course, codes []*models.EnrollmentCode) {
+	for _, code := range codes {
+		if code.RecipientEmail == "" {
+			continue
+		}
+		courses := []service.GiftedCourse{{Course: course.Name, Code: code.Code, Link: enrollment.RedeemLink(code.Code)}}
+		if err := h.mailer.SendGift(ctx, code.RecipientEmail, code.RecipientName, "", code.Message, courses); err != nil {
+			h.logger.WarnContext(ctx, "Failed to email enrollment code", "code_id", code.ID, "to", code.RecipientEmail, "error", err)
+		}
+	}
+}
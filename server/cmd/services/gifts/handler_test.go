@@ -0,0 +1,273 @@
+package gifts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"services/internal/enrollment"
+	"services/internal/models"
+	"services/internal/repository"
+	"services/internal/service"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ===================== Mock Repositories =====================
+
+type mockCodeRepo struct {
+	codes    []*models.EnrollmentCode
+	enrolled map[string]bool // userID/courseID pairs
+}
+
+func (m *mockCodeRepo) Create(ctx context.Context, codes []*models.EnrollmentCode) error {
+	m.codes = append(m.codes, codes...)
+	return nil
+}
+func (m *mockCodeRepo) FindByCode(ctx context.Context, code string) (*models.EnrollmentCode, error) {
+	for _, c := range m.codes {
+		if c.Code == enrollment.NormalizeCode(code) {
+			return c, nil
+		}
+	}
+	return nil, repository.ErrEnrollmentCodeNotFound
+}
+func (m *mockCodeRepo) FindByIssuer(ctx context.Context, issuedByID, kind string) ([]*models.EnrollmentCode, error) {
+	var result []*models.EnrollmentCode
+	for _, c := range m.codes {
+		if c.IssuedByID == issuedByID && c.Kind == kind {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+func (m *mockCodeRepo) FindByOrderID(ctx context.Context, orderID string) ([]*models.EnrollmentCode, error) {
+	return nil, nil
+}
+
+// Redeem applies the same rules as the Postgres implementation
+func (m *mockCodeRepo) Redeem(ctx context.Context, code, userID string) (*models.EnrollmentCode, error) {
+	c, err := m.FindByCode(ctx, code)
+	if err != nil || c.RevokedAt != nil {
+		return nil, repository.ErrEnrollmentCodeNotFound
+	}
+	if c.RedeemedAt != nil {
+		return nil, repository.ErrEnrollmentCodeRedeemed
+	}
+	if m.enrolled[userID+"/"+c.CourseID] {
+		return nil, repository.ErrAlreadyEnrolled
+	}
+	now := time.Now()
+	c.RedeemedByID, c.RedeemedAt = &userID, &now
+	if m.enrolled == nil {
+		m.enrolled = make(map[string]bool)
+	}
+	m.enrolled[userID+"/"+c.CourseID] = true
+	return c, nil
+}
+func (m *mockCodeRepo) RevokeByOrderID(ctx context.Context, orderID string, at time.Time) (int64, error) {
+	return 0, nil
+}
+func (m *mockCodeRepo) HasRedeemed(ctx context.Context, userID, courseID string) (bool, error) {
+	return false, nil
+}
+
+type mockCourseRepo struct {
+	courses []*models.Course
+}
+
+func (m *mockCourseRepo) Create(ctx context.Context, course *models.Course) error { return nil }
+func (m *mockCourseRepo) FindByID(ctx context.Context, id string) (*models.Course, error) {
+	for _, c := range m.courses {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, repository.ErrCourseNotFound
+}
+func (m *mockCourseRepo) FindAll(ctx context.Context) ([]*models.Course, error) { return nil, nil }
+func (m *mockCourseRepo) FindByInstructorID(ctx context.Context, instructorID string) ([]*models.Course, error) {
+	return nil, nil
+}
+func (m *mockCourseRepo) Update(ctx context.Context, course *models.Course) error { return nil }
+func (m *mockCourseRepo) Delete(ctx context.Context, id string) error             { return nil }
+
+type mockMailer struct {
+	sent chan string
+}
+
+func (m *mockMailer) SendGift(ctx context.Context, to, name, from, message string, courses []service.GiftedCourse) error {
+	m.sent <- to
+	return nil
+}
+
+// ===================== Helper =====================
+
+func contextWithUserID(userID string) context.Context {
+	return context.WithValue(context.Background(), models.UserIDContextKey, userID)
+}
+
+func newTestHandler(codeRepo *mockCodeRepo) *GiftHandler {
+	return &GiftHandler{
+		logger:     slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		codeRepo:   codeRepo,
+		courseRepo: &mockCourseRepo{courses: []*models.Course{{ID: "course-1", Name: "French A1"}}},
+		mailer:     &mockMailer{sent: make(chan string, 16)},
+	}
+}
+
+func redeem(h *GiftHandler, userID, code string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"code": code})
+	req, _ := http.NewRequestWithContext(contextWithUserID(userID), "POST", "/api/redeem", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.Redeem(rr, req)
+	return rr
+}
+
+// ===================== Redeem Tests =====================
+
+func TestRedeem(t *testing.T) {
+	codeRepo := &mockCodeRepo{
+		codes: []*models.EnrollmentCode{
+			{ID: "code-1", Code: "7KQX-M2VD-9HTR-C4PW", Kind: models.EnrollmentCodeGift, CourseID: "course-1", IssuedByID: "buyer-1"},
+			{ID: "code-2", Code: "ABCD-EFGH-JKMN-PQRS", Kind: models.EnrollmentCodeComplimentary, CourseID: "course-1", IssuedByID: "staff-1"},
+		},
+		enrolled: map[string]bool{"user-2/course-1": true},
+	}
+	h := newTestHandler(codeRepo)
+
+	tests := []struct {
+		name   string
+		userID string
+		code   string
+		want   int
+	}{
+		{name: "typed loosely", userID: "user-1", code: "7kqx m2vd 9htr c4pw", want: http.StatusOK},
+		{name: "already redeemed", userID: "user-3", code: "7KQX-M2VD-9HTR-C4PW", want: http.StatusConflict},
+		{name: "unknown code", userID: "user-1", code: "ZZZZ-ZZZZ-ZZZZ-ZZZZ", want: http.StatusNotFound},
+		{name: "already enrolled", userID: "user-2", code: "ABCD-EFGH-JKMN-PQRS", want: http.StatusConflict},
+		{name: "missing code", userID: "user-1", code: " ", want: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rr := redeem(h, tt.userID, tt.code); rr.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+
+	if redeemedBy := codeRepo.codes[0].RedeemedByID; redeemedBy == nil || *redeemedBy != "user-1" {
+		t.Errorf("expected user-1 to have redeemed the gift, got %v", redeemedBy)
+	}
+	if codeRepo.codes[1].RedeemedAt != nil {
+		t.Error("expected the code of an owned course to stay unused")
+	}
+}
+
+// ===================== ListMyGifts Tests =====================
+
+func TestListMyGifts_ShowsRedemptionButNotRedeemer(t *testing.T) {
+	redeemedAt := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	redeemer := "user-2"
+	orderID := "order-1"
+	codeRepo := &mockCodeRepo{codes: []*models.EnrollmentCode{
+		{Code: "7KQX-M2VD-9HTR-C4PW", Kind: models.EnrollmentCodeGift, CourseID: "course-1", IssuedByID: "user-1", OrderID: &orderID, RecipientEmail: "friend@example.com", RedeemedByID: &redeemer, RedeemedAt: &redeemedAt},
+		{Code: "ABCD-EFGH-JKMN-PQRS", Kind: models.EnrollmentCodeGift, CourseID: "course-1", IssuedByID: "user-3"},
+	}}
+	h := newTestHandler(codeRepo)
+
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "GET", "/api/gifts", nil)
+	rr := httptest.NewRecorder()
+	h.ListMyGifts(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if strings.Contains(rr.Body.String(), redeemer) {
+		t.Error("expected the redeemer not to be shown to the buyer")
+	}
+	var gifts []Gift
+	if err := json.Unmarshal(rr.Body.Bytes(), &gifts); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(gifts) != 1 || !gifts[0].Redeemed || gifts[0].OrderID != "order-1" || gifts[0].RecipientEmail != "friend@example.com" {
+		t.Errorf("expected the buyer's one redeemed gift, got %+v", gifts)
+	}
+}
+
+// ===================== IssueCodes Tests =====================
+
+func issueCodes(h *GiftHandler, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequestWithContext(contextWithUserID("staff-1"), "POST", "/api/admin/enrollment-codes", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.IssueCodes(rr, req)
+	return rr
+}
+
+func TestIssueCodes_Quantity(t *testing.T) {
+	codeRepo := &mockCodeRepo{}
+	h := newTestHandler(codeRepo)
+
+	rr := issueCodes(h, `{"course_id": "course-1", "quantity": 25}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(codeRepo.codes) != 25 {
+		t.Fatalf("expected 25 codes, got %d", len(codeRepo.codes))
+	}
+	seen := make(map[string]bool)
+	for _, c := range codeRepo.codes {
+		if c.Kind != models.EnrollmentCodeComplimentary || c.IssuedByID != "staff-1" || c.OrderID != nil || seen[c.Code] {
+			t.Fatalf("unexpected code %+v", c)
+		}
+		seen[c.Code] = true
+	}
+}
+
+func TestIssueCodes_EmailsRecipients(t *testing.T) {
+	codeRepo := &mockCodeRepo{}
+	h := newTestHandler(codeRepo)
+
+	rr := issueCodes(h, `{"course_id": "course-1", "recipients": [{"email": "a@example.com"}, {"email": "b@example.com", "name": "Bea"}]}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(codeRepo.codes) != 2 || codeRepo.codes[1].RecipientName != "Bea" {
+		t.Fatalf("expected a code per recipient, got %+v", codeRepo.codes)
+	}
+
+	sent := h.mailer.(*mockMailer).sent
+	got := map[string]bool{<-sent: true, <-sent: true}
+	if !got["a@example.com"] || !got["b@example.com"] {
+		t.Errorf("expected both recipients to be emailed, got %v", got)
+	}
+}
+
+func TestIssueCodes_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "no codes", body: `{"course_id": "course-1"}`, want: http.StatusBadRequest},
+		{name: "too many", body: `{"course_id": "course-1", "quantity": 501}`, want: http.StatusBadRequest},
+		{name: "both", body: `{"course_id": "course-1", "quantity": 1, "recipients": [{"email": "a@example.com"}]}`, want: http.StatusBadRequest},
+		{name: "bad email", body: `{"course_id": "course-1", "recipients": [{"email": "nope"}]}`, want: http.StatusBadRequest},
+		{name: "unknown course", body: `{"course_id": "course-9", "quantity": 1}`, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codeRepo := &mockCodeRepo{}
+			if rr := issueCodes(newTestHandler(codeRepo), tt.body); rr.Code != tt.want {
+				t.Errorf("expected %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+			if len(codeRepo.codes) != 0 {
+				t.Errorf("expected no codes, got %d", len(codeRepo.codes))
+			}
+		})
+	}
+}
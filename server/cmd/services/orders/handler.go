@@ -0,0 +1,221 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"services/internal/api"
+	"services/internal/invoice"
+	"services/internal/models"
+	"services/internal/repository"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type OrderHandler struct {
+	logger      *slog.Logger
+	orderRepo   repository.OrderRepository
+	invoiceRepo repository.InvoiceRepository
+}
+
+func NewOrderHandler(logger *slog.Logger, db *gorm.DB) *OrderHandler {
+	return &OrderHandler{
+		logger:      logger,
+		orderRepo:   repository.NewPostgresOrderRepository(db),
+		invoiceRepo: repository.NewPostgresInvoiceRepository(db),
+	}
+}
+
+// ListMyOrders returns the caller's orders, newest first, with their items,
+// payments and payment attempts
+func (h *OrderHandler) ListMyOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	orders, err := h.orderRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to list orders", "user_id", userID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to list orders")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, orders)
+}
+
+// GetMyOrder returns one of the caller's orders. Orders of other users are
+// reported as not found so their IDs cannot be probed.
+func (h *OrderHandler) GetMyOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	order, err := h.orderRepo.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrOrderNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Order not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Failed to get order", "user_id", userID, "order_id", id, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get order")
+		return
+	}
+	if order.UserID != userID {
+		h.logger.WarnContext(ctx, "Order requested by another user", "user_id", userID, "order_id", id)
+		api.RespondWithError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, order)
+}
+
+// GetMyReceipt returns the PDF receipt of one of the caller's paid orders.
+// Orders that are not paid yet have no receipt. ?installment=N returns the
+// receipt of an installment paid after checkout instead.
+func (h *OrderHandler) GetMyReceipt(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	order, err := h.orderRepo.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrOrderNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Order not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Failed to get order", "user_id", userID, "order_id", id, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get receipt")
+		return
+	}
+	if order.UserID != userID {
+		h.logger.WarnContext(ctx, "Receipt requested by another user", "user_id", userID, "order_id", id)
+		api.RespondWithError(w, http.StatusNotFound, "Order not found")
+		return
+	}
+
+	installment := 0
+	if raw := r.URL.Query().Get("installment"); raw != "" {
+		installment, err = strconv.Atoi(raw)
+		if err != nil || installment < 0 {
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid installment")
+			return
+		}
+	}
+
+	inv, err := h.invoiceRepo.FindByOrderID(ctx, order.ID, installment)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvoiceNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Receipt not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Failed to get invoice", "user_id", userID, "order_id", id, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get receipt")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", inv.Number+".pdf"))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(invoice.PDF(inv))
+}
+
+// Pages of the back-office order search hold defaultPageSize orders unless
+// the caller asks for another limit, up to maxPageSize
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// ListOrders is the back-office order search. It accepts user_id, status and
+// a from/to date range (RFC 3339 or YYYY-MM-DD; a date-only "to" includes
+// that whole day), and pages through the results with limit and offset.
+func (h *OrderHandler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	filter, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid filter: "+err.Error())
+		return
+	}
+
+	orders, err := h.orderRepo.FindByFilter(ctx, filter)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to search orders", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to list orders")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, orders)
+}
+
+func parseOrderFilter(query url.Values) (repository.OrderFilter, error) {
+	filter := repository.OrderFilter{
+		UserID: query.Get("user_id"),
+		Status: query.Get("status"),
+		Limit:  defaultPageSize,
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+		filter.Limit = min(limit, maxPageSize)
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return filter, fmt.Errorf("invalid offset %q", v)
+		}
+		filter.Offset = offset
+	}
+
+	if v := query.Get("from"); v != "" {
+		from, _, err := parseFilterTime(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date %q", v)
+		}
+		filter.From = &from
+	}
+	if v := query.Get("to"); v != "" {
+		to, dateOnly, err := parseFilterTime(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date %q", v)
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = &to
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+
+	return filter, nil
+}
+
+// parseFilterTime accepts RFC 3339 timestamps and plain dates, reporting
+// which of the two it got
+func parseFilterTime(v string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	return t, true, err
+}
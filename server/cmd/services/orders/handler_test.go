@@ -0,0 +1,272 @@
+package orders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"services/internal/models"
+	"services/internal/repository"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ===================== Mock Repository =====================
+
+type mockOrderRepo struct {
+	orders     []*models.Order
+	lastFilter repository.OrderFilter
+}
+
+func (m *mockOrderRepo) Create(ctx context.Context, order *models.Order) error { return nil }
+func (m *mockOrderRepo) FindByID(ctx context.Context, id string) (*models.Order, error) {
+	for _, o := range m.orders {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return nil, repository.ErrOrderNotFound
+}
+func (m *mockOrderRepo) FindByIDForUpdate(ctx context.Context, id string) (*models.Order, error) {
+	return m.FindByID(ctx, id)
+}
+func (m *mockOrderRepo) FindAll(ctx context.Context) ([]*models.Order, error) {
+	return m.orders, nil
+}
+func (m *mockOrderRepo) FindByUserID(ctx context.Context, userID string) ([]*models.Order, error) {
+	var result []*models.Order
+	for _, o := range m.orders {
+		if o.UserID == userID {
+			result = append(result, o)
+		}
+	}
+	return result, nil
+}
+func (m *mockOrderRepo) FindByFilter(ctx context.Context, filter repository.OrderFilter) ([]*models.Order, error) {
+	m.lastFilter = filter
+	return m.orders, nil
+}
+func (m *mockOrderRepo) Update(ctx context.Context, order *models.Order) error            { return nil }
+func (m *mockOrderRepo) UpdateStatus(ctx context.Context, id string, status string) error { return nil }
+func (m *mockOrderRepo) Delete(ctx context.Context, id string) error                      { return nil }
+
+type mockInvoiceRepo struct {
+	invoices []*models.Invoice
+}
+
+func (m *mockInvoiceRepo) NextSequence(ctx context.Context) (int64, error)                { return 0, nil }
+func (m *mockInvoiceRepo) Create(ctx context.Context, invoice *models.Invoice) error      { return nil }
+func (m *mockInvoiceRepo) MarkEmailed(ctx context.Context, id string, at time.Time) error { return nil }
+func (m *mockInvoiceRepo) FindByOrderID(ctx context.Context, orderID string, installment int) (*models.Invoice, error) {
+	for _, inv := range m.invoices {
+		if inv.OrderID == orderID && inv.Installment == installment {
+			return inv, nil
+		}
+	}
+	return nil, repository.ErrInvoiceNotFound
+}
+
+// ===================== Helper =====================
+
+func newTestHandler(orderRepo *mockOrderRepo) *OrderHandler {
+	return &OrderHandler{
+		logger:      slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		orderRepo:   orderRepo,
+		invoiceRepo: &mockInvoiceRepo{},
+	}
+}
+
+func contextWithUserID(userID string) context.Context {
+	return context.WithValue(context.Background(), models.UserIDContextKey, userID)
+}
+
+// ===================== Tests =====================
+
+func TestListMyOrders_OnlyOwnOrders(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "COMPLETED"},
+			{ID: "order-2", UserID: "user-2", Status: "COMPLETED"},
+		},
+	}
+	h := newTestHandler(orderRepo)
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "GET", "/api/orders", nil)
+	rr := httptest.NewRecorder()
+	h.ListMyOrders(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var orders []models.Order
+	_ = json.NewDecoder(rr.Body).Decode(&orders)
+	if len(orders) != 1 || orders[0].ID != "order-1" {
+		t.Errorf("expected only order-1, got %+v", orders)
+	}
+}
+
+func TestListMyOrders_Unauthorized(t *testing.T) {
+	h := newTestHandler(&mockOrderRepo{})
+	req, _ := http.NewRequest("GET", "/api/orders", nil)
+	rr := httptest.NewRecorder()
+	h.ListMyOrders(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestGetMyOrder(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "COMPLETED"},
+			{ID: "order-2", UserID: "user-2", Status: "COMPLETED"},
+		},
+	}
+	tests := []struct {
+		name    string
+		orderID string
+		want    int
+	}{
+		{name: "own order", orderID: "order-1", want: http.StatusOK},
+		{name: "other user's order", orderID: "order-2", want: http.StatusNotFound},
+		{name: "missing order", orderID: "order-3", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(orderRepo)
+			req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "GET", "/api/orders/"+tt.orderID, nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.orderID})
+			rr := httptest.NewRecorder()
+			h.GetMyOrder(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
+
+func TestGetMyReceipt(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "COMPLETED"},
+			{ID: "order-2", UserID: "user-2", Status: "COMPLETED"},
+			{ID: "order-3", UserID: "user-1", Status: "PENDING"},
+		},
+	}
+	invoiceRepo := &mockInvoiceRepo{invoices: []*models.Invoice{
+		{ID: "invoice-1", OrderID: "order-1", Number: "INV-000001", Currency: "CAD"},
+		{ID: "invoice-2", OrderID: "order-2", Number: "INV-000002", Currency: "CAD"},
+	}}
+	tests := []struct {
+		name    string
+		orderID string
+		want    int
+	}{
+		{name: "own paid order", orderID: "order-1", want: http.StatusOK},
+		{name: "other user's order", orderID: "order-2", want: http.StatusNotFound},
+		{name: "unpaid order", orderID: "order-3", want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(orderRepo)
+			h.invoiceRepo = invoiceRepo
+			req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "GET", "/api/orders/"+tt.orderID+"/receipt", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": tt.orderID})
+			rr := httptest.NewRecorder()
+			h.GetMyReceipt(rr, req)
+
+			if rr.Code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, rr.Code)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != "application/pdf" {
+				t.Errorf("expected a PDF, got %q", ct)
+			}
+			if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="INV-000001.pdf"` {
+				t.Errorf("unexpected Content-Disposition %q", cd)
+			}
+			if !bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF-")) {
+				t.Errorf("body is not a PDF: %.20q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestListOrders_Filters(t *testing.T) {
+	orderRepo := &mockOrderRepo{}
+	h := newTestHandler(orderRepo)
+	query := url.Values{
+		"user_id": {"user-1"},
+		"status":  {"COMPLETED"},
+		"from":    {"2026-01-01"},
+		"to":      {"2026-01-31"},
+	}
+	req, _ := http.NewRequest("GET", "/api/admin/orders?"+query.Encode(), nil)
+	rr := httptest.NewRecorder()
+	h.ListOrders(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	f := orderRepo.lastFilter
+	if f.UserID != "user-1" || f.Status != "COMPLETED" {
+		t.Errorf("unexpected filter: %+v", f)
+	}
+	if f.From == nil || !f.From.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected from: %v", f.From)
+	}
+	// A date-only "to" covers the whole day
+	if f.To == nil || !f.To.Equal(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected to: %v", f.To)
+	}
+	if f.Limit != defaultPageSize || f.Offset != 0 {
+		t.Errorf("expected the first page of %d, got limit %d offset %d", defaultPageSize, f.Limit, f.Offset)
+	}
+}
+
+func TestListOrders_Pagination(t *testing.T) {
+	tests := []struct {
+		query  string
+		limit  int
+		offset int
+	}{
+		{query: "limit=20&offset=40", limit: 20, offset: 40},
+		{query: "limit=100000", limit: maxPageSize},
+	}
+	for _, tt := range tests {
+		orderRepo := &mockOrderRepo{}
+		h := newTestHandler(orderRepo)
+		req, _ := http.NewRequest("GET", "/api/admin/orders?"+tt.query, nil)
+		rr := httptest.NewRecorder()
+		h.ListOrders(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", tt.query, rr.Code)
+		}
+		if f := orderRepo.lastFilter; f.Limit != tt.limit || f.Offset != tt.offset {
+			t.Errorf("%s: expected limit %d offset %d, got %d and %d", tt.query, tt.limit, tt.offset, f.Limit, f.Offset)
+		}
+	}
+}
+
+func TestListOrders_InvalidRange(t *testing.T) {
+	for _, query := range []string{"from=yesterday", "to=2026-13-01", "from=2026-02-01&to=2026-01-01", "limit=0", "limit=ten", "offset=-1"} {
+		h := newTestHandler(&mockOrderRepo{})
+		req, _ := http.NewRequest("GET", "/api/admin/orders?"+query, nil)
+		rr := httptest.NewRecorder()
+		h.ListOrders(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net/http"
 	"services/internal/api"
+	"services/internal/installment"
 	"services/internal/models"
 	"services/internal/repository"
 	"strconv"
@@ -46,6 +47,11 @@ func (h *PaymentPlanHandler) CreatePaymentPlan(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if err := installment.Validate(&plan); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if err := h.repo.Create(ctx, &plan); err != nil {
 		h.logger.ErrorContext(ctx, "Error creating payment plan", "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create payment plan")
@@ -111,6 +117,11 @@ func (h *PaymentPlanHandler) UpdatePaymentPlan(w http.ResponseWriter, r *http.Re
 	}
 	plan.ID = uint(idUint)
 
+	if err := installment.Validate(&plan); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	if err := h.repo.Update(ctx, &plan); err != nil {
 		if err == repository.ErrPaymentPlanNotFound {
 			api.RespondWithError(w, http.StatusNotFound, "Payment plan not found")
@@ -0,0 +1,91 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"services/internal/api"
+	"services/internal/models"
+	"services/internal/money"
+)
+
+// recordAttempt remembers a session created for one of our orders so only
+// that session can later be captured against it. installmentID is set when
+// the session pays a later installment of a plan.
+func (h *PaymentHandler) recordAttempt(ctx context.Context, order *models.Order, provider PaymentProvider, charge Charge, installmentID *string, sessionID string) (*models.PaymentAttempt, error) {
+	total := charge.Total()
+	attempt := &models.PaymentAttempt{
+		OrderID:         order.ID,
+		Provider:        provider.Name(),
+		ProviderOrderID: sessionID,
+		Amount:          total.Amount,
+		Currency:        total.Currency,
+		Status:          "CREATED",
+		InstallmentID:   installmentID,
+	}
+	if err := h.attemptRepo.Create(ctx, attempt); err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+// captureMismatch compares a completed checkout capture with the order it is
+// meant to pay for and returns why they differ, or "" when they match
+func captureMismatch(order *models.Order, capture *CaptureResult) string {
+	return mismatch(order.ID, chargeFor(order).Total(), capture)
+}
+
+// mismatch checks a capture's reference and amount against what was charged
+func mismatch(orderID string, expected money.Money, capture *CaptureResult) string {
+	if capture.Reference != orderID {
+		return fmt.Sprintf("reference %q does not match order %s", capture.Reference, orderID)
+	}
+	if capture.Amount.Currency == "" {
+		return "captured amount is missing or not valid"
+	}
+	if capture.Amount != expected {
+		return fmt.Sprintf("captured %s, expected %s", capture.Amount, expected)
+	}
+	return ""
+}
+
+// recordMismatch flags an attempt whose capture did not match its order so it
+// shows up for review. The order itself is left untouched.
+func (h *PaymentHandler) recordMismatch(ctx context.Context, attempt *models.PaymentAttempt, captureID, reason string) {
+	h.logger.ErrorContext(ctx, "Capture does not match order", "order_id", attempt.OrderID, "provider", attempt.Provider, "session_id", attempt.ProviderOrderID, "capture_id", captureID, "reason", reason)
+	attempt.Status = "MISMATCH"
+	attempt.CaptureID = captureID
+	attempt.MismatchReason = reason
+	if err := h.attemptRepo.Update(ctx, attempt); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to record payment mismatch", "order_id", attempt.OrderID, "session_id", attempt.ProviderOrderID, "error", err)
+	}
+}
+
+// markAttemptCaptured records the capture on a verified attempt. Failing to
+// do so is logged but does not hold up fulfillment.
+func (h *PaymentHandler) markAttemptCaptured(ctx context.Context, attempt *models.PaymentAttempt, captureID string) {
+	attempt.Status = "CAPTURED"
+	attempt.CaptureID = captureID
+	if err := h.attemptRepo.Update(ctx, attempt); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to mark payment attempt captured", "order_id", attempt.OrderID, "session_id", attempt.ProviderOrderID, "error", err)
+	}
+}
+
+// ListPaymentAttempts lists attempts by status for review, defaulting to the
+// captures that did not match their order
+func (h *PaymentHandler) ListPaymentAttempts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "MISMATCH"
+	}
+
+	attempts, err := h.attemptRepo.FindByStatus(ctx, status)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to list payment attempts", "status", status, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to list payment attempts")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, attempts)
+}
@@ -0,0 +1,89 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/coupon"
+	"services/internal/models"
+	"services/internal/repository"
+	"slices"
+	"strings"
+	"time"
+)
+
+// couponUnavailableError is a coupon that other orders used up, or that
+// stopped applying, between pricing the cart and placing the order
+type couponUnavailableError struct {
+	Code string
+	Err  error
+}
+
+func (e *couponUnavailableError) Error() string {
+	return fmt.Sprintf("coupon %s: %v", e.Code, e.Err)
+}
+
+func (e *couponUnavailableError) Unwrap() error { return e.Err }
+
+// reserveCoupons redeems the order's coupons before it is paid, so usage
+// limits count orders still waiting for payment. Each coupon row is locked
+// while its usage is counted, which stops two checkouts from both taking the
+// last use. The order's own earlier reservations are released first, so a
+// retry counts them once.
+func reserveCoupons(ctx context.Context, repos repository.Repositories, order *models.Order, now time.Time) error {
+	if len(order.Discounts) == 0 {
+		return nil
+	}
+	if err := repos.Coupons.ReleaseRedemptions(ctx, order.ID); err != nil {
+		return err
+	}
+
+	// Lock in a fixed order so checkouts sharing coupons cannot deadlock
+	discounts := slices.Clone(order.Discounts)
+	slices.SortFunc(discounts, func(a, b models.OrderDiscount) int { return strings.Compare(a.CouponID, b.CouponID) })
+	for _, discount := range discounts {
+		c, err := repos.Coupons.FindByIDForUpdate(ctx, discount.CouponID)
+		if errors.Is(err, repository.ErrCouponNotFound) {
+			return &couponUnavailableError{Code: discount.Code, Err: coupon.ErrInactive}
+		}
+		if err != nil {
+			return err
+		}
+		usage, err := repos.Coupons.CountRedemptions(ctx, c.ID, order.UserID)
+		if err != nil {
+			return err
+		}
+		if err := coupon.Check(c, usage, now); err != nil {
+			return &couponUnavailableError{Code: discount.Code, Err: err}
+		}
+
+		redemption := models.CouponRedemption{
+			CouponID: discount.CouponID,
+			UserID:   order.UserID,
+			OrderID:  order.ID,
+			Amount:   discount.Amount,
+		}
+		if err := repos.Coupons.CreateRedemption(ctx, &redemption); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failOrder marks an unpaid order FAILED and gives back the coupon uses it
+// reserved. Orders that were paid in the meantime are left alone.
+func (h *PaymentHandler) failOrder(ctx context.Context, orderID string) error {
+	return h.uow.Do(ctx, func(repos repository.Repositories) error {
+		current, err := repos.Orders.FindByIDForUpdate(ctx, orderID)
+		if err != nil {
+			return err
+		}
+		if isFulfilled(current.Status) {
+			return nil
+		}
+		if err := repos.Orders.UpdateStatus(ctx, orderID, "FAILED"); err != nil {
+			return err
+		}
+		return repos.Coupons.ReleaseRedemptions(ctx, orderID)
+	})
+}
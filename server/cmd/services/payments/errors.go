@@ -1,5 +1,13 @@
 package payments
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"services/internal/paypal"
+)
+
 const (
 	// HTTP Status Codes commonly returned by PayPal
 	StatusUnprocessableEntity = "422"
@@ -45,3 +53,68 @@ const (
 	ErrInternalServerError = "INTERNAL_SERVER_ERROR"
 	ErrServiceUnavailable  = "SERVICE_UNAVAILABLE"
 )
+
+// captureOutcome is how a capture endpoint should react to a failed capture
+type captureOutcome int
+
+const (
+	// captureFailed means the payment will not go through; the order is FAILED
+	captureFailed captureOutcome = iota
+	// captureDeclined means the payer's instrument or account was refused
+	captureDeclined
+	// captureAlreadyDone means the provider already captured this payment for us
+	captureAlreadyDone
+	// captureRetryable means the provider had an outage; the order stays PENDING
+	captureRetryable
+)
+
+// classifyCaptureError maps a provider's capture error onto an outcome
+func classifyCaptureError(err error) captureOutcome {
+	switch {
+	case errors.Is(err, ErrAlreadyCaptured):
+		return captureAlreadyDone
+	case errors.Is(err, ErrPaymentDeclined):
+		return captureDeclined
+	case errors.Is(err, ErrProviderUnavailable):
+		return captureRetryable
+	}
+	return captureFailed
+}
+
+// declineIssues are the issue codes that mean the payer has to pay another way
+var declineIssues = []string{
+	ErrInstrumentDeclined,
+	ErrTransactionRefused,
+	ErrCardExpired,
+	ErrMaxPaymentAttemptsExceeded,
+	ErrPayerAccountLockedOrClosed,
+}
+
+// paypalError wraps a PayPal API error in the provider error it amounts to.
+// Only ORDER_ALREADY_CAPTURED counts as already captured: captures are not
+// sent with an invoice_id or request ID, so the duplicate codes cannot refer
+// to our order.
+func paypalError(err error) error {
+	var apiErr *paypal.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	if apiErr.HasIssue(ErrOrderAlreadyCaptured) {
+		return fmt.Errorf("%w: %w", ErrAlreadyCaptured, err)
+	}
+	for _, issue := range declineIssues {
+		if apiErr.HasIssue(issue) {
+			return fmt.Errorf("%w: %w", ErrPaymentDeclined, err)
+		}
+	}
+	if apiErr.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %w", ErrNotFoundAtProvider, err)
+	}
+	if apiErr.StatusCode >= http.StatusInternalServerError ||
+		apiErr.HasIssue(ErrInternalServerError) ||
+		apiErr.HasIssue(ErrServiceUnavailable) {
+		return fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+	return err
+}
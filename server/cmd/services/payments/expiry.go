@@ -0,0 +1,143 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"services/internal/models"
+	"services/internal/repository"
+	"time"
+)
+
+// DefaultPendingOrderTTL is how long an order can wait for payment before it
+// is cancelled, overridden by PENDING_ORDER_TTL (a Go duration such as 48h)
+const DefaultPendingOrderTTL = 24 * time.Hour
+
+func pendingOrderTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("PENDING_ORDER_TTL"))
+	if err != nil || ttl <= 0 {
+		return DefaultPendingOrderTTL
+	}
+	return ttl
+}
+
+// ExpireStaleOrders cancels PENDING orders older than PENDING_ORDER_TTL,
+// giving back the coupon uses they reserved. One order failing to expire is
+// logged and left for the next run.
+func (h *PaymentHandler) ExpireStaleOrders(ctx context.Context, now time.Time) error {
+	cutoff := now.Add(-pendingOrderTTL())
+	orders, err := h.orderRepo.FindByFilter(ctx, repository.OrderFilter{Status: "PENDING", To: &cutoff})
+	if err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		if err := h.expireOrder(ctx, order); err != nil {
+			h.logger.WarnContext(ctx, "Failed to expire pending order", "user_id", order.UserID, "order_id", order.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// expireOrder looks up the order's open sessions before cancelling it. One
+// that was captured after all is verified and fulfilled instead, and one
+// whose capture is still pending keeps the order waiting. The rest are
+// cancelled at providers that support it, so they can no longer be paid, and
+// voided here: captures of a cancelled order are refused, and providers
+// discard the sessions they cannot cancel once they expire. If a provider
+// cannot be reached the order is left for the next run.
+func (h *PaymentHandler) expireOrder(ctx context.Context, order *models.Order) error {
+	var open []*models.PaymentAttempt
+	for i := range order.Attempts {
+		attempt := &order.Attempts[i]
+		if attempt.Status != "CREATED" {
+			continue
+		}
+
+		provider, err := h.providers.Get(attempt.Provider)
+		if err != nil {
+			return fmt.Errorf("failed to look up session %s at %q: %w", attempt.ProviderOrderID, attempt.Provider, err)
+		}
+		result, err := provider.FetchStatus(ctx, attempt.ProviderOrderID)
+		if err != nil {
+			if errors.Is(err, ErrNotFoundAtProvider) {
+				// Already discarded by the provider
+				open = append(open, attempt)
+				continue
+			}
+			return fmt.Errorf("failed to look up session %s: %w", attempt.ProviderOrderID, err)
+		}
+
+		if result.Status == "COMPLETED" {
+			if reason := captureMismatch(order, result); reason != "" {
+				h.recordMismatch(ctx, attempt, result.CaptureID, reason)
+				continue
+			}
+			h.logger.InfoContext(ctx, "Pending order was paid, fulfilling instead of expiring", "user_id", order.UserID, "order_id", order.ID, "session_id", attempt.ProviderOrderID)
+			h.markAttemptCaptured(ctx, attempt, result.CaptureID)
+			return h.fulfillOrder(ctx, order, attempt, result.CaptureID)
+		}
+
+		if result.Status == "PENDING" {
+			// The payment may still complete, so the order waits for it
+			h.logger.InfoContext(ctx, "Pending order has a capture in progress, not expiring", "user_id", order.UserID, "order_id", order.ID, "session_id", attempt.ProviderOrderID)
+			return nil
+		}
+
+		if canceller, ok := provider.(SessionCanceller); ok && result.Status != "EXPIRED" {
+			if err := canceller.CancelSession(ctx, attempt.ProviderOrderID); err != nil && !errors.Is(err, ErrNotFoundAtProvider) {
+				return fmt.Errorf("failed to cancel session %s: %w", attempt.ProviderOrderID, err)
+			}
+		}
+		open = append(open, attempt)
+	}
+
+	cancelled := false
+	err := h.uow.Do(ctx, func(repos repository.Repositories) error {
+		current, err := repos.Orders.FindByIDForUpdate(ctx, order.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != "PENDING" {
+			return nil
+		}
+		cancelled = true
+		if err := repos.Orders.UpdateStatus(ctx, order.ID, "CANCELLED"); err != nil {
+			return err
+		}
+		return repos.Coupons.ReleaseRedemptions(ctx, order.ID)
+	})
+	if err != nil || !cancelled {
+		return err
+	}
+
+	for _, attempt := range open {
+		attempt.Status = "VOIDED"
+		if err := h.attemptRepo.Update(ctx, attempt); err != nil {
+			h.logger.ErrorContext(ctx, "Failed to void payment attempt", "order_id", order.ID, "session_id", attempt.ProviderOrderID, "error", err)
+		}
+	}
+	h.logger.InfoContext(ctx, "Expired pending order", "user_id", order.UserID, "order_id", order.ID, "created_at", order.CreatedAt)
+	return nil
+}
+
+// RunOrderExpiry runs ExpireStaleOrders now and then every interval until ctx
+// is cancelled. A run is skipped while another replica is expiring orders.
+func (h *PaymentHandler) RunOrderExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := h.jobs.TryDo(ctx, "order-expiry", func(ctx context.Context) error {
+			return h.ExpireStaleOrders(ctx, time.Now())
+		})
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Order expiry run failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
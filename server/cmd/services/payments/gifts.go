@@ -0,0 +1,131 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"services/internal/enrollment"
+	"services/internal/models"
+	"services/internal/repository"
+	"services/internal/service"
+	"strings"
+	"time"
+)
+
+// maxGiftMessage is the longest note a buyer can send with a gift
+const maxGiftMessage = 1000
+
+// GiftMailer sends enrollment codes to gift recipients so it can be mocked in
+// tests
+type GiftMailer interface {
+	SendGift(ctx context.Context, to, name, from, message string, courses []service.GiftedCourse) error
+}
+
+// giftRequest is the optional "gift" of a checkout. When it is set the whole
+// order is bought for the recipient.
+type giftRequest struct {
+	RecipientEmail string `json:"recipient_email"`
+	RecipientName  string `json:"recipient_name"`
+	Message        string `json:"message"`
+}
+
+// validate trims the request and checks the recipient can be emailed
+func (g *giftRequest) validate() error {
+	g.RecipientEmail = strings.TrimSpace(g.RecipientEmail)
+	g.RecipientName = strings.TrimSpace(g.RecipientName)
+	g.Message = strings.TrimSpace(g.Message)
+
+	addr, err := mail.ParseAddress(g.RecipientEmail)
+	if err != nil || addr.Address != g.RecipientEmail {
+		return errors.New("recipient email is invalid")
+	}
+	if len(g.Message) > maxGiftMessage {
+		return errors.New("message is too long")
+	}
+	return nil
+}
+
+// mintGiftCodes creates one enrollment code per course of a gift order. It
+// runs in the fulfillment transaction, so codes only exist for paid orders.
+func mintGiftCodes(ctx context.Context, repos repository.Repositories, order *models.Order) ([]*models.EnrollmentCode, error) {
+	codes := make([]*models.EnrollmentCode, 0, len(order.Items))
+	for _, item := range order.Items {
+		code, err := enrollment.NewCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, &models.EnrollmentCode{
+			Code:           code,
+			Kind:           models.EnrollmentCodeGift,
+			CourseID:       item.CourseID,
+			IssuedByID:     order.UserID,
+			OrderID:        &order.ID,
+			OrderItemID:    &item.ID,
+			RecipientEmail: order.GiftRecipientEmail,
+			RecipientName:  order.GiftRecipientName,
+			Message:        order.GiftMessage,
+		})
+	}
+	if err := repos.Codes.Create(ctx, codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// emailGift sends the recipient of a gift order the codes minted for it. The
+// buyer can still see the codes in their gifts if the email fails.
+func (h *PaymentHandler) emailGift(ctx context.Context, order *models.Order, codes []*models.EnrollmentCode) {
+	names := make(map[string]string, len(order.Items))
+	for _, item := range order.Items {
+		names[item.CourseID] = item.CourseName
+	}
+	courses := make([]service.GiftedCourse, 0, len(codes))
+	for _, code := range codes {
+		courses = append(courses, service.GiftedCourse{
+			Course: names[code.CourseID],
+			Code:   code.Code,
+			Link:   enrollment.RedeemLink(code.Code),
+		})
+	}
+
+	var from string
+	if buyer, err := h.userRepo.FindByID(ctx, order.UserID); err == nil {
+		from = buyer.Name
+	}
+	if err := h.gifts.SendGift(ctx, order.GiftRecipientEmail, order.GiftRecipientName, from, order.GiftMessage, courses); err != nil {
+		h.logger.WarnContext(ctx, "Failed to email gift", "user_id", order.UserID, "order_id", order.ID, "error", err)
+	}
+}
+
+// revokeGift withdraws the codes of a refunded gift order. Codes nobody has
+// redeemed stop working and whoever redeemed one loses the course, unless
+// another order or code also grants it to them.
+func (h *PaymentHandler) revokeGift(ctx context.Context, order *models.Order) {
+	revoked, err := h.codeRepo.RevokeByOrderID(ctx, order.ID, time.Now())
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to revoke gift codes after refund", "order_id", order.ID, "error", err)
+		return
+	}
+	codes, err := h.codeRepo.FindByOrderID(ctx, order.ID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to load gift codes after refund", "order_id", order.ID, "error", err)
+		return
+	}
+	for _, code := range codes {
+		if code.RedeemedByID == nil {
+			continue
+		}
+		granted, err := h.courseGrantedElsewhere(ctx, *code.RedeemedByID, code.CourseID, order.ID)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Failed to check other grants of gifted course, keeping access", "user_id", *code.RedeemedByID, "course_id", code.CourseID, "error", err)
+			continue
+		}
+		if granted {
+			continue
+		}
+		if err := h.userRepo.RevokeCourse(ctx, *code.RedeemedByID, code.CourseID); err != nil {
+			h.logger.ErrorContext(ctx, "Failed to revoke gifted course after refund", "user_id", *code.RedeemedByID, "course_id", code.CourseID, "error", err)
+		}
+	}
+	h.logger.InfoContext(ctx, "Revoked gift codes after refund", "order_id", order.ID, "unredeemed", revoked)
+}
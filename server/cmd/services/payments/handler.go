@@ -1,45 +1,83 @@
 package payments
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"services/internal/api"
+	"services/internal/installment"
 	"services/internal/models"
+	"services/internal/money"
 	"services/internal/paypal"
 	"services/internal/repository"
-	"strings"
+	"services/internal/service"
+	"services/internal/stripe"
+	"services/internal/tax"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	"gorm.io/gorm"
 )
 
-// PayPalClient is an interface for PayPal operations so it can be mocked in tests.
-type PayPalClient interface {
-	CreateOrder(amount float64, orderID string) (string, string, error)
-	CaptureOrder(orderID string) (string, error)
-}
-
 type PaymentHandler struct {
-	logger       *slog.Logger
-	repo         repository.PaymentRepository
-	orderRepo    repository.OrderRepository
-	cartRepo     repository.CartRepository
-	userRepo     repository.UserRepository
-	db           *gorm.DB
-	paypalClient PayPalClient
+	logger          *slog.Logger
+	repo            repository.PaymentRepository
+	orderRepo       repository.OrderRepository
+	cartRepo        repository.CartRepository
+	courseRepo      repository.CourseRepository
+	userRepo        repository.UserRepository
+	webhookRepo     repository.WebhookEventRepository
+	attemptRepo     repository.PaymentAttemptRepository
+	taxRepo         repository.TaxRateRepository
+	installmentRepo repository.InstallmentRepository
+	invoiceRepo     repository.InvoiceRepository
+	codeRepo        repository.EnrollmentCodeRepository
+	settingsRepo    repository.SettingsRepository
+	uow             repository.UnitOfWork
+	jobs            repository.JobLocker
+	db              *gorm.DB
+	providers       *Providers
+	mailer          ReceiptMailer
+	gifts           GiftMailer
+	reminders       InstallmentMailer
+
+	receipts sync.WaitGroup // receipts and gift codes still being emailed
 }
 
 func NewPaymentHandler(logger *slog.Logger, db *gorm.DB) *PaymentHandler {
+	settingsRepo := repository.NewPostgresSettingsRepository(db)
+	// Card payments are only offered once Stripe has been set up
+	var cards []PaymentProvider
+	if client := stripe.NewClient(); client.Configured() {
+		cards = append(cards, newStripeProvider(client))
+	}
+	notifications := service.NewNotificationService(logger, settingsRepo)
 	return &PaymentHandler{
-		logger:       logger,
-		repo:         repository.NewPostgresPaymentRepository(db),
-		orderRepo:    repository.NewPostgresOrderRepository(db),
-		cartRepo:     repository.NewPostgresCartRepository(db),
-		userRepo:     repository.NewPostgresUserRepository(db),
-		db:           db,
-		paypalClient: paypal.NewClient(),
+		logger:          logger,
+		repo:            repository.NewPostgresPaymentRepository(db),
+		orderRepo:       repository.NewPostgresOrderRepository(db),
+		cartRepo:        repository.NewPostgresCartRepository(db),
+		courseRepo:      repository.NewPostgresCourseRepository(db),
+		userRepo:        repository.NewPostgresUserRepository(db),
+		webhookRepo:     repository.NewPostgresWebhookEventRepository(db),
+		attemptRepo:     repository.NewPostgresPaymentAttemptRepository(db),
+		taxRepo:         repository.NewPostgresTaxRateRepository(db),
+		installmentRepo: repository.NewPostgresInstallmentRepository(db),
+		invoiceRepo:     repository.NewPostgresInvoiceRepository(db),
+		codeRepo:        repository.NewPostgresEnrollmentCodeRepository(db),
+		settingsRepo:    settingsRepo,
+		uow:             repository.NewPostgresUnitOfWork(db),
+		jobs:            repository.NewPostgresJobLocker(db),
+		db:              db,
+		providers:       NewProviders(newPayPalProvider(paypal.NewClient()), cards...),
+		mailer:          notifications,
+		gifts:           notifications,
+		reminders:       notifications,
 	}
 }
 
@@ -54,6 +92,32 @@ func (h *PaymentHandler) Checkout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The province can be given at checkout, otherwise the profile's is used.
+	// The provider defaults to PayPal. A gift sends the courses to someone
+	// else instead of enrolling the buyer.
+	var req struct {
+		Province string       `json:"province"`
+		Provider string       `json:"provider"`
+		Gift     *giftRequest `json:"gift"`
+	}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+	provider, err := h.providers.Get(req.Provider)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Unknown payment provider")
+		return
+	}
+	if req.Gift != nil {
+		if err := req.Gift.validate(); err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid gift: "+err.Error())
+			return
+		}
+	}
+
 	// 2. Get Cart
 	cart, err := h.cartRepo.GetCartByUserID(ctx, userID)
 	if err != nil || len(cart.Items) == 0 {
@@ -61,48 +125,193 @@ func (h *PaymentHandler) Checkout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 3. Calculate total
-	total, err := h.cartRepo.GetCartTotal(ctx, cart.ID)
-	if err != nil || total <= 0 {
+	for _, item := range cart.Items {
+		if item.PaymentPlanID != nil && item.PaymentPlan == nil {
+			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("The payment plan for %s is no longer available", item.Course.Name))
+			return
+		}
+		// Defaulting on a plan suspends the buyer's access, which a gift
+		// recipient does not have
+		if item.PaymentPlanID != nil && req.Gift != nil {
+			api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s cannot be gifted on a payment plan", item.Course.Name))
+			return
+		}
+	}
+
+	// Prices and availability may have changed since the items were added.
+	// The buyer reviews any change before being charged.
+	warnings, err := h.revalidateCart(ctx, userID, cart, req.Gift != nil)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to revalidate cart", "user_id", userID, "cart_id", cart.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to check cart")
+		return
+	}
+	if len(warnings) > 0 {
+		h.logger.InfoContext(ctx, "Cart changed since items were added", "user_id", userID, "cart_id", cart.ID, "warnings", len(warnings))
+		api.RespondWithJSON(w, http.StatusConflict, map[string]any{
+			"error":    "Your cart has changed, please review it before paying",
+			"warnings": warnings,
+		})
+		return
+	}
+
+	// 3. Calculate total after coupons
+	quote, err := h.cartRepo.GetCartQuote(ctx, cart.ID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid cart total")
+		return
+	}
+	if rejected := quote.Rejection(); rejected != nil {
+		api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Coupon %s can no longer be applied: %s", rejected.Code, rejected.Reason))
+		return
+	}
+	// A cart can only come to zero through its coupons; such orders are
+	// fulfilled without a payment session
+	free := quote.Total == 0 && quote.Discount > 0
+	if quote.Total <= 0 && !free {
 		api.RespondWithError(w, http.StatusBadRequest, "Invalid cart total")
 		return
 	}
 
-	// 4. Create Order with Items
+	// 4. Work out sales tax on the discounted prices
+	province, err := h.taxProvince(ctx, userID, req.Province)
+	if err != nil {
+		switch {
+		case errors.Is(err, errProvinceRequired):
+			api.RespondWithError(w, http.StatusBadRequest, "Province is required to calculate sales tax")
+		case errors.Is(err, tax.ErrUnknownProvince):
+			api.RespondWithError(w, http.StatusBadRequest, "Unknown province")
+		default:
+			h.logger.ErrorContext(ctx, "Failed to get buyer's province", "user_id", userID, "error", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "Failed to calculate tax")
+		}
+		return
+	}
+	rates, err := h.taxRepo.FindByProvince(ctx, province)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to load tax rates", "province", province, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to calculate tax")
+		return
+	}
+	if len(rates) == 0 {
+		h.logger.WarnContext(ctx, "No tax rates configured for province", "province", province)
+	}
+	var taxLines []tax.Line
+	for _, item := range cart.Items {
+		taxLines = append(taxLines, tax.Line{ID: item.ID, Amount: item.Price - quote.ItemDiscounts[item.ID]})
+	}
+	taxes := tax.Calculate(rates, taxLines)
+
+	// 5. Create Order with Items, the discount lines, the tax lines and the
+	// installment schedule for items bought on a payment plan
 	var orderItems []models.OrderItem
 	for _, item := range cart.Items {
+		installments := 1
+		if item.PaymentPlan != nil {
+			installments = installment.Count(item.PaymentPlan)
+		}
 		orderItems = append(orderItems, models.OrderItem{
-			CourseID: item.CourseID,
-			Price:    item.Price,
+			CourseID:       item.CourseID,
+			Price:          item.Price,
+			Discount:       quote.ItemDiscounts[item.ID],
+			Tax:            taxes.Lines[item.ID],
+			PaymentPlanID:  item.PaymentPlanID,
+			Installments:   installments,
+			CourseName:     item.Course.Name,
+			CourseImageURL: item.Course.ImageURL,
+		})
+	}
+	var orderTaxes []models.OrderTax
+	for _, component := range taxes.Components {
+		orderTaxes = append(orderTaxes, models.OrderTax{
+			Name:   component.Name,
+			Rate:   component.Rate,
+			Amount: component.Amount,
+		})
+	}
+	var discounts []models.OrderDiscount
+	for _, line := range quote.Applied {
+		discounts = append(discounts, models.OrderDiscount{
+			CouponID: line.CouponID,
+			Code:     line.Code,
+			Amount:   line.Amount,
 		})
 	}
 
 	order := models.Order{
 		UserID:      userID,
-		TotalAmount: total,
+		Subtotal:    quote.Subtotal,
+		Discount:    quote.Discount,
+		TaxTotal:    taxes.Total,
+		TaxProvince: province,
+		TotalAmount: quote.Total + taxes.Total,
+		Currency:    money.Currency(),
 		Status:      "PENDING",
 		Items:       orderItems,
+		Discounts:   discounts,
+		Taxes:       orderTaxes,
+	}
+	if req.Gift != nil {
+		order.GiftRecipientEmail = req.Gift.RecipientEmail
+		order.GiftRecipientName = req.Gift.RecipientName
+		order.GiftMessage = req.Gift.Message
+	}
+	if !free {
+		order.Installments = scheduleInstallments(orderItems, time.Now())
 	}
 
-	if err := h.orderRepo.Create(ctx, &order); err != nil {
+	// The order reserves its coupons as it is placed, so other checkouts
+	// see them used
+	err = h.uow.Do(ctx, func(repos repository.Repositories) error {
+		if err := repos.Orders.Create(ctx, &order); err != nil {
+			return err
+		}
+		return reserveCoupons(ctx, repos, &order, time.Now())
+	})
+	var unavailable *couponUnavailableError
+	if errors.As(err, &unavailable) {
+		api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Coupon %s can no longer be applied: %s", unavailable.Code, unavailable.Err))
+		return
+	}
+	if err != nil {
 		h.logger.ErrorContext(ctx, "Failed to create order", "user_id", userID, "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create order")
 		return
 	}
 
-	// 6. Create PayPal Order
-	paypalOrderID, approveURL, err := h.paypalClient.CreateOrder(total, order.ID)
+	if free {
+		if err := h.fulfillOrder(ctx, &order, nil, ""); err != nil {
+			api.RespondWithError(w, http.StatusInternalServerError, "Failed to complete order")
+			return
+		}
+		h.logger.InfoContext(ctx, "Fulfilled order covered by coupons", "user_id", userID, "order_id", order.ID)
+		api.RespondWithJSON(w, http.StatusOK, map[string]string{
+			"order_id": order.ID,
+			"status":   "success",
+		})
+		return
+	}
+
+	// 6. Create a payment session for the total, or the first installment
+	charge := chargeFor(&order)
+	session, err := provider.CreateSession(ctx, charge, order.ID)
 	if err != nil {
-		h.logger.ErrorContext(ctx, "Failed to create PayPal order", "user_id", userID, "error", err)
+		h.logger.ErrorContext(ctx, "Failed to create payment session", "user_id", userID, "provider", provider.Name(), "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Payment provider error")
 		return
 	}
 
-	h.logger.InfoContext(ctx, "Created PayPal order", "user_id", userID, "paypal_order_id", paypalOrderID, "order_id", order.ID)
+	h.logger.InfoContext(ctx, "Created payment session", "user_id", userID, "provider", provider.Name(), "session_id", session.ID, "order_id", order.ID)
+
+	if _, err := h.recordAttempt(ctx, &order, provider, charge, nil, session.ID); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to record payment attempt", "user_id", userID, "session_id", session.ID, "order_id", order.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create order")
+		return
+	}
 
 	api.RespondWithJSON(w, http.StatusOK, map[string]string{
 		"order_id":    order.ID,
-		"approve_url": approveURL,
+		"approve_url": session.RedirectURL,
 	})
 }
 
@@ -126,74 +335,252 @@ func (h *PaymentHandler) CaptureCheckout(w http.ResponseWriter, r *http.Request)
 		api.RespondWithError(w, http.StatusNotFound, "Order not found")
 		return
 	}
+	if order.UserID != userID {
+		h.logger.WarnContext(ctx, "Capture attempted on another user's order", "user_id", userID, "order_id", req.OrderID)
+		api.RespondWithError(w, http.StatusNotFound, "Order not found")
+		return
+	}
 
-	// If already completed, return success immediately (Idempotency)
+	// If already completed, return success immediately (Idempotency). A
+	// refunded order was paid once already and is never charged again.
 	if order.Status == "COMPLETED" {
 		h.logger.InfoContext(ctx, "Order already COMPLETED, skipping double capture", "user_id", userID, "order_id", req.OrderID)
 		api.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "success", "message": "already_completed"})
 		return
 	}
+	if isFulfilled(order.Status) {
+		h.logger.WarnContext(ctx, "Capture attempted on a refunded order", "user_id", userID, "order_id", req.OrderID, "status", order.Status)
+		api.RespondWithError(w, http.StatusConflict, "Order has already been paid")
+		return
+	}
+	if order.Status == "CANCELLED" {
+		api.RespondWithError(w, http.StatusConflict, "Order has expired, please check out again")
+		return
+	}
 
-	// 2. Capture PayPal Order
-	status, err := h.paypalClient.CaptureOrder(req.Token)
+	// 2. The session must be one we created for this order
+	attempt, err := h.attemptRepo.FindByProviderOrderID(ctx, req.Token)
+	if err != nil || attempt.OrderID != order.ID {
+		h.logger.WarnContext(ctx, "Payment session is not an attempt of this order", "user_id", userID, "order_id", req.OrderID, "session_id", req.Token, "error", err)
+		api.RespondWithError(w, http.StatusBadRequest, "Payment does not match this order")
+		return
+	}
+	provider, err := h.providers.Get(attempt.Provider)
 	if err != nil {
-		h.logger.ErrorContext(ctx, "Failed to capture PayPal order", "user_id", userID, "error", err)
-
-		// Check if error is due to order already being captured
-		isAlreadyCaptured := (strings.Contains(err.Error(), ErrOrderAlreadyCaptured) ||
-			strings.Contains(err.Error(), ErrDuplicateResource) ||
-			strings.Contains(err.Error(), StatusUnprocessableEntity) ||
-			strings.Contains(err.Error(), StatusBadRequest))
+		h.logger.ErrorContext(ctx, "Payment attempt has an unknown provider", "order_id", req.OrderID, "provider", attempt.Provider)
+		api.RespondWithError(w, http.StatusInternalServerError, "Payment provider error")
+		return
+	}
 
-		if isAlreadyCaptured {
-			h.logger.InfoContext(ctx, "PayPal reported order already captured or duplicate. Marking as COMPLETED.", "user_id", userID, "order_id", req.OrderID)
-			status = "COMPLETED"
-		} else {
-			_ = h.orderRepo.UpdateStatus(ctx, req.OrderID, "FAILED")
+	// 3. Capture the payment
+	capture, err := provider.Capture(ctx, req.Token)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to capture payment", "user_id", userID, "provider", provider.Name(), "error", err)
+
+		switch classifyCaptureError(err) {
+		case captureAlreadyDone:
+			// Look the capture up so it is verified like a fresh one
+			h.logger.InfoContext(ctx, "Provider reported payment already captured, fetching it", "user_id", userID, "order_id", req.OrderID)
+			capture, err = provider.FetchStatus(ctx, req.Token)
+			if err != nil {
+				h.logger.ErrorContext(ctx, "Failed to fetch captured payment", "user_id", userID, "order_id", req.OrderID, "error", err)
+				api.RespondWithError(w, http.StatusBadGateway, "Payment provider unavailable, please try again")
+				return
+			}
+		case captureRetryable:
+			// Leave the order PENDING so the buyer can retry once the provider recovers
+			api.RespondWithError(w, http.StatusBadGateway, "Payment provider unavailable, please try again")
+			return
+		case captureDeclined:
+			_ = h.failOrder(ctx, req.OrderID)
+			api.RespondWithError(w, http.StatusBadRequest, "Payment was declined")
+			return
+		default:
+			_ = h.failOrder(ctx, req.OrderID)
 			api.RespondWithError(w, http.StatusBadRequest, "Failed to capture payment")
 			return
 		}
 	}
+	status := capture.Status
+	transactionID := req.Token
+	if capture.CaptureID != "" {
+		transactionID = capture.CaptureID
+	}
+
+	// 4. Check the capture is for this order, amount and currency
+	if status == "COMPLETED" {
+		if reason := captureMismatch(order, capture); reason != "" {
+			h.recordMismatch(ctx, attempt, capture.CaptureID, reason)
+			api.RespondWithError(w, http.StatusBadRequest, "Payment does not match this order")
+			return
+		}
+		h.markAttemptCaptured(ctx, attempt, capture.CaptureID)
+	}
 
 	if status == "COMPLETED" {
-		if err := h.orderRepo.UpdateStatus(ctx, req.OrderID, "COMPLETED"); err != nil {
-			h.logger.ErrorContext(ctx, "Failed to update order status to COMPLETED", "user_id", userID, "order_id", req.OrderID, "error", err)
+		if err := h.fulfillOrder(ctx, order, attempt, transactionID); err != nil {
+			// The money is captured but nothing was written; the order stays
+			// PENDING and capturing again fetches the finished capture
+			api.RespondWithError(w, http.StatusInternalServerError, "Payment captured but the order could not be completed, please retry")
+			return
 		}
 
-		// Re-fetch or use existing order object if it hasn't changed (Items are needed)
-		if order.Items == nil {
-			order, _ = h.orderRepo.FindByID(ctx, req.OrderID)
+		api.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "success"})
+	} else if status == "PENDING" {
+		// An eCheck or a capture under review completes later, through the
+		// webhook. The order stays PENDING until then.
+		if err := h.recordCapturePayment(ctx, order, attempt.Provider, req.Token, transactionID, "PENDING"); err != nil {
+			h.logger.ErrorContext(ctx, "Failed to record pending payment", "user_id", userID, "order_id", req.OrderID, "capture_id", transactionID, "error", err)
 		}
+		h.logger.InfoContext(ctx, "Capture is pending at the provider", "user_id", userID, "order_id", req.OrderID, "capture_id", transactionID)
+		api.RespondWithJSON(w, http.StatusAccepted, map[string]string{"status": "pending", "message": "Payment is being processed, access is granted once it completes"})
+	} else {
+		_ = h.failOrder(ctx, req.OrderID)
+		api.RespondWithError(w, http.StatusBadRequest, "Payment not completed")
+	}
+}
 
-		payment := models.Payment{
-			OrderID:             req.OrderID,
-			TransactionAmount:   order.TotalAmount,
-			TransactionMethod:   "PAYPAL",
-			TransactionStatus:   "SUCCESS",
-			PayPalTransactionID: req.Token,
+// fulfillOrder marks an order COMPLETED, records the successful payment,
+// enrolls the buyer in every purchased course (or, for a gift, mints an
+// enrollment code per course for the recipient), redeems its coupons and
+// clears their cart. On a payment plan the payment is the first installment,
+// which is marked paid. It is
+// shared by the browser capture flow and the provider webhooks. attempt is
+// the session the payment was made through, or nil for an order its coupons
+// paid for in full, which records no payment.
+//
+// All steps run in one transaction, so either every write lands or none do.
+// The order row is locked first and already fulfilled orders are skipped,
+// which makes it safe to call again after a failure or a concurrent webhook.
+func (h *PaymentHandler) fulfillOrder(ctx context.Context, order *models.Order, attempt *models.PaymentAttempt, captureID string) error {
+	var issued *models.Invoice
+	var gifted []*models.EnrollmentCode
+	err := h.uow.Do(ctx, func(repos repository.Repositories) error {
+		current, err := repos.Orders.FindByIDForUpdate(ctx, order.ID)
+		if err != nil {
+			return err
 		}
-		if err := h.repo.Create(ctx, &payment); err != nil {
-			h.logger.ErrorContext(ctx, "Failed to create payment record", "user_id", userID, "order_id", req.OrderID, "error", err)
+		if isFulfilled(current.Status) {
+			h.logger.InfoContext(ctx, "Order already fulfilled, skipping", "user_id", order.UserID, "order_id", order.ID, "status", current.Status)
+			return nil
 		}
 
-		for _, item := range order.Items {
-			if err := h.userRepo.AssignCourse(ctx, userID, item.CourseID); err != nil {
-				h.logger.ErrorContext(ctx, "Failed to assign course to user", "user_id", userID, "course_id", item.CourseID, "error", err)
+		if err := repos.Orders.UpdateStatus(ctx, order.ID, "COMPLETED"); err != nil {
+			return err
+		}
+
+		var payment *models.Payment
+		if attempt != nil {
+			payment, err = repos.Payments.FindByProviderTransactionID(ctx, captureID)
+			if err == nil && payment.TransactionStatus == "PENDING" {
+				// A capture recorded while pending has now gone through
+				payment.TransactionStatus = "SUCCESS"
+				err = repos.Payments.Update(ctx, payment)
+			}
+			if errors.Is(err, repository.ErrPaymentNotFound) {
+				payment = &models.Payment{
+					OrderID:               order.ID,
+					TransactionAmount:     chargeFor(order).Total().Amount,
+					Currency:              order.Currency,
+					TransactionMethod:     attempt.Provider,
+					TransactionStatus:     "SUCCESS",
+					Provider:              attempt.Provider,
+					ProviderSessionID:     attempt.ProviderOrderID,
+					ProviderTransactionID: captureID,
+				}
+				err = repos.Payments.Create(ctx, payment)
+			}
+			if err != nil {
+				return err
 			}
 		}
 
-		cart, _ := h.cartRepo.GetCartByUserID(ctx, userID)
-		if cart != nil {
-			if err := h.cartRepo.ClearCart(ctx, cart.ID); err != nil {
-				h.logger.ErrorContext(ctx, "Failed to clear cart", "cart_id", cart.ID, "error", err)
+		if first := firstInstallment(order); first != nil && payment != nil {
+			paidAt := time.Now()
+			first.Status = installment.StatusPaid
+			first.PaidAt = &paidAt
+			first.PaymentID = &payment.ID
+			if err := repos.Installments.Update(ctx, first); err != nil {
+				return err
 			}
 		}
 
-		api.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "success"})
-	} else {
-		_ = h.orderRepo.UpdateStatus(ctx, req.OrderID, "FAILED")
-		api.RespondWithError(w, http.StatusBadRequest, "Payment not completed")
+		issued, err = h.issueInvoice(ctx, repos, order, payment)
+		if err != nil {
+			return err
+		}
+
+		if order.IsGift() {
+			gifted, err = mintGiftCodes(ctx, repos, order)
+			if err != nil {
+				return err
+			}
+		} else {
+			for _, item := range order.Items {
+				if err := repos.Users.AssignCourse(ctx, order.UserID, item.CourseID); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Coupons were reserved at checkout. An order that failed in the
+		// meantime gave them back, but it is paid now so they are used.
+		for _, discount := range order.Discounts {
+			redemption := models.CouponRedemption{
+				CouponID: discount.CouponID,
+				UserID:   order.UserID,
+				OrderID:  order.ID,
+				Amount:   discount.Amount,
+			}
+			if err := repos.Coupons.CreateRedemption(ctx, &redemption); err != nil {
+				return err
+			}
+		}
+
+		cart, err := repos.Carts.GetCartByUserID(ctx, order.UserID)
+		if errors.Is(err, repository.ErrCartNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return repos.Carts.ClearCart(ctx, cart.ID)
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to fulfill order", "user_id", order.UserID, "order_id", order.ID, "error", err)
+		return fmt.Errorf("failed to fulfill order %s: %w", order.ID, err)
+	}
+
+	if issued != nil {
+		h.receipts.Add(1)
+		go func() {
+			defer h.receipts.Done()
+			h.emailReceipt(context.WithoutCancel(ctx), issued)
+		}()
+	}
+	if len(gifted) > 0 {
+		h.receipts.Add(1)
+		go func() {
+			defer h.receipts.Done()
+			h.emailGift(context.WithoutCancel(ctx), order, gifted)
+		}()
+	}
+	return nil
+}
+
+// WaitForReceipts blocks until receipts of orders fulfilled so far have been
+// emailed, for commands that exit once they are done
+func (h *PaymentHandler) WaitForReceipts() {
+	h.receipts.Wait()
+}
+
+// isFulfilled reports whether an order has already been through fulfillment
+func isFulfilled(status string) bool {
+	switch status {
+	case "COMPLETED", "PARTIALLY_REFUNDED", "REFUNDED":
+		return true
 	}
+	return false
 }
 
 func (h *PaymentHandler) RetryOrder(w http.ResponseWriter, r *http.Request) {
@@ -201,32 +588,94 @@ func (h *PaymentHandler) RetryOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	userID, _ := ctx.Value(models.UserIDContextKey).(string)
+
 	order, err := h.orderRepo.FindByID(ctx, id)
 	if err != nil {
 		api.RespondWithError(w, http.StatusNotFound, "Order not found")
 		return
 	}
+	if order.UserID != userID {
+		h.logger.WarnContext(ctx, "Retry attempted on another user's order", "user_id", userID, "order_id", id)
+		api.RespondWithError(w, http.StatusNotFound, "Order not found")
+		return
+	}
 
-	if order.Status == "COMPLETED" {
+	// Refunded orders were paid once, a new session would charge them again
+	if isFulfilled(order.Status) {
 		api.RespondWithError(w, http.StatusBadRequest, "Order already completed")
 		return
 	}
+	if order.Status == "CANCELLED" {
+		api.RespondWithError(w, http.StatusConflict, "Order has expired, please check out again")
+		return
+	}
 
-	paypalOrderID, approveURL, err := h.paypalClient.CreateOrder(order.TotalAmount, order.ID)
+	provider, ok := h.requestedProvider(w, r)
+	if !ok {
+		return
+	}
+
+	// A failed order gave back its coupons, it needs them again to be paid
+	if order.Status == "FAILED" {
+		err := h.uow.Do(ctx, func(repos repository.Repositories) error {
+			return reserveCoupons(ctx, repos, order, time.Now())
+		})
+		var unavailable *couponUnavailableError
+		if errors.As(err, &unavailable) {
+			api.RespondWithError(w, http.StatusConflict, fmt.Sprintf("Coupon %s can no longer be applied: %s, please check out again", unavailable.Code, unavailable.Err))
+			return
+		}
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Failed to reserve coupons for retry", "order_id", order.ID, "error", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "Failed to retry order")
+			return
+		}
+	}
+
+	charge := chargeFor(order)
+	session, err := provider.CreateSession(ctx, charge, order.ID)
 	if err != nil {
-		h.logger.ErrorContext(ctx, "Failed to retry PayPal order", "error", err)
+		h.logger.ErrorContext(ctx, "Failed to retry payment session", "provider", provider.Name(), "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Payment provider error")
 		return
 	}
 
-	h.logger.InfoContext(ctx, "Retrying PayPal order", "paypal_order_id", paypalOrderID, "order_id", order.ID)
+	h.logger.InfoContext(ctx, "Retrying payment", "provider", provider.Name(), "session_id", session.ID, "order_id", order.ID)
+
+	if _, err := h.recordAttempt(ctx, order, provider, charge, nil, session.ID); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to record payment attempt", "session_id", session.ID, "order_id", order.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to retry order")
+		return
+	}
 
 	api.RespondWithJSON(w, http.StatusOK, map[string]string{
 		"order_id":    order.ID,
-		"approve_url": approveURL,
+		"approve_url": session.RedirectURL,
 	})
 }
 
+// requestedProvider reads the provider a retry or installment payment should
+// go through from an optional {"provider": ...} body. It responds itself when
+// the body or provider is invalid.
+func (h *PaymentHandler) requestedProvider(w http.ResponseWriter, r *http.Request) (PaymentProvider, bool) {
+	var req struct {
+		Provider string `json:"provider"`
+	}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return nil, false
+		}
+	}
+	provider, err := h.providers.Get(req.Provider)
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Unknown payment provider")
+		return nil, false
+	}
+	return provider, true
+}
+
 // === LEGACY PAYMENT ENDPOINTS ===
 
 func (h *PaymentHandler) CreatePayment(w http.ResponseWriter, r *http.Request) {
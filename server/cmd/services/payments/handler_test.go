@@ -10,9 +10,21 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"services/internal/coupon"
 	"services/internal/models"
+	"services/internal/money"
+	"services/internal/paypal"
+	"services/internal/paypal/paypaltest"
 	"services/internal/repository"
+	"services/internal/service"
+	"services/internal/stripe"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -20,22 +32,53 @@ import (
 // ===================== Mock PayPal Client =====================
 
 type mockPayPalClient struct {
-	createOrderFn  func(amount float64, orderID string) (string, string, error)
-	captureOrderFn func(orderID string) (string, error)
+	createOrderFn   func(total money.Money, orderID string) (string, string, error)
+	captureOrderFn  func(orderID string) (*paypal.CaptureResult, error)
+	getOrderFn      func(orderID string) (*paypal.CaptureResult, error)
+	getCaptureFn    func(captureID string) (*paypal.CaptureDetails, error)
+	refundCaptureFn func(captureID string, amount money.Money) (*paypal.RefundResult, error)
+	verifyErr       error
+	lastCharge      paypal.Charge
 }
 
-func (m *mockPayPalClient) CreateOrder(amount float64, orderID string) (string, string, error) {
+func (m *mockPayPalClient) CreateOrder(ctx context.Context, charge paypal.Charge, orderID string) (string, string, error) {
+	m.lastCharge = charge
 	if m.createOrderFn != nil {
-		return m.createOrderFn(amount, orderID)
+		return m.createOrderFn(charge.Total(), orderID)
 	}
 	return "PAYPAL_ORDER_123", "https://paypal.com/approve/123", nil
 }
 
-func (m *mockPayPalClient) CaptureOrder(orderID string) (string, error) {
+func (m *mockPayPalClient) CaptureOrder(ctx context.Context, orderID string) (*paypal.CaptureResult, error) {
 	if m.captureOrderFn != nil {
 		return m.captureOrderFn(orderID)
 	}
-	return "COMPLETED", nil
+	return &paypal.CaptureResult{Status: "COMPLETED", CaptureID: "CAPTURE-" + orderID}, nil
+}
+
+func (m *mockPayPalClient) GetOrder(ctx context.Context, orderID string) (*paypal.CaptureResult, error) {
+	if m.getOrderFn != nil {
+		return m.getOrderFn(orderID)
+	}
+	return nil, errors.New("order not found")
+}
+
+func (m *mockPayPalClient) GetCapture(ctx context.Context, captureID string) (*paypal.CaptureDetails, error) {
+	if m.getCaptureFn != nil {
+		return m.getCaptureFn(captureID)
+	}
+	return nil, errors.New("capture not found")
+}
+
+func (m *mockPayPalClient) RefundCapture(ctx context.Context, captureID string, amount money.Money, orderID, note, requestID string) (*paypal.RefundResult, error) {
+	if m.refundCaptureFn != nil {
+		return m.refundCaptureFn(captureID, amount)
+	}
+	return &paypal.RefundResult{ID: "REFUND-1", Status: "COMPLETED"}, nil
+}
+
+func (m *mockPayPalClient) VerifyWebhookSignature(ctx context.Context, headers http.Header, body []byte) error {
+	return m.verifyErr
 }
 
 // ===================== Mock Repositories =====================
@@ -49,6 +92,9 @@ func (m *mockPaymentRepo) Create(ctx context.Context, payment *models.Payment) e
 	if m.createFn != nil {
 		return m.createFn(ctx, payment)
 	}
+	if payment.ID == "" {
+		payment.ID = fmt.Sprintf("created-payment-%d", len(m.payments)+1)
+	}
 	m.payments = append(m.payments, payment)
 	return nil
 }
@@ -63,6 +109,14 @@ func (m *mockPaymentRepo) FindByID(ctx context.Context, id string) (*models.Paym
 func (m *mockPaymentRepo) FindAll(ctx context.Context) ([]*models.Payment, error) {
 	return m.payments, nil
 }
+func (m *mockPaymentRepo) FindByProviderTransactionID(ctx context.Context, transactionID string) (*models.Payment, error) {
+	for _, p := range m.payments {
+		if p.ProviderTransactionID == transactionID {
+			return p, nil
+		}
+	}
+	return nil, repository.ErrPaymentNotFound
+}
 func (m *mockPaymentRepo) Update(ctx context.Context, payment *models.Payment) error { return nil }
 func (m *mockPaymentRepo) Delete(ctx context.Context, id string) error               { return nil }
 
@@ -76,6 +130,9 @@ func (m *mockOrderRepo) Create(ctx context.Context, order *models.Order) error {
 		return m.createFn(ctx, order)
 	}
 	order.ID = "test-order-id"
+	if len(m.orders) > 0 {
+		order.ID = fmt.Sprintf("test-order-id-%d", len(m.orders)+1)
+	}
 	m.orders = append(m.orders, order)
 	return nil
 }
@@ -87,6 +144,14 @@ func (m *mockOrderRepo) FindByID(ctx context.Context, id string) (*models.Order,
 	}
 	return nil, repository.ErrOrderNotFound
 }
+func (m *mockOrderRepo) FindByIDForUpdate(ctx context.Context, id string) (*models.Order, error) {
+	order, err := m.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	copied := *order
+	return &copied, nil
+}
 func (m *mockOrderRepo) FindAll(ctx context.Context) ([]*models.Order, error) {
 	return m.orders, nil
 }
@@ -99,6 +164,16 @@ func (m *mockOrderRepo) FindByUserID(ctx context.Context, userID string) ([]*mod
 	}
 	return result, nil
 }
+func (m *mockOrderRepo) FindByFilter(ctx context.Context, filter repository.OrderFilter) ([]*models.Order, error) {
+	var result []*models.Order
+	for _, o := range m.orders {
+		if (filter.Status == "" || o.Status == filter.Status) && (filter.To == nil || o.CreatedAt.Before(*filter.To)) &&
+			(filter.From == nil || !o.CreatedAt.Before(*filter.From)) {
+			result = append(result, o)
+		}
+	}
+	return result, nil
+}
 func (m *mockOrderRepo) Update(ctx context.Context, order *models.Order) error { return nil }
 func (m *mockOrderRepo) UpdateStatus(ctx context.Context, id string, status string) error {
 	for _, o := range m.orders {
@@ -112,9 +187,12 @@ func (m *mockOrderRepo) UpdateStatus(ctx context.Context, id string, status stri
 func (m *mockOrderRepo) Delete(ctx context.Context, id string) error { return nil }
 
 type mockCartRepo struct {
-	cart    *models.Cart
-	total   float64
-	cleared bool
+	cart     *models.Cart
+	total    money.Amount
+	quote    *coupon.Quote // Overrides total when set
+	cleared  bool
+	removed  []string                // cart item IDs removed
+	repriced map[string]money.Amount // cart item ID -> new price
 }
 
 func (m *mockCartRepo) GetCartByUserID(ctx context.Context, userID string) (*models.Cart, error) {
@@ -138,21 +216,107 @@ func (m *mockCartRepo) GetCartItem(ctx context.Context, cartItemID string) (*mod
 func (m *mockCartRepo) UpdateCartItem(ctx context.Context, cartItem *models.CartItem) error {
 	return nil
 }
-func (m *mockCartRepo) RemoveItemFromCart(ctx context.Context, cartItemID string) error { return nil }
+func (m *mockCartRepo) SetItemPlan(ctx context.Context, cartItemID string, planID *uint, price money.Amount) error {
+	if m.repriced == nil {
+		m.repriced = make(map[string]money.Amount)
+	}
+	m.repriced[cartItemID] = price
+	return nil
+}
+func (m *mockCartRepo) RemoveItemFromCart(ctx context.Context, cartItemID string) error {
+	m.removed = append(m.removed, cartItemID)
+	return nil
+}
 func (m *mockCartRepo) GetCartItemByCourseID(ctx context.Context, cartID, courseID string) (*models.CartItem, error) {
 	return nil, nil
 }
-func (m *mockCartRepo) GetCartTotal(ctx context.Context, cartID string) (float64, error) {
+func (m *mockCartRepo) AddCouponToCart(ctx context.Context, cartCoupon *models.CartCoupon) error {
+	return nil
+}
+func (m *mockCartRepo) RemoveCouponFromCart(ctx context.Context, cartID, couponID string) error {
+	return nil
+}
+func (m *mockCartRepo) GetCartTotal(ctx context.Context, cartID string) (money.Amount, error) {
 	return m.total, nil
 }
+func (m *mockCartRepo) GetCartQuote(ctx context.Context, cartID string) (*coupon.Quote, error) {
+	if m.quote != nil {
+		return m.quote, nil
+	}
+	return &coupon.Quote{Subtotal: m.total, Total: m.total}, nil
+}
+func (m *mockCartRepo) ClaimAbandoned(ctx context.Context, idleSince, remindedBefore, now time.Time) ([]*models.Cart, error) {
+	return nil, nil
+}
+func (m *mockCartRepo) GetGuestCart(ctx context.Context, cartID string) (*models.Cart, error) {
+	return nil, repository.ErrCartNotFound
+}
+func (m *mockCartRepo) MergeGuestCart(ctx context.Context, guestCartID, userID string) (int64, error) {
+	return 0, nil
+}
+func (m *mockCartRepo) DeleteStaleGuestCarts(ctx context.Context, idleSince time.Time) (int64, error) {
+	return 0, nil
+}
+
+type mockCouponRepo struct {
+	coupons     map[string]*models.Coupon // Coupons not listed are active without limits
+	redemptions []*models.CouponRedemption
+}
+
+func (m *mockCouponRepo) Create(ctx context.Context, c *models.Coupon) error { return nil }
+func (m *mockCouponRepo) FindByID(ctx context.Context, id string) (*models.Coupon, error) {
+	return nil, repository.ErrCouponNotFound
+}
+func (m *mockCouponRepo) FindByIDForUpdate(ctx context.Context, id string) (*models.Coupon, error) {
+	if c, ok := m.coupons[id]; ok {
+		return c, nil
+	}
+	return &models.Coupon{ID: id, Active: true}, nil
+}
+func (m *mockCouponRepo) FindByCode(ctx context.Context, code string) (*models.Coupon, error) {
+	return nil, repository.ErrCouponNotFound
+}
+func (m *mockCouponRepo) FindAll(ctx context.Context) ([]*models.Coupon, error) { return nil, nil }
+func (m *mockCouponRepo) Update(ctx context.Context, c *models.Coupon) error    { return nil }
+func (m *mockCouponRepo) Delete(ctx context.Context, id string) error           { return nil }
+func (m *mockCouponRepo) CountRedemptions(ctx context.Context, couponID, userID string) (coupon.Usage, error) {
+	var usage coupon.Usage
+	for _, r := range m.redemptions {
+		if r.CouponID == couponID {
+			usage.Total++
+			if r.UserID == userID {
+				usage.ByUser++
+			}
+		}
+	}
+	return usage, nil
+}
+func (m *mockCouponRepo) CreateRedemption(ctx context.Context, redemption *models.CouponRedemption) error {
+	for _, r := range m.redemptions {
+		if r.CouponID == redemption.CouponID && r.OrderID == redemption.OrderID {
+			return nil
+		}
+	}
+	m.redemptions = append(m.redemptions, redemption)
+	return nil
+}
+func (m *mockCouponRepo) ReleaseRedemptions(ctx context.Context, orderID string) error {
+	m.redemptions = slices.DeleteFunc(m.redemptions, func(r *models.CouponRedemption) bool { return r.OrderID == orderID })
+	return nil
+}
 
 type mockUserRepo struct {
 	assignedCourses map[string][]string // userID -> []courseID
+	revokedCourses  map[string][]string // userID -> []courseID
+	assignErr       error
+	province        string // Province on every user's profile
+	updated         *models.User
+	suspended       map[string]bool // courseID -> suspended
 }
 
 func (m *mockUserRepo) Create(ctx context.Context, user *models.User) error { return nil }
 func (m *mockUserRepo) FindByID(ctx context.Context, id string) (*models.User, error) {
-	return nil, nil
+	return &models.User{ID: id, Name: "Student " + id, Email: id + "@example.com", Province: m.province}, nil
 }
 func (m *mockUserRepo) FindByGoogleID(ctx context.Context, googleID string) (*models.User, error) {
 	return nil, nil
@@ -161,18 +325,417 @@ func (m *mockUserRepo) FindByEmail(ctx context.Context, email string) (*models.U
 	return nil, nil
 }
 func (m *mockUserRepo) FindAll(ctx context.Context) ([]*models.User, error) { return nil, nil }
-func (m *mockUserRepo) Update(ctx context.Context, user *models.User) error { return nil }
-func (m *mockUserRepo) Delete(ctx context.Context, id string) error         { return nil }
+func (m *mockUserRepo) Update(ctx context.Context, user *models.User) error {
+	m.updated = user
+	return nil
+}
+func (m *mockUserRepo) Delete(ctx context.Context, id string) error { return nil }
 func (m *mockUserRepo) GetPurchasedCourses(ctx context.Context, userID string) ([]*models.Course, error) {
 	return nil, nil
 }
+func (m *mockUserRepo) GetEnrolledCourseIDs(ctx context.Context, userID string) ([]string, error) {
+	return m.assignedCourses[userID], nil
+}
 func (m *mockUserRepo) AssignCourse(ctx context.Context, userID string, courseID string) error {
+	if m.assignErr != nil {
+		return m.assignErr
+	}
 	if m.assignedCourses == nil {
 		m.assignedCourses = make(map[string][]string)
 	}
 	m.assignedCourses[userID] = append(m.assignedCourses[userID], courseID)
 	return nil
 }
+func (m *mockUserRepo) RevokeCourse(ctx context.Context, userID string, courseID string) error {
+	if m.revokedCourses == nil {
+		m.revokedCourses = make(map[string][]string)
+	}
+	m.revokedCourses[userID] = append(m.revokedCourses[userID], courseID)
+	return nil
+}
+
+func (m *mockUserRepo) SuspendCourse(ctx context.Context, userID string, courseID string) error {
+	if m.suspended == nil {
+		m.suspended = make(map[string]bool)
+	}
+	m.suspended[courseID] = true
+	return nil
+}
+func (m *mockUserRepo) RestoreCourse(ctx context.Context, userID string, courseID string) error {
+	if m.suspended == nil {
+		m.suspended = make(map[string]bool)
+	}
+	m.suspended[courseID] = false
+	return nil
+}
+func (m *mockUserRepo) MarkEmailVerified(ctx context.Context, userID string, at time.Time) error {
+	return nil
+}
+func (m *mockUserRepo) ClaimVerificationEmail(ctx context.Context, userID string, now time.Time, interval time.Duration) (bool, error) {
+	return true, nil
+}
+func (m *mockUserRepo) ClaimPasswordResetEmail(ctx context.Context, userID string, now time.Time, interval time.Duration) (bool, error) {
+	return true, nil
+}
+
+// mockCourseRepo serves the given courses. Without any, every course exists
+// at the price its cart item was added for.
+type mockCourseRepo struct {
+	courses map[string]*models.Course
+	cart    *mockCartRepo
+}
+
+func (m *mockCourseRepo) Create(ctx context.Context, course *models.Course) error { return nil }
+func (m *mockCourseRepo) FindByID(ctx context.Context, id string) (*models.Course, error) {
+	if m.courses != nil {
+		if course, ok := m.courses[id]; ok {
+			return course, nil
+		}
+		return nil, repository.ErrCourseNotFound
+	}
+	course := &models.Course{ID: id, Name: "Course " + id}
+	if m.cart != nil && m.cart.cart != nil {
+		for _, item := range m.cart.cart.Items {
+			if item.CourseID == id && item.PaymentPlan == nil {
+				course.Name = item.Course.Name
+				course.Price = item.Price
+			}
+		}
+	}
+	return course, nil
+}
+func (m *mockCourseRepo) FindAll(ctx context.Context) ([]*models.Course, error)   { return nil, nil }
+func (m *mockCourseRepo) Update(ctx context.Context, course *models.Course) error { return nil }
+func (m *mockCourseRepo) Delete(ctx context.Context, id string) error             { return nil }
+
+type mockInstallmentRepo struct {
+	installments []*models.Installment
+}
+
+func (m *mockInstallmentRepo) FindByID(ctx context.Context, id string) (*models.Installment, error) {
+	for _, inst := range m.installments {
+		if inst.ID == id {
+			copied := *inst
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrInstallmentNotFound
+}
+func (m *mockInstallmentRepo) FindByIDForUpdate(ctx context.Context, id string) (*models.Installment, error) {
+	return m.FindByID(ctx, id)
+}
+func (m *mockInstallmentRepo) FindByOrderID(ctx context.Context, orderID string) ([]models.Installment, error) {
+	var result []models.Installment
+	for _, inst := range m.installments {
+		if inst.OrderID == orderID {
+			result = append(result, *inst)
+		}
+	}
+	return result, nil
+}
+func (m *mockInstallmentRepo) FindUnpaidDue(ctx context.Context, now time.Time) ([]models.Installment, error) {
+	var result []models.Installment
+	for _, inst := range m.installments {
+		if inst.Status != "PAID" && !inst.DueAt.After(now) {
+			result = append(result, *inst)
+		}
+	}
+	return result, nil
+}
+func (m *mockInstallmentRepo) ClaimReminders(ctx context.Context, now, before time.Time) ([]models.Installment, error) {
+	var result []models.Installment
+	for _, inst := range m.installments {
+		if inst.Status == "SCHEDULED" && inst.RemindedAt == nil && inst.DueAt.After(now) && !inst.DueAt.After(before) {
+			inst.RemindedAt = &now
+			result = append(result, *inst)
+		}
+	}
+	return result, nil
+}
+func (m *mockInstallmentRepo) ClaimCharge(ctx context.Context, id string, now time.Time, interval time.Duration) (bool, error) {
+	for _, inst := range m.installments {
+		if inst.ID == id && inst.Status != "PAID" && (inst.LastChargeAt == nil || !inst.LastChargeAt.After(now.Add(-interval))) {
+			inst.LastChargeAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+func (m *mockInstallmentRepo) Update(ctx context.Context, installment *models.Installment) error {
+	for _, inst := range m.installments {
+		if inst.ID == installment.ID {
+			*inst = *installment
+		}
+	}
+	return nil
+}
+
+// mockInvoiceRepo is locked because receipts are marked emailed from a goroutine
+type mockInvoiceRepo struct {
+	mu       sync.Mutex
+	counter  int64
+	invoices []*models.Invoice
+}
+
+func (m *mockInvoiceRepo) NextSequence(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counter++
+	return m.counter, nil
+}
+func (m *mockInvoiceRepo) Create(ctx context.Context, invoice *models.Invoice) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	invoice.ID = fmt.Sprintf("invoice-%d", len(m.invoices)+1)
+	m.invoices = append(m.invoices, invoice)
+	return nil
+}
+func (m *mockInvoiceRepo) FindByOrderID(ctx context.Context, orderID string, installment int) (*models.Invoice, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, inv := range m.invoices {
+		if inv.OrderID == orderID && inv.Installment == installment {
+			return inv, nil
+		}
+	}
+	return nil, repository.ErrInvoiceNotFound
+}
+func (m *mockInvoiceRepo) MarkEmailed(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, inv := range m.invoices {
+		if inv.ID == id {
+			inv.EmailedAt = &at
+			return nil
+		}
+	}
+	return repository.ErrInvoiceNotFound
+}
+
+type mockSettingsRepo struct {
+	settings map[string]string
+}
+
+func (m *mockSettingsRepo) GetSetting(ctx context.Context, key string) (string, error) {
+	return m.settings[key], nil
+}
+func (m *mockSettingsRepo) SetSetting(ctx context.Context, key string, value string) error {
+	return nil
+}
+func (m *mockSettingsRepo) ListSettings(ctx context.Context) ([]*models.AppSetting, error) {
+	return nil, nil
+}
+
+type sentReceipt struct {
+	to, number string
+	pdf        []byte
+}
+
+// mockMailer reports each receipt on sent, which is buffered so tests that do
+// not wait for the email are not blocked
+type mockMailer struct {
+	sent chan sentReceipt
+}
+
+func (m *mockMailer) SendReceipt(ctx context.Context, to, number string, pdf []byte) error {
+	m.sent <- sentReceipt{to: to, number: number, pdf: pdf}
+	return nil
+}
+
+type mockTaxRateRepo struct {
+	rates []models.TaxRate
+}
+
+func (m *mockTaxRateRepo) FindByProvince(ctx context.Context, province string) ([]models.TaxRate, error) {
+	var result []models.TaxRate
+	for _, r := range m.rates {
+		if r.Province == province {
+			result = append(result, r)
+		}
+	}
+	return result, nil
+}
+func (m *mockTaxRateRepo) FindAll(ctx context.Context) ([]models.TaxRate, error) { return m.rates, nil }
+func (m *mockTaxRateRepo) ReplaceProvince(ctx context.Context, province string, rates []models.TaxRate) error {
+	return nil
+}
+
+type mockWebhookRepo struct {
+	events map[string]*models.WebhookEvent
+}
+
+func (m *mockWebhookRepo) Record(ctx context.Context, event *models.WebhookEvent) (bool, error) {
+	if m.events == nil {
+		m.events = make(map[string]*models.WebhookEvent)
+	}
+	if _, ok := m.events[event.ID]; ok {
+		return false, nil
+	}
+	m.events[event.ID] = event
+	return true, nil
+}
+func (m *mockWebhookRepo) Delete(ctx context.Context, id string) error {
+	delete(m.events, id)
+	return nil
+}
+
+type mockAttemptRepo struct {
+	attempts []*models.PaymentAttempt
+}
+
+func (m *mockAttemptRepo) Create(ctx context.Context, attempt *models.PaymentAttempt) error {
+	m.attempts = append(m.attempts, attempt)
+	return nil
+}
+func (m *mockAttemptRepo) FindByProviderOrderID(ctx context.Context, providerOrderID string) (*models.PaymentAttempt, error) {
+	for _, a := range m.attempts {
+		if a.ProviderOrderID == providerOrderID {
+			return a, nil
+		}
+	}
+	return nil, repository.ErrPaymentAttemptNotFound
+}
+func (m *mockAttemptRepo) FindByStatus(ctx context.Context, status string) ([]*models.PaymentAttempt, error) {
+	var result []*models.PaymentAttempt
+	for _, a := range m.attempts {
+		if a.Status == status {
+			result = append(result, a)
+		}
+	}
+	return result, nil
+}
+func (m *mockAttemptRepo) Update(ctx context.Context, attempt *models.PaymentAttempt) error {
+	return nil
+}
+
+type mockCodeRepo struct {
+	codes  []*models.EnrollmentCode
+	orders *mockOrderRepo // Gift orders of the codes, a refunded one no longer grants
+}
+
+func (m *mockCodeRepo) Create(ctx context.Context, codes []*models.EnrollmentCode) error {
+	for _, c := range codes {
+		c.ID = fmt.Sprintf("code-%d", len(m.codes)+1)
+		m.codes = append(m.codes, c)
+	}
+	return nil
+}
+func (m *mockCodeRepo) FindByCode(ctx context.Context, code string) (*models.EnrollmentCode, error) {
+	return nil, repository.ErrEnrollmentCodeNotFound
+}
+func (m *mockCodeRepo) FindByIssuer(ctx context.Context, issuedByID, kind string) ([]*models.EnrollmentCode, error) {
+	return nil, nil
+}
+func (m *mockCodeRepo) FindByOrderID(ctx context.Context, orderID string) ([]*models.EnrollmentCode, error) {
+	var result []*models.EnrollmentCode
+	for _, c := range m.codes {
+		if c.OrderID != nil && *c.OrderID == orderID {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+func (m *mockCodeRepo) Redeem(ctx context.Context, code, userID string) (*models.EnrollmentCode, error) {
+	return nil, repository.ErrEnrollmentCodeNotFound
+}
+func (m *mockCodeRepo) RevokeByOrderID(ctx context.Context, orderID string, at time.Time) (int64, error) {
+	var revoked int64
+	for _, c := range m.codes {
+		if c.OrderID != nil && *c.OrderID == orderID && c.RedeemedAt == nil && c.RevokedAt == nil {
+			c.RevokedAt = &at
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+func (m *mockCodeRepo) HasRedeemed(ctx context.Context, userID, courseID string) (bool, error) {
+	for _, c := range m.codes {
+		if c.RedeemedByID == nil || *c.RedeemedByID != userID || c.CourseID != courseID {
+			continue
+		}
+		if c.OrderID != nil && m.orders != nil {
+			if order, err := m.orders.FindByID(ctx, *c.OrderID); err == nil && order.Status == "REFUNDED" {
+				continue
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+type sentGift struct {
+	to, from string
+	courses  []service.GiftedCourse
+}
+
+type mockGiftMailer struct {
+	sent chan sentGift
+}
+
+func (m *mockGiftMailer) SendGift(ctx context.Context, to, name, from, message string, courses []service.GiftedCourse) error {
+	m.sent <- sentGift{to: to, from: from, courses: courses}
+	return nil
+}
+
+type sentReminder struct {
+	to     string
+	amount money.Money
+	link   string
+}
+
+type mockInstallmentMailer struct {
+	sent []sentReminder
+}
+
+func (m *mockInstallmentMailer) SendInstallmentReminder(ctx context.Context, to, name string, amount money.Money, dueAt time.Time, link string) error {
+	m.sent = append(m.sent, sentReminder{to: to, amount: amount, link: link})
+	return nil
+}
+
+// mockUnitOfWork hands out the mock repositories and emulates a rollback by
+// restoring their state when fn fails
+type mockUnitOfWork struct {
+	repos repository.Repositories
+}
+
+func (m *mockUnitOfWork) Do(ctx context.Context, fn func(repos repository.Repositories) error) error {
+	orders := m.repos.Orders.(*mockOrderRepo)
+	payments := m.repos.Payments.(*mockPaymentRepo)
+	users := m.repos.Users.(*mockUserRepo)
+	carts := m.repos.Carts.(*mockCartRepo)
+	coupons := m.repos.Coupons.(*mockCouponRepo)
+	invoices := m.repos.Invoices.(*mockInvoiceRepo)
+	codes := m.repos.Codes.(*mockCodeRepo)
+
+	statuses := make([]string, len(orders.orders))
+	for i, o := range orders.orders {
+		statuses[i] = o.Status
+	}
+	paymentCount := len(payments.payments)
+	assigned := make(map[string][]string, len(users.assignedCourses))
+	for k, v := range users.assignedCourses {
+		assigned[k] = append([]string(nil), v...)
+	}
+	cleared := carts.cleared
+	redemptions := slices.Clone(coupons.redemptions)
+	counter, invoiceCount := invoices.counter, len(invoices.invoices)
+	codeCount := len(codes.codes)
+
+	if err := fn(m.repos); err != nil {
+		orders.orders = orders.orders[:len(statuses)]
+		for i, o := range orders.orders {
+			o.Status = statuses[i]
+		}
+		payments.payments = payments.payments[:paymentCount]
+		users.assignedCourses = assigned
+		carts.cleared = cleared
+		coupons.redemptions = redemptions
+		invoices.counter, invoices.invoices = counter, invoices.invoices[:invoiceCount]
+		codes.codes = codes.codes[:codeCount]
+		return err
+	}
+	return nil
+}
 
 // ===================== Helper =====================
 
@@ -181,14 +744,68 @@ func contextWithUserID(userID string) context.Context {
 }
 
 func newTestHandler(paymentRepo *mockPaymentRepo, orderRepo *mockOrderRepo, cartRepo *mockCartRepo, userRepo *mockUserRepo, pp *mockPayPalClient) *PaymentHandler {
+	installmentRepo := &mockInstallmentRepo{}
+	invoiceRepo := &mockInvoiceRepo{}
+	codeRepo := &mockCodeRepo{orders: orderRepo}
 	return &PaymentHandler{
-		logger:       slog.New(slog.NewTextHandler(os.Stdout, nil)),
-		repo:         paymentRepo,
-		orderRepo:    orderRepo,
-		cartRepo:     cartRepo,
-		userRepo:     userRepo,
-		db:           nil, // no raw db in these tests
-		paypalClient: pp,
+		logger:      slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		repo:        paymentRepo,
+		orderRepo:   orderRepo,
+		cartRepo:    cartRepo,
+		courseRepo:  &mockCourseRepo{cart: cartRepo},
+		userRepo:    userRepo,
+		webhookRepo: &mockWebhookRepo{},
+		attemptRepo: &mockAttemptRepo{},
+		taxRepo: &mockTaxRateRepo{rates: []models.TaxRate{
+			{Province: "ON", Name: "HST", Rate: 13},
+			{Province: "QC", Name: "GST", Rate: 5},
+			{Province: "QC", Name: "QST", Rate: 9.975},
+		}},
+		installmentRepo: installmentRepo,
+		invoiceRepo:     invoiceRepo,
+		codeRepo:        codeRepo,
+		settingsRepo:    &mockSettingsRepo{settings: map[string]string{"invoice_seller_name": "A1 French Classes", "invoice_seller_tax_id": "123456789RT0001"}},
+		uow: &mockUnitOfWork{repos: repository.Repositories{
+			Orders:       orderRepo,
+			Payments:     paymentRepo,
+			Users:        userRepo,
+			Carts:        cartRepo,
+			Coupons:      &mockCouponRepo{},
+			Installments: installmentRepo,
+			Invoices:     invoiceRepo,
+			Codes:        codeRepo,
+		}},
+		jobs:      &mockJobLocker{},
+		db:        nil, // no raw db in these tests
+		providers: NewProviders(newPayPalProvider(pp)),
+		mailer:    &mockMailer{sent: make(chan sentReceipt, 16)},
+		gifts:     &mockGiftMailer{sent: make(chan sentGift, 16)},
+		reminders: &mockInstallmentMailer{},
+	}
+}
+
+// withAttempt registers a PayPal order as an attempt of a local order, the way
+// Checkout does, so captures of it are accepted
+func withAttempt(h *PaymentHandler, orderID, paypalOrderID string) *models.PaymentAttempt {
+	attempt := &models.PaymentAttempt{OrderID: orderID, Provider: "PAYPAL", ProviderOrderID: paypalOrderID, Status: "CREATED"}
+	repo := h.attemptRepo.(*mockAttemptRepo)
+	repo.attempts = append(repo.attempts, attempt)
+	return attempt
+}
+
+// paypalAttempt is the PayPal order an order was paid through
+func paypalAttempt(orderID string) *models.PaymentAttempt {
+	return &models.PaymentAttempt{OrderID: orderID, Provider: "PAYPAL", ProviderOrderID: "PAYPAL-" + orderID, Status: "CAPTURED"}
+}
+
+// completedCapture is what PayPal returns for a capture of the given order
+func completedCapture(orderID string, amount money.Amount) *paypal.CaptureResult {
+	return &paypal.CaptureResult{
+		Status:      "COMPLETED",
+		CaptureID:   "CAPTURE-1",
+		ReferenceID: orderID,
+		CustomID:    orderID,
+		Amount:      paypal.Amount{CurrencyCode: "CAD", Value: amount.String()},
 	}
 }
 
@@ -259,7 +876,7 @@ func TestCheckout_OrderCreationFailure(t *testing.T) {
 			return errors.New("database error")
 		},
 	}
-	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{cart: cart, total: 100}, &mockUserRepo{}, &mockPayPalClient{})
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{cart: cart, total: 100}, &mockUserRepo{province: "ON"}, &mockPayPalClient{})
 	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
 	rr := httptest.NewRecorder()
 	h.Checkout(rr, req)
@@ -277,11 +894,11 @@ func TestCheckout_PayPalFailure(t *testing.T) {
 		},
 	}
 	pp := &mockPayPalClient{
-		createOrderFn: func(amount float64, orderID string) (string, string, error) {
+		createOrderFn: func(total money.Money, orderID string) (string, string, error) {
 			return "", "", fmt.Errorf("paypal is down")
 		},
 	}
-	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{cart: cart, total: 100}, &mockUserRepo{}, pp)
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{cart: cart, total: 100}, &mockUserRepo{province: "ON"}, pp)
 	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
 	rr := httptest.NewRecorder()
 	h.Checkout(rr, req)
@@ -291,35 +908,404 @@ func TestCheckout_PayPalFailure(t *testing.T) {
 	}
 }
 
-// ===================== CaptureCheckout Tests =====================
-
-func TestCaptureCheckout_InvalidJSON(t *testing.T) {
-	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
-	req, _ := http.NewRequest("POST", "/api/checkout/capture", bytes.NewBuffer([]byte("NOT_JSON")))
-	rr := httptest.NewRecorder()
-	h.CaptureCheckout(rr, req)
-
-	if rr.Code != http.StatusBadRequest {
-		t.Errorf("expected 400 for invalid JSON, got %d", rr.Code)
-	}
-}
-
-func TestCaptureCheckout_PayPalCaptureFailure(t *testing.T) {
-	pp := &mockPayPalClient{
-		captureOrderFn: func(orderID string) (string, error) {
-			return "", fmt.Errorf("capture failed")
+func TestCheckout_AppliesCouponDiscount(t *testing.T) {
+	t.Setenv("CURRENCY", "CAD")
+	cart := &models.Cart{
+		ID: "cart-1",
+		Items: []models.CartItem{
+			{ID: "item-1", CourseID: "course-1", Price: 10000},
 		},
 	}
-	orderRepo := &mockOrderRepo{
-		orders: []*models.Order{
-			{ID: "order-1", Status: "PENDING"},
+	cartRepo := &mockCartRepo{cart: cart, quote: &coupon.Quote{
+		Subtotal:      10000,
+		Discount:      2000,
+		Total:         8000,
+		Applied:       []coupon.Line{{CouponID: "coupon-1", Code: "SAVE20", Amount: 2000}},
+		ItemDiscounts: map[string]money.Amount{"item-1": 2000},
+	}}
+	var charged money.Money
+	pp := &mockPayPalClient{
+		createOrderFn: func(total money.Money, orderID string) (string, string, error) {
+			charged = total
+			return "PAYPAL-1", "https://paypal.test/approve", nil
 		},
 	}
-	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
-	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "paypal-token"})
-	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+	orderRepo := &mockOrderRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, cartRepo, &mockUserRepo{province: "ON"}, pp)
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
 	rr := httptest.NewRecorder()
-	h.CaptureCheckout(rr, req)
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// HST is charged on the discounted price
+	if charged != money.New(9040, "CAD") {
+		t.Errorf("expected PayPal to be asked for 90.40 CAD, got %s", charged)
+	}
+	order := orderRepo.orders[0]
+	if order.Subtotal != 10000 || order.Discount != 2000 || order.TaxTotal != 1040 || order.TotalAmount != 9040 {
+		t.Errorf("expected 100.00 - 20.00 + 10.40 = 90.40, got %s - %s + %s = %s", order.Subtotal, order.Discount, order.TaxTotal, order.TotalAmount)
+	}
+	if len(order.Discounts) != 1 || order.Discounts[0].Code != "SAVE20" || order.Discounts[0].Amount != 2000 {
+		t.Errorf("expected one SAVE20 discount line of 20.00, got %+v", order.Discounts)
+	}
+	if order.Items[0].Price != 10000 || order.Items[0].Discount != 2000 {
+		t.Errorf("expected item price 100.00 with 20.00 off, got %+v", order.Items[0])
+	}
+}
+
+func TestCheckout_OneUseCouponHeldByUnpaidOrder(t *testing.T) {
+	t.Setenv("CURRENCY", "CAD")
+	cart := &models.Cart{
+		ID: "cart-1",
+		Items: []models.CartItem{
+			{ID: "item-1", CourseID: "course-1", Price: 10000},
+		},
+	}
+	cartRepo := &mockCartRepo{cart: cart, quote: &coupon.Quote{
+		Subtotal:      10000,
+		Discount:      2000,
+		Total:         8000,
+		Applied:       []coupon.Line{{CouponID: "coupon-1", Code: "ONCE", Amount: 2000}},
+		ItemDiscounts: map[string]money.Amount{"item-1": 2000},
+	}}
+	orderRepo := &mockOrderRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, cartRepo, &mockUserRepo{province: "ON"}, &mockPayPalClient{})
+	coupons := h.uow.(*mockUnitOfWork).repos.Coupons.(*mockCouponRepo)
+	coupons.coupons = map[string]*models.Coupon{"coupon-1": {ID: "coupon-1", Code: "ONCE", Active: true, MaxRedemptions: 1}}
+
+	checkout := func(userID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequestWithContext(contextWithUserID(userID), "POST", "/api/checkout", nil)
+		rr := httptest.NewRecorder()
+		h.Checkout(rr, req)
+		return rr
+	}
+
+	if rr := checkout("user-1"); rr.Code != http.StatusOK {
+		t.Fatalf("expected the first checkout to get the coupon, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(coupons.redemptions) != 1 || coupons.redemptions[0].OrderID != orderRepo.orders[0].ID {
+		t.Fatalf("expected the unpaid order to reserve the coupon, got %+v", coupons.redemptions)
+	}
+
+	rr := checkout("user-2")
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "ONCE") {
+		t.Errorf("expected the second checkout to be refused the used coupon, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(orderRepo.orders) != 1 {
+		t.Errorf("expected no order placed for the second checkout, got %d orders", len(orderRepo.orders))
+	}
+
+	// Once the first order fails its coupon is free again
+	if err := h.failOrder(context.Background(), orderRepo.orders[0].ID); err != nil {
+		t.Fatalf("failOrder() = %v", err)
+	}
+	if rr := checkout("user-2"); rr.Code != http.StatusOK {
+		t.Errorf("expected the coupon to be usable after the first order failed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCheckout_FulfillsOrderCoveredByCoupons(t *testing.T) {
+	t.Setenv("CURRENCY", "CAD")
+	cart := &models.Cart{
+		ID: "cart-1",
+		Items: []models.CartItem{
+			{ID: "item-1", CourseID: "course-1", Price: 10000},
+		},
+	}
+	cartRepo := &mockCartRepo{cart: cart, quote: &coupon.Quote{
+		Subtotal:      10000,
+		Discount:      10000,
+		Total:         0,
+		Applied:       []coupon.Line{{CouponID: "coupon-1", Code: "FREE", Amount: 10000}},
+		ItemDiscounts: map[string]money.Amount{"item-1": 10000},
+	}}
+	pp := &mockPayPalClient{
+		createOrderFn: func(total money.Money, orderID string) (string, string, error) {
+			t.Error("expected no PayPal order for a free order")
+			return "", "", errors.New("not expected")
+		},
+	}
+	orderRepo := &mockOrderRepo{}
+	paymentRepo := &mockPaymentRepo{}
+	userRepo := &mockUserRepo{province: "ON"}
+	h := newTestHandler(paymentRepo, orderRepo, cartRepo, userRepo, pp)
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	order := orderRepo.orders[0]
+	if order.Status != "COMPLETED" || order.TotalAmount != 0 {
+		t.Errorf("expected a COMPLETED order of 0.00, got %s of %s", order.Status, order.TotalAmount)
+	}
+	if len(paymentRepo.payments) != 0 {
+		t.Errorf("expected no payment recorded, got %d", len(paymentRepo.payments))
+	}
+	if got := userRepo.assignedCourses["user-1"]; len(got) != 1 || got[0] != "course-1" {
+		t.Errorf("expected course-1 assigned to user-1, got %v", got)
+	}
+}
+
+func TestCheckout_ChargesProvinceTax(t *testing.T) {
+	t.Setenv("CURRENCY", "CAD")
+	cart := &models.Cart{
+		ID: "cart-1",
+		Items: []models.CartItem{
+			{ID: "item-1", CourseID: "course-1", Price: 10000},
+			{ID: "item-2", CourseID: "course-2", Price: 4999},
+		},
+	}
+	orderRepo := &mockOrderRepo{}
+	userRepo := &mockUserRepo{}
+	pp := &mockPayPalClient{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{cart: cart, total: 14999}, userRepo, pp)
+	body, _ := json.Marshal(map[string]string{"province": "qc"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	order := orderRepo.orders[0]
+	// GST 5.00 + 2.50 and QST 9.98 + 4.99
+	if order.TaxProvince != "QC" || order.TaxTotal != 2247 || order.TotalAmount != 17246 {
+		t.Errorf("expected 22.47 QC tax for a total of 172.46, got %s %s total %s", order.TaxProvince, order.TaxTotal, order.TotalAmount)
+	}
+	if order.Items[0].Tax != 1498 || order.Items[1].Tax != 749 {
+		t.Errorf("unexpected line taxes %s and %s", order.Items[0].Tax, order.Items[1].Tax)
+	}
+	if len(order.Taxes) != 2 || order.Taxes[0].Name != "GST" || order.Taxes[1].Name != "QST" {
+		t.Errorf("expected GST and QST lines, got %+v", order.Taxes)
+	}
+	want := paypal.Charge{Currency: "CAD", ItemTotal: 14999, TaxTotal: 2247}
+	if pp.lastCharge != want {
+		t.Errorf("expected PayPal breakdown %+v, got %+v", want, pp.lastCharge)
+	}
+	if userRepo.updated == nil || userRepo.updated.Province != "QC" {
+		t.Errorf("expected the province to be saved to the profile, got %+v", userRepo.updated)
+	}
+}
+
+func TestCheckout_ProvinceRequired(t *testing.T) {
+	cart := &models.Cart{
+		ID:    "cart-1",
+		Items: []models.CartItem{{ID: "item-1", CourseID: "course-1", Price: 10000}},
+	}
+	orderRepo := &mockOrderRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{cart: cart, total: 10000}, &mockUserRepo{}, &mockPayPalClient{})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without a province, got %d", rr.Code)
+	}
+	if len(orderRepo.orders) != 0 {
+		t.Errorf("expected no order to be created, got %d", len(orderRepo.orders))
+	}
+}
+
+func TestCheckout_RejectedCoupon(t *testing.T) {
+	cart := &models.Cart{
+		ID: "cart-1",
+		Items: []models.CartItem{
+			{ID: "item-1", CourseID: "course-1", Price: 10000},
+		},
+	}
+	cartRepo := &mockCartRepo{cart: cart, quote: &coupon.Quote{
+		Subtotal: 10000,
+		Total:    10000,
+		Rejected: []coupon.Line{{CouponID: "coupon-1", Code: "SPRING", Reason: coupon.ErrExpired.Error(), Err: coupon.ErrExpired}},
+	}}
+	orderRepo := &mockOrderRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, cartRepo, &mockUserRepo{}, &mockPayPalClient{})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an expired coupon, got %d", rr.Code)
+	}
+	if len(orderRepo.orders) != 0 {
+		t.Errorf("expected no order to be created, got %d", len(orderRepo.orders))
+	}
+}
+
+func TestCheckout_RepricesChangedCourse(t *testing.T) {
+	cart := &models.Cart{ID: "cart-1", Items: []models.CartItem{
+		{ID: "item-1", CourseID: "course-1", Price: 10000, Course: models.Course{Name: "French A1"}},
+	}}
+	cartRepo := &mockCartRepo{cart: cart, total: 10000}
+	orderRepo := &mockOrderRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, cartRepo, &mockUserRepo{province: "ON"}, &mockPayPalClient{})
+	h.courseRepo = &mockCourseRepo{courses: map[string]*models.Course{
+		"course-1": {ID: "course-1", Name: "French A1", Price: 15000, Discount: 20},
+	}}
+
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Warnings []CartWarning `json:"warnings"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("expected one warning, got %+v", resp.Warnings)
+	}
+	if w := resp.Warnings[0]; w.Code != CartItemRepriced || w.CartItemID != "item-1" || w.OldPrice != 10000 || w.NewPrice != 12000 {
+		t.Errorf("unexpected warning %+v", w)
+	}
+	if cartRepo.repriced["item-1"] != 12000 {
+		t.Errorf("expected the cart item to be repriced to 120.00, got %v", cartRepo.repriced)
+	}
+	if len(orderRepo.orders) != 0 {
+		t.Errorf("expected no order until the buyer reviews the cart, got %d", len(orderRepo.orders))
+	}
+}
+
+func TestCheckout_RemovesOwnedAndUnavailableCourses(t *testing.T) {
+	cart := &models.Cart{ID: "cart-1", Items: []models.CartItem{
+		{ID: "item-1", CourseID: "course-1", Price: 10000, Course: models.Course{Name: "French A1"}},
+		{ID: "item-2", CourseID: "course-2", Price: 10000, Course: models.Course{Name: "French A2"}},
+		{ID: "item-3", CourseID: "course-3", Price: 10000},
+	}}
+	cartRepo := &mockCartRepo{cart: cart, total: 30000}
+	orderRepo := &mockOrderRepo{}
+	userRepo := &mockUserRepo{province: "ON", assignedCourses: map[string][]string{"user-1": {"course-1"}}}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, cartRepo, userRepo, &mockPayPalClient{})
+	h.courseRepo = &mockCourseRepo{courses: map[string]*models.Course{
+		"course-1": {ID: "course-1", Name: "French A1", Price: 10000},
+		"course-2": {ID: "course-2", Name: "French A2", Price: 10000},
+	}}
+
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Warnings []CartWarning `json:"warnings"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	codes := map[string]string{}
+	for _, w := range resp.Warnings {
+		codes[w.CourseID] = w.Code
+	}
+	if want := map[string]string{"course-1": CartItemOwned, "course-3": CartItemUnavailable}; !reflect.DeepEqual(codes, want) {
+		t.Errorf("expected warnings %v, got %v", want, codes)
+	}
+	if !reflect.DeepEqual(cartRepo.removed, []string{"item-1", "item-3"}) {
+		t.Errorf("expected item-1 and item-3 to be removed, got %v", cartRepo.removed)
+	}
+	if len(cart.Items) != 1 || cart.Items[0].ID != "item-2" {
+		t.Errorf("expected only item-2 to remain, got %+v", cart.Items)
+	}
+	if len(orderRepo.orders) != 0 {
+		t.Errorf("expected no order, got %d", len(orderRepo.orders))
+	}
+}
+
+func TestCheckout_GiftOfOwnedCourse(t *testing.T) {
+	cart := &models.Cart{ID: "cart-1", Items: []models.CartItem{
+		{ID: "item-1", CourseID: "course-1", Price: 10000, Course: models.Course{Name: "French A1", Price: 10000}},
+	}}
+	cartRepo := &mockCartRepo{cart: cart, total: 10000}
+	orderRepo := &mockOrderRepo{}
+	userRepo := &mockUserRepo{province: "ON", assignedCourses: map[string][]string{"user-1": {"course-1"}}}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, cartRepo, userRepo, &mockPayPalClient{})
+
+	body := `{"gift": {"recipient_email": " friend@example.com ", "recipient_name": "Sam", "message": "Bonne chance!"}}`
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a gift of an owned course, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(orderRepo.orders) != 1 {
+		t.Fatalf("expected 1 order, got %d", len(orderRepo.orders))
+	}
+	order := orderRepo.orders[0]
+	if !order.IsGift() || order.GiftRecipientEmail != "friend@example.com" || order.GiftRecipientName != "Sam" || order.GiftMessage != "Bonne chance!" {
+		t.Errorf("expected the gift recipient on the order, got %+v", order)
+	}
+}
+
+func TestCheckout_InvalidGift(t *testing.T) {
+	planID := uint(1)
+	tests := []struct {
+		name string
+		body string
+		plan *uint
+	}{
+		{name: "invalid email", body: `{"gift": {"recipient_email": "not an email"}}`},
+		{name: "payment plan", body: `{"gift": {"recipient_email": "friend@example.com"}}`, plan: &planID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := models.CartItem{ID: "item-1", CourseID: "course-1", Price: 10000, Course: models.Course{Name: "French A1", Price: 10000}, PaymentPlanID: tt.plan}
+			if tt.plan != nil {
+				item.PaymentPlan = &models.PaymentPlan{ID: *tt.plan, CourseID: "course-1", Amount: 3000, Installments: 4}
+			}
+			cartRepo := &mockCartRepo{cart: &models.Cart{ID: "cart-1", Items: []models.CartItem{item}}, total: 10000}
+			orderRepo := &mockOrderRepo{}
+			h := newTestHandler(&mockPaymentRepo{}, orderRepo, cartRepo, &mockUserRepo{province: "ON"}, &mockPayPalClient{})
+
+			req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			h.Checkout(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			if len(orderRepo.orders) != 0 {
+				t.Errorf("expected no order, got %d", len(orderRepo.orders))
+			}
+		})
+	}
+}
+
+// ===================== CaptureCheckout Tests =====================
+
+func TestCaptureCheckout_InvalidJSON(t *testing.T) {
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	req, _ := http.NewRequest("POST", "/api/checkout/capture", bytes.NewBuffer([]byte("NOT_JSON")))
+	rr := httptest.NewRecorder()
+	h.CaptureCheckout(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid JSON, got %d", rr.Code)
+	}
+}
+
+func TestCaptureCheckout_PayPalCaptureFailure(t *testing.T) {
+	pp := &mockPayPalClient{
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return nil, fmt.Errorf("capture failed")
+		},
+	}
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "PENDING"},
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+	withAttempt(h, "order-1", "paypal-token")
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "paypal-token"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.CaptureCheckout(rr, req)
 
 	if rr.Code != http.StatusBadRequest {
 		t.Errorf("expected 400 for PayPal capture failure, got %d", rr.Code)
@@ -333,7 +1319,7 @@ func TestCaptureCheckout_Idempotency(t *testing.T) {
 	// 1. Order already COMPLETED
 	orderRepo := &mockOrderRepo{
 		orders: []*models.Order{
-			{ID: "order-completed", Status: "COMPLETED"},
+			{ID: "order-completed", UserID: "user-1", Status: "COMPLETED"},
 		},
 	}
 	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
@@ -347,21 +1333,64 @@ func TestCaptureCheckout_Idempotency(t *testing.T) {
 	}
 }
 
+func TestCaptureCheckout_RefundedOrderIsNotCharged(t *testing.T) {
+	for _, status := range []string{"REFUNDED", "PARTIALLY_REFUNDED"} {
+		captured := false
+		pp := &mockPayPalClient{
+			captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+				captured = true
+				return completedCapture("order-1", 10000), nil
+			},
+		}
+		orderRepo := &mockOrderRepo{
+			orders: []*models.Order{
+				{ID: "order-1", UserID: "user-1", Status: status, TotalAmount: 10000, Currency: "CAD"},
+			},
+		}
+		h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+		withAttempt(h, "order-1", "token")
+		body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "token"})
+		req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		h.CaptureCheckout(rr, req)
+
+		if rr.Code != http.StatusConflict {
+			t.Errorf("%s: expected 409, got %d", status, rr.Code)
+		}
+		if captured {
+			t.Errorf("%s: expected no capture of a refunded order", status)
+		}
+		if orderRepo.orders[0].Status != status {
+			t.Errorf("%s: expected the order status to be kept, got %s", status, orderRepo.orders[0].Status)
+		}
+	}
+}
+
 func TestCaptureCheckout_PayPalAlreadyCaptured(t *testing.T) {
 	// 2. PayPal returns ORDER_ALREADY_CAPTURED error
 	pp := &mockPayPalClient{
-		captureOrderFn: func(orderID string) (string, error) {
-			return "", fmt.Errorf("failed to capture order, status: 422, body: {\"name\":\"UNPROCESSABLE_ENTITY\",\"message\":\"The requested action could not be performed, semantically incorrect, or failed business validation.\",\"debug_id\":\"f3391930662f4\",\"details\":[{\"issue\":\"ORDER_ALREADY_CAPTURED\",\"description\":\"Order already captured.\"}]}")
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return nil, &paypal.APIError{
+				Op:         "capture order",
+				StatusCode: http.StatusUnprocessableEntity,
+				Name:       "UNPROCESSABLE_ENTITY",
+				DebugID:    "f3391930662f4",
+				Details:    []paypal.ErrorDetail{{Issue: "ORDER_ALREADY_CAPTURED", Description: "Order already captured."}},
+			}
+		},
+		getOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return completedCapture("order-pending", 10000), nil
 		},
 	}
 	orderRepo := &mockOrderRepo{
 		orders: []*models.Order{
-			{ID: "order-pending", Status: "PENDING", TotalAmount: 100},
+			{ID: "order-pending", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD"},
 		},
 	}
 	paymentRepo := &mockPaymentRepo{}
 	userRepo := &mockUserRepo{}
 	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{cart: &models.Cart{ID: "cart-1"}}, userRepo, pp)
+	withAttempt(h, "order-pending", "token")
 
 	body, _ := json.Marshal(map[string]string{"order_id": "order-pending", "token": "token"})
 	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
@@ -374,117 +1403,2076 @@ func TestCaptureCheckout_PayPalAlreadyCaptured(t *testing.T) {
 	if orderRepo.orders[0].Status != "COMPLETED" {
 		t.Errorf("expected order status COMPLETED, got %s", orderRepo.orders[0].Status)
 	}
+	if len(paymentRepo.payments) != 1 || paymentRepo.payments[0].ProviderTransactionID != "CAPTURE-1" {
+		t.Errorf("expected one payment for CAPTURE-1, got %+v", paymentRepo.payments)
+	}
 }
 
-func TestCaptureCheckout_NonCompletedStatus(t *testing.T) {
+func TestCaptureCheckout_PayPalBadRequestIsNotSuccess(t *testing.T) {
 	pp := &mockPayPalClient{
-		captureOrderFn: func(orderID string) (string, error) {
-			return "VOIDED", nil
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return nil, &paypal.APIError{
+				Op:         "capture order",
+				StatusCode: http.StatusBadRequest,
+				Name:       "INVALID_REQUEST",
+				Details:    []paypal.ErrorDetail{{Issue: "INVALID_PARAMETER_VALUE"}},
+			}
 		},
 	}
 	orderRepo := &mockOrderRepo{
 		orders: []*models.Order{
-			{ID: "order-1", Status: "PENDING"},
+			{ID: "order-pending", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD"},
 		},
 	}
-	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
-	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "token"})
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, pp)
+	withAttempt(h, "order-pending", "token")
+
+	body, _ := json.Marshal(map[string]string{"order_id": "order-pending", "token": "token"})
 	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
 	rr := httptest.NewRecorder()
 	h.CaptureCheckout(rr, req)
 
 	if rr.Code != http.StatusBadRequest {
-		t.Errorf("expected 400 for non-COMPLETED status, got %d", rr.Code)
+		t.Errorf("expected 400 for invalid request, got %d", rr.Code)
 	}
 	if orderRepo.orders[0].Status != "FAILED" {
 		t.Errorf("expected order status FAILED, got %s", orderRepo.orders[0].Status)
 	}
+	if len(userRepo.assignedCourses) != 0 {
+		t.Errorf("expected no courses assigned, got %d", len(userRepo.assignedCourses))
+	}
 }
 
-// ===================== RetryOrder Tests =====================
+func TestCaptureCheckout_PayPalOutageKeepsOrderPending(t *testing.T) {
+	pp := &mockPayPalClient{
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return nil, &paypal.APIError{
+				Op:         "capture order",
+				StatusCode: http.StatusServiceUnavailable,
+				Name:       "SERVICE_UNAVAILABLE",
+			}
+		},
+	}
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-pending", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD"},
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+	withAttempt(h, "order-pending", "token")
 
-func TestRetryOrder_OrderNotFound(t *testing.T) {
-	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
-	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/non-existent/retry", nil)
-	req = mux.SetURLVars(req, map[string]string{"id": "non-existent"})
+	body, _ := json.Marshal(map[string]string{"order_id": "order-pending", "token": "token"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
 	rr := httptest.NewRecorder()
-	h.RetryOrder(rr, req)
+	h.CaptureCheckout(rr, req)
 
-	if rr.Code != http.StatusNotFound {
-		t.Errorf("expected 404 for missing order, got %d", rr.Code)
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected 502 for PayPal outage, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "PENDING" {
+		t.Errorf("expected order status PENDING, got %s", orderRepo.orders[0].Status)
 	}
 }
 
-func TestRetryOrder_AlreadyCompleted(t *testing.T) {
+func TestCaptureCheckout_NonCompletedStatus(t *testing.T) {
+	pp := &mockPayPalClient{
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return &paypal.CaptureResult{Status: "VOIDED"}, nil
+		},
+	}
 	orderRepo := &mockOrderRepo{
 		orders: []*models.Order{
-			{ID: "completed-order", Status: "COMPLETED", TotalAmount: 100, UserID: "user-1"},
+			{ID: "order-1", UserID: "user-1", Status: "PENDING"},
 		},
 	}
-	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
-	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/completed-order/retry", nil)
-	req = mux.SetURLVars(req, map[string]string{"id": "completed-order"})
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+	withAttempt(h, "order-1", "token")
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "token"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
 	rr := httptest.NewRecorder()
-	h.RetryOrder(rr, req)
+	h.CaptureCheckout(rr, req)
 
 	if rr.Code != http.StatusBadRequest {
-		t.Errorf("expected 400 for completed order retry, got %d", rr.Code)
+		t.Errorf("expected 400 for non-COMPLETED status, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "FAILED" {
+		t.Errorf("expected order status FAILED, got %s", orderRepo.orders[0].Status)
 	}
 }
 
-func TestRetryOrder_Success(t *testing.T) {
+func TestCaptureCheckout_PendingCaptureKeepsOrderPending(t *testing.T) {
+	pending := completedCapture("order-1", 10000)
+	pending.Status = "PENDING"
+	pp := &mockPayPalClient{
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return pending, nil
+		},
+	}
 	orderRepo := &mockOrderRepo{
 		orders: []*models.Order{
-			{ID: "failed-order", Status: "FAILED", TotalAmount: 250.50, UserID: "user-1"},
+			{ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1"}}},
 		},
 	}
+	paymentRepo := &mockPaymentRepo{}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+	withAttempt(h, "order-1", "token")
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "token"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.CaptureCheckout(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for a pending capture, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if orderRepo.orders[0].Status != "PENDING" {
+		t.Errorf("expected order to stay PENDING, got %s", orderRepo.orders[0].Status)
+	}
+	if len(paymentRepo.payments) != 1 || paymentRepo.payments[0].TransactionStatus != "PENDING" {
+		t.Fatalf("expected one PENDING payment, got %+v", paymentRepo.payments)
+	}
+	if paymentRepo.payments[0].ProviderTransactionID != "CAPTURE-1" {
+		t.Errorf("expected payment for CAPTURE-1, got %s", paymentRepo.payments[0].ProviderTransactionID)
+	}
+}
+
+func TestCaptureCheckout_FulfillmentFailureRollsBack(t *testing.T) {
+	alreadyCaptured := false
 	pp := &mockPayPalClient{
-		createOrderFn: func(amount float64, orderID string) (string, string, error) {
-			if amount != 250.50 {
-				t.Errorf("expected amount 250.50, got %f", amount)
-			}
-			if orderID != "failed-order" {
-				t.Errorf("expected orderID 'failed-order', got %s", orderID)
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			if alreadyCaptured {
+				return nil, &paypal.APIError{
+					StatusCode: http.StatusUnprocessableEntity,
+					Name:       "UNPROCESSABLE_ENTITY",
+					Details:    []paypal.ErrorDetail{{Issue: "ORDER_ALREADY_CAPTURED"}},
+				}
 			}
-			return "PAYPAL_RETRY_123", "https://paypal.com/approve/retry", nil
+			alreadyCaptured = true
+			return completedCapture("order-1", 10000), nil
+		},
+		getOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return completedCapture("order-1", 10000), nil
 		},
 	}
-	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
-	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/failed-order/retry", nil)
-	req = mux.SetURLVars(req, map[string]string{"id": "failed-order"})
-	rr := httptest.NewRecorder()
-	h.RetryOrder(rr, req)
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1"}}},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	cartRepo := &mockCartRepo{cart: &models.Cart{ID: "cart-1"}}
+	userRepo := &mockUserRepo{assignErr: errors.New("connection reset")}
+	h := newTestHandler(paymentRepo, orderRepo, cartRepo, userRepo, pp)
+	withAttempt(h, "order-1", "PAYPAL-1")
 
-	if rr.Code != http.StatusOK {
-		t.Errorf("expected 200 for retry, got %d", rr.Code)
+	capture := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "PAYPAL-1"})
+		req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+		rr := httptest.NewRecorder()
+		h.CaptureCheckout(rr, req)
+		return rr
 	}
 
-	var resp map[string]string
-	_ = json.NewDecoder(rr.Body).Decode(&resp)
+	if rr := capture(); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 when fulfillment fails, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "PENDING" {
+		t.Errorf("expected order to stay PENDING, got %s", orderRepo.orders[0].Status)
+	}
+	if len(paymentRepo.payments) != 0 || cartRepo.cleared {
+		t.Errorf("expected no partial writes, got %d payments, cart cleared %v", len(paymentRepo.payments), cartRepo.cleared)
+	}
 
-	if resp["approve_url"] != "https://paypal.com/approve/retry" {
-		t.Errorf("expected retry approve_url, got %s", resp["approve_url"])
+	// The retry hits ORDER_ALREADY_CAPTURED and completes the fulfillment
+	userRepo.assignErr = nil
+	if rr := capture(); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on retry, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "COMPLETED" {
+		t.Errorf("expected order COMPLETED, got %s", orderRepo.orders[0].Status)
+	}
+	if len(paymentRepo.payments) != 1 || len(userRepo.assignedCourses["user-1"]) != 1 || !cartRepo.cleared {
+		t.Errorf("expected full fulfillment, got %d payments, courses %v, cart cleared %v",
+			len(paymentRepo.payments), userRepo.assignedCourses["user-1"], cartRepo.cleared)
 	}
 }
 
-func TestRetryOrder_PayPalDown(t *testing.T) {
+func TestCaptureCheckout_OtherUsersOrder(t *testing.T) {
 	orderRepo := &mockOrderRepo{
 		orders: []*models.Order{
-			{ID: "failed-order", Status: "FAILED", TotalAmount: 100, UserID: "user-1"},
+			{ID: "order-1", UserID: "user-2", Status: "PENDING", TotalAmount: 10000, Currency: "CAD"},
 		},
 	}
 	pp := &mockPayPalClient{
-		createOrderFn: func(amount float64, orderID string) (string, string, error) {
-			return "", "", fmt.Errorf("PayPal timeout")
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			t.Error("did not expect a capture for another user's order")
+			return nil, nil
 		},
 	}
 	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
-	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/failed-order/retry", nil)
-	req = mux.SetURLVars(req, map[string]string{"id": "failed-order"})
+	withAttempt(h, "order-1", "PAYPAL-1")
+
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "PAYPAL-1"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
 	rr := httptest.NewRecorder()
-	h.RetryOrder(rr, req)
+	h.CaptureCheckout(rr, req)
 
-	if rr.Code != http.StatusInternalServerError {
-		t.Errorf("expected 500 when PayPal is down, got %d", rr.Code)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's order, got %d", rr.Code)
+	}
+}
+
+func TestCaptureCheckout_UnknownPayPalOrder(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD"},
+			{ID: "order-cheap", UserID: "user-1", Status: "PENDING", TotalAmount: 100, Currency: "CAD"},
+		},
+	}
+	pp := &mockPayPalClient{
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			t.Error("did not expect a capture for a foreign PayPal order")
+			return nil, nil
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+	withAttempt(h, "order-cheap", "PAYPAL-CHEAP")
+
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "PAYPAL-CHEAP"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.CaptureCheckout(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a PayPal order of another order, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "PENDING" {
+		t.Errorf("expected order to stay PENDING, got %s", orderRepo.orders[0].Status)
+	}
+}
+
+func TestCaptureCheckout_AmountMismatchRecorded(t *testing.T) {
+	pp := &mockPayPalClient{
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return completedCapture("order-1", 100), nil
+		},
+	}
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1"}}},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, userRepo, pp)
+	attempt := withAttempt(h, "order-1", "PAYPAL-1")
+
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "PAYPAL-1"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.CaptureCheckout(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for amount mismatch, got %d", rr.Code)
+	}
+	if attempt.Status != "MISMATCH" || attempt.MismatchReason == "" || attempt.CaptureID != "CAPTURE-1" {
+		t.Errorf("expected attempt recorded as MISMATCH, got %+v", attempt)
+	}
+	if orderRepo.orders[0].Status != "PENDING" || len(paymentRepo.payments) != 0 || len(userRepo.assignedCourses) != 0 {
+		t.Errorf("expected no fulfillment, got status %s, %d payments, courses %v",
+			orderRepo.orders[0].Status, len(paymentRepo.payments), userRepo.assignedCourses)
+	}
+}
+
+func TestCaptureMismatch(t *testing.T) {
+	order := &models.Order{ID: "order-1", TotalAmount: 4999, Currency: "CAD"}
+	tests := []struct {
+		name    string
+		capture *CaptureResult
+		want    bool
+	}{
+		{name: "match", capture: fromPayPalCapture(completedCapture("order-1", 4999))},
+		{name: "custom id only", capture: fromPayPalCapture(&paypal.CaptureResult{CustomID: "order-1", Amount: paypal.Amount{CurrencyCode: "CAD", Value: "49.99"}})},
+		{name: "other order", capture: fromPayPalCapture(completedCapture("order-2", 4999)), want: true},
+		{name: "amount", capture: fromPayPalCapture(completedCapture("order-1", 4998)), want: true},
+		{name: "currency", capture: &CaptureResult{Reference: "order-1", Amount: money.New(4999, "EUR")}, want: true},
+		{name: "no amount", capture: fromPayPalCapture(&paypal.CaptureResult{ReferenceID: "order-1"}), want: true},
+		{name: "invalid amount", capture: fromPayPalCapture(&paypal.CaptureResult{ReferenceID: "order-1", Amount: paypal.Amount{CurrencyCode: "CAD", Value: "lots"}}), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := captureMismatch(order, tt.capture); (got != "") != tt.want {
+				t.Errorf("captureMismatch() = %q, want mismatch %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFulfillOrder_SkipsFulfilledOrder(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1"}}},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, userRepo, &mockPayPalClient{})
+
+	for i := 0; i < 2; i++ {
+		if err := h.fulfillOrder(context.Background(), orderRepo.orders[0], paypalAttempt(orderRepo.orders[0].ID), "CAPTURE-1"); err != nil {
+			t.Fatalf("fulfillOrder call %d: %v", i+1, err)
+		}
+	}
+	if len(paymentRepo.payments) != 1 {
+		t.Errorf("expected 1 payment, got %d", len(paymentRepo.payments))
+	}
+	if len(userRepo.assignedCourses["user-1"]) != 1 {
+		t.Errorf("expected 1 enrollment, got %v", userRepo.assignedCourses["user-1"])
+	}
+}
+
+func TestFulfillOrder_RedeemsCouponsOnce(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{
+				ID: "order-1", UserID: "user-1", Status: "PENDING", Subtotal: 10000, Discount: 2000, TotalAmount: 8000, Currency: "CAD",
+				Items:     []models.OrderItem{{CourseID: "course-1", Price: 10000, Discount: 2000}},
+				Discounts: []models.OrderDiscount{{CouponID: "coupon-1", Code: "SAVE20", Amount: 2000}},
+			},
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	for i := 0; i < 2; i++ {
+		if err := h.fulfillOrder(context.Background(), orderRepo.orders[0], paypalAttempt(orderRepo.orders[0].ID), "CAPTURE-1"); err != nil {
+			t.Fatalf("fulfillOrder call %d: %v", i+1, err)
+		}
+	}
+	redemptions := h.uow.(*mockUnitOfWork).repos.Coupons.(*mockCouponRepo).redemptions
+	if len(redemptions) != 1 {
+		t.Fatalf("expected 1 redemption, got %d", len(redemptions))
+	}
+	if r := redemptions[0]; r.CouponID != "coupon-1" || r.UserID != "user-1" || r.OrderID != "order-1" || r.Amount != 2000 {
+		t.Errorf("unexpected redemption %+v", r)
+	}
+}
+
+func TestFulfillOrder_GiftMintsCodesForRecipient(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{{
+			ID: "order-1", UserID: "user-1", Status: "PENDING", Subtotal: 20000, TotalAmount: 20000, Currency: "CAD",
+			GiftRecipientEmail: "friend@example.com", GiftRecipientName: "Sam",
+			Items: []models.OrderItem{
+				{ID: "order-item-1", CourseID: "course-1", CourseName: "French A1", Price: 10000},
+				{ID: "order-item-2", CourseID: "course-2", CourseName: "French A2", Price: 10000},
+			},
+		}},
+	}
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, &mockPayPalClient{})
+
+	for i := 0; i < 2; i++ {
+		if err := h.fulfillOrder(context.Background(), orderRepo.orders[0], paypalAttempt("order-1"), "CAPTURE-1"); err != nil {
+			t.Fatalf("fulfillOrder call %d: %v", i+1, err)
+		}
+	}
+	h.WaitForReceipts()
+
+	if len(userRepo.assignedCourses["user-1"]) != 0 {
+		t.Errorf("expected the buyer not to be enrolled, got %v", userRepo.assignedCourses["user-1"])
+	}
+	codes := h.codeRepo.(*mockCodeRepo).codes
+	if len(codes) != 2 {
+		t.Fatalf("expected one code per course, got %d", len(codes))
+	}
+	for _, c := range codes {
+		if c.Kind != models.EnrollmentCodeGift || c.IssuedByID != "user-1" || *c.OrderID != "order-1" || c.RecipientEmail != "friend@example.com" || c.Code == "" {
+			t.Errorf("unexpected code %+v", c)
+		}
+	}
+
+	gifts := h.gifts.(*mockGiftMailer).sent
+	if len(gifts) != 1 {
+		t.Fatalf("expected one gift email, got %d", len(gifts))
+	}
+	gift := <-gifts
+	if gift.to != "friend@example.com" || gift.from != "Student user-1" || len(gift.courses) != 2 || gift.courses[0].Code != codes[0].Code || gift.courses[0].Course != "French A1" {
+		t.Errorf("unexpected gift email %+v", gift)
+	}
+}
+
+func TestFulfillOrder_IssuesNumberedInvoice(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{
+				ID: "order-1", UserID: "user-1", Status: "PENDING", Subtotal: 10000, TaxTotal: 1300, TotalAmount: 11300, Currency: "CAD", TaxProvince: "ON",
+				Items: []models.OrderItem{{CourseID: "course-1", CourseName: "French A1", Price: 10000, Tax: 1300, Installments: 1}},
+				Taxes: []models.OrderTax{{Name: "HST", Rate: 13, Amount: 1300}},
+			},
+			{ID: "order-2", UserID: "user-2", Status: "PENDING", Subtotal: 5000, TotalAmount: 5000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-2", Price: 5000}}},
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	for i, order := range orderRepo.orders {
+		if err := h.fulfillOrder(context.Background(), order, paypalAttempt(order.ID), fmt.Sprintf("CAPTURE-%d", i+1)); err != nil {
+			t.Fatalf("fulfillOrder(%s) = %v", order.ID, err)
+		}
+	}
+
+	invoices := h.invoiceRepo.(*mockInvoiceRepo)
+	first, err := invoices.FindByOrderID(context.Background(), "order-1", 0)
+	if err != nil {
+		t.Fatalf("expected an invoice for order-1: %v", err)
+	}
+	if first.Number != "INV-000001" || first.SellerName != "A1 French Classes" || first.BuyerEmail != "user-1@example.com" || first.PaymentReference != "CAPTURE-1" {
+		t.Errorf("unexpected invoice %+v", first)
+	}
+	if len(first.Lines) != 1 || first.Lines[0].Description != "French A1" || len(first.Taxes) != 1 || first.Total != 11300 || first.Paid != 11300 {
+		t.Errorf("unexpected invoice lines %+v, taxes %+v", first.Lines, first.Taxes)
+	}
+	if second, _ := invoices.FindByOrderID(context.Background(), "order-2", 0); second == nil || second.Sequence != 2 {
+		t.Errorf("expected order-2 to be invoice 2, got %+v", second)
+	}
+
+	mailer := h.mailer.(*mockMailer)
+	for i := 0; i < 2; i++ {
+		select {
+		case sent := <-mailer.sent:
+			if !bytes.HasPrefix(sent.pdf, []byte("%PDF-")) || !strings.HasSuffix(sent.to, "@example.com") {
+				t.Errorf("unexpected receipt email %s to %s", sent.number, sent.to)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected receipt emails for both orders")
+		}
+	}
+}
+
+func TestFulfillOrder_FailureDoesNotUseInvoiceNumber(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1", Price: 10000}}},
+		},
+	}
+	userRepo := &mockUserRepo{assignErr: errors.New("db down")}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, &mockPayPalClient{})
+
+	if err := h.fulfillOrder(context.Background(), orderRepo.orders[0], paypalAttempt(orderRepo.orders[0].ID), "CAPTURE-1"); err == nil {
+		t.Fatal("expected fulfillment to fail")
+	}
+	userRepo.assignErr = nil
+	if err := h.fulfillOrder(context.Background(), orderRepo.orders[0], paypalAttempt(orderRepo.orders[0].ID), "CAPTURE-1"); err != nil {
+		t.Fatalf("fulfillOrder() = %v", err)
+	}
+
+	invoices := h.invoiceRepo.(*mockInvoiceRepo).invoices
+	if len(invoices) != 1 || invoices[0].Number != "INV-000001" {
+		t.Errorf("expected one invoice INV-000001, got %+v", invoices)
+	}
+}
+
+// ===================== Installment Tests =====================
+
+func TestCheckout_PaymentPlanChargesFirstInstallment(t *testing.T) {
+	t.Setenv("CURRENCY", "CAD")
+	planID := uint(7)
+	plan := &models.PaymentPlan{ID: planID, CourseID: "course-1", Amount: 3000, Installments: 9}
+	cart := &models.Cart{
+		ID: "cart-1",
+		Items: []models.CartItem{
+			{ID: "item-1", CourseID: "course-1", Price: 27000, PaymentPlanID: &planID, PaymentPlan: plan},
+			{ID: "item-2", CourseID: "course-2", Price: 10000},
+		},
+	}
+	orderRepo := &mockOrderRepo{}
+	pp := &mockPayPalClient{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{cart: cart, total: 37000}, &mockUserRepo{province: "ON"}, pp)
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", nil)
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	order := orderRepo.orders[0]
+	if order.TotalAmount != 41810 || len(order.Installments) != 9 {
+		t.Fatalf("expected 418.10 over 9 installments, got %s over %d", order.TotalAmount, len(order.Installments))
+	}
+	// 305.10 over 9 is 33.90 a month, plus 113.00 for the course paid in full
+	if order.Installments[0].Amount != 14690 || order.Installments[8].Amount != 3390 {
+		t.Errorf("unexpected installments %s and %s", order.Installments[0].Amount, order.Installments[8].Amount)
+	}
+	var scheduled money.Amount
+	for _, inst := range order.Installments {
+		scheduled += inst.Amount
+	}
+	if scheduled != order.TotalAmount {
+		t.Errorf("expected installments to add up to %s, got %s", order.TotalAmount, scheduled)
+	}
+	if order.Items[0].Installments != 9 || order.Items[1].Installments != 1 {
+		t.Errorf("expected only the plan item in installments, got %d and %d", order.Items[0].Installments, order.Items[1].Installments)
+	}
+	if got := pp.lastCharge.Total(); got != money.New(14690, "CAD") {
+		t.Errorf("expected PayPal to charge the first installment, got %s", got)
+	}
+	// HST of 35.10 on the plan is 3.90 a month, the course paid in full
+	// carries its 13.00 on the first installment
+	if order.Installments[0].Tax != 1690 || order.Installments[8].Tax != 390 {
+		t.Errorf("expected installment tax of 16.90 and 3.90, got %s and %s", order.Installments[0].Tax, order.Installments[8].Tax)
+	}
+	if pp.lastCharge.ItemTotal != 13000 || pp.lastCharge.TaxTotal != 1690 {
+		t.Errorf("expected PayPal to get items of 130.00 and tax of 16.90, got %+v", pp.lastCharge)
+	}
+}
+
+func TestFulfillOrder_PaysFirstInstallment(t *testing.T) {
+	order := &models.Order{
+		ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 9000, Currency: "CAD",
+		Items: []models.OrderItem{{CourseID: "course-1", Installments: 3}},
+		Installments: []models.Installment{
+			{ID: "inst-1", OrderID: "order-1", Sequence: 1, Amount: 3000, Status: "SCHEDULED"},
+			{ID: "inst-2", OrderID: "order-1", Sequence: 2, Amount: 3000, Status: "SCHEDULED"},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	h := newTestHandler(paymentRepo, &mockOrderRepo{orders: []*models.Order{order}}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	if err := h.fulfillOrder(context.Background(), order, paypalAttempt(order.ID), "CAPTURE-1"); err != nil {
+		t.Fatalf("fulfillOrder() = %v", err)
+	}
+	if len(paymentRepo.payments) != 1 || paymentRepo.payments[0].TransactionAmount != 3000 {
+		t.Errorf("expected a 30.00 payment for the first installment, got %+v", paymentRepo.payments)
+	}
+	if order.Installments[0].Status != "PAID" || order.Installments[1].Status != "SCHEDULED" {
+		t.Errorf("expected only the first installment paid, got %s and %s", order.Installments[0].Status, order.Installments[1].Status)
+	}
+}
+
+// planOrder is a completed order for one course on a three month plan, with
+// its first installment paid
+func planOrder(h *PaymentHandler, second string) *models.Order {
+	order := &models.Order{
+		ID: "order-1", UserID: "user-1", Status: "COMPLETED", TotalAmount: 9000, Currency: "CAD",
+		Items: []models.OrderItem{{CourseID: "course-1", Installments: 3}},
+		Installments: []models.Installment{
+			{ID: "inst-1", OrderID: "order-1", Sequence: 1, Amount: 3000, Status: "PAID", DueAt: time.Now().AddDate(0, -1, -10)},
+			{ID: "inst-2", OrderID: "order-1", Sequence: 2, Amount: 3000, Status: second, DueAt: time.Now().AddDate(0, 0, -10)},
+			{ID: "inst-3", OrderID: "order-1", Sequence: 3, Amount: 3000, Status: "SCHEDULED", DueAt: time.Now().AddDate(0, 0, 20)},
+		},
+	}
+	h.orderRepo.(*mockOrderRepo).orders = append(h.orderRepo.(*mockOrderRepo).orders, order)
+	repo := h.installmentRepo.(*mockInstallmentRepo)
+	for i := range order.Installments {
+		repo.installments = append(repo.installments, &order.Installments[i])
+	}
+	return order
+}
+
+func TestProcessInstallments_DefaultSuspendsAccess(t *testing.T) {
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, userRepo, &mockPayPalClient{})
+	order := planOrder(h, "SCHEDULED")
+
+	// Within the grace period the installment is only overdue
+	if err := h.ProcessInstallments(context.Background(), order.Installments[1].DueAt.Add(24*time.Hour)); err != nil {
+		t.Fatalf("ProcessInstallments() = %v", err)
+	}
+	if order.Installments[1].Status != "OVERDUE" || userRepo.suspended["course-1"] {
+		t.Errorf("expected OVERDUE with access kept, got %s suspended %v", order.Installments[1].Status, userRepo.suspended)
+	}
+
+	if err := h.ProcessInstallments(context.Background(), time.Now()); err != nil {
+		t.Fatalf("ProcessInstallments() = %v", err)
+	}
+	if order.Installments[1].Status != "DEFAULTED" || !userRepo.suspended["course-1"] {
+		t.Errorf("expected DEFAULTED with access suspended, got %s suspended %v", order.Installments[1].Status, userRepo.suspended)
+	}
+	if order.Installments[2].Status != "SCHEDULED" {
+		t.Errorf("expected the next installment untouched, got %s", order.Installments[2].Status)
+	}
+}
+
+// staleInstallmentRepo lists installments as they were before a payment
+// settled them, the way a billing run that started earlier would
+type staleInstallmentRepo struct {
+	*mockInstallmentRepo
+	listed []models.Installment
+}
+
+func (m *staleInstallmentRepo) FindUnpaidDue(ctx context.Context, now time.Time) ([]models.Installment, error) {
+	return m.listed, nil
+}
+
+func TestProcessInstallments_KeepsConcurrentPayment(t *testing.T) {
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, userRepo, &mockPayPalClient{})
+	order := planOrder(h, "OVERDUE")
+	listed := []models.Installment{order.Installments[1]}
+	order.Installments[1].Status = "PAID"
+	h.installmentRepo = &staleInstallmentRepo{mockInstallmentRepo: h.installmentRepo.(*mockInstallmentRepo), listed: listed}
+
+	if err := h.ProcessInstallments(context.Background(), time.Now()); err != nil {
+		t.Fatalf("ProcessInstallments() = %v", err)
+	}
+	if order.Installments[1].Status != "PAID" || userRepo.suspended["course-1"] {
+		t.Errorf("expected the paid installment kept with access, got %s suspended %v", order.Installments[1].Status, userRepo.suspended)
+	}
+}
+
+func TestSendInstallmentReminders_OncePerInstallment(t *testing.T) {
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	order := planOrder(h, "SCHEDULED")
+	order.Installments[2].DueAt = time.Now().Add(48 * time.Hour)
+	mailer := h.reminders.(*mockInstallmentMailer)
+
+	for range 2 {
+		if err := h.SendInstallmentReminders(context.Background(), time.Now()); err != nil {
+			t.Fatalf("SendInstallmentReminders() = %v", err)
+		}
+	}
+
+	if len(mailer.sent) != 1 {
+		t.Fatalf("expected one reminder, got %d", len(mailer.sent))
+	}
+	sent := mailer.sent[0]
+	if sent.to != "user-1@example.com" || sent.amount != money.New(3000, "CAD") || !strings.HasSuffix(sent.link, "/orders/order-1") {
+		t.Errorf("unexpected reminder %+v", sent)
+	}
+}
+
+func TestCaptureInstallment_RestoresAccess(t *testing.T) {
+	pp := &mockPayPalClient{
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return completedCapture("order-1", 3000), nil
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	userRepo := &mockUserRepo{suspended: map[string]bool{"course-1": true}}
+	h := newTestHandler(paymentRepo, &mockOrderRepo{}, &mockCartRepo{}, userRepo, pp)
+	order := planOrder(h, "DEFAULTED")
+	installmentID := "inst-2"
+	attempt := withAttempt(h, "order-1", "PAYPAL-2")
+	attempt.InstallmentID = &installmentID
+
+	body, _ := json.Marshal(map[string]string{"token": "PAYPAL-2"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/installments/inst-2/capture", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "inst-2"})
+	rr := httptest.NewRecorder()
+	h.CaptureInstallment(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if order.Installments[1].Status != "PAID" || len(paymentRepo.payments) != 1 || paymentRepo.payments[0].TransactionAmount != 3000 {
+		t.Errorf("expected the installment paid with a 30.00 payment, got %s and %+v", order.Installments[1].Status, paymentRepo.payments)
+	}
+	if userRepo.suspended["course-1"] {
+		t.Error("expected access to be restored")
+	}
+
+	receipt, err := h.invoiceRepo.FindByOrderID(context.Background(), "order-1", 2)
+	if err != nil {
+		t.Fatalf("expected a receipt for the installment: %v", err)
+	}
+	if receipt.Number != "INV-000001" || receipt.Paid != 3000 || receipt.Balance != 3000 || receipt.Total != 9000 || receipt.PaymentReference != completedCapture("order-1", 3000).CaptureID {
+		t.Errorf("unexpected installment receipt %+v", receipt)
+	}
+	select {
+	case sent := <-h.mailer.(*mockMailer).sent:
+		if sent.number != receipt.Number || sent.to != "user-1@example.com" {
+			t.Errorf("unexpected receipt email %s to %s", sent.number, sent.to)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the installment receipt to be emailed")
+	}
+}
+
+func TestCaptureInstallment_DeclinedIsTracked(t *testing.T) {
+	pp := &mockPayPalClient{
+		captureOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return nil, &paypal.APIError{StatusCode: http.StatusUnprocessableEntity, Name: "UNPROCESSABLE_ENTITY", Details: []paypal.ErrorDetail{{Issue: "INSTRUMENT_DECLINED"}}}
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, pp)
+	order := planOrder(h, "OVERDUE")
+	installmentID := "inst-2"
+	withAttempt(h, "order-1", "PAYPAL-2").InstallmentID = &installmentID
+
+	body, _ := json.Marshal(map[string]string{"token": "PAYPAL-2"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/installments/inst-2/capture", bytes.NewBuffer(body))
+	req = mux.SetURLVars(req, map[string]string{"id": "inst-2"})
+	rr := httptest.NewRecorder()
+	h.CaptureInstallment(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a declined payment, got %d", rr.Code)
+	}
+	if inst := order.Installments[1]; inst.Status != "OVERDUE" || inst.FailedAttempts != 1 || inst.LastFailure == "" {
+		t.Errorf("expected the failure recorded on the overdue installment, got %+v", inst)
+	}
+}
+
+func TestPayInstallment_OtherUsersInstallment(t *testing.T) {
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	planOrder(h, "SCHEDULED")
+
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-2"), "POST", "/api/installments/inst-2/pay", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "inst-2"})
+	rr := httptest.NewRecorder()
+	h.PayInstallment(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's installment, got %d", rr.Code)
+	}
+}
+
+// ===================== Order Expiry Tests =====================
+
+func TestExpireStaleOrders_CancelsAndVoids(t *testing.T) {
+	now := time.Now()
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{
+				ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-25 * time.Hour),
+				Attempts: []models.PaymentAttempt{{OrderID: "order-1", ProviderOrderID: "PAYPAL-1", Status: "CREATED"}},
+			},
+			{ID: "order-2", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-time.Hour)},
+		},
+	}
+	pp := &mockPayPalClient{
+		getOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return &paypal.CaptureResult{Status: "APPROVED"}, nil
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+
+	if err := h.ExpireStaleOrders(context.Background(), now); err != nil {
+		t.Fatalf("ExpireStaleOrders() = %v", err)
+	}
+	if orderRepo.orders[0].Status != "CANCELLED" || orderRepo.orders[0].Attempts[0].Status != "VOIDED" {
+		t.Errorf("expected the stale order cancelled and its PayPal order voided, got %s / %s", orderRepo.orders[0].Status, orderRepo.orders[0].Attempts[0].Status)
+	}
+	if orderRepo.orders[1].Status != "PENDING" {
+		t.Errorf("expected the recent order to stay PENDING, got %s", orderRepo.orders[1].Status)
+	}
+
+	// A late capture of the voided PayPal order is refused
+	withAttempt(h, "order-1", "PAYPAL-1")
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "PAYPAL-1"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.CaptureCheckout(rr, req)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("expected 409 capturing an expired order, got %d", rr.Code)
+	}
+}
+
+func TestExpireStaleOrders_FulfillsCapturedOrder(t *testing.T) {
+	now := time.Now()
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{
+				ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-48 * time.Hour),
+				Items:    []models.OrderItem{{CourseID: "course-1", Price: 10000}},
+				Attempts: []models.PaymentAttempt{{OrderID: "order-1", ProviderOrderID: "PAYPAL-1", Status: "CREATED"}},
+			},
+		},
+	}
+	pp := &mockPayPalClient{
+		getOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return completedCapture("order-1", 10000), nil
+		},
+	}
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, pp)
+
+	if err := h.ExpireStaleOrders(context.Background(), now); err != nil {
+		t.Fatalf("ExpireStaleOrders() = %v", err)
+	}
+	if orderRepo.orders[0].Status != "COMPLETED" || len(userRepo.assignedCourses["user-1"]) != 1 {
+		t.Errorf("expected a captured order to be fulfilled, got %s", orderRepo.orders[0].Status)
+	}
+}
+
+func TestExpireStaleOrders_PayPalUnavailable(t *testing.T) {
+	now := time.Now()
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{
+				ID: "order-1", UserID: "user-1", Status: "PENDING", CreatedAt: now.Add(-48 * time.Hour),
+				Attempts: []models.PaymentAttempt{{OrderID: "order-1", ProviderOrderID: "PAYPAL-1", Status: "CREATED"}},
+			},
+		},
+	}
+	pp := &mockPayPalClient{
+		getOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return nil, &paypal.APIError{StatusCode: http.StatusServiceUnavailable, Name: ErrServiceUnavailable}
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+
+	if err := h.ExpireStaleOrders(context.Background(), now); err != nil {
+		t.Fatalf("ExpireStaleOrders() = %v", err)
+	}
+	if orderRepo.orders[0].Status != "PENDING" {
+		t.Errorf("expected the order to wait for PayPal, got %s", orderRepo.orders[0].Status)
+	}
+}
+
+func TestExpireStaleOrders_ExpiresStripeSession(t *testing.T) {
+	now := time.Now()
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{
+				ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-25 * time.Hour),
+				Attempts: []models.PaymentAttempt{{OrderID: "order-1", Provider: "STRIPE", ProviderOrderID: "cs_1", Status: "CREATED"}},
+			},
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	expired := false
+	withStripe(t, h, map[string]http.HandlerFunc{
+		"/v1/checkout/sessions/cs_1": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"id":"cs_1","status":"open","payment_status":"unpaid","client_reference_id":"order-1"}`))
+		},
+		"/v1/checkout/sessions/cs_1/expire": func(w http.ResponseWriter, r *http.Request) {
+			expired = r.Method == "POST"
+			_, _ = w.Write([]byte(`{"id":"cs_1","status":"expired","payment_status":"unpaid"}`))
+		},
+	})
+
+	if err := h.ExpireStaleOrders(context.Background(), now); err != nil {
+		t.Fatalf("ExpireStaleOrders() = %v", err)
+	}
+	if !expired {
+		t.Error("expected the Checkout Session to be expired at Stripe")
+	}
+	if orderRepo.orders[0].Status != "CANCELLED" || orderRepo.orders[0].Attempts[0].Status != "VOIDED" {
+		t.Errorf("expected the order cancelled and its session voided, got %s / %s", orderRepo.orders[0].Status, orderRepo.orders[0].Attempts[0].Status)
+	}
+}
+
+func TestExpireStaleOrders_KeepsOrderWhenSessionCannotBeExpired(t *testing.T) {
+	now := time.Now()
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{
+				ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-25 * time.Hour),
+				Attempts: []models.PaymentAttempt{{OrderID: "order-1", Provider: "STRIPE", ProviderOrderID: "cs_1", Status: "CREATED"}},
+			},
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	withStripe(t, h, map[string]http.HandlerFunc{
+		// Paid with a bank debit that is still processing
+		"/v1/checkout/sessions/cs_1": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"id":"cs_1","status":"complete","payment_status":"unpaid","client_reference_id":"order-1"}`))
+		},
+		"/v1/checkout/sessions/cs_1/expire": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error":{"type":"invalid_request_error","message":"Only Checkout Sessions with a status in [\"open\"] can be expired."}}`))
+		},
+	})
+
+	if err := h.ExpireStaleOrders(context.Background(), now); err != nil {
+		t.Fatalf("ExpireStaleOrders() = %v", err)
+	}
+	if orderRepo.orders[0].Status != "PENDING" || orderRepo.orders[0].Attempts[0].Status != "CREATED" {
+		t.Errorf("expected the order left for the next run, got %s / %s", orderRepo.orders[0].Status, orderRepo.orders[0].Attempts[0].Status)
+	}
+}
+
+// mockJobLocker runs jobs unless held is set, as if another replica had the lock
+type mockJobLocker struct {
+	held bool
+}
+
+func (m *mockJobLocker) TryDo(ctx context.Context, job string, fn func(ctx context.Context) error) (bool, error) {
+	if m.held {
+		return false, nil
+	}
+	return true, fn(ctx)
+}
+
+func TestRunOrderExpiry_SkipsWhileAnotherReplicaRuns(t *testing.T) {
+	now := time.Now()
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{{ID: "order-1", UserID: "user-1", Status: "PENDING", CreatedAt: now.Add(-25 * time.Hour)}},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	h.jobs = &mockJobLocker{held: true}
+	h.RunOrderExpiry(ctx, time.Hour)
+	if orderRepo.orders[0].Status != "PENDING" {
+		t.Fatalf("expected no run while the lock is held, got %s", orderRepo.orders[0].Status)
+	}
+
+	h.jobs = &mockJobLocker{}
+	h.RunOrderExpiry(ctx, time.Hour)
+	if orderRepo.orders[0].Status != "CANCELLED" {
+		t.Errorf("expected the order expired once the lock is free, got %s", orderRepo.orders[0].Status)
+	}
+}
+
+// ===================== Reconcile Tests =====================
+
+// reconcileFixture has one order for each kind of drift plus one that agrees
+// with PayPal and one outside the date range
+func reconcileFixture(now time.Time) (*mockOrderRepo, *mockPayPalClient) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{
+				ID: "order-paid", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-time.Hour),
+				Items:    []models.OrderItem{{CourseID: "course-1", Price: 10000}},
+				Attempts: []models.PaymentAttempt{{OrderID: "order-paid", ProviderOrderID: "PAYPAL-PAID", Status: "CREATED"}},
+			},
+			{
+				ID: "order-uncaptured", UserID: "user-2", Status: "COMPLETED", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-time.Hour),
+				Payments: []models.Payment{{ID: "payment-2", OrderID: "order-uncaptured", TransactionAmount: 10000, Currency: "CAD", TransactionStatus: "SUCCESS", ProviderTransactionID: "CAPTURE-MISSING"}},
+			},
+			{
+				ID: "order-short", UserID: "user-3", Status: "COMPLETED", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-time.Hour),
+				Payments: []models.Payment{{ID: "payment-3", OrderID: "order-short", TransactionAmount: 10000, Currency: "CAD", TransactionStatus: "SUCCESS", ProviderTransactionID: "CAPTURE-SHORT"}},
+			},
+			{
+				ID: "order-ok", UserID: "user-4", Status: "COMPLETED", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-time.Hour),
+				Payments: []models.Payment{{ID: "payment-4", OrderID: "order-ok", TransactionAmount: 10000, Currency: "CAD", TransactionStatus: "SUCCESS", ProviderTransactionID: "CAPTURE-OK"}},
+			},
+			{
+				ID: "order-old", UserID: "user-5", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", CreatedAt: now.Add(-30 * 24 * time.Hour),
+				Attempts: []models.PaymentAttempt{{OrderID: "order-old", ProviderOrderID: "PAYPAL-OLD", Status: "CREATED"}},
+			},
+		},
+	}
+	pp := &mockPayPalClient{
+		getOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			switch orderID {
+			case "PAYPAL-PAID":
+				return completedCapture("order-paid", 10000), nil
+			case "PAYPAL-OLD":
+				return completedCapture("order-old", 10000), nil
+			}
+			return nil, &paypal.APIError{StatusCode: http.StatusNotFound, Name: "RESOURCE_NOT_FOUND"}
+		},
+		getCaptureFn: func(captureID string) (*paypal.CaptureDetails, error) {
+			switch captureID {
+			case "CAPTURE-SHORT":
+				return &paypal.CaptureDetails{ID: captureID, Status: "COMPLETED", Amount: paypal.Amount{CurrencyCode: "CAD", Value: "90.00"}}, nil
+			case "CAPTURE-OK":
+				return &paypal.CaptureDetails{ID: captureID, Status: "COMPLETED", Amount: paypal.Amount{CurrencyCode: "CAD", Value: "100.00"}}, nil
+			}
+			return nil, &paypal.APIError{StatusCode: http.StatusNotFound, Name: "RESOURCE_NOT_FOUND"}
+		},
+	}
+	return orderRepo, pp
+}
+
+func driftKinds(report *ReconcileReport) map[string]string {
+	kinds := make(map[string]string)
+	for _, d := range report.Drifts {
+		kinds[d.OrderID] = d.Kind
+	}
+	return kinds
+}
+
+func TestReconcile_DryRunOnlyReports(t *testing.T) {
+	now := time.Now()
+	orderRepo, pp := reconcileFixture(now)
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, pp)
+
+	report, err := h.Reconcile(context.Background(), now.Add(-24*time.Hour), now, false)
+	if err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+
+	want := map[string]string{
+		"order-paid":       DriftPaidNotFulfilled,
+		"order-uncaptured": DriftMissingCapture,
+		"order-short":      DriftAmountMismatch,
+	}
+	if got := driftKinds(report); !reflect.DeepEqual(got, want) {
+		t.Errorf("drifts = %v, want %v", got, want)
+	}
+	if report.Orders != 4 || report.Unresolved() != 3 {
+		t.Errorf("expected 4 orders checked and 3 unresolved, got %d and %d", report.Orders, report.Unresolved())
+	}
+	if orderRepo.orders[0].Status != "PENDING" || orderRepo.orders[1].Status != "COMPLETED" || len(userRepo.assignedCourses) != 0 {
+		t.Error("a dry run must not change any order")
+	}
+}
+
+func TestReconcile_ApplyFixesStatuses(t *testing.T) {
+	now := time.Now()
+	orderRepo, pp := reconcileFixture(now)
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, pp)
+
+	report, err := h.Reconcile(context.Background(), now.Add(-24*time.Hour), now, true)
+	if err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	h.WaitForReceipts()
+
+	if orderRepo.orders[0].Status != "COMPLETED" || len(userRepo.assignedCourses["user-1"]) != 1 {
+		t.Errorf("expected the paid order to be fulfilled, got %s", orderRepo.orders[0].Status)
+	}
+	if orderRepo.orders[0].Attempts[0].Status != "CAPTURED" {
+		t.Errorf("expected the attempt to be marked captured, got %s", orderRepo.orders[0].Attempts[0].Status)
+	}
+	if orderRepo.orders[1].Status != "FAILED" {
+		t.Errorf("expected the order with no capture to be failed, got %s", orderRepo.orders[1].Status)
+	}
+	if orderRepo.orders[2].Status != "COMPLETED" {
+		t.Errorf("an amount mismatch must be left for review, got %s", orderRepo.orders[2].Status)
+	}
+	if orderRepo.orders[4].Status != "PENDING" {
+		t.Errorf("orders outside the range must be left alone, got %s", orderRepo.orders[4].Status)
+	}
+	if report.Unresolved() != 1 {
+		t.Errorf("expected only the mismatch to be unresolved, got %+v", report.Drifts)
+	}
+}
+
+func TestReconcile_LookupFailureIsReported(t *testing.T) {
+	now := time.Now()
+	orderRepo, _ := reconcileFixture(now)
+	pp := &mockPayPalClient{
+		getOrderFn: func(orderID string) (*paypal.CaptureResult, error) {
+			return nil, &paypal.APIError{StatusCode: http.StatusServiceUnavailable, Name: ErrServiceUnavailable}
+		},
+		getCaptureFn: func(captureID string) (*paypal.CaptureDetails, error) {
+			return nil, &paypal.APIError{StatusCode: http.StatusServiceUnavailable, Name: ErrServiceUnavailable}
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+
+	report, err := h.Reconcile(context.Background(), now.Add(-24*time.Hour), now, true)
+	if err != nil {
+		t.Fatalf("Reconcile() = %v", err)
+	}
+	for _, d := range report.Drifts {
+		if d.Kind != DriftLookupFailed || d.Fixed {
+			t.Errorf("expected only unfixed lookup failures, got %+v", d)
+		}
+	}
+	if len(report.Drifts) != 4 || orderRepo.orders[1].Status != "COMPLETED" {
+		t.Errorf("expected every order in range to be reported and left alone, got %+v", report.Drifts)
+	}
+}
+
+// ===================== RetryOrder Tests =====================
+
+func TestRetryOrder_OrderNotFound(t *testing.T) {
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/non-existent/retry", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "non-existent"})
+	rr := httptest.NewRecorder()
+	h.RetryOrder(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for missing order, got %d", rr.Code)
+	}
+}
+
+func TestRetryOrder_AlreadyCompleted(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "completed-order", Status: "COMPLETED", TotalAmount: 10000, Currency: "CAD", UserID: "user-1"},
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/completed-order/retry", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "completed-order"})
+	rr := httptest.NewRecorder()
+	h.RetryOrder(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for completed order retry, got %d", rr.Code)
+	}
+}
+
+func TestRetryOrder_RefundedOrder(t *testing.T) {
+	for _, status := range []string{"REFUNDED", "PARTIALLY_REFUNDED"} {
+		orderRepo := &mockOrderRepo{
+			orders: []*models.Order{
+				{ID: "refunded-order", Status: status, TotalAmount: 10000, Currency: "CAD", UserID: "user-1"},
+			},
+		}
+		pp := &mockPayPalClient{}
+		h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+		req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/refunded-order/retry", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "refunded-order"})
+		rr := httptest.NewRecorder()
+		h.RetryOrder(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", status, rr.Code)
+		}
+		if len(h.attemptRepo.(*mockAttemptRepo).attempts) != 0 {
+			t.Errorf("%s: expected no new payment session", status)
+		}
+	}
+}
+
+func TestRetryOrder_Success(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "failed-order", Status: "FAILED", TotalAmount: 25050, Currency: "CAD", UserID: "user-1"},
+		},
+	}
+	pp := &mockPayPalClient{
+		createOrderFn: func(total money.Money, orderID string) (string, string, error) {
+			if total != money.New(25050, "CAD") {
+				t.Errorf("expected 250.50 CAD, got %s", total)
+			}
+			if orderID != "failed-order" {
+				t.Errorf("expected orderID 'failed-order', got %s", orderID)
+			}
+			return "PAYPAL_RETRY_123", "https://paypal.com/approve/retry", nil
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/failed-order/retry", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "failed-order"})
+	rr := httptest.NewRecorder()
+	h.RetryOrder(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for retry, got %d", rr.Code)
+	}
+
+	var resp map[string]string
+	_ = json.NewDecoder(rr.Body).Decode(&resp)
+
+	if resp["approve_url"] != "https://paypal.com/approve/retry" {
+		t.Errorf("expected retry approve_url, got %s", resp["approve_url"])
+	}
+
+	attempts := h.attemptRepo.(*mockAttemptRepo).attempts
+	if len(attempts) != 1 || attempts[0].ProviderOrderID != "PAYPAL_RETRY_123" || attempts[0].OrderID != "failed-order" {
+		t.Errorf("expected the retry to be recorded as an attempt, got %+v", attempts)
+	}
+}
+
+func TestRetryOrder_OtherUsersOrder(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "failed-order", Status: "FAILED", TotalAmount: 1000, Currency: "CAD", UserID: "user-2"},
+		},
+	}
+	pp := &mockPayPalClient{
+		createOrderFn: func(total money.Money, orderID string) (string, string, error) {
+			t.Error("did not expect a PayPal order for another user's order")
+			return "", "", nil
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/failed-order/retry", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "failed-order"})
+	rr := httptest.NewRecorder()
+	h.RetryOrder(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's order, got %d", rr.Code)
+	}
+}
+
+func TestRetryOrder_PayPalDown(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "failed-order", Status: "FAILED", TotalAmount: 10000, Currency: "CAD", UserID: "user-1"},
+		},
+	}
+	pp := &mockPayPalClient{
+		createOrderFn: func(total money.Money, orderID string) (string, string, error) {
+			return "", "", fmt.Errorf("PayPal timeout")
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/orders/failed-order/retry", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "failed-order"})
+	rr := httptest.NewRecorder()
+	h.RetryOrder(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 when PayPal is down, got %d", rr.Code)
+	}
+}
+
+// ===================== PayPalWebhook Tests =====================
+
+func captureEventBody(eventID, eventType, orderID string) []byte {
+	body, _ := json.Marshal(map[string]any{
+		"id":            eventID,
+		"event_type":    eventType,
+		"resource_type": "capture",
+		"resource": map[string]any{
+			"id":        "CAPTURE-1",
+			"status":    "COMPLETED",
+			"custom_id": orderID,
+			"amount":    map[string]string{"currency_code": "CAD", "value": "100.00"},
+			"supplementary_data": map[string]any{
+				"related_ids": map[string]string{"order_id": "PAYPAL-1"},
+			},
+		},
+	})
+	return body
+}
+
+func TestPayPalWebhook_InvalidSignature(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{{ID: "order-1", Status: "PENDING", UserID: "user-1"}},
+	}
+	pp := &mockPayPalClient{verifyErr: errors.New("bad signature")}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+
+	req, _ := http.NewRequest("POST", "/api/webhooks/paypal", bytes.NewBuffer(captureEventBody("WH-1", "PAYMENT.CAPTURE.COMPLETED", "order-1")))
+	rr := httptest.NewRecorder()
+	h.PayPalWebhook(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for invalid signature, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "PENDING" {
+		t.Errorf("expected order to stay PENDING, got %s", orderRepo.orders[0].Status)
+	}
+}
+
+func TestPayPalWebhook_CaptureCompletedFulfillsOrder(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", Status: "PENDING", UserID: "user-1", TotalAmount: 10000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1"}}},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	userRepo := &mockUserRepo{}
+	cartRepo := &mockCartRepo{cart: &models.Cart{ID: "cart-1"}}
+	h := newTestHandler(paymentRepo, orderRepo, cartRepo, userRepo, &mockPayPalClient{})
+	withAttempt(h, "order-1", "PAYPAL-1")
+
+	req, _ := http.NewRequest("POST", "/api/webhooks/paypal", bytes.NewBuffer(captureEventBody("WH-1", "PAYMENT.CAPTURE.COMPLETED", "order-1")))
+	rr := httptest.NewRecorder()
+	h.PayPalWebhook(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "COMPLETED" {
+		t.Errorf("expected order status COMPLETED, got %s", orderRepo.orders[0].Status)
+	}
+	if len(paymentRepo.payments) != 1 || paymentRepo.payments[0].ProviderTransactionID != "CAPTURE-1" {
+		t.Errorf("expected one payment for CAPTURE-1, got %+v", paymentRepo.payments)
+	}
+	if got := userRepo.assignedCourses["user-1"]; len(got) != 1 || got[0] != "course-1" {
+		t.Errorf("expected course-1 assigned to user-1, got %v", got)
+	}
+	if !cartRepo.cleared {
+		t.Error("expected cart to be cleared")
+	}
+}
+
+func TestPayPalWebhook_CaptureAmountMismatchNotFulfilled(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", Status: "PENDING", UserID: "user-1", TotalAmount: 25000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1"}}},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	attempt := withAttempt(h, "order-1", "PAYPAL-1")
+
+	req, _ := http.NewRequest("POST", "/api/webhooks/paypal", bytes.NewBuffer(captureEventBody("WH-1", "PAYMENT.CAPTURE.COMPLETED", "order-1")))
+	rr := httptest.NewRecorder()
+	h.PayPalWebhook(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 so PayPal stops redelivering, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "PENDING" || len(paymentRepo.payments) != 0 {
+		t.Errorf("expected order not fulfilled, got status %s and %d payments", orderRepo.orders[0].Status, len(paymentRepo.payments))
+	}
+	if attempt.Status != "MISMATCH" {
+		t.Errorf("expected attempt MISMATCH, got %s", attempt.Status)
+	}
+}
+
+func TestPayPalWebhook_DuplicateEventIgnored(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", Status: "PENDING", UserID: "user-1", TotalAmount: 10000, Currency: "CAD"},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/api/webhooks/paypal", bytes.NewBuffer(captureEventBody("WH-1", "PAYMENT.CAPTURE.PENDING", "order-1")))
+		rr := httptest.NewRecorder()
+		h.PayPalWebhook(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("delivery %d: expected 200, got %d", i+1, rr.Code)
+		}
+	}
+
+	if len(paymentRepo.payments) != 1 {
+		t.Errorf("expected a single pending payment, got %d", len(paymentRepo.payments))
+	}
+}
+
+func TestPayPalWebhook_CaptureDeniedFailsOrder(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{{ID: "order-1", Status: "PENDING", UserID: "user-1"}},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	req, _ := http.NewRequest("POST", "/api/webhooks/paypal", bytes.NewBuffer(captureEventBody("WH-2", "PAYMENT.CAPTURE.DENIED", "order-1")))
+	rr := httptest.NewRecorder()
+	h.PayPalWebhook(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "FAILED" {
+		t.Errorf("expected order status FAILED, got %s", orderRepo.orders[0].Status)
+	}
+}
+
+// ===================== RefundOrder Tests =====================
+
+func completedOrderWithCapture() *models.Order {
+	return &models.Order{
+		ID:          "order-1",
+		UserID:      "user-1",
+		Status:      "COMPLETED",
+		TotalAmount: 10000,
+		Currency:    "CAD",
+		Items:       []models.OrderItem{{CourseID: "course-1"}},
+		Payments: []models.Payment{
+			{ID: "payment-1", OrderID: "order-1", TransactionAmount: 10000, Currency: "CAD", TransactionStatus: "SUCCESS", ProviderTransactionID: "CAPTURE-1"},
+		},
+	}
+}
+
+func refundRequest(t *testing.T, orderID string, body map[string]any) *http.Request {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequestWithContext(contextWithUserID("admin-1"), "POST", "/api/admin/orders/"+orderID+"/refunds", bytes.NewBuffer(b))
+	return mux.SetURLVars(req, map[string]string{"id": orderID})
+}
+
+func TestRefundOrder_Partial(t *testing.T) {
+	order := completedOrderWithCapture()
+	orderRepo := &mockOrderRepo{orders: []*models.Order{order}}
+	paymentRepo := &mockPaymentRepo{}
+	var refundedCapture string
+	pp := &mockPayPalClient{
+		refundCaptureFn: func(captureID string, amount money.Money) (*paypal.RefundResult, error) {
+			refundedCapture = captureID
+			if amount != money.New(4000, "CAD") {
+				t.Errorf("expected refund of 40.00 CAD, got %s", amount)
+			}
+			return &paypal.RefundResult{ID: "REFUND-1", Status: "COMPLETED"}, nil
+		},
+	}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"amount": 40}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if refundedCapture != "CAPTURE-1" {
+		t.Errorf("expected capture CAPTURE-1 to be refunded, got %s", refundedCapture)
+	}
+	if order.Status != "PARTIALLY_REFUNDED" {
+		t.Errorf("expected order status PARTIALLY_REFUNDED, got %s", order.Status)
+	}
+	if len(paymentRepo.payments) != 1 {
+		t.Fatalf("expected one refund payment, got %d", len(paymentRepo.payments))
+	}
+	refund := paymentRepo.payments[0]
+	if refund.TransactionType != "REFUND" || refund.ParentPaymentID == nil || *refund.ParentPaymentID != "payment-1" {
+		t.Errorf("expected refund linked to payment-1, got %+v", refund)
+	}
+}
+
+func TestApplyRefund_PendingRefundSettles(t *testing.T) {
+	tests := []struct {
+		name          string
+		later         string
+		refundStatus  string
+		paymentStatus string
+		orderStatus   string
+	}{
+		{name: "goes through", later: "COMPLETED", refundStatus: "SUCCESS", paymentStatus: "REFUNDED", orderStatus: "REFUNDED"},
+		{name: "fails", later: "FAILED", refundStatus: "FAILED", paymentStatus: "SUCCESS", orderStatus: "COMPLETED"},
+		{name: "is cancelled", later: "CANCELLED", refundStatus: "FAILED", paymentStatus: "SUCCESS", orderStatus: "COMPLETED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := completedOrderWithCapture()
+			orderRepo := &mockOrderRepo{orders: []*models.Order{order}}
+			paymentRepo := &mockPaymentRepo{}
+			h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+			ctx := context.Background()
+
+			if _, err := h.applyRefund(ctx, order, &order.Payments[0], "REFUND-1", "PENDING", 10000); err != nil {
+				t.Fatalf("applyRefund(PENDING) = %v", err)
+			}
+			if order.Status != "REFUNDED" {
+				t.Fatalf("expected the pending refund to count, got order status %s", order.Status)
+			}
+
+			refund, err := h.applyRefund(ctx, order, &order.Payments[0], "REFUND-1", tt.later, 10000)
+			if err != nil {
+				t.Fatalf("applyRefund(%s) = %v", tt.later, err)
+			}
+			if len(paymentRepo.payments) != 1 || refund.TransactionStatus != tt.refundStatus {
+				t.Errorf("expected the one refund to become %s, got %d refunds, %s", tt.refundStatus, len(paymentRepo.payments), refund.TransactionStatus)
+			}
+			if got := paymentOf(order, "payment-1").TransactionStatus; got != tt.paymentStatus {
+				t.Errorf("expected the payment to be %s, got %s", tt.paymentStatus, got)
+			}
+			if order.Status != tt.orderStatus {
+				t.Errorf("expected the order to be %s, got %s", tt.orderStatus, order.Status)
+			}
+		})
+	}
+}
+
+func TestRefundOrder_FullWithRevoke(t *testing.T) {
+	order := completedOrderWithCapture()
+	orderRepo := &mockOrderRepo{orders: []*models.Order{order}}
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, &mockPayPalClient{})
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"revoke_access": true}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if order.Status != "REFUNDED" {
+		t.Errorf("expected order status REFUNDED, got %s", order.Status)
+	}
+	if got := userRepo.revokedCourses["user-1"]; len(got) != 1 || got[0] != "course-1" {
+		t.Errorf("expected course-1 revoked from user-1, got %v", got)
+	}
+}
+
+func TestRefundOrder_PartialRefundKeepsAccess(t *testing.T) {
+	order := completedOrderWithCapture()
+	orderRepo := &mockOrderRepo{orders: []*models.Order{order}}
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, &mockPayPalClient{})
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"amount": 40, "revoke_access": true}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := userRepo.revokedCourses["user-1"]; len(got) != 0 {
+		t.Errorf("expected no course revoked after a partial refund, got %v", got)
+	}
+}
+
+func TestRefundOrder_RevokeKeepsCoursesGrantedElsewhere(t *testing.T) {
+	order := completedOrderWithCapture()
+	order.Items = []models.OrderItem{{CourseID: "course-1"}, {CourseID: "course-2"}, {CourseID: "course-3"}}
+	other := &models.Order{ID: "order-2", UserID: "user-1", Status: "COMPLETED", Items: []models.OrderItem{{CourseID: "course-1"}}}
+	refunded := &models.Order{ID: "order-3", UserID: "user-1", Status: "REFUNDED", Items: []models.OrderItem{{CourseID: "course-3"}}}
+	orderRepo := &mockOrderRepo{orders: []*models.Order{order, other, refunded}}
+	userRepo := &mockUserRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, userRepo, &mockPayPalClient{})
+	redeemer := "user-1"
+	h.codeRepo.(*mockCodeRepo).codes = []*models.EnrollmentCode{
+		{ID: "code-1", Kind: "COMPLIMENTARY", CourseID: "course-2", RedeemedByID: &redeemer},
+	}
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"revoke_access": true}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := userRepo.revokedCourses["user-1"]; len(got) != 1 || got[0] != "course-3" {
+		t.Errorf("expected only course-3 revoked, course-1 and course-2 are granted elsewhere, got %v", got)
+	}
+}
+
+func TestRefundOrder_ExceedsRemaining(t *testing.T) {
+	order := completedOrderWithCapture()
+	parentID := "payment-1"
+	order.Status = "PARTIALLY_REFUNDED"
+	order.Payments = append(order.Payments, models.Payment{
+		ID: "refund-0", TransactionAmount: 8000, TransactionStatus: "SUCCESS", TransactionType: "REFUND", ParentPaymentID: &parentID,
+	})
+	orderRepo := &mockOrderRepo{orders: []*models.Order{order}}
+	pp := &mockPayPalClient{
+		refundCaptureFn: func(captureID string, amount money.Money) (*paypal.RefundResult, error) {
+			t.Error("PayPal should not be called for an over-refund")
+			return nil, nil
+		},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"amount": 30}))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for refund above remaining balance, got %d", rr.Code)
+	}
+}
+
+func TestRefundOrder_PendingOrder(t *testing.T) {
+	orderRepo := &mockOrderRepo{orders: []*models.Order{{ID: "order-1", Status: "PENDING"}}}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{}))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for pending order, got %d", rr.Code)
+	}
+}
+
+func installmentOrderWithCaptures() *models.Order {
+	order := completedOrderWithCapture()
+	order.Payments[0].TransactionAmount = 5000
+	order.Payments = append(order.Payments, models.Payment{
+		ID: "payment-2", OrderID: "order-1", TransactionAmount: 5000, Currency: "CAD", TransactionStatus: "SUCCESS", ProviderTransactionID: "CAPTURE-2",
+	})
+	return order
+}
+
+func TestRefundOrder_SeveralPaymentsRequirePaymentID(t *testing.T) {
+	orderRepo := &mockOrderRepo{orders: []*models.Order{installmentOrderWithCaptures()}}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{}))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without payment_id, got %d", rr.Code)
+	}
+}
+
+func TestRefundOrder_InstallmentPayment(t *testing.T) {
+	order := installmentOrderWithCaptures()
+	orderRepo := &mockOrderRepo{orders: []*models.Order{order}}
+	var refundedCapture string
+	pp := &mockPayPalClient{
+		refundCaptureFn: func(captureID string, amount money.Money) (*paypal.RefundResult, error) {
+			refundedCapture = captureID
+			return &paypal.RefundResult{ID: "REFUND-" + captureID, Status: "COMPLETED"}, nil
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, &mockUserRepo{}, pp)
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"payment_id": "payment-2"}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if refundedCapture != "CAPTURE-2" {
+		t.Errorf("expected capture CAPTURE-2 to be refunded, got %s", refundedCapture)
+	}
+	if order.Payments[1].TransactionStatus != "REFUNDED" {
+		t.Errorf("expected payment-2 REFUNDED, got %s", order.Payments[1].TransactionStatus)
+	}
+	if order.Status != "PARTIALLY_REFUNDED" {
+		t.Errorf("expected order PARTIALLY_REFUNDED while payment-1 is kept, got %s", order.Status)
+	}
+
+	// Refunding the other installment as well covers everything captured
+	order.Payments = append(order.Payments, *paymentRepo.payments[0])
+	rr = httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"payment_id": "payment-1"}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if order.Status != "REFUNDED" {
+		t.Errorf("expected order REFUNDED, got %s", order.Status)
+	}
+}
+
+func TestRefundOrder_UnknownPaymentID(t *testing.T) {
+	orderRepo := &mockOrderRepo{orders: []*models.Order{installmentOrderWithCaptures()}}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"payment_id": "payment-9"}))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a payment of another order, got %d", rr.Code)
+	}
+}
+
+// ===================== Stripe Tests =====================
+
+// withStripe offers Stripe next to PayPal, talking to a local stand-in that
+// serves the routes the test registers
+func withStripe(t *testing.T, h *PaymentHandler, routes map[string]http.HandlerFunc) {
+	t.Helper()
+	stub := http.NewServeMux()
+	for path, fn := range routes {
+		stub.HandleFunc(path, fn)
+	}
+	srv := httptest.NewServer(stub)
+	t.Cleanup(srv.Close)
+
+	paypalProvider, _ := h.providers.Get("PAYPAL")
+	h.providers = NewProviders(paypalProvider, newStripeProvider(&stripe.Client{
+		SecretKey:     "sk_test_123",
+		WebhookSecret: "whsec_test",
+		BaseURL:       srv.URL,
+		HTTPClient:    srv.Client(),
+	}))
+}
+
+func TestCheckout_StripeCreatesCheckoutSession(t *testing.T) {
+	t.Setenv("CURRENCY", "CAD")
+	t.Setenv("FRONTEND_URL", "https://a1.example")
+	cart := &models.Cart{ID: "cart-1", Items: []models.CartItem{{ID: "item-1", CourseID: "course-1", Price: 10000}}}
+	orderRepo := &mockOrderRepo{}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{cart: cart, total: 10000}, &mockUserRepo{province: "ON"}, &mockPayPalClient{})
+	withStripe(t, h, map[string]http.HandlerFunc{
+		"/v1/checkout/sessions": func(w http.ResponseWriter, r *http.Request) {
+			_ = r.ParseForm()
+			orderID := r.PostForm.Get("client_reference_id")
+			if got, want := r.PostForm.Get("success_url"), "https://a1.example/checkout/success?order_id="+orderID+"&token={CHECKOUT_SESSION_ID}"; got != want {
+				t.Errorf("success_url = %q, want %q", got, want)
+			}
+			if got := r.PostForm.Get("line_items[0][price_data][unit_amount]"); got != "11300" {
+				t.Errorf("expected 113.00 with HST, got %s", got)
+			}
+			_, _ = w.Write([]byte(`{"id":"cs_test_1","url":"https://checkout.stripe.test/cs_test_1","status":"open","payment_status":"unpaid"}`))
+		},
+	})
+
+	body, _ := json.Marshal(map[string]string{"provider": "stripe"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	h.Checkout(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if resp["approve_url"] != "https://checkout.stripe.test/cs_test_1" {
+		t.Errorf("expected the Stripe checkout URL, got %q", resp["approve_url"])
+	}
+	attempts := h.attemptRepo.(*mockAttemptRepo).attempts
+	if len(attempts) != 1 || attempts[0].Provider != "STRIPE" || attempts[0].ProviderOrderID != "cs_test_1" {
+		t.Errorf("expected a STRIPE attempt for cs_test_1, got %+v", attempts)
+	}
+}
+
+func TestCheckout_UnknownProvider(t *testing.T) {
+	cart := &models.Cart{ID: "cart-1", Items: []models.CartItem{{ID: "item-1", CourseID: "course-1", Price: 10000}}}
+	orderRepo := &mockOrderRepo{}
+	// Stripe is not configured, so it cannot be chosen either
+	for _, provider := range []string{"bitcoin", "stripe"} {
+		h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{cart: cart, total: 10000}, &mockUserRepo{province: "ON"}, &mockPayPalClient{})
+		body, _ := json.Marshal(map[string]string{"provider": provider})
+		req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		h.Checkout(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", provider, rr.Code)
+		}
+	}
+	if len(orderRepo.orders) != 0 {
+		t.Errorf("expected no order to be created, got %d", len(orderRepo.orders))
+	}
+}
+
+func TestCaptureCheckout_StripeSession(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1"}}},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	withStripe(t, h, map[string]http.HandlerFunc{
+		"/v1/checkout/sessions/cs_test_1": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"id":"cs_test_1","status":"complete","payment_status":"paid","client_reference_id":"order-1","amount_total":10000,"currency":"cad","payment_intent":"pi_1"}`))
+		},
+	})
+	attempt := withAttempt(h, "order-1", "cs_test_1")
+	attempt.Provider = "STRIPE"
+
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "cs_test_1"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.CaptureCheckout(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if orderRepo.orders[0].Status != "COMPLETED" {
+		t.Errorf("expected order status COMPLETED, got %s", orderRepo.orders[0].Status)
+	}
+	if len(paymentRepo.payments) != 1 {
+		t.Fatalf("expected one payment, got %d", len(paymentRepo.payments))
+	}
+	if p := paymentRepo.payments[0]; p.Provider != "STRIPE" || p.ProviderSessionID != "cs_test_1" || p.ProviderTransactionID != "pi_1" {
+		t.Errorf("expected a STRIPE payment for pi_1 through cs_test_1, got %+v", p)
+	}
+}
+
+func TestCaptureCheckout_StripeSessionUnpaid(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{{ID: "order-1", UserID: "user-1", Status: "PENDING", TotalAmount: 10000, Currency: "CAD"}},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	withStripe(t, h, map[string]http.HandlerFunc{
+		"/v1/checkout/sessions/cs_test_1": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"id":"cs_test_1","status":"open","payment_status":"unpaid","client_reference_id":"order-1"}`))
+		},
+	})
+	withAttempt(h, "order-1", "cs_test_1").Provider = "STRIPE"
+
+	body, _ := json.Marshal(map[string]string{"order_id": "order-1", "token": "cs_test_1"})
+	req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.CaptureCheckout(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unpaid session, got %d", rr.Code)
+	}
+}
+
+func TestRefundOrder_RefundsThroughPaymentProvider(t *testing.T) {
+	order := completedOrderWithCapture()
+	order.Payments[0].Provider = "STRIPE"
+	order.Payments[0].ProviderTransactionID = "pi_1"
+	paymentRepo := &mockPaymentRepo{}
+	pp := &mockPayPalClient{
+		refundCaptureFn: func(captureID string, amount money.Money) (*paypal.RefundResult, error) {
+			t.Error("expected PayPal not to be asked for a Stripe refund")
+			return nil, errors.New("wrong provider")
+		},
+	}
+	h := newTestHandler(paymentRepo, &mockOrderRepo{orders: []*models.Order{order}}, &mockCartRepo{}, &mockUserRepo{}, pp)
+	withStripe(t, h, map[string]http.HandlerFunc{
+		"/v1/refunds": func(w http.ResponseWriter, r *http.Request) {
+			_ = r.ParseForm()
+			if r.PostForm.Get("payment_intent") != "pi_1" || r.PostForm.Get("amount") != "4000" {
+				t.Errorf("unexpected refund form %v", r.PostForm)
+			}
+			_, _ = w.Write([]byte(`{"id":"re_1","status":"succeeded","amount":4000,"currency":"cad","payment_intent":"pi_1"}`))
+		},
+	})
+
+	rr := httptest.NewRecorder()
+	h.RefundOrder(rr, refundRequest(t, "order-1", map[string]any{"amount": 40}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(paymentRepo.payments) != 1 {
+		t.Fatalf("expected one refund payment, got %d", len(paymentRepo.payments))
+	}
+	if refund := paymentRepo.payments[0]; refund.Provider != "STRIPE" || refund.ProviderTransactionID != "re_1" || refund.TransactionStatus != "SUCCESS" {
+		t.Errorf("expected a successful STRIPE refund re_1, got %+v", refund)
+	}
+}
+
+// stripePlanOrder is a plan order whose first installment was paid by card
+// through Stripe, with the second one due
+func stripePlanOrder(h *PaymentHandler) *models.Order {
+	order := planOrder(h, "SCHEDULED")
+	paymentID := "payment-1"
+	order.Installments[0].PaymentID = &paymentID
+	order.Payments = []models.Payment{{ID: paymentID, OrderID: "order-1", TransactionAmount: 3000, Currency: "CAD", TransactionStatus: "SUCCESS", Provider: "STRIPE", ProviderTransactionID: "pi_1"}}
+	return order
+}
+
+func TestProcessInstallments_ChargesSavedCard(t *testing.T) {
+	paymentRepo := &mockPaymentRepo{}
+	h := newTestHandler(paymentRepo, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	order := stripePlanOrder(h)
+	charges := 0
+	withStripe(t, h, map[string]http.HandlerFunc{
+		"/v1/payment_intents/pi_1": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"id":"pi_1","status":"succeeded","customer":"cus_1","payment_method":"pm_1"}`))
+		},
+		"/v1/payment_intents": func(w http.ResponseWriter, r *http.Request) {
+			charges++
+			_ = r.ParseForm()
+			if r.PostForm.Get("customer") != "cus_1" || r.PostForm.Get("payment_method") != "pm_1" || r.PostForm.Get("amount") != "3000" {
+				t.Errorf("unexpected charge form %v", r.PostForm)
+			}
+			_, _ = w.Write([]byte(`{"id":"pi_2","status":"succeeded","amount":3000,"amount_received":3000,"currency":"cad","metadata":{"order_id":"order-1"}}`))
+		},
+	})
+
+	for range 2 {
+		if err := h.ProcessInstallments(context.Background(), time.Now()); err != nil {
+			t.Fatalf("ProcessInstallments() = %v", err)
+		}
+	}
+
+	if charges != 1 {
+		t.Errorf("expected the card to be charged once, got %d", charges)
+	}
+	if inst := order.Installments[1]; inst.Status != "PAID" {
+		t.Errorf("expected the due installment paid, got %s", inst.Status)
+	}
+	if len(paymentRepo.payments) != 1 || paymentRepo.payments[0].ProviderTransactionID != "pi_2" {
+		t.Errorf("expected a payment for pi_2, got %+v", paymentRepo.payments)
+	}
+	if order.Installments[2].Status != "SCHEDULED" {
+		t.Errorf("expected the installment not yet due untouched, got %s", order.Installments[2].Status)
+	}
+}
+
+func TestProcessInstallments_DeclinedSavedCard(t *testing.T) {
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	order := stripePlanOrder(h)
+	withStripe(t, h, map[string]http.HandlerFunc{
+		"/v1/payment_intents/pi_1": func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"id":"pi_1","status":"succeeded","customer":"cus_1","payment_method":"pm_1"}`))
+		},
+		"/v1/payment_intents": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPaymentRequired)
+			_, _ = w.Write([]byte(`{"error":{"type":"card_error","code":"card_declined","message":"Your card was declined."}}`))
+		},
+	})
+
+	if err := h.ProcessInstallments(context.Background(), time.Now()); err != nil {
+		t.Fatalf("ProcessInstallments() = %v", err)
+	}
+
+	if inst := order.Installments[1]; inst.Status != "DEFAULTED" || inst.FailedAttempts != 1 || inst.LastFailure == "" {
+		t.Errorf("expected the failure recorded on the defaulted installment, got %+v", inst)
+	}
+}
+
+// stripeWebhookRequest signs body the way Stripe does
+func stripeWebhookRequest(body []byte, secret string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, _ := http.NewRequest("POST", "/api/webhooks/stripe", bytes.NewBuffer(body))
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%s,v1=%s", timestamp, stripe.Sign(secret, timestamp, body)))
+	return req
+}
+
+func sessionCompletedBody(eventID, orderID string) []byte {
+	body, _ := json.Marshal(map[string]any{
+		"id":   eventID,
+		"type": "checkout.session.completed",
+		"data": map[string]any{
+			"object": map[string]any{
+				"id":                  "cs_test_1",
+				"status":              "complete",
+				"payment_status":      "paid",
+				"client_reference_id": orderID,
+				"amount_total":        10000,
+				"currency":            "cad",
+				"payment_intent":      "pi_1",
+			},
+		},
+	})
+	return body
+}
+
+func TestStripeWebhook_SessionCompletedFulfillsOrder(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{
+			{ID: "order-1", Status: "PENDING", UserID: "user-1", TotalAmount: 10000, Currency: "CAD", Items: []models.OrderItem{{CourseID: "course-1"}}},
+		},
+	}
+	paymentRepo := &mockPaymentRepo{}
+	h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	withStripe(t, h, nil)
+	withAttempt(h, "order-1", "cs_test_1").Provider = "STRIPE"
+
+	rr := httptest.NewRecorder()
+	h.StripeWebhook(rr, stripeWebhookRequest(sessionCompletedBody("evt_1", "order-1"), "whsec_test"))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if orderRepo.orders[0].Status != "COMPLETED" {
+		t.Errorf("expected order status COMPLETED, got %s", orderRepo.orders[0].Status)
+	}
+	if len(paymentRepo.payments) != 1 || paymentRepo.payments[0].Provider != "STRIPE" || paymentRepo.payments[0].ProviderTransactionID != "pi_1" {
+		t.Errorf("expected one STRIPE payment for pi_1, got %+v", paymentRepo.payments)
+	}
+	if event := h.webhookRepo.(*mockWebhookRepo).events["evt_1"]; event == nil || event.Provider != "STRIPE" {
+		t.Errorf("expected evt_1 to be recorded for STRIPE, got %+v", event)
+	}
+}
+
+func refundEventBody(eventID, eventType, status string) []byte {
+	body, _ := json.Marshal(map[string]any{
+		"id":   eventID,
+		"type": eventType,
+		"data": map[string]any{
+			"object": map[string]any{
+				"id":             "re_1",
+				"status":         status,
+				"amount":         10000,
+				"currency":       "cad",
+				"payment_intent": "pi_1",
+				"metadata":       map[string]string{"order_id": "order-1"},
+			},
+		},
+	})
+	return body
+}
+
+func TestStripeWebhook_RefundUpdatedSettlesPendingRefund(t *testing.T) {
+	for _, eventType := range []string{"refund.updated", "charge.refund.updated"} {
+		order := &models.Order{
+			ID: "order-1", Status: "COMPLETED", UserID: "user-1", TotalAmount: 10000, Currency: "CAD",
+			Payments: []models.Payment{
+				{ID: "payment-1", OrderID: "order-1", TransactionAmount: 10000, Currency: "CAD", TransactionStatus: "SUCCESS", Provider: "STRIPE", ProviderTransactionID: "pi_1"},
+			},
+		}
+		paymentRepo := &mockPaymentRepo{payments: []*models.Payment{&order.Payments[0]}}
+		h := newTestHandler(paymentRepo, &mockOrderRepo{orders: []*models.Order{order}}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+		withStripe(t, h, nil)
+
+		rr := httptest.NewRecorder()
+		h.StripeWebhook(rr, stripeWebhookRequest(refundEventBody("evt_1", "refund.created", "pending"), "whsec_test"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200 for refund.created, got %d: %s", eventType, rr.Code, rr.Body.String())
+		}
+		rr = httptest.NewRecorder()
+		h.StripeWebhook(rr, stripeWebhookRequest(refundEventBody("evt_2", eventType, "failed"), "whsec_test"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", eventType, rr.Code, rr.Body.String())
+		}
+
+		refund, err := paymentRepo.FindByProviderTransactionID(context.Background(), "re_1")
+		if err != nil || refund.TransactionStatus != "FAILED" {
+			t.Errorf("%s: expected the refund to be marked FAILED, got %+v, %v", eventType, refund, err)
+		}
+		if order.Status != "COMPLETED" {
+			t.Errorf("%s: expected the order to be COMPLETED again, got %s", eventType, order.Status)
+		}
+	}
+}
+
+func TestStripeWebhook_InvalidSignature(t *testing.T) {
+	orderRepo := &mockOrderRepo{
+		orders: []*models.Order{{ID: "order-1", Status: "PENDING", UserID: "user-1", TotalAmount: 10000, Currency: "CAD"}},
+	}
+	h := newTestHandler(&mockPaymentRepo{}, orderRepo, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+	withStripe(t, h, nil)
+
+	rr := httptest.NewRecorder()
+	h.StripeWebhook(rr, stripeWebhookRequest(sessionCompletedBody("evt_1", "order-1"), "whsec_other"))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for invalid signature, got %d", rr.Code)
+	}
+	if orderRepo.orders[0].Status != "PENDING" {
+		t.Errorf("expected order to stay PENDING, got %s", orderRepo.orders[0].Status)
+	}
+}
+
+func TestStripeWebhook_NotConfigured(t *testing.T) {
+	h := newTestHandler(&mockPaymentRepo{}, &mockOrderRepo{}, &mockCartRepo{}, &mockUserRepo{}, &mockPayPalClient{})
+
+	rr := httptest.NewRecorder()
+	h.StripeWebhook(rr, stripeWebhookRequest(sessionCompletedBody("evt_1", "order-1"), "whsec_test"))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 when Stripe is not configured, got %d", rr.Code)
+	}
+}
+
+// ===================== Fake PayPal Tests =====================
+
+// withFakePayPal points the handler's PayPal provider at an in-process fake
+func withFakePayPal(t *testing.T, h *PaymentHandler) *paypaltest.Server {
+	t.Helper()
+	fake := paypaltest.NewServer()
+	t.Cleanup(fake.Close)
+	h.providers = NewProviders(newPayPalProvider(&paypal.Client{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		WebhookID:    "WH-TEST",
+		BaseURL:      fake.URL,
+		HTTPClient:   &http.Client{},
+	}))
+	return fake
+}
+
+func TestFakePayPal_CheckoutThenCapture(t *testing.T) {
+	t.Setenv("CURRENCY", "CAD")
+	tests := []struct {
+		name        string
+		outcome     paypaltest.Outcome
+		wantCode    int
+		wantStatus  string
+		wantPayment bool
+	}{
+		{name: "captured", wantCode: http.StatusOK, wantStatus: "COMPLETED", wantPayment: true},
+		{name: "already captured", outcome: paypaltest.OutcomeAlreadyCaptured, wantCode: http.StatusOK, wantStatus: "COMPLETED", wantPayment: true},
+		{name: "provider down", outcome: paypaltest.OutcomeServerError, wantCode: http.StatusBadGateway, wantStatus: "PENDING"},
+		{name: "declined", outcome: paypaltest.OutcomeDeclined, wantCode: http.StatusBadRequest, wantStatus: "FAILED"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cart := &models.Cart{ID: "cart-1", Items: []models.CartItem{{ID: "item-1", CourseID: "course-1", Price: 10000}}}
+			orderRepo := &mockOrderRepo{}
+			paymentRepo := &mockPaymentRepo{}
+			h := newTestHandler(paymentRepo, orderRepo, &mockCartRepo{cart: cart, total: 10000}, &mockUserRepo{province: "ON"}, &mockPayPalClient{})
+			fake := withFakePayPal(t, h)
+
+			req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout", bytes.NewReader([]byte(`{}`)))
+			rr := httptest.NewRecorder()
+			h.Checkout(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("checkout: expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			attempts := h.attemptRepo.(*mockAttemptRepo).attempts
+			if len(attempts) != 1 {
+				t.Fatalf("expected one attempt, got %d", len(attempts))
+			}
+			paypalOrderID := attempts[0].ProviderOrderID
+			if err := fake.Approve(paypalOrderID); err != nil {
+				t.Fatalf("Approve: %v", err)
+			}
+			if tt.outcome != "" {
+				fake.Script(paypaltest.OpCapture, tt.outcome)
+			}
+
+			body, _ := json.Marshal(map[string]string{"order_id": orderRepo.orders[0].ID, "token": paypalOrderID})
+			req, _ = http.NewRequestWithContext(contextWithUserID("user-1"), "POST", "/api/checkout/capture", bytes.NewReader(body))
+			rr = httptest.NewRecorder()
+			h.CaptureCheckout(rr, req)
+
+			if rr.Code != tt.wantCode {
+				t.Fatalf("capture: expected %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+			if got := orderRepo.orders[0].Status; got != tt.wantStatus {
+				t.Errorf("expected order status %s, got %s", tt.wantStatus, got)
+			}
+			if got := len(paymentRepo.payments) == 1; got != tt.wantPayment {
+				t.Errorf("expected a payment: %v, got %+v", tt.wantPayment, paymentRepo.payments)
+			}
+			if tt.wantPayment {
+				if p := paymentRepo.payments[0]; p.TransactionAmount != 11300 || p.ProviderSessionID != paypalOrderID || p.ProviderTransactionID == "" {
+					t.Errorf("unexpected payment %+v", p)
+				}
+			}
+		})
 	}
 }
@@ -0,0 +1,578 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"services/internal/api"
+	"services/internal/installment"
+	"services/internal/models"
+	"services/internal/money"
+	"services/internal/paypal"
+	"services/internal/repository"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Automatic charges of a saved payment method are retried once a day, up to
+// three failures, after which the buyer has to pay the installment
+const (
+	chargeRetryInterval = 24 * time.Hour
+	maxAutomaticCharges = 3
+)
+
+// InstallmentMailer sends reminders of installments coming due so it can be
+// mocked in tests
+type InstallmentMailer interface {
+	SendInstallmentReminder(ctx context.Context, to, name string, amount money.Money, dueAt time.Time, link string) error
+}
+
+// scheduleInstallments splits an order's items into monthly installments
+// when any of them is bought on a payment plan. Orders paid in full have no
+// schedule.
+func scheduleInstallments(items []models.OrderItem, start time.Time) []models.Installment {
+	onPlan := false
+	lines := make([]installment.Line, 0, len(items))
+	taxLines := make([]installment.Line, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, installment.Line{Amount: item.Price - item.Discount + item.Tax, Installments: item.Installments})
+		taxLines = append(taxLines, installment.Line{Amount: item.Tax, Installments: item.Installments})
+		onPlan = onPlan || item.Installments > 1
+	}
+	if !onPlan {
+		return nil
+	}
+
+	// The tax is split the same way, so each installment knows its share
+	taxes := installment.Schedule(taxLines, start)
+	var installments []models.Installment
+	for i, payment := range installment.Schedule(lines, start) {
+		installments = append(installments, models.Installment{
+			Sequence: payment.Sequence,
+			Amount:   payment.Amount,
+			Tax:      taxes[i].Amount,
+			DueAt:    payment.DueAt,
+			Status:   installment.StatusScheduled,
+		})
+	}
+	return installments
+}
+
+// firstInstallment returns the installment paid at checkout, or nil when the
+// order is paid in full
+func firstInstallment(order *models.Order) *models.Installment {
+	for i := range order.Installments {
+		if order.Installments[i].Sequence == 1 {
+			return &order.Installments[i]
+		}
+	}
+	return nil
+}
+
+// planItems returns the items of an order that are paid in installments.
+// Access to these is what gets suspended when the plan is in default.
+func planItems(order *models.Order) []models.OrderItem {
+	var items []models.OrderItem
+	for _, item := range order.Items {
+		if item.Installments > 1 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// installmentMismatch compares a completed capture with the installment it
+// is meant to pay for and returns why they differ, or "" when they match
+func installmentMismatch(order *models.Order, inst *models.Installment, capture *CaptureResult) string {
+	return mismatch(order.ID, money.New(inst.Amount, order.Currency), capture)
+}
+
+// loadInstallment finds an installment and its order for the caller. It
+// writes the error response and returns false when the installment is not
+// theirs.
+func (h *PaymentHandler) loadInstallment(w http.ResponseWriter, r *http.Request) (*models.Installment, *models.Order, bool) {
+	ctx := r.Context()
+	id := mux.Vars(r)["id"]
+	userID, _ := ctx.Value(models.UserIDContextKey).(string)
+
+	inst, err := h.installmentRepo.FindByID(ctx, id)
+	if err != nil {
+		if !errors.Is(err, repository.ErrInstallmentNotFound) {
+			h.logger.ErrorContext(ctx, "Failed to load installment", "installment_id", id, "error", err)
+		}
+		api.RespondWithError(w, http.StatusNotFound, "Installment not found")
+		return nil, nil, false
+	}
+	order, err := h.orderRepo.FindByID(ctx, inst.OrderID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to load order for installment", "installment_id", id, "order_id", inst.OrderID, "error", err)
+		api.RespondWithError(w, http.StatusNotFound, "Installment not found")
+		return nil, nil, false
+	}
+	if order.UserID != userID {
+		h.logger.WarnContext(ctx, "Installment accessed by another user", "user_id", userID, "installment_id", id)
+		api.RespondWithError(w, http.StatusNotFound, "Installment not found")
+		return nil, nil, false
+	}
+	return inst, order, true
+}
+
+// PayInstallment creates a payment session for one unpaid installment of a
+// plan, with the provider chosen in an optional {"provider": ...} body. The
+// buyer approves it and completes the payment with CaptureInstallment.
+// Installments can be paid early, and paying a defaulted one restores access.
+func (h *PaymentHandler) PayInstallment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	inst, order, ok := h.loadInstallment(w, r)
+	if !ok {
+		return
+	}
+
+	if inst.Status == installment.StatusPaid {
+		api.RespondWithError(w, http.StatusBadRequest, "Installment already paid")
+		return
+	}
+	if order.Status != "COMPLETED" && order.Status != "PARTIALLY_REFUNDED" {
+		api.RespondWithError(w, http.StatusBadRequest, "The order must be paid at checkout first")
+		return
+	}
+
+	provider, ok := h.requestedProvider(w, r)
+	if !ok {
+		return
+	}
+
+	charge := installmentCharge(order, inst)
+	session, err := provider.CreateSession(ctx, charge, order.ID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to create payment session for installment", "provider", provider.Name(), "order_id", order.ID, "installment_id", inst.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Payment provider error")
+		return
+	}
+
+	if _, err := h.recordAttempt(ctx, order, provider, charge, &inst.ID, session.ID); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to record payment attempt", "session_id", session.ID, "order_id", order.ID, "installment_id", inst.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to start payment")
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Created payment session for installment", "provider", provider.Name(), "session_id", session.ID, "order_id", order.ID, "installment_id", inst.ID)
+
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{
+		"order_id":       order.ID,
+		"installment_id": inst.ID,
+		"approve_url":    session.RedirectURL,
+	})
+}
+
+// CaptureInstallment captures an approved session created by PayInstallment and marks the installment paid
+func (h *PaymentHandler) CaptureInstallment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	inst, order, ok := h.loadInstallment(w, r)
+	if !ok {
+		return
+	}
+	if inst.Status == installment.StatusPaid {
+		api.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "success", "message": "already_completed"})
+		return
+	}
+
+	attempt, err := h.attemptRepo.FindByProviderOrderID(ctx, req.Token)
+	if err != nil || attempt.OrderID != order.ID || attempt.InstallmentID == nil || *attempt.InstallmentID != inst.ID {
+		h.logger.WarnContext(ctx, "Payment session is not an attempt of this installment", "order_id", order.ID, "installment_id", inst.ID, "session_id", req.Token, "error", err)
+		api.RespondWithError(w, http.StatusBadRequest, "Payment does not match this installment")
+		return
+	}
+	provider, err := h.providers.Get(attempt.Provider)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Payment attempt has an unknown provider", "order_id", order.ID, "installment_id", inst.ID, "provider", attempt.Provider)
+		api.RespondWithError(w, http.StatusInternalServerError, "Payment provider error")
+		return
+	}
+
+	capture, err := provider.Capture(ctx, req.Token)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to capture installment", "order_id", order.ID, "installment_id", inst.ID, "error", err)
+
+		switch classifyCaptureError(err) {
+		case captureAlreadyDone:
+			capture, err = provider.FetchStatus(ctx, req.Token)
+			if err != nil {
+				h.logger.ErrorContext(ctx, "Failed to fetch captured payment", "order_id", order.ID, "installment_id", inst.ID, "error", err)
+				api.RespondWithError(w, http.StatusBadGateway, "Payment provider unavailable, please try again")
+				return
+			}
+		case captureRetryable:
+			api.RespondWithError(w, http.StatusBadGateway, "Payment provider unavailable, please try again")
+			return
+		case captureDeclined:
+			h.recordInstallmentFailure(ctx, inst, "payment declined")
+			api.RespondWithError(w, http.StatusBadRequest, "Payment was declined")
+			return
+		default:
+			h.recordInstallmentFailure(ctx, inst, err.Error())
+			api.RespondWithError(w, http.StatusBadRequest, "Failed to capture payment")
+			return
+		}
+	}
+
+	if capture.Status != "COMPLETED" {
+		h.recordInstallmentFailure(ctx, inst, "capture "+capture.Status)
+		api.RespondWithError(w, http.StatusBadRequest, "Payment not completed")
+		return
+	}
+	if reason := installmentMismatch(order, inst, capture); reason != "" {
+		h.recordMismatch(ctx, attempt, capture.CaptureID, reason)
+		api.RespondWithError(w, http.StatusBadRequest, "Payment does not match this installment")
+		return
+	}
+	h.markAttemptCaptured(ctx, attempt, capture.CaptureID)
+
+	if err := h.settleInstallment(ctx, order, inst.ID, attempt, capture.CaptureID); err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, "Payment captured but the installment could not be recorded, please retry")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "success"})
+}
+
+// settleInstallment records the payment of an installment, marks it paid and
+// issues its receipt in one transaction. Once none of the order's
+// installments is in default, access to its plan courses is restored. Paid
+// installments are skipped, so the capture flow and the webhook can both call
+// it.
+func (h *PaymentHandler) settleInstallment(ctx context.Context, order *models.Order, installmentID string, attempt *models.PaymentAttempt, captureID string) error {
+	var issued *models.Invoice
+	err := h.uow.Do(ctx, func(repos repository.Repositories) error {
+		inst, err := repos.Installments.FindByIDForUpdate(ctx, installmentID)
+		if err != nil {
+			return err
+		}
+		if inst.Status == installment.StatusPaid {
+			h.logger.InfoContext(ctx, "Installment already paid, skipping", "order_id", order.ID, "installment_id", installmentID)
+			return nil
+		}
+
+		payment, err := repos.Payments.FindByProviderTransactionID(ctx, captureID)
+		if errors.Is(err, repository.ErrPaymentNotFound) {
+			payment = &models.Payment{
+				OrderID:               order.ID,
+				TransactionAmount:     inst.Amount,
+				Currency:              order.Currency,
+				TransactionMethod:     attempt.Provider,
+				TransactionStatus:     "SUCCESS",
+				Provider:              attempt.Provider,
+				ProviderSessionID:     attempt.ProviderOrderID,
+				ProviderTransactionID: captureID,
+			}
+			err = repos.Payments.Create(ctx, payment)
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		inst.Status = installment.StatusPaid
+		inst.PaidAt = &now
+		inst.PaymentID = &payment.ID
+		if err := repos.Installments.Update(ctx, inst); err != nil {
+			return err
+		}
+
+		installments, err := repos.Installments.FindByOrderID(ctx, order.ID)
+		if err != nil {
+			return err
+		}
+		var balance money.Amount
+		for _, other := range installments {
+			if other.Status != installment.StatusPaid {
+				balance += other.Amount
+			}
+		}
+		issued, err = h.issueInstallmentReceipt(ctx, repos, order, inst, payment, balance)
+		if err != nil {
+			return err
+		}
+
+		for _, other := range installments {
+			if other.Status == installment.StatusDefaulted {
+				return nil
+			}
+		}
+		for _, item := range planItems(order) {
+			if err := repos.Users.RestoreCourse(ctx, order.UserID, item.CourseID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to settle installment", "order_id", order.ID, "installment_id", installmentID, "error", err)
+		return fmt.Errorf("failed to settle installment %s: %w", installmentID, err)
+	}
+	h.logger.InfoContext(ctx, "Installment paid", "order_id", order.ID, "installment_id", installmentID, "capture_id", captureID)
+
+	if issued != nil {
+		h.receipts.Add(1)
+		go func() {
+			defer h.receipts.Done()
+			h.emailReceipt(context.WithoutCancel(ctx), issued)
+		}()
+	}
+	return nil
+}
+
+// recordInstallmentFailure counts a failed payment of an installment. It
+// stays unpaid with its due date unchanged, so a failure does not extend the
+// grace period. The installment is reloaded under a lock so a payment that
+// settled it in the meantime is never overwritten.
+func (h *PaymentHandler) recordInstallmentFailure(ctx context.Context, inst *models.Installment, reason string) {
+	h.logger.WarnContext(ctx, "Installment payment failed", "order_id", inst.OrderID, "installment_id", inst.ID, "reason", reason)
+	err := h.uow.Do(ctx, func(repos repository.Repositories) error {
+		current, err := repos.Installments.FindByIDForUpdate(ctx, inst.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status == installment.StatusPaid {
+			return nil
+		}
+		current.FailedAttempts++
+		current.LastFailure = reason
+		return repos.Installments.Update(ctx, current)
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to record installment failure", "installment_id", inst.ID, "error", err)
+	}
+}
+
+// ProcessInstallments charges unpaid installments that have come due to the
+// payment method saved at checkout, where the provider supports it. Those
+// still unpaid move to OVERDUE, and to DEFAULTED once their grace period is
+// over, which suspends access to the order's plan courses. It is safe to run
+// repeatedly.
+func (h *PaymentHandler) ProcessInstallments(ctx context.Context, now time.Time) error {
+	due, err := h.installmentRepo.FindUnpaidDue(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	grace := installment.GracePeriod()
+	var errs []error
+	for i := range due {
+		inst := &due[i]
+		if h.chargeInstallment(ctx, inst, now) {
+			continue
+		}
+		if installment.Status(inst, now, grace) == inst.Status {
+			continue
+		}
+		if err := h.advanceInstallment(ctx, inst.ID, now, grace); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// chargeInstallment charges a due installment to the payment method saved
+// with the plan's first installment and reports whether it got paid. Each
+// installment is charged at most once per chargeRetryInterval, across every
+// instance running the job, and no more after maxAutomaticCharges failures.
+func (h *PaymentHandler) chargeInstallment(ctx context.Context, inst *models.Installment, now time.Time) bool {
+	if inst.FailedAttempts >= maxAutomaticCharges {
+		return false
+	}
+	order, err := h.orderRepo.FindByID(ctx, inst.OrderID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to load order for installment charge", "order_id", inst.OrderID, "installment_id", inst.ID, "error", err)
+		return false
+	}
+	source := firstInstallmentPayment(order)
+	if source == nil {
+		return false
+	}
+	provider, err := h.providers.Get(source.Provider)
+	if err != nil {
+		return false
+	}
+	charger, ok := provider.(SavedPaymentCharger)
+	if !ok {
+		return false
+	}
+
+	claimed, err := h.installmentRepo.ClaimCharge(ctx, inst.ID, now, chargeRetryInterval)
+	if err != nil || !claimed {
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Failed to claim installment charge", "installment_id", inst.ID, "error", err)
+		}
+		return false
+	}
+
+	charge := installmentCharge(order, inst)
+	requestID := fmt.Sprintf("installment-%s-%d", inst.ID, inst.FailedAttempts)
+	capture, err := charger.ChargeSaved(ctx, source.ProviderTransactionID, charge, order.ID, requestID)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNoSavedPayment):
+			h.logger.InfoContext(ctx, "No saved payment method for installment", "order_id", order.ID, "installment_id", inst.ID)
+		case errors.Is(err, ErrPaymentDeclined):
+			h.recordInstallmentFailure(ctx, inst, "automatic charge declined")
+		default:
+			h.logger.ErrorContext(ctx, "Failed to charge installment", "provider", provider.Name(), "order_id", order.ID, "installment_id", inst.ID, "error", err)
+		}
+		return false
+	}
+
+	attempt, err := h.recordAttempt(ctx, order, provider, charge, &inst.ID, capture.CaptureID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to record payment attempt", "session_id", capture.CaptureID, "order_id", order.ID, "installment_id", inst.ID, "error", err)
+		return false
+	}
+	if capture.Status != "COMPLETED" {
+		h.recordInstallmentFailure(ctx, inst, "automatic charge "+capture.Status)
+		return false
+	}
+	if reason := installmentMismatch(order, inst, capture); reason != "" {
+		h.recordMismatch(ctx, attempt, capture.CaptureID, reason)
+		return false
+	}
+	h.markAttemptCaptured(ctx, attempt, capture.CaptureID)
+
+	return h.settleInstallment(ctx, order, inst.ID, attempt, capture.CaptureID) == nil
+}
+
+// firstInstallmentPayment returns the payment made for the first installment
+// of a plan, whose payment method later installments are charged to
+func firstInstallmentPayment(order *models.Order) *models.Payment {
+	first := firstInstallment(order)
+	if first == nil || first.PaymentID == nil {
+		return nil
+	}
+	return paymentOf(order, *first.PaymentID)
+}
+
+// advanceInstallment moves an unpaid installment to the status it should
+// have at now. A DEFAULTED installment suspends the buyer's access to the
+// courses paid for by the plan. The installment is reloaded under a lock and
+// skipped once paid, so a payment settled since it was listed is kept.
+func (h *PaymentHandler) advanceInstallment(ctx context.Context, id string, now time.Time, grace time.Duration) error {
+	var inst *models.Installment
+	var order *models.Order
+	err := h.uow.Do(ctx, func(repos repository.Repositories) error {
+		var err error
+		inst, err = repos.Installments.FindByIDForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		status := installment.Status(inst, now, grace)
+		if status == inst.Status {
+			return nil
+		}
+
+		inst.Status = status
+		if err := repos.Installments.Update(ctx, inst); err != nil {
+			return err
+		}
+		if status != installment.StatusDefaulted {
+			return nil
+		}
+
+		order, err = repos.Orders.FindByID(ctx, inst.OrderID)
+		if err != nil {
+			return err
+		}
+		for _, item := range planItems(order) {
+			if err := repos.Users.SuspendCourse(ctx, order.UserID, item.CourseID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to update installment status", "installment_id", id, "error", err)
+		return fmt.Errorf("failed to update installment %s: %w", id, err)
+	}
+
+	switch inst.Status {
+	case installment.StatusOverdue:
+		h.logger.InfoContext(ctx, "Installment overdue", "order_id", inst.OrderID, "installment_id", inst.ID, "due_at", inst.DueAt, "grace_ends_at", inst.DueAt.Add(grace))
+	case installment.StatusDefaulted:
+		if order != nil {
+			h.logger.WarnContext(ctx, "Installment defaulted, course access suspended", "user_id", order.UserID, "order_id", order.ID, "installment_id", inst.ID)
+		}
+	}
+	return nil
+}
+
+// SendInstallmentReminders emails buyers whose next installment falls due
+// within INSTALLMENT_REMINDER_DAYS. Installments are claimed before the email
+// goes out, so each is reminded about at most once even when runs overlap.
+func (h *PaymentHandler) SendInstallmentReminders(ctx context.Context, now time.Time) error {
+	due, err := h.installmentRepo.ClaimReminders(ctx, now, now.Add(installment.ReminderLead()))
+	if err != nil {
+		return err
+	}
+
+	for _, inst := range due {
+		order, err := h.orderRepo.FindByID(ctx, inst.OrderID)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Failed to load order for installment reminder", "order_id", inst.OrderID, "installment_id", inst.ID, "error", err)
+			continue
+		}
+		buyer, err := h.userRepo.FindByID(ctx, order.UserID)
+		if err != nil || buyer.Email == "" {
+			h.logger.WarnContext(ctx, "Buyer has no email, installment reminder not sent", "user_id", order.UserID, "installment_id", inst.ID, "error", err)
+			continue
+		}
+
+		amount := money.New(inst.Amount, order.Currency)
+		if err := h.reminders.SendInstallmentReminder(ctx, buyer.Email, buyer.Name, amount, inst.DueAt, orderLink(order.ID)); err != nil {
+			h.logger.WarnContext(ctx, "Failed to send installment reminder", "user_id", order.UserID, "installment_id", inst.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// orderLink is the order page where the buyer pays its installments
+func orderLink(orderID string) string {
+	return paypal.FrontendURL() + "/orders/" + url.PathEscape(orderID)
+}
+
+// RunInstallmentBilling runs ProcessInstallments and SendInstallmentReminders
+// now and then every interval until ctx is cancelled. A run is skipped while
+// another replica is billing.
+func (h *PaymentHandler) RunInstallmentBilling(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := h.jobs.TryDo(ctx, "installment-billing", func(ctx context.Context) error {
+			now := time.Now()
+			if err := h.ProcessInstallments(ctx, now); err != nil {
+				h.logger.ErrorContext(ctx, "Installment billing run failed", "error", err)
+			}
+			if err := h.SendInstallmentReminders(ctx, now); err != nil {
+				h.logger.ErrorContext(ctx, "Installment reminder run failed", "error", err)
+			}
+			return nil
+		})
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Installment billing run failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package payments
+
+import (
+	"context"
+	"services/internal/invoice"
+	"services/internal/models"
+	"services/internal/money"
+	"services/internal/repository"
+	"time"
+)
+
+// ReceiptMailer sends receipts to buyers so it can be mocked in tests
+type ReceiptMailer interface {
+	SendReceipt(ctx context.Context, to, number string, pdf []byte) error
+}
+
+// issueInvoice numbers and stores the invoice of an order being fulfilled. It
+// runs in the fulfillment transaction, so the number is only used up if the
+// order is.
+func (h *PaymentHandler) issueInvoice(ctx context.Context, repos repository.Repositories, order *models.Order, payment *models.Payment) (*models.Invoice, error) {
+	buyer, err := repos.Users.FindByID(ctx, order.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := invoice.Build(order, buyer, h.seller(ctx), payment, time.Now())
+	if err := numberInvoice(ctx, repos, inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// issueInstallmentReceipt numbers and stores the receipt of an installment
+// paid after checkout. It runs in the transaction that settles the
+// installment; balance is what is left to pay on the plan afterwards.
+func (h *PaymentHandler) issueInstallmentReceipt(ctx context.Context, repos repository.Repositories, order *models.Order, inst *models.Installment, payment *models.Payment, balance money.Amount) (*models.Invoice, error) {
+	buyer, err := repos.Users.FindByID(ctx, order.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := invoice.Build(order, buyer, h.seller(ctx), payment, time.Now())
+	inv.Installment = inst.Sequence
+	inv.Balance = balance
+	if err := numberInvoice(ctx, repos, inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// numberInvoice gives a built invoice the next number and stores it
+func numberInvoice(ctx context.Context, repos repository.Repositories, inv *models.Invoice) error {
+	sequence, err := repos.Invoices.NextSequence(ctx)
+	if err != nil {
+		return err
+	}
+	inv.Sequence = sequence
+	inv.Number = invoice.Number(sequence)
+	return repos.Invoices.Create(ctx, inv)
+}
+
+// seller reads the seller details printed on invoices from app settings
+func (h *PaymentHandler) seller(ctx context.Context) invoice.Seller {
+	get := func(key string) string {
+		value, err := h.settingsRepo.GetSetting(ctx, key)
+		if err != nil {
+			h.logger.WarnContext(ctx, "Failed to read invoice setting", "key", key, "error", err)
+		}
+		return value
+	}
+	return invoice.Seller{
+		Name:    get(invoice.SettingSellerName),
+		Address: get(invoice.SettingSellerAddress),
+		Email:   get(invoice.SettingSellerEmail),
+		TaxID:   get(invoice.SettingSellerTaxID),
+	}
+}
+
+// emailReceipt sends the receipt of a newly issued invoice to the buyer. A
+// failure is only logged; the receipt can still be downloaded.
+func (h *PaymentHandler) emailReceipt(ctx context.Context, inv *models.Invoice) {
+	if inv.BuyerEmail == "" {
+		h.logger.WarnContext(ctx, "Buyer has no email, receipt not sent", "order_id", inv.OrderID, "invoice", inv.Number)
+		return
+	}
+	if err := h.mailer.SendReceipt(ctx, inv.BuyerEmail, inv.Number, invoice.PDF(inv)); err != nil {
+		h.logger.WarnContext(ctx, "Failed to email receipt", "order_id", inv.OrderID, "invoice", inv.Number, "error", err)
+		return
+	}
+	if err := h.invoiceRepo.MarkEmailed(ctx, inv.ID, time.Now()); err != nil {
+		h.logger.WarnContext(ctx, "Failed to mark receipt emailed", "order_id", inv.OrderID, "invoice", inv.Number, "error", err)
+	}
+}
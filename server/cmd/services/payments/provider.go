@@ -0,0 +1,164 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"services/internal/money"
+	"sort"
+	"strings"
+)
+
+// PaymentProvider takes payments through one external processor. A checkout
+// picks the provider; everything after that goes back to the provider
+// recorded on the payment attempt.
+type PaymentProvider interface {
+	// Name is what is stored on attempts and payments, such as PAYPAL
+	Name() string
+	// CreateSession starts a payment the buyer approves at the session's
+	// redirect URL
+	CreateSession(ctx context.Context, charge Charge, orderID string) (*Session, error)
+	// Capture takes the money of an approved session
+	Capture(ctx context.Context, sessionID string) (*CaptureResult, error)
+	// FetchStatus looks up a session without changing it
+	FetchStatus(ctx context.Context, sessionID string) (*CaptureResult, error)
+	// FetchCapture looks up a capture as the provider sees it now, refunds
+	// included
+	FetchCapture(ctx context.Context, captureID string) (*CaptureResult, error)
+	// Refund refunds a capture. A zero amount refunds whatever remains of it.
+	// requestID makes the call idempotent so a retry never refunds twice.
+	Refund(ctx context.Context, captureID string, amount money.Money, orderID, note, requestID string) (*RefundResult, error)
+	// ParseWebhook verifies a webhook delivery and translates it
+	ParseWebhook(ctx context.Context, headers http.Header, body []byte) (*WebhookEvent, error)
+}
+
+// SavedPaymentCharger is implemented by providers that can keep the payment
+// method of a plan's first installment and charge later installments to it
+// while the buyer is not present
+type SavedPaymentCharger interface {
+	// ChargeSaved charges the payment method saved with the capture of an
+	// earlier payment. requestID makes the call idempotent.
+	ChargeSaved(ctx context.Context, captureID string, charge Charge, orderID, requestID string) (*CaptureResult, error)
+}
+
+// SessionCanceller is implemented by providers that can stop an unpaid
+// session from being paid. PayPal has no way to cancel an order created for
+// capture, so only captures of it are refused.
+type SessionCanceller interface {
+	// CancelSession cancels an open session at the provider
+	CancelSession(ctx context.Context, sessionID string) error
+}
+
+// Errors providers wrap their own errors in, so a failure is handled the same
+// way whichever provider it came from
+var (
+	ErrAlreadyCaptured     = errors.New("payment already captured")
+	ErrPaymentDeclined     = errors.New("payment declined")
+	ErrProviderUnavailable = errors.New("payment provider unavailable")
+	ErrNotFoundAtProvider  = errors.New("not found at payment provider")
+	ErrMalformedWebhook    = errors.New("malformed webhook")
+	ErrUnknownProvider     = errors.New("unknown payment provider")
+	ErrNoSavedPayment      = errors.New("no saved payment method")
+)
+
+// Charge is what the buyer is asked to pay, split into the lines providers
+// show them. SavePaymentMethod asks providers that support it to keep the
+// payment method for the later installments of a plan.
+type Charge struct {
+	Currency          string
+	ItemTotal         money.Amount
+	Discount          money.Amount
+	TaxTotal          money.Amount
+	SavePaymentMethod bool
+}
+
+// Total is what the buyer pays
+func (c Charge) Total() money.Money {
+	return money.New(c.ItemTotal-c.Discount+c.TaxTotal, c.Currency)
+}
+
+// Session is a payment the buyer has yet to approve
+type Session struct {
+	ID          string
+	RedirectURL string
+}
+
+// CaptureResult is the state of a payment at the provider, with the fields
+// needed to check that it matches the local order. Statuses use one
+// vocabulary across providers: COMPLETED once the money is taken, then
+// PENDING, DECLINED, FAILED, REFUNDED and PARTIALLY_REFUNDED.
+type CaptureResult struct {
+	Status    string
+	CaptureID string
+	Reference string      // our order ID, as the provider echoes it back
+	Amount    money.Money // what was actually captured, zero if the provider sent nothing valid
+}
+
+// RefundResult is the outcome of a refund. Status is COMPLETED, PENDING,
+// FAILED or CANCELLED.
+type RefundResult struct {
+	ID     string
+	Status string
+}
+
+// What a webhook event reports, whichever provider sent it
+const (
+	EventPaymentCompleted = "PAYMENT_COMPLETED"
+	EventPaymentDenied    = "PAYMENT_DENIED"
+	EventPaymentPending   = "PAYMENT_PENDING"
+	EventPaymentRefunded  = "PAYMENT_REFUNDED"
+)
+
+// WebhookEvent is a verified webhook delivery. Kind is one of the Event
+// constants, or empty for events that are recorded and otherwise ignored.
+type WebhookEvent struct {
+	ID         string // the provider's event ID, processed at most once
+	Type       string // the provider's own event type
+	Kind       string
+	ResourceID string
+	SessionID  string // the session the payment was made through
+	CaptureID  string // the capture, or for refunds the refunded capture
+	RefundID   string
+	Reference  string // our order ID
+	Status     string // of the capture, or of the refund
+	Amount     money.Money
+}
+
+// Providers are the payment providers a checkout can choose from
+type Providers struct {
+	byName   map[string]PaymentProvider
+	fallback string
+}
+
+// NewProviders registers providers by name. The first one is used when a
+// checkout does not choose.
+func NewProviders(fallback PaymentProvider, others ...PaymentProvider) *Providers {
+	p := &Providers{byName: map[string]PaymentProvider{}, fallback: fallback.Name()}
+	for _, provider := range append([]PaymentProvider{fallback}, others...) {
+		p.byName[provider.Name()] = provider
+	}
+	return p
+}
+
+// Get finds a provider by name, case insensitively. An empty name is the
+// fallback provider.
+func (p *Providers) Get(name string) (PaymentProvider, error) {
+	if name == "" {
+		name = p.fallback
+	}
+	provider, ok := p.byName[strings.ToUpper(name)]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// Names lists the registered providers
+func (p *Providers) Names() []string {
+	names := make([]string, 0, len(p.byName))
+	for name := range p.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
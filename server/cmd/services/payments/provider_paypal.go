@@ -0,0 +1,145 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"services/internal/money"
+	"services/internal/paypal"
+)
+
+// PayPalClient is an interface for PayPal operations so it can be mocked in tests.
+// Every call takes the request context so cancellation and tracing reach PayPal.
+type PayPalClient interface {
+	CreateOrder(ctx context.Context, charge paypal.Charge, orderID string) (string, string, error)
+	CaptureOrder(ctx context.Context, orderID string) (*paypal.CaptureResult, error)
+	GetOrder(ctx context.Context, orderID string) (*paypal.CaptureResult, error)
+	GetCapture(ctx context.Context, captureID string) (*paypal.CaptureDetails, error)
+	RefundCapture(ctx context.Context, captureID string, amount money.Money, orderID, note, requestID string) (*paypal.RefundResult, error)
+	VerifyWebhookSignature(ctx context.Context, headers http.Header, body []byte) error
+}
+
+// paypalProvider takes payments through PayPal Checkout. A session is a
+// PayPal order; the buyer approves it on PayPal and it is captured on return.
+type paypalProvider struct {
+	client PayPalClient
+}
+
+func newPayPalProvider(client PayPalClient) *paypalProvider {
+	return &paypalProvider{client: client}
+}
+
+func (p *paypalProvider) Name() string {
+	return "PAYPAL"
+}
+
+func (p *paypalProvider) CreateSession(ctx context.Context, charge Charge, orderID string) (*Session, error) {
+	// PayPal payment methods are not saved, so later installments are paid
+	// by the buyer
+	paypalCharge := paypal.Charge{Currency: charge.Currency, ItemTotal: charge.ItemTotal, Discount: charge.Discount, TaxTotal: charge.TaxTotal}
+	paypalOrderID, approveURL, err := p.client.CreateOrder(ctx, paypalCharge, orderID)
+	if err != nil {
+		return nil, paypalError(err)
+	}
+	return &Session{ID: paypalOrderID, RedirectURL: approveURL}, nil
+}
+
+func (p *paypalProvider) Capture(ctx context.Context, sessionID string) (*CaptureResult, error) {
+	result, err := p.client.CaptureOrder(ctx, sessionID)
+	if err != nil {
+		return nil, paypalError(err)
+	}
+	return fromPayPalCapture(result), nil
+}
+
+func (p *paypalProvider) FetchStatus(ctx context.Context, sessionID string) (*CaptureResult, error) {
+	result, err := p.client.GetOrder(ctx, sessionID)
+	if err != nil {
+		return nil, paypalError(err)
+	}
+	return fromPayPalCapture(result), nil
+}
+
+func (p *paypalProvider) FetchCapture(ctx context.Context, captureID string) (*CaptureResult, error) {
+	details, err := p.client.GetCapture(ctx, captureID)
+	if err != nil {
+		return nil, paypalError(err)
+	}
+	amount, _ := details.Amount.Money()
+	return &CaptureResult{
+		Status:    details.Status,
+		CaptureID: details.ID,
+		Reference: details.CustomID,
+		Amount:    amount,
+	}, nil
+}
+
+func (p *paypalProvider) Refund(ctx context.Context, captureID string, amount money.Money, orderID, note, requestID string) (*RefundResult, error) {
+	result, err := p.client.RefundCapture(ctx, captureID, amount, orderID, note, requestID)
+	if err != nil {
+		return nil, paypalError(err)
+	}
+	return &RefundResult{ID: result.ID, Status: result.Status}, nil
+}
+
+// ParseWebhook verifies a delivery with PayPal. PAYMENT.CAPTURE.* events
+// carry the capture, or for refunds the refund, as their resource.
+func (p *paypalProvider) ParseWebhook(ctx context.Context, headers http.Header, body []byte) (*WebhookEvent, error) {
+	event, err := paypal.ParseWebhookEvent(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedWebhook, err)
+	}
+
+	if err := p.client.VerifyWebhookSignature(ctx, headers, body); err != nil {
+		return nil, err
+	}
+
+	resource, err := event.DecodeCapture()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedWebhook, err)
+	}
+
+	parsed := &WebhookEvent{
+		ID:         event.ID,
+		Type:       event.EventType,
+		ResourceID: resource.ID,
+		SessionID:  resource.SupplementaryData.RelatedIDs.OrderID,
+		CaptureID:  resource.ID,
+		Reference:  resource.CustomID,
+		Status:     resource.Status,
+	}
+	parsed.Amount, _ = resource.Amount.Money()
+
+	switch event.EventType {
+	case paypal.EventCaptureCompleted:
+		parsed.Kind = EventPaymentCompleted
+	case paypal.EventCaptureDenied:
+		parsed.Kind = EventPaymentDenied
+	case paypal.EventCapturePending:
+		parsed.Kind = EventPaymentPending
+	case paypal.EventCaptureRefunded:
+		parsed.Kind = EventPaymentRefunded
+		parsed.RefundID = resource.ID
+		parsed.CaptureID = resource.ParentCaptureID()
+		if _, err := resource.Amount.Money(); err != nil {
+			return nil, fmt.Errorf("%w: invalid refund amount %q: %w", ErrMalformedWebhook, resource.Amount.Value, err)
+		}
+	}
+	return parsed, nil
+}
+
+// fromPayPalCapture translates a PayPal order. The order ID is sent as both
+// reference_id and custom_id; either is enough to match it.
+func fromPayPalCapture(result *paypal.CaptureResult) *CaptureResult {
+	reference := result.ReferenceID
+	if reference == "" {
+		reference = result.CustomID
+	}
+	amount, _ := result.Amount.Money()
+	return &CaptureResult{
+		Status:    result.Status,
+		CaptureID: result.CaptureID,
+		Reference: reference,
+		Amount:    amount,
+	}
+}
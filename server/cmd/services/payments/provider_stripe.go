@@ -0,0 +1,242 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"services/internal/money"
+	"services/internal/paypal"
+	"services/internal/stripe"
+	"strings"
+	"time"
+)
+
+// stripeProvider takes card payments through Stripe Checkout. A session is a
+// Checkout Session; Stripe captures the card as soon as the buyer pays, so
+// capturing only reads the session back. Captures are payment intents.
+type stripeProvider struct {
+	client *stripe.Client
+}
+
+func newStripeProvider(client *stripe.Client) *stripeProvider {
+	return &stripeProvider{client: client}
+}
+
+func (p *stripeProvider) Name() string {
+	return "STRIPE"
+}
+
+// CreateSession sends the buyer back to the same success page as PayPal,
+// with the session ID in place of PayPal's token
+func (p *stripeProvider) CreateSession(ctx context.Context, charge Charge, orderID string) (*Session, error) {
+	frontendURL := paypal.FrontendURL()
+
+	session, err := p.client.CreateCheckoutSession(ctx, stripe.SessionParams{
+		OrderID:     orderID,
+		Description: "Order " + orderID,
+		Amount:      charge.Total(),
+		// Stripe fills in {CHECKOUT_SESSION_ID} itself, so it must not be escaped
+		SuccessURL: fmt.Sprintf("%s/checkout/success?order_id=%s&token={CHECKOUT_SESSION_ID}", frontendURL, url.QueryEscape(orderID)),
+		CancelURL:  fmt.Sprintf("%s/checkout/cancel?order_id=%s", frontendURL, url.QueryEscape(orderID)),
+		SaveCard:   charge.SavePaymentMethod,
+	})
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return &Session{ID: session.ID, RedirectURL: session.URL}, nil
+}
+
+func (p *stripeProvider) Capture(ctx context.Context, sessionID string) (*CaptureResult, error) {
+	return p.FetchStatus(ctx, sessionID)
+}
+
+func (p *stripeProvider) FetchStatus(ctx context.Context, sessionID string) (*CaptureResult, error) {
+	session, err := p.client.GetCheckoutSession(ctx, sessionID)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+
+	result := &CaptureResult{
+		Status:    sessionStatus(session),
+		CaptureID: session.PaymentIntent,
+		Reference: session.ClientReferenceID,
+	}
+	if result.Status == "COMPLETED" {
+		result.Amount = session.Money()
+	}
+	return result, nil
+}
+
+func (p *stripeProvider) FetchCapture(ctx context.Context, captureID string) (*CaptureResult, error) {
+	intent, err := p.client.GetPaymentIntent(ctx, captureID)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return &CaptureResult{
+		Status:    paymentIntentStatus(intent),
+		CaptureID: intent.ID,
+		Reference: intent.Metadata["order_id"],
+		Amount:    intent.Money(),
+	}, nil
+}
+
+func (p *stripeProvider) Refund(ctx context.Context, captureID string, amount money.Money, orderID, note, requestID string) (*RefundResult, error) {
+	refund, err := p.client.CreateRefund(ctx, captureID, amount.Amount, orderID, note, requestID)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return &RefundResult{ID: refund.ID, Status: refundStatus(refund.Status)}, nil
+}
+
+// ChargeSaved charges the card saved with the payment intent of the plan's
+// first installment
+func (p *stripeProvider) ChargeSaved(ctx context.Context, captureID string, charge Charge, orderID, requestID string) (*CaptureResult, error) {
+	source, err := p.client.GetPaymentIntent(ctx, captureID)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	if source.Customer == "" || source.PaymentMethod == "" {
+		return nil, ErrNoSavedPayment
+	}
+
+	intent, err := p.client.ChargeSavedCard(ctx, source.Customer, source.PaymentMethod, charge.Total(), orderID, requestID)
+	if err != nil {
+		return nil, stripeError(err)
+	}
+	return &CaptureResult{
+		Status:    paymentIntentStatus(intent),
+		CaptureID: intent.ID,
+		Reference: intent.Metadata["order_id"],
+		Amount:    intent.Money(),
+	}, nil
+}
+
+// CancelSession expires the Checkout Session of an order that is being
+// cancelled
+func (p *stripeProvider) CancelSession(ctx context.Context, sessionID string) error {
+	if _, err := p.client.ExpireCheckoutSession(ctx, sessionID); err != nil {
+		return stripeError(err)
+	}
+	return nil
+}
+
+// ParseWebhook verifies the Stripe-Signature header. Cards usually complete
+// with the session, other methods report later through the async events.
+func (p *stripeProvider) ParseWebhook(ctx context.Context, headers http.Header, body []byte) (*WebhookEvent, error) {
+	if err := p.client.VerifyWebhookSignature(headers.Get("Stripe-Signature"), body, time.Now()); err != nil {
+		return nil, err
+	}
+
+	event, err := stripe.ParseWebhookEvent(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedWebhook, err)
+	}
+	parsed := &WebhookEvent{ID: event.ID, Type: event.Type}
+
+	switch event.Type {
+	case stripe.EventCheckoutCompleted, stripe.EventAsyncPaymentSucceeded, stripe.EventAsyncPaymentFailed:
+		session, err := event.DecodeSession()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedWebhook, err)
+		}
+		parsed.ResourceID = session.ID
+		parsed.SessionID = session.ID
+		parsed.CaptureID = session.PaymentIntent
+		parsed.Reference = session.ClientReferenceID
+		parsed.Status = sessionStatus(session)
+		parsed.Amount = session.Money()
+
+		switch {
+		case event.Type == stripe.EventAsyncPaymentFailed:
+			parsed.Kind = EventPaymentDenied
+		case session.PaymentStatus == "paid":
+			parsed.Kind = EventPaymentCompleted
+		default:
+			parsed.Kind = EventPaymentPending
+		}
+
+	case stripe.EventRefundCreated, stripe.EventRefundUpdated, stripe.EventChargeRefundUpdated:
+		refund, err := event.DecodeRefund()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMalformedWebhook, err)
+		}
+		parsed.Kind = EventPaymentRefunded
+		parsed.ResourceID = refund.ID
+		parsed.RefundID = refund.ID
+		parsed.CaptureID = refund.PaymentIntent
+		parsed.Reference = refund.Metadata["order_id"]
+		parsed.Status = refundStatus(refund.Status)
+		parsed.Amount = refund.Money()
+	}
+	return parsed, nil
+}
+
+// sessionStatus translates a Checkout Session into the shared capture
+// statuses. A complete session that is not yet paid is waiting on a
+// delayed payment method.
+func sessionStatus(session *stripe.Session) string {
+	switch {
+	case session.PaymentStatus == "paid":
+		return "COMPLETED"
+	case session.Status == "complete":
+		return "PENDING"
+	}
+	return strings.ToUpper(session.Status)
+}
+
+// paymentIntentStatus translates a payment intent, taking the refunds on its
+// charge into account
+func paymentIntentStatus(intent *stripe.PaymentIntent) string {
+	switch intent.Status {
+	case "succeeded":
+		charge := intent.LatestCharge
+		switch {
+		case charge == nil || charge.AmountRefunded == 0:
+			return "COMPLETED"
+		case charge.Refunded:
+			return "REFUNDED"
+		}
+		return "PARTIALLY_REFUNDED"
+	case "processing":
+		return "PENDING"
+	case "requires_payment_method":
+		return "DECLINED"
+	case "canceled":
+		return "FAILED"
+	}
+	return strings.ToUpper(intent.Status)
+}
+
+func refundStatus(status string) string {
+	switch status {
+	case "succeeded":
+		return "COMPLETED"
+	case "pending", "requires_action":
+		return "PENDING"
+	case "failed":
+		return "FAILED"
+	case "canceled":
+		return "CANCELLED"
+	}
+	return strings.ToUpper(status)
+}
+
+// stripeError wraps a Stripe API error in the provider error it amounts to
+func stripeError(err error) error {
+	var apiErr *stripe.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	switch {
+	case apiErr.StatusCode == http.StatusNotFound || apiErr.Code == stripe.ErrCodeResourceMissing:
+		return fmt.Errorf("%w: %w", ErrNotFoundAtProvider, err)
+	case apiErr.Type == stripe.ErrTypeCard:
+		return fmt.Errorf("%w: %w", ErrPaymentDeclined, err)
+	case apiErr.StatusCode >= http.StatusInternalServerError || apiErr.Type == stripe.ErrTypeAPI:
+		return fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/models"
+	"services/internal/money"
+	"services/internal/repository"
+	"time"
+)
+
+// Kinds of drift between local orders and the payment providers
+const (
+	DriftPaidNotFulfilled = "PAID_NOT_FULFILLED" // captured at the provider, still PENDING, FAILED or CANCELLED locally
+	DriftMissingCapture   = "MISSING_CAPTURE"    // COMPLETED locally, no completed capture at the provider
+	DriftAmountMismatch   = "AMOUNT_MISMATCH"    // captured amount differs from what was recorded
+	DriftLookupFailed     = "LOOKUP_FAILED"      // the provider could not be asked, the order was not checked
+)
+
+// Drift is one disagreement between an order and a payment provider
+type Drift struct {
+	OrderID     string
+	UserID      string
+	Kind        string
+	LocalStatus string
+	Provider    string
+	ProviderID  string // session or capture ID at the provider
+	Detail      string
+	Fixed       bool
+	FixError    string
+}
+
+// ReconcileReport lists the drift found over a date range
+type ReconcileReport struct {
+	From    time.Time
+	To      time.Time
+	Applied bool
+	Orders  int
+	Drifts  []Drift
+}
+
+// Unresolved counts the drifts that still need a person to look at them
+func (r *ReconcileReport) Unresolved() int {
+	n := 0
+	for _, d := range r.Drifts {
+		if !d.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+// Reconcile checks the orders created in [from, to) against their payment
+// providers. Unpaid orders have their sessions looked up for captures that
+// never reached us; paid orders have each capture looked up. With apply set, paid orders
+// are fulfilled and paid orders with no capture are marked FAILED. Amount
+// mismatches are only reported, they need a person to decide.
+func (h *PaymentHandler) Reconcile(ctx context.Context, from, to time.Time, apply bool) (*ReconcileReport, error) {
+	orders, err := h.orderRepo.FindByFilter(ctx, repository.OrderFilter{From: &from, To: &to})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{From: from, To: to, Applied: apply, Orders: len(orders)}
+	for _, order := range orders {
+		// Orders paid for by coupons never reached a provider
+		if order.TotalAmount == 0 && isFulfilled(order.Status) {
+			continue
+		}
+		var drifts []Drift
+		switch {
+		case isFulfilled(order.Status):
+			drifts = h.reconcilePaid(ctx, order, apply)
+		case order.Status == "PENDING" || order.Status == "FAILED" || order.Status == "CANCELLED":
+			drifts = h.reconcileUnpaid(ctx, order, apply)
+		}
+		report.Drifts = append(report.Drifts, drifts...)
+	}
+	return report, nil
+}
+
+// reconcileUnpaid looks for a captured session behind an order that was never
+// fulfilled. Only the first good capture is fulfilled; sessions for later
+// installments only exist once an order is paid, so they are skipped.
+func (h *PaymentHandler) reconcileUnpaid(ctx context.Context, order *models.Order, apply bool) []Drift {
+	var drifts []Drift
+	for i := range order.Attempts {
+		attempt := &order.Attempts[i]
+		if attempt.InstallmentID != nil {
+			continue
+		}
+
+		provider, err := h.providers.Get(attempt.Provider)
+		if err != nil {
+			drifts = append(drifts, newDrift(order, DriftLookupFailed, attempt.Provider, attempt.ProviderOrderID, err.Error()))
+			continue
+		}
+		result, err := provider.FetchStatus(ctx, attempt.ProviderOrderID)
+		if err != nil {
+			if errors.Is(err, ErrNotFoundAtProvider) {
+				continue
+			}
+			drifts = append(drifts, newDrift(order, DriftLookupFailed, attempt.Provider, attempt.ProviderOrderID, err.Error()))
+			continue
+		}
+		if result.Status != "COMPLETED" {
+			continue
+		}
+
+		if reason := captureMismatch(order, result); reason != "" {
+			drifts = append(drifts, newDrift(order, DriftAmountMismatch, attempt.Provider, attempt.ProviderOrderID, reason))
+			if apply && attempt.Status != "MISMATCH" {
+				h.recordMismatch(ctx, attempt, result.CaptureID, reason)
+			}
+			continue
+		}
+
+		drift := newDrift(order, DriftPaidNotFulfilled, attempt.Provider, attempt.ProviderOrderID, fmt.Sprintf("captured as %s", result.CaptureID))
+		if apply {
+			h.markAttemptCaptured(ctx, attempt, result.CaptureID)
+			setFixed(&drift, h.fulfillOrder(ctx, order, attempt, result.CaptureID))
+		}
+		return append(drifts, drift)
+	}
+	return drifts
+}
+
+// reconcilePaid looks up every capture recorded against a paid order
+func (h *PaymentHandler) reconcilePaid(ctx context.Context, order *models.Order, apply bool) []Drift {
+	var drifts []Drift
+	var missing []*models.Payment
+	recorded := 0
+	for i := range order.Payments {
+		payment := &order.Payments[i]
+		if payment.TransactionType == "REFUND" || payment.TransactionStatus == "FAILED" || payment.TransactionStatus == "PENDING" {
+			continue
+		}
+		recorded++
+
+		provider, err := h.providers.Get(payment.Provider)
+		if err != nil {
+			drifts = append(drifts, newDrift(order, DriftLookupFailed, payment.Provider, payment.ProviderTransactionID, err.Error()))
+			continue
+		}
+		capture, err := provider.FetchCapture(ctx, payment.ProviderTransactionID)
+		if err != nil {
+			if errors.Is(err, ErrNotFoundAtProvider) {
+				drifts = append(drifts, newDrift(order, DriftMissingCapture, provider.Name(), payment.ProviderTransactionID, "capture not found at the provider"))
+				missing = append(missing, payment)
+				continue
+			}
+			drifts = append(drifts, newDrift(order, DriftLookupFailed, provider.Name(), payment.ProviderTransactionID, err.Error()))
+			continue
+		}
+
+		switch capture.Status {
+		case "DECLINED", "FAILED":
+			drifts = append(drifts, newDrift(order, DriftMissingCapture, provider.Name(), capture.CaptureID, "capture is "+capture.Status+" at the provider"))
+			missing = append(missing, payment)
+			continue
+		}
+
+		expected := money.New(payment.TransactionAmount, payment.Currency)
+		if capture.Amount.Currency == "" {
+			drifts = append(drifts, newDrift(order, DriftAmountMismatch, provider.Name(), capture.CaptureID, "captured amount is missing or not valid"))
+		} else if capture.Amount != expected {
+			drifts = append(drifts, newDrift(order, DriftAmountMismatch, provider.Name(), capture.CaptureID, fmt.Sprintf("captured %s, recorded %s", capture.Amount, expected)))
+		}
+	}
+
+	if recorded == 0 {
+		drift := newDrift(order, DriftMissingCapture, "", "", "no payment recorded")
+		if apply {
+			setFixed(&drift, h.markOrderFailed(ctx, order, nil))
+		}
+		return append(drifts, drift)
+	}
+
+	// Only an order none of whose captures went through is unpaid
+	if apply && len(missing) == recorded {
+		fixErr := h.markOrderFailed(ctx, order, missing)
+		for i := range drifts {
+			if drifts[i].Kind == DriftMissingCapture {
+				setFixed(&drifts[i], fixErr)
+			}
+		}
+	}
+	return drifts
+}
+
+// markOrderFailed fails a paid order whose payments never reached a provider.
+// Course access is left alone, revoking it is a call for a person to make.
+func (h *PaymentHandler) markOrderFailed(ctx context.Context, order *models.Order, payments []*models.Payment) error {
+	err := h.uow.Do(ctx, func(repos repository.Repositories) error {
+		for _, payment := range payments {
+			payment.TransactionStatus = "FAILED"
+			if err := repos.Payments.Update(ctx, payment); err != nil {
+				return err
+			}
+		}
+		if err := repos.Orders.UpdateStatus(ctx, order.ID, "FAILED"); err != nil {
+			return err
+		}
+		return repos.Coupons.ReleaseRedemptions(ctx, order.ID)
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to mark unpaid order failed", "user_id", order.UserID, "order_id", order.ID, "error", err)
+		return err
+	}
+	h.logger.InfoContext(ctx, "Marked order with no provider capture failed", "user_id", order.UserID, "order_id", order.ID)
+	return nil
+}
+
+func setFixed(drift *Drift, err error) {
+	if err != nil {
+		drift.FixError = err.Error()
+		return
+	}
+	drift.Fixed = true
+}
+
+func newDrift(order *models.Order, kind, provider, providerID, detail string) Drift {
+	return Drift{
+		OrderID:     order.ID,
+		UserID:      order.UserID,
+		Kind:        kind,
+		LocalStatus: order.Status,
+		Provider:    provider,
+		ProviderID:  providerID,
+		Detail:      detail,
+	}
+}
@@ -0,0 +1,339 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"services/internal/api"
+	"services/internal/models"
+	"services/internal/money"
+	"services/internal/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// RefundOrder refunds all or part of a captured order through the provider
+// that took the payment and records the refund against the original payment.
+func (h *PaymentHandler) RefundOrder(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req struct {
+		PaymentID    string       `json:"payment_id"` // Required when the order has more than one captured payment
+		Amount       money.Amount `json:"amount"`     // Optional, defaults to the remaining refundable balance of the payment
+		Reason       string       `json:"reason"`
+		RevokeAccess bool         `json:"revoke_access"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	order, err := h.orderRepo.FindByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrOrderNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Order not found")
+			return
+		}
+		h.logger.ErrorContext(ctx, "Failed to load order for refund", "order_id", id, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get order")
+		return
+	}
+
+	if order.Status != "COMPLETED" && order.Status != "PARTIALLY_REFUNDED" {
+		api.RespondWithError(w, http.StatusBadRequest, "Only completed orders can be refunded")
+		return
+	}
+
+	original, reason := refundablePayment(order, req.PaymentID)
+	if original == nil {
+		api.RespondWithError(w, http.StatusBadRequest, reason)
+		return
+	}
+
+	remaining := original.TransactionAmount - refundedAmount(order, original)
+	amount := req.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		api.RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Refund amount must be between 0.01 and %s", remaining))
+		return
+	}
+
+	provider, err := h.providers.Get(original.Provider)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Payment has an unknown provider", "order_id", order.ID, "provider", original.Provider)
+		api.RespondWithError(w, http.StatusInternalServerError, "Payment provider error")
+		return
+	}
+
+	// The request ID only changes once a refund has been recorded, so a
+	// retried admin request cannot refund the same balance twice.
+	requestID := fmt.Sprintf("refund-%s-%s-%d", order.ID, original.ID, len(refundsFor(order, original)))
+	result, err := provider.Refund(ctx, original.ProviderTransactionID, money.New(amount, original.Currency), order.ID, req.Reason, requestID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to refund capture", "order_id", order.ID, "provider", provider.Name(), "capture_id", original.ProviderTransactionID, "amount", amount, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Payment provider error")
+		return
+	}
+
+	refund, err := h.applyRefund(ctx, order, original, result.ID, result.Status, amount)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Refund issued but not recorded", "order_id", order.ID, "refund_id", result.ID, "amount", amount, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Refund issued but failed to record it")
+		return
+	}
+
+	if req.RevokeAccess {
+		h.revokeRefundedAccess(ctx, order.ID)
+	}
+
+	h.logger.InfoContext(ctx, "Refunded order", "order_id", order.ID, "refund_id", result.ID, "amount", amount, "revoke_access", req.RevokeAccess)
+
+	api.RespondWithJSON(w, http.StatusCreated, refund)
+}
+
+// revokeRefundedAccess takes back the courses of an order once its refunds
+// cover everything paid on it. Refunds are not split across lines, so after a
+// partial refund no line counts as fully refunded and access is kept. A course
+// the buyer also holds through another paid order or a redeemed code stays.
+func (h *PaymentHandler) revokeRefundedAccess(ctx context.Context, orderID string) {
+	order, err := h.orderRepo.FindByID(ctx, orderID)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to load order to revoke access", "order_id", orderID, "error", err)
+		return
+	}
+	if order.Status != "REFUNDED" {
+		h.logger.InfoContext(ctx, "Order is only partly refunded, keeping access", "order_id", order.ID, "status", order.Status)
+		return
+	}
+	if order.IsGift() {
+		h.revokeGift(ctx, order)
+		return
+	}
+
+	for _, item := range order.Items {
+		granted, err := h.courseGrantedElsewhere(ctx, order.UserID, item.CourseID, order.ID)
+		if err != nil {
+			h.logger.ErrorContext(ctx, "Failed to check other grants of course, keeping access", "user_id", order.UserID, "course_id", item.CourseID, "error", err)
+			continue
+		}
+		if granted {
+			h.logger.InfoContext(ctx, "Course is also granted elsewhere, keeping access", "user_id", order.UserID, "course_id", item.CourseID, "order_id", order.ID)
+			continue
+		}
+		if err := h.userRepo.RevokeCourse(ctx, order.UserID, item.CourseID); err != nil {
+			h.logger.ErrorContext(ctx, "Failed to revoke course after refund", "user_id", order.UserID, "course_id", item.CourseID, "error", err)
+		}
+	}
+}
+
+// courseGrantedElsewhere reports whether the user holds the course through a
+// paid order other than refundedOrderID that is not fully refunded, or through
+// a redeemed code
+func (h *PaymentHandler) courseGrantedElsewhere(ctx context.Context, userID, courseID, refundedOrderID string) (bool, error) {
+	orders, err := h.orderRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, other := range orders {
+		if other.ID == refundedOrderID || other.IsGift() || (other.Status != "COMPLETED" && other.Status != "PARTIALLY_REFUNDED") {
+			continue
+		}
+		for _, item := range other.Items {
+			if item.CourseID == courseID {
+				return true, nil
+			}
+		}
+	}
+	return h.codeRepo.HasRedeemed(ctx, userID, courseID)
+}
+
+// applyRefund records a refund issued against original and moves the payment
+// to REFUNDED or PARTIALLY_REFUNDED. The order becomes REFUNDED once the
+// refunds cover everything captured on it, across all of its payments. The
+// writes share one transaction. It is idempotent per refund ID, so the
+// webhook echo of a refund we issued ourselves is a no-op, while a later
+// status for a known refund (a pending refund that went through or failed)
+// updates it and the statuses are worked out again.
+func (h *PaymentHandler) applyRefund(ctx context.Context, order *models.Order, original *models.Payment, refundID, providerStatus string, amount money.Amount) (*models.Payment, error) {
+	status := "SUCCESS"
+	switch providerStatus {
+	case "PENDING":
+		status = "PENDING"
+	case "FAILED", "CANCELLED":
+		status = "FAILED"
+	}
+
+	var refund *models.Payment
+	err := h.uow.Do(ctx, func(repos repository.Repositories) error {
+		// Concurrent refunds of the same order queue up here, and the order is
+		// reloaded after the lock so the totals include refunds just recorded
+		if _, err := repos.Orders.FindByIDForUpdate(ctx, order.ID); err != nil {
+			return err
+		}
+		current, err := repos.Orders.FindByID(ctx, order.ID)
+		if err != nil {
+			return err
+		}
+
+		existing, err := repos.Payments.FindByProviderTransactionID(ctx, refundID)
+		switch {
+		case err == nil:
+			refund = existing
+			if existing.TransactionStatus == status {
+				return nil
+			}
+			refund.TransactionStatus = status
+			if err := repos.Payments.Update(ctx, refund); err != nil {
+				return err
+			}
+			if loaded := paymentOf(current, refund.ID); loaded != nil {
+				loaded.TransactionStatus = status
+			}
+			if refund.ParentPaymentID != nil {
+				original = &models.Payment{ID: *refund.ParentPaymentID}
+			}
+
+		case errors.Is(err, repository.ErrPaymentNotFound):
+			parentID := original.ID
+			refund = &models.Payment{
+				OrderID:               order.ID,
+				TransactionAmount:     amount,
+				Currency:              original.Currency,
+				TransactionMethod:     original.TransactionMethod,
+				TransactionStatus:     status,
+				TransactionType:       "REFUND",
+				Provider:              original.Provider,
+				ProviderTransactionID: refundID,
+				ParentPaymentID:       &parentID,
+			}
+			if err := repos.Payments.Create(ctx, refund); err != nil {
+				return err
+			}
+			current.Payments = append(current.Payments, *refund)
+
+		default:
+			return err
+		}
+
+		return updateRefundStatuses(ctx, repos, current, original.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// updateRefundStatuses works out the status of the refunded payment and of
+// the order from the refunds recorded on it that have not failed
+func updateRefundStatuses(ctx context.Context, repos repository.Repositories, order *models.Order, paymentID string) error {
+	payment := paymentOf(order, paymentID)
+	if payment == nil {
+		return fmt.Errorf("refunded payment %s is not a payment of order %s", paymentID, order.ID)
+	}
+	if status := refundStatusOf(payment.TransactionAmount, refundedAmount(order, payment), "SUCCESS"); status != payment.TransactionStatus {
+		payment.TransactionStatus = status
+		if err := repos.Payments.Update(ctx, payment); err != nil {
+			return err
+		}
+	}
+
+	var captured, refunded money.Amount
+	for _, p := range capturedPayments(order) {
+		captured += p.TransactionAmount
+		refunded += refundedAmount(order, p)
+	}
+	if status := refundStatusOf(captured, refunded, "COMPLETED"); status != order.Status {
+		return repos.Orders.UpdateStatus(ctx, order.ID, status)
+	}
+	return nil
+}
+
+// refundStatusOf is REFUNDED or PARTIALLY_REFUNDED depending on how much of
+// total was refunded, or unrefunded when nothing was
+func refundStatusOf(total, refunded money.Amount, unrefunded string) string {
+	switch {
+	case refunded <= 0:
+		return unrefunded
+	case refunded >= total:
+		return "REFUNDED"
+	}
+	return "PARTIALLY_REFUNDED"
+}
+
+// capturedPayments returns the order's captured (non-refund) payments, one
+// per installment paid on a payment plan
+func capturedPayments(order *models.Order) []*models.Payment {
+	var payments []*models.Payment
+	for i := range order.Payments {
+		p := &order.Payments[i]
+		if p.TransactionType == "REFUND" {
+			continue
+		}
+		switch p.TransactionStatus {
+		case "SUCCESS", "PARTIALLY_REFUNDED", "REFUNDED":
+			payments = append(payments, p)
+		}
+	}
+	return payments
+}
+
+// refundablePayment picks the captured payment a refund is issued against.
+// The payment ID may only be left out when the order has a single captured
+// payment. It returns nil and the reason when there is no such payment.
+func refundablePayment(order *models.Order, paymentID string) (*models.Payment, string) {
+	captured := capturedPayments(order)
+	if paymentID != "" {
+		for _, p := range captured {
+			if p.ID == paymentID {
+				return p, ""
+			}
+		}
+		return nil, "Payment is not a captured payment of this order"
+	}
+	switch len(captured) {
+	case 0:
+		return nil, "Order has no captured payment to refund"
+	case 1:
+		return captured[0], ""
+	default:
+		return nil, "payment_id is required for orders with more than one payment"
+	}
+}
+
+// paymentOf finds one of an order's payments by ID
+func paymentOf(order *models.Order, id string) *models.Payment {
+	for i := range order.Payments {
+		if order.Payments[i].ID == id {
+			return &order.Payments[i]
+		}
+	}
+	return nil
+}
+
+// refundsFor returns the refunds recorded against original
+func refundsFor(order *models.Order, original *models.Payment) []models.Payment {
+	var refunds []models.Payment
+	for _, p := range order.Payments {
+		if p.TransactionType == "REFUND" && p.ParentPaymentID != nil && *p.ParentPaymentID == original.ID {
+			refunds = append(refunds, p)
+		}
+	}
+	return refunds
+}
+
+// refundedAmount sums the refunds against original that have not failed
+func refundedAmount(order *models.Order, original *models.Payment) money.Amount {
+	var total money.Amount
+	for _, p := range refundsFor(order, original) {
+		if p.TransactionStatus != "FAILED" {
+			total += p.TransactionAmount
+		}
+	}
+	return total
+}
@@ -0,0 +1,111 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/installment"
+	"services/internal/models"
+	"services/internal/money"
+	"services/internal/repository"
+)
+
+// Ways a cart item can have changed since it was added
+const (
+	CartItemOwned       = "ALREADY_OWNED"
+	CartItemUnavailable = "UNAVAILABLE"
+	CartItemRepriced    = "PRICE_CHANGED"
+)
+
+// CartWarning tells the buyer what happened to an item of their cart at
+// checkout. Owned and unavailable items were removed; repriced items now cost
+// NewPrice.
+type CartWarning struct {
+	Code       string       `json:"code"`
+	CartItemID string       `json:"cart_item_id"`
+	CourseID   string       `json:"course_id"`
+	CourseName string       `json:"course_name,omitempty"`
+	OldPrice   money.Amount `json:"old_price"`
+	NewPrice   money.Amount `json:"new_price,omitempty"`
+	Message    string       `json:"message"`
+}
+
+// revalidateCart checks the cart's items against the current courses before
+// anything is charged. Courses the buyer is already enrolled in and courses
+// that no longer exist are removed from the cart, and items whose course or
+// plan price changed are repriced. The cart is updated both in the database
+// and in place, and a warning is returned for every change. Buyers can gift
+// courses they own themselves, so ownership is not checked for gifts.
+func (h *PaymentHandler) revalidateCart(ctx context.Context, userID string, cart *models.Cart, gift bool) ([]CartWarning, error) {
+	owned := make(map[string]bool)
+	if !gift {
+		enrolled, err := h.userRepo.GetEnrolledCourseIDs(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range enrolled {
+			owned[id] = true
+		}
+	}
+
+	var warnings []CartWarning
+	kept := cart.Items[:0]
+	for _, item := range cart.Items {
+		// A deleted course is not preloaded with the cart
+		name := item.Course.Name
+		if name == "" {
+			name = "A course"
+		}
+		warning := CartWarning{
+			CartItemID: item.ID,
+			CourseID:   item.CourseID,
+			CourseName: item.Course.Name,
+			OldPrice:   item.Price,
+		}
+
+		if owned[item.CourseID] {
+			if err := h.cartRepo.RemoveItemFromCart(ctx, item.ID); err != nil && !errors.Is(err, repository.ErrCartItemNotFound) {
+				return nil, err
+			}
+			warning.Code = CartItemOwned
+			warning.Message = fmt.Sprintf("You are already enrolled in %s, so it was removed from your cart", name)
+			warnings = append(warnings, warning)
+			continue
+		}
+
+		course, err := h.courseRepo.FindByID(ctx, item.CourseID)
+		if errors.Is(err, repository.ErrCourseNotFound) {
+			if err := h.cartRepo.RemoveItemFromCart(ctx, item.ID); err != nil && !errors.Is(err, repository.ErrCartItemNotFound) {
+				return nil, err
+			}
+			warning.Code = CartItemUnavailable
+			warning.Message = fmt.Sprintf("%s is no longer available, so it was removed from your cart", name)
+			warnings = append(warnings, warning)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		item.Course = *course
+
+		// Priced the same way as AddToCart
+		price := course.Price.PercentOff(course.Discount)
+		if item.PaymentPlan != nil {
+			price = installment.Price(item.PaymentPlan)
+		}
+		if price != item.Price {
+			if err := h.cartRepo.SetItemPlan(ctx, item.ID, item.PaymentPlanID, price); err != nil {
+				return nil, err
+			}
+			warning.Code = CartItemRepriced
+			warning.CourseName = course.Name
+			warning.NewPrice = price
+			warning.Message = fmt.Sprintf("The price of %s changed from %s to %s", course.Name, item.Price, price)
+			warnings = append(warnings, warning)
+			item.Price = price
+		}
+		kept = append(kept, item)
+	}
+	cart.Items = kept
+	return warnings, nil
+}
@@ -0,0 +1,68 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"services/internal/models"
+	"services/internal/tax"
+)
+
+var errProvinceRequired = errors.New("province is required to calculate sales tax")
+
+// taxProvince picks the province an order is taxed in: the one given at
+// checkout, otherwise the one on the buyer's profile. A province given at
+// checkout is saved to a profile that has none.
+func (h *PaymentHandler) taxProvince(ctx context.Context, userID, requested string) (string, error) {
+	user, err := h.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if requested == "" {
+		if user.Province == "" {
+			return "", errProvinceRequired
+		}
+		return tax.NormalizeProvince(user.Province)
+	}
+
+	province, err := tax.NormalizeProvince(requested)
+	if err != nil {
+		return "", err
+	}
+	if user.Province == "" {
+		user.Province = province
+		if err := h.userRepo.Update(ctx, user); err != nil {
+			h.logger.WarnContext(ctx, "Failed to save province to profile", "user_id", userID, "error", err)
+		}
+	}
+	return province, nil
+}
+
+// chargeFor is what the provider is asked to collect for an order at checkout.
+// Orders on a payment plan are charged their first installment. Orders from
+// before discounts and tax were recorded only have a total, so they are sent
+// without a breakdown that would not add up.
+func chargeFor(order *models.Order) Charge {
+	if first := firstInstallment(order); first != nil {
+		charge := installmentCharge(order, first)
+		charge.SavePaymentMethod = true
+		return charge
+	}
+	charge := Charge{
+		Currency:  order.Currency,
+		ItemTotal: order.Subtotal,
+		Discount:  order.Discount,
+		TaxTotal:  order.TaxTotal,
+	}
+	if charge.Total().Amount != order.TotalAmount {
+		return Charge{Currency: order.Currency, ItemTotal: order.TotalAmount}
+	}
+	return charge
+}
+
+// installmentCharge is what the provider is asked to collect for one
+// installment, split into its share of the discounted items and of the tax.
+// Installments scheduled before their tax was recorded have none.
+func installmentCharge(order *models.Order, inst *models.Installment) Charge {
+	return Charge{Currency: order.Currency, ItemTotal: inst.Amount - inst.Tax, TaxTotal: inst.Tax}
+}
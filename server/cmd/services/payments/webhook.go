@@ -0,0 +1,291 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"services/internal/api"
+	"services/internal/models"
+	"services/internal/repository"
+)
+
+// maxWebhookBodyBytes caps the size of a webhook delivery we are willing to read
+const maxWebhookBodyBytes = 1 << 20
+
+// PayPalWebhook receives PayPal webhook deliveries
+func (h *PaymentHandler) PayPalWebhook(w http.ResponseWriter, r *http.Request) {
+	h.providerWebhook(w, r, "PAYPAL")
+}
+
+// StripeWebhook receives Stripe webhook deliveries. It is not found unless
+// Stripe is configured.
+func (h *PaymentHandler) StripeWebhook(w http.ResponseWriter, r *http.Request) {
+	h.providerWebhook(w, r, "STRIPE")
+}
+
+// providerWebhook handles a delivery from the named provider. The routes are
+// public, so every delivery is verified before it is acted on. Each event ID
+// is processed at most once; redeliveries are acknowledged and ignored.
+func (h *PaymentHandler) providerWebhook(w http.ResponseWriter, r *http.Request, name string) {
+	ctx := r.Context()
+
+	provider, err := h.providers.Get(name)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	defer func() { _ = r.Body.Close() }()
+
+	event, err := provider.ParseWebhook(ctx, r.Header, body)
+	if err != nil {
+		if errors.Is(err, ErrMalformedWebhook) {
+			h.logger.WarnContext(ctx, "Rejected malformed webhook", "provider", name, "error", err)
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid webhook event")
+			return
+		}
+		h.logger.WarnContext(ctx, "Rejected webhook with invalid signature", "provider", name, "error", err)
+		api.RespondWithError(w, http.StatusUnauthorized, "Invalid webhook signature")
+		return
+	}
+
+	isNew, err := h.webhookRepo.Record(ctx, &models.WebhookEvent{
+		ID:         event.ID,
+		Provider:   name,
+		EventType:  event.Type,
+		ResourceID: event.ResourceID,
+		Payload:    string(body),
+	})
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Failed to record webhook", "provider", name, "event_id", event.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to record webhook")
+		return
+	}
+	if !isNew {
+		h.logger.InfoContext(ctx, "Ignoring duplicate webhook", "provider", name, "event_id", event.ID, "event_type", event.Type)
+		api.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "duplicate"})
+		return
+	}
+
+	if err := h.handlePaymentEvent(ctx, name, event); err != nil {
+		h.logger.ErrorContext(ctx, "Failed to process webhook", "provider", name, "event_id", event.ID, "event_type", event.Type, "error", err)
+		// Forget the event so the provider's redelivery gets processed
+		if delErr := h.webhookRepo.Delete(ctx, event.ID); delErr != nil {
+			h.logger.ErrorContext(ctx, "Failed to forget webhook", "provider", name, "event_id", event.ID, "error", delErr)
+		}
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to process webhook")
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Processed webhook", "provider", name, "event_id", event.ID, "event_type", event.Type, "resource_id", event.ResourceID)
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{"status": "processed"})
+}
+
+func (h *PaymentHandler) handlePaymentEvent(ctx context.Context, provider string, event *WebhookEvent) error {
+	switch event.Kind {
+	case EventPaymentCompleted:
+		order, err := h.orderForCapture(ctx, event)
+		if err != nil || order == nil {
+			return err
+		}
+		if order.Status == "COMPLETED" && len(order.Installments) == 0 {
+			return nil
+		}
+		return h.fulfillWebhookCapture(ctx, order, event)
+
+	case EventPaymentDenied:
+		order, err := h.orderForCapture(ctx, event)
+		if err != nil || order == nil {
+			return err
+		}
+		if order.Status == "COMPLETED" {
+			if inst, err := h.installmentForCapture(ctx, order, event); err != nil || inst != nil {
+				if inst != nil {
+					h.recordInstallmentFailure(ctx, inst, "capture denied")
+				}
+				return err
+			}
+			h.logger.WarnContext(ctx, "Capture denied for an already completed order", "order_id", order.ID, "capture_id", event.CaptureID)
+			return nil
+		}
+		if err := h.failOrder(ctx, order.ID); err != nil {
+			return err
+		}
+		return h.recordCapturePayment(ctx, order, provider, event.SessionID, event.CaptureID, "FAILED")
+
+	case EventPaymentPending:
+		order, err := h.orderForCapture(ctx, event)
+		if err != nil || order == nil {
+			return err
+		}
+		return h.recordCapturePayment(ctx, order, provider, event.SessionID, event.CaptureID, "PENDING")
+
+	case EventPaymentRefunded:
+		return h.markCaptureRefunded(ctx, event)
+
+	default:
+		h.logger.InfoContext(ctx, "Ignoring unhandled webhook event type", "event_type", event.Type)
+		return nil
+	}
+}
+
+// fulfillWebhookCapture checks a completed capture against the order and its
+// attempt before fulfilling. Mismatches are recorded and acknowledged so the
+// provider does not keep redelivering them.
+func (h *PaymentHandler) fulfillWebhookCapture(ctx context.Context, order *models.Order, event *WebhookEvent) error {
+	attempt, err := h.attemptRepo.FindByProviderOrderID(ctx, event.SessionID)
+	if err != nil && !errors.Is(err, repository.ErrPaymentAttemptNotFound) {
+		return err
+	}
+	if attempt == nil || attempt.OrderID != order.ID {
+		h.logger.ErrorContext(ctx, "Capture is not an attempt of its order", "order_id", order.ID, "session_id", event.SessionID, "capture_id", event.CaptureID)
+		return nil
+	}
+
+	capture := &CaptureResult{
+		Status:    event.Status,
+		CaptureID: event.CaptureID,
+		Reference: event.Reference,
+		Amount:    event.Amount,
+	}
+
+	if attempt.InstallmentID != nil {
+		inst := installmentOf(order, *attempt.InstallmentID)
+		if inst == nil {
+			h.logger.ErrorContext(ctx, "Capture is for an unknown installment", "order_id", order.ID, "installment_id", *attempt.InstallmentID, "capture_id", event.CaptureID)
+			return nil
+		}
+		if reason := installmentMismatch(order, inst, capture); reason != "" {
+			h.recordMismatch(ctx, attempt, event.CaptureID, reason)
+			return nil
+		}
+		h.markAttemptCaptured(ctx, attempt, event.CaptureID)
+		return h.settleInstallment(ctx, order, inst.ID, attempt, event.CaptureID)
+	}
+	if order.Status == "COMPLETED" {
+		return nil
+	}
+
+	if reason := captureMismatch(order, capture); reason != "" {
+		h.recordMismatch(ctx, attempt, event.CaptureID, reason)
+		return nil
+	}
+	h.markAttemptCaptured(ctx, attempt, event.CaptureID)
+
+	return h.fulfillOrder(ctx, order, attempt, event.CaptureID)
+}
+
+// installmentForCapture returns the installment a capture was paying for, or
+// nil when it was a checkout capture
+func (h *PaymentHandler) installmentForCapture(ctx context.Context, order *models.Order, event *WebhookEvent) (*models.Installment, error) {
+	attempt, err := h.attemptRepo.FindByProviderOrderID(ctx, event.SessionID)
+	if err != nil {
+		if errors.Is(err, repository.ErrPaymentAttemptNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if attempt.OrderID != order.ID || attempt.InstallmentID == nil {
+		return nil, nil
+	}
+	return installmentOf(order, *attempt.InstallmentID), nil
+}
+
+// installmentOf finds one of an order's installments by ID
+func installmentOf(order *models.Order, id string) *models.Installment {
+	for i := range order.Installments {
+		if order.Installments[i].ID == id {
+			return &order.Installments[i]
+		}
+	}
+	return nil
+}
+
+// orderForCapture resolves our order from the reference the provider echoed
+// back. A nil order with a nil error means the capture is not ours to act on.
+func (h *PaymentHandler) orderForCapture(ctx context.Context, event *WebhookEvent) (*models.Order, error) {
+	if event.Reference == "" {
+		h.logger.WarnContext(ctx, "Capture has no order reference, cannot match order", "capture_id", event.CaptureID)
+		return nil, nil
+	}
+	order, err := h.orderRepo.FindByID(ctx, event.Reference)
+	if err != nil {
+		if errors.Is(err, repository.ErrOrderNotFound) {
+			h.logger.WarnContext(ctx, "Capture references unknown order", "capture_id", event.CaptureID, "order_id", event.Reference)
+			return nil, nil
+		}
+		return nil, err
+	}
+	return order, nil
+}
+
+// recordCapturePayment stores a payment row for a capture, once per capture ID
+func (h *PaymentHandler) recordCapturePayment(ctx context.Context, order *models.Order, provider, sessionID, captureID, status string) error {
+	existing, err := h.repo.FindByProviderTransactionID(ctx, captureID)
+	if err == nil {
+		existing.TransactionStatus = status
+		return h.repo.Update(ctx, existing)
+	}
+	if !errors.Is(err, repository.ErrPaymentNotFound) {
+		return err
+	}
+
+	return h.repo.Create(ctx, &models.Payment{
+		OrderID:               order.ID,
+		TransactionAmount:     chargeFor(order).Total().Amount,
+		Currency:              order.Currency,
+		TransactionMethod:     provider,
+		TransactionStatus:     status,
+		Provider:              provider,
+		ProviderSessionID:     sessionID,
+		ProviderTransactionID: captureID,
+	})
+}
+
+// markCaptureRefunded records a refund reported by the provider, including
+// refunds issued from its dashboard rather than through RefundOrder
+func (h *PaymentHandler) markCaptureRefunded(ctx context.Context, event *WebhookEvent) error {
+	orderID := event.Reference
+
+	payment, err := h.repo.FindByProviderTransactionID(ctx, event.CaptureID)
+	switch {
+	case err == nil:
+		orderID = payment.OrderID
+	case !errors.Is(err, repository.ErrPaymentNotFound):
+		return err
+	}
+
+	if orderID == "" {
+		h.logger.WarnContext(ctx, "Refund could not be matched to an order", "refund_id", event.RefundID, "capture_id", event.CaptureID)
+		return nil
+	}
+
+	order, err := h.orderRepo.FindByID(ctx, orderID)
+	if err != nil {
+		if errors.Is(err, repository.ErrOrderNotFound) {
+			h.logger.WarnContext(ctx, "Refund references unknown order", "refund_id", event.RefundID, "order_id", orderID)
+			return nil
+		}
+		return err
+	}
+
+	// Prefer the payment the refunded capture belongs to, since orders paid
+	// in installments have one captured payment per installment
+	original := payment
+	if original == nil || original.TransactionType == "REFUND" {
+		original, _ = refundablePayment(order, "")
+	}
+	if original == nil {
+		h.logger.WarnContext(ctx, "Refund for order without a captured payment", "refund_id", event.RefundID, "order_id", order.ID)
+		return h.orderRepo.UpdateStatus(ctx, order.ID, "REFUNDED")
+	}
+
+	_, err = h.applyRefund(ctx, order, original, event.RefundID, event.Status, event.Amount.Amount)
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"log/slog"
 	"net/http"
 	"services/internal/api"
+	"services/internal/auth"
 	"services/internal/models"
 	"services/internal/repository"
 	"strconv"
@@ -45,6 +46,8 @@ func (h *ReviewHandler) CreateReview(w http.ResponseWriter, r *http.Request) {
 		api.RespondWithError(w, http.StatusBadRequest, "Invalid JSON format")
 		return
 	}
+	// Reviews are always written as the caller so authorship can be trusted
+	review.UserID, _ = ctx.Value(models.UserIDContextKey).(string)
 
 	if err := h.repo.Create(ctx, &review); err != nil {
 		h.logger.ErrorContext(ctx, "Error creating review", "error", err)
@@ -111,6 +114,12 @@ func (h *ReviewHandler) UpdateReview(w http.ResponseWriter, r *http.Request) {
 	}
 	review.ID = uint(idUint)
 
+	existing, ok := h.authorizeChange(w, r, id)
+	if !ok {
+		return
+	}
+	review.UserID = existing.UserID
+
 	if err := h.repo.Update(ctx, &review); err != nil {
 		if err == repository.ErrReviewNotFound {
 			api.RespondWithError(w, http.StatusNotFound, "Review not found")
@@ -129,6 +138,10 @@ func (h *ReviewHandler) DeleteReview(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id := vars["id"]
 
+	if _, ok := h.authorizeChange(w, r, id); !ok {
+		return
+	}
+
 	if err := h.repo.Delete(ctx, id); err != nil {
 		if err == repository.ErrReviewNotFound {
 			api.RespondWithError(w, http.StatusNotFound, "Review not found")
@@ -141,3 +154,29 @@ func (h *ReviewHandler) DeleteReview(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// authorizeChange loads the review and allows the change only for its author
+// or a holder of the review moderation scope. It writes the error response
+// itself when the change is not allowed.
+func (h *ReviewHandler) authorizeChange(w http.ResponseWriter, r *http.Request, id string) (*models.Review, bool) {
+	ctx := r.Context()
+	review, err := h.repo.FindByID(ctx, id)
+	if err != nil {
+		if err == repository.ErrReviewNotFound {
+			api.RespondWithError(w, http.StatusNotFound, "Review not found")
+			return nil, false
+		}
+		h.logger.ErrorContext(ctx, "Error getting review", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get review")
+		return nil, false
+	}
+
+	userID, _ := ctx.Value(models.UserIDContextKey).(string)
+	scopes, _ := ctx.Value(models.ScopesContextKey).([]string)
+	if (userID == "" || review.UserID != userID) && !auth.HasScope(scopes, auth.ScopeReviewsModerate) {
+		h.logger.WarnContext(ctx, "Review change by non-author", "user_id", userID, "review_id", id)
+		api.RespondWithError(w, http.StatusForbidden, "Forbidden")
+		return nil, false
+	}
+	return review, true
+}
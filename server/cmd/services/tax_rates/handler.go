@@ -0,0 +1,88 @@
+package taxrates
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"services/internal/api"
+	"services/internal/models"
+	"services/internal/repository"
+	"services/internal/tax"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+type TaxRateHandler struct {
+	logger *slog.Logger
+	repo   repository.TaxRateRepository
+}
+
+func NewTaxRateHandler(logger *slog.Logger, db *gorm.DB) *TaxRateHandler {
+	repo := repository.NewPostgresTaxRateRepository(db)
+	return &TaxRateHandler{
+		logger: logger,
+		repo:   repo,
+	}
+}
+
+// ListTaxRates returns the sales tax rates, optionally for one ?province=
+func (h *TaxRateHandler) ListTaxRates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var rates []models.TaxRate
+	var err error
+	if p := r.URL.Query().Get("province"); p != "" {
+		province, perr := tax.NormalizeProvince(p)
+		if perr != nil {
+			api.RespondWithError(w, http.StatusBadRequest, "Unknown province")
+			return
+		}
+		rates, err = h.repo.FindByProvince(ctx, province)
+	} else {
+		rates, err = h.repo.FindAll(ctx)
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "Error listing tax rates", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to list tax rates")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusOK, rates)
+}
+
+// ReplaceProvinceRates sets every tax charged in a province. The body is the
+// full list, e.g. [{"name":"GST","rate":5},{"name":"QST","rate":9.975}]; an
+// empty list stops tax being charged there. Orders already placed keep the
+// rates they were charged.
+func (h *TaxRateHandler) ReplaceProvinceRates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	province, err := tax.NormalizeProvince(mux.Vars(r)["province"])
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Unknown province")
+		return
+	}
+
+	var rates []models.TaxRate
+	if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+	for i := range rates {
+		rates[i].Name = strings.ToUpper(strings.TrimSpace(rates[i].Name))
+		if rates[i].Name == "" || rates[i].Rate <= 0 || rates[i].Rate >= 100 {
+			api.RespondWithError(w, http.StatusBadRequest, "Each rate needs a name and a percentage between 0 and 100")
+			return
+		}
+	}
+
+	if err := h.repo.ReplaceProvince(ctx, province, rates); err != nil {
+		h.logger.ErrorContext(ctx, "Error replacing tax rates", "province", province, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to update tax rates")
+		return
+	}
+
+	h.logger.InfoContext(ctx, "Replaced tax rates", "province", province, "count", len(rates))
+	api.RespondWithJSON(w, http.StatusOK, rates)
+}
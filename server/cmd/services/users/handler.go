@@ -1,32 +1,62 @@
 package User
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"services/internal/api"
 	"services/internal/auth"
+	"services/internal/loginguard"
 	"services/internal/models"
 	"services/internal/repository"
+	"services/internal/service"
+	"services/internal/tax"
+	"strconv"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// Mailer sends the emails about a user's account so it can be mocked in
+// tests
+type Mailer interface {
+	SendNewDeviceAlert(ctx context.Context, to, name, device, ip string, at time.Time) error
+	SendPasswordReset(ctx context.Context, to, name, link string, validFor time.Duration) error
+	SendEmailVerification(ctx context.Context, to, name, link string, validFor time.Duration) error
+}
+
 type UserHandler struct {
 	logger      *slog.Logger
 	repo        repository.UserRepository
 	sessionRepo repository.SessionRepository
+	cartRepo    repository.CartRepository
+	planRepo    repository.PaymentPlanRepository
+	resetRepo   repository.PasswordResetRepository
+	mailer      Mailer
+	loginGuard  *loginguard.Guard
+	attempts    repository.LoginAttemptRepository
 }
 
 func NewUserHandler(logger *slog.Logger, db *gorm.DB) *UserHandler {
 	repo := repository.NewPostgresUserRepository(db)
 	sessionRepo := repository.NewPostgresSessionRepository(db)
+	settingsRepo := repository.NewPostgresSettingsRepository(db)
+	attempts := repository.NewPostgresLoginAttemptRepository(db)
+	loginGuard := loginguard.NewGuard(logger, attempts, repository.NewPostgresAuditRepository(db))
 	return &UserHandler{
 		logger:      logger,
 		repo:        repo,
 		sessionRepo: sessionRepo,
+		cartRepo:    repository.NewPostgresCartRepository(db),
+		planRepo:    repository.NewPostgresPaymentPlanRepository(db),
+		resetRepo:   repository.NewPostgresPasswordResetRepository(db),
+		mailer:      service.NewNotificationService(logger, settingsRepo),
+		loginGuard:  loginGuard,
+		attempts:    attempts,
 	}
 }
 
@@ -104,6 +134,7 @@ func (uh *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		Name        string `json:"name"`
 		DateOfBirth string `json:"dob"`
 		Age         int    `json:"age"`
+		Province    string `json:"province"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -130,6 +161,14 @@ func (uh *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	if req.DateOfBirth != "" {
 		user.DateOfBirth = req.DateOfBirth
 	}
+	if req.Province != "" {
+		province, err := tax.NormalizeProvince(req.Province)
+		if err != nil {
+			api.RespondWithError(w, http.StatusBadRequest, "Unknown province")
+			return
+		}
+		user.Province = province
+	}
 
 	// Save updates
 	if err := uh.repo.Update(ctx, user); err != nil {
@@ -175,7 +214,11 @@ func (uh *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 			GoogleID: &googleInfo.Sub,
 			Email:    googleInfo.Email,
 			Name:     googleInfo.Name,
-			Type:     "student", // Default type
+			Type:     models.UserTypeStudent, // Default type
+		}
+		if googleInfo.EmailVerified {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
 		}
 
 		if err := uh.repo.Create(ctx, user); err != nil {
@@ -183,41 +226,29 @@ func (uh *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 			api.RespondWithError(w, http.StatusInternalServerError, "Failed to create user")
 			return
 		}
+	} else if googleInfo.EmailVerified && user.EmailVerifiedAt == nil && user.Email == googleInfo.Email {
+		// Google has verified the address since the account was made
+		now := time.Now()
+		if err := uh.repo.MarkEmailVerified(ctx, user.ID, now); err != nil {
+			uh.logger.ErrorContext(ctx, "Error marking email verified", "user_id", user.ID, "error", err)
+		} else {
+			user.EmailVerifiedAt = &now
+		}
 	}
 
-	// Generate tokens
-	accessToken, err := auth.GenerateAccessToken(user.ID, user.Email)
-	if err != nil {
-		uh.logger.ErrorContext(ctx, "Error generating access token", "error", err)
-		api.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
-		return
-	}
-
-	refreshToken, err := auth.GenerateRefreshToken()
+	// Generate tokens and create session
+	tokens, err := uh.signIn(r, user)
 	if err != nil {
-		uh.logger.ErrorContext(ctx, "Error generating refresh token", "error", err)
-		api.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
-		return
-	}
-
-	// Create session
-	session := &models.Session{
-		UserID:       user.ID,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresAt:    time.Now().Add(15 * time.Minute),
-	}
-
-	if err := uh.sessionRepo.CreateSession(ctx, session); err != nil {
 		uh.logger.ErrorContext(ctx, "Error creating session", "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create session")
 		return
 	}
+	uh.mergeGuestCart(r, user.ID)
 
 	// Return tokens
 	api.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
 		"token_type":    "Bearer",
 		"user":          user,
 	})
@@ -236,50 +267,40 @@ func (uh *UserHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Find session by refresh token
-	session, err := uh.sessionRepo.FindByRefreshToken(ctx, req.RefreshToken)
+	current, err := uh.sessionRepo.FindByRefreshToken(ctx, req.RefreshToken)
 	if err != nil {
 		api.RespondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
 		return
 	}
 
-	// Delete old session
-	if err := uh.sessionRepo.DeleteSession(ctx, req.RefreshToken); err != nil {
-		uh.logger.WarnContext(ctx, "Error deleting old session", "error", err)
-	}
-
 	// Generate new tokens
-	accessToken, err := auth.GenerateAccessToken(session.UserID, session.User.Email)
+	session, tokens, err := newSession(r, &current.User)
 	if err != nil {
-		uh.logger.ErrorContext(ctx, "Error generating access token", "error", err)
+		uh.logger.ErrorContext(ctx, "Error generating tokens", "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	refreshToken, err := auth.GenerateRefreshToken()
-	if err != nil {
-		uh.logger.ErrorContext(ctx, "Error generating refresh token", "error", err)
-		api.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
-		return
-	}
-
-	// Create new session
-	newSession := &models.Session{
-		UserID:       session.UserID,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresAt:    time.Now().Add(15 * time.Minute),
-	}
-
-	if err := uh.sessionRepo.CreateSession(ctx, newSession); err != nil {
-		uh.logger.ErrorContext(ctx, "Error creating session", "error", err)
-		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create session")
+	// Replace the old session with the new one in the same family
+	if err := uh.sessionRepo.RotateSession(ctx, current.ID, session); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRefreshTokenReused):
+			uh.logger.WarnContext(ctx, "Rotated refresh token was used again, revoked its session family as suspected theft",
+				"user_id", current.UserID, "family_id", current.FamilyID, "session_id", current.ID, "remote_addr", r.RemoteAddr)
+			api.RespondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		case errors.Is(err, repository.ErrInvalidToken), errors.Is(err, repository.ErrSessionNotFound):
+			api.RespondWithError(w, http.StatusUnauthorized, "Invalid refresh token")
+		default:
+			uh.logger.ErrorContext(ctx, "Error rotating session", "error", err)
+			api.RespondWithError(w, http.StatusInternalServerError, "Failed to create session")
+		}
 		return
 	}
 
 	// Return new tokens
 	api.RespondWithJSON(w, http.StatusOK, map[string]string{
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
 		"token_type":    "Bearer",
 	})
 }
@@ -346,7 +367,7 @@ func (uh *UserHandler) Signup(w http.ResponseWriter, r *http.Request) {
 		Name:     req.Name,
 		Email:    req.Email,
 		Password: hashedPassword,
-		Type:     "student", // Default type
+		Type:     models.UserTypeStudent, // Default type
 		GoogleID: nil,
 	}
 
@@ -356,39 +377,24 @@ func (uh *UserHandler) Signup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate tokens
-	accessToken, err := auth.GenerateAccessToken(user.ID, user.Email)
-	if err != nil {
-		uh.logger.ErrorContext(ctx, "Error generating access token", "error", err)
-		api.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
-		return
+	// The user can ask for another email if this one fails
+	if _, err := uh.sendVerificationEmail(ctx, user); err != nil {
+		uh.logger.ErrorContext(ctx, "Error sending verification email", "user_id", user.ID, "error", err)
 	}
 
-	refreshToken, err := auth.GenerateRefreshToken()
+	// Generate tokens and create session
+	tokens, err := uh.signIn(r, user)
 	if err != nil {
-		uh.logger.ErrorContext(ctx, "Error generating refresh token", "error", err)
-		api.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
-		return
-	}
-
-	// Create session
-	session := &models.Session{
-		UserID:       user.ID,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresAt:    time.Now().Add(15 * time.Minute),
-	}
-
-	if err := uh.sessionRepo.CreateSession(ctx, session); err != nil {
 		uh.logger.ErrorContext(ctx, "Error creating session", "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create session")
 		return
 	}
+	uh.mergeGuestCart(r, user.ID)
 
 	// Return tokens
 	api.RespondWithJSON(w, http.StatusCreated, map[string]interface{}{
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
 		"token_type":    "Bearer",
 		"user":          user,
 	})
@@ -413,57 +419,60 @@ func (uh *UserHandler) LoginWithEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find user by email
-	user, err := uh.repo.FindByEmail(ctx, req.Email)
+	// Slow down and lock out guessing, the same way for unknown emails. The
+	// attempt is counted as failed up front so parallel guesses cannot all
+	// get past the same wait.
+	ip := api.ClientIP(r)
+	wait, err := uh.loginGuard.Reserve(ctx, req.Email, ip, time.Now())
 	if err != nil {
-		if err == repository.ErrUserNotFound {
-			api.RespondWithError(w, http.StatusUnauthorized, "Invalid email or password")
-			return
-		}
-		uh.logger.ErrorContext(ctx, "Error finding user", "error", err)
+		uh.logger.ErrorContext(ctx, "Error checking login attempts", "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
-
-	// Verify password
-	if err := auth.ComparePassword(user.Password, req.Password); err != nil {
-		api.RespondWithError(w, http.StatusUnauthorized, "Invalid email or password")
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		api.RespondWithError(w, http.StatusTooManyRequests, "Too many login attempts, please try again later")
 		return
 	}
 
-	// Generate tokens
-	accessToken, err := auth.GenerateAccessToken(user.ID, user.Email)
-	if err != nil {
-		uh.logger.ErrorContext(ctx, "Error generating access token", "error", err)
-		api.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+	// Find user by email
+	user, err := uh.repo.FindByEmail(ctx, req.Email)
+	if err != nil && err != repository.ErrUserNotFound {
+		uh.logger.ErrorContext(ctx, "Error finding user", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Internal server error")
 		return
 	}
 
-	refreshToken, err := auth.GenerateRefreshToken()
-	if err != nil {
-		uh.logger.ErrorContext(ctx, "Error generating refresh token", "error", err)
-		api.RespondWithError(w, http.StatusInternalServerError, "Failed to generate token")
+	// Verify password. Unknown emails and accounts without a password are
+	// checked against a dummy hash so they take as long as a wrong password.
+	passwordHash := dummyPasswordHash()
+	if user != nil && user.Password != "" {
+		passwordHash = user.Password
+	}
+	if err := auth.ComparePassword(passwordHash, req.Password); err != nil || user == nil || user.Password == "" {
+		if err := uh.loginGuard.Fail(ctx, req.Email, ip, time.Now()); err != nil {
+			uh.logger.ErrorContext(ctx, "Error recording failed login", "error", err)
+		}
+		api.RespondWithError(w, http.StatusUnauthorized, "Invalid email or password")
 		return
 	}
-
-	// Create session
-	session := &models.Session{
-		UserID:       user.ID,
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresAt:    time.Now().Add(15 * time.Minute),
+	if err := uh.loginGuard.Succeed(ctx, req.Email, ip); err != nil {
+		uh.logger.ErrorContext(ctx, "Error clearing failed logins", "user_id", user.ID, "error", err)
 	}
 
-	if err := uh.sessionRepo.CreateSession(ctx, session); err != nil {
+	// Generate tokens and create session
+	tokens, err := uh.signIn(r, user)
+	if err != nil {
 		uh.logger.ErrorContext(ctx, "Error creating session", "error", err)
 		api.RespondWithError(w, http.StatusInternalServerError, "Failed to create session")
 		return
 	}
+	uh.mergeGuestCart(r, user.ID)
 
 	// Return tokens
 	api.RespondWithJSON(w, http.StatusOK, map[string]interface{}{
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
 		"token_type":    "Bearer",
 		"user":          user,
 	})
@@ -488,3 +497,28 @@ func (uh *UserHandler) GetUserCourses(w http.ResponseWriter, r *http.Request) {
 
 	api.RespondWithJSON(w, http.StatusOK, courses)
 }
+
+// mergeGuestCart moves the guest cart named by the X-Cart-Token header into
+// the user's cart. Signing in still succeeds if it fails, the guest cart is
+// just left behind.
+func (uh *UserHandler) mergeGuestCart(r *http.Request, userID string) {
+	token := r.Header.Get(auth.CartTokenHeader)
+	if token == "" {
+		return
+	}
+	ctx := r.Context()
+	cartID, err := auth.ValidateCartToken(token)
+	if err != nil {
+		uh.logger.WarnContext(ctx, "Ignoring invalid guest cart token", "user_id", userID)
+		return
+	}
+
+	moved, err := uh.cartRepo.MergeGuestCart(ctx, cartID, userID)
+	if err != nil {
+		if !errors.Is(err, repository.ErrCartNotFound) {
+			uh.logger.ErrorContext(ctx, "Error merging guest cart", "user_id", userID, "cart_id", cartID, "error", err)
+		}
+		return
+	}
+	uh.logger.InfoContext(ctx, "Merged guest cart", "user_id", userID, "cart_id", cartID, "items", moved)
+}
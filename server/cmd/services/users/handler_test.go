@@ -0,0 +1,609 @@
+package User
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"services/internal/auth"
+	"services/internal/models"
+	"services/internal/repository"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ===================== Mock Repositories =====================
+
+// mockSessionRepo applies the same rules as the Postgres implementation
+type mockSessionRepo struct {
+	mu       sync.Mutex
+	sessions []*models.Session
+}
+
+func (m *mockSessionRepo) CreateSession(ctx context.Context, session *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.create(session)
+	return nil
+}
+
+// create stores a session, starting a new family when it has none. Callers
+// hold mu.
+func (m *mockSessionRepo) create(session *models.Session) {
+	session.ID = fmt.Sprintf("session-%d", len(m.sessions)+1)
+	if session.FamilyID == "" {
+		session.FamilyID = "family-" + session.ID
+	}
+	m.sessions = append(m.sessions, session)
+}
+
+func (m *mockSessionRepo) FindByAccessToken(ctx context.Context, token string) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		if s.AccessTokenHash == auth.HashToken(token) {
+			if s.ExpiresAt.Before(time.Now()) || s.RevokedAt != nil {
+				return nil, repository.ErrInvalidToken
+			}
+			return s, nil
+		}
+	}
+	return nil, repository.ErrSessionNotFound
+}
+
+func (m *mockSessionRepo) FindByRefreshToken(ctx context.Context, token string) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		if s.RefreshTokenHash == auth.HashToken(token) {
+			copied := *s
+			return &copied, nil
+		}
+	}
+	return nil, repository.ErrSessionNotFound
+}
+
+func (m *mockSessionRepo) RotateSession(ctx context.Context, currentID string, next *models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.sessions, func(s *models.Session) bool { return s.ID == currentID })
+	if i < 0 {
+		return repository.ErrSessionNotFound
+	}
+	current := m.sessions[i]
+
+	now := time.Now()
+	if current.RevokedAt != nil {
+		return repository.ErrInvalidToken
+	}
+	if current.RotatedAt != nil {
+		for _, s := range m.sessions {
+			if s.FamilyID == current.FamilyID && s.RevokedAt == nil {
+				s.RevokedAt = &now
+			}
+		}
+		return repository.ErrRefreshTokenReused
+	}
+	if current.RefreshExpiresAt.Before(now) {
+		return repository.ErrInvalidToken
+	}
+
+	current.RotatedAt = &now
+	next.UserID = current.UserID
+	next.User = current.User
+	next.FamilyID = current.FamilyID
+	m.create(next)
+	return nil
+}
+
+func (m *mockSessionRepo) FindUserSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*models.Session
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.RotatedAt == nil && s.RevokedAt == nil && s.RefreshExpiresAt.After(time.Now()) {
+			result = append(result, s)
+		}
+	}
+	slices.SortFunc(result, func(a, b *models.Session) int { return b.LastSeenAt.Compare(a.LastSeenAt) })
+	return result, nil
+}
+
+func (m *mockSessionRepo) FindUserAgents(ctx context.Context, userID string) ([]string, error) {
+	return nil, nil
+}
+func (m *mockSessionRepo) TouchSession(ctx context.Context, id string, at time.Time) error {
+	return nil
+}
+func (m *mockSessionRepo) DeleteSession(ctx context.Context, token string) error {
+	return nil
+}
+
+func (m *mockSessionRepo) DeleteFamily(ctx context.Context, userID, familyID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := slices.DeleteFunc(slices.Clone(m.sessions), func(s *models.Session) bool {
+		return s.UserID == userID && s.FamilyID == familyID
+	})
+	if len(kept) == len(m.sessions) {
+		return repository.ErrSessionNotFound
+	}
+	m.sessions = kept
+	return nil
+}
+
+func (m *mockSessionRepo) DeleteExpiredSessions(ctx context.Context) error {
+	return nil
+}
+
+func (m *mockSessionRepo) DeleteUserSessions(ctx context.Context, userID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions = slices.DeleteFunc(m.sessions, func(s *models.Session) bool { return s.UserID == userID })
+	return nil
+}
+
+// userSessions returns every stored session of the user, rotated and revoked
+// ones included
+func (m *mockSessionRepo) userSessions(userID string) []*models.Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*models.Session
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+type mockUserRepo struct {
+	users []*models.User
+}
+
+func (m *mockUserRepo) Create(ctx context.Context, user *models.User) error { return nil }
+func (m *mockUserRepo) FindByID(ctx context.Context, id string) (*models.User, error) {
+	for _, u := range m.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+func (m *mockUserRepo) FindByGoogleID(ctx context.Context, googleID string) (*models.User, error) {
+	return nil, repository.ErrUserNotFound
+}
+func (m *mockUserRepo) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+func (m *mockUserRepo) FindAll(ctx context.Context) ([]*models.User, error) { return m.users, nil }
+func (m *mockUserRepo) Update(ctx context.Context, user *models.User) error { return nil }
+func (m *mockUserRepo) Delete(ctx context.Context, id string) error         { return nil }
+func (m *mockUserRepo) GetPurchasedCourses(ctx context.Context, userID string) ([]*models.Course, error) {
+	return nil, nil
+}
+func (m *mockUserRepo) GetEnrolledCourseIDs(ctx context.Context, userID string) ([]string, error) {
+	return nil, nil
+}
+func (m *mockUserRepo) AssignCourse(ctx context.Context, userID string, courseID string) error {
+	return nil
+}
+func (m *mockUserRepo) RevokeCourse(ctx context.Context, userID string, courseID string) error {
+	return nil
+}
+func (m *mockUserRepo) SuspendCourse(ctx context.Context, userID string, courseID string) error {
+	return nil
+}
+func (m *mockUserRepo) RestoreCourse(ctx context.Context, userID string, courseID string) error {
+	return nil
+}
+func (m *mockUserRepo) MarkEmailVerified(ctx context.Context, userID string, at time.Time) error {
+	return nil
+}
+func (m *mockUserRepo) ClaimVerificationEmail(ctx context.Context, userID string, now time.Time, interval time.Duration) (bool, error) {
+	return true, nil
+}
+func (m *mockUserRepo) ClaimPasswordResetEmail(ctx context.Context, userID string, now time.Time, interval time.Duration) (bool, error) {
+	return true, nil
+}
+
+type mockPlanRepo struct {
+	sessionLimit int
+}
+
+func (m *mockPlanRepo) Create(ctx context.Context, plan *models.PaymentPlan) error { return nil }
+func (m *mockPlanRepo) FindByID(ctx context.Context, id string) (*models.PaymentPlan, error) {
+	return nil, nil
+}
+func (m *mockPlanRepo) FindAll(ctx context.Context) ([]*models.PaymentPlan, error) { return nil, nil }
+func (m *mockPlanRepo) Update(ctx context.Context, plan *models.PaymentPlan) error { return nil }
+func (m *mockPlanRepo) Delete(ctx context.Context, id string) error                { return nil }
+func (m *mockPlanRepo) FindSessionLimit(ctx context.Context, userID string) (int, error) {
+	return m.sessionLimit, nil
+}
+
+// mockResetRepo applies the same rules as the Postgres implementation,
+// signing the user out of the sessions in sessions
+type mockResetRepo struct {
+	mu       sync.Mutex
+	resets   []*models.PasswordReset
+	sessions *mockSessionRepo
+	password map[string]string // userID -> password hash
+}
+
+func (m *mockResetRepo) Create(ctx context.Context, reset *models.PasswordReset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resets = append(m.resets, reset)
+	return nil
+}
+
+func (m *mockResetRepo) ResetPassword(ctx context.Context, token, passwordHash string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.resets, func(r *models.PasswordReset) bool { return r.TokenHash == auth.HashToken(token) })
+	if i < 0 {
+		return "", repository.ErrPasswordResetInvalid
+	}
+	reset := m.resets[i]
+	now := time.Now()
+	if reset.UsedAt != nil || reset.ExpiresAt.Before(now) {
+		return "", repository.ErrPasswordResetInvalid
+	}
+
+	if m.password == nil {
+		m.password = make(map[string]string)
+	}
+	m.password[reset.UserID] = passwordHash
+	for _, r := range m.resets {
+		if r.UserID == reset.UserID && r.UsedAt == nil {
+			r.UsedAt = &now
+		}
+	}
+	if err := m.sessions.DeleteUserSessions(ctx, reset.UserID); err != nil {
+		return "", err
+	}
+	return reset.UserID, nil
+}
+
+type sentReset struct {
+	to, link string
+}
+
+type mockMailer struct {
+	resets chan sentReset
+}
+
+func (m *mockMailer) SendNewDeviceAlert(ctx context.Context, to, name, device, ip string, at time.Time) error {
+	return nil
+}
+func (m *mockMailer) SendPasswordReset(ctx context.Context, to, name, link string, validFor time.Duration) error {
+	m.resets <- sentReset{to: to, link: link}
+	return nil
+}
+func (m *mockMailer) SendEmailVerification(ctx context.Context, to, name, link string, validFor time.Duration) error {
+	return nil
+}
+
+// ===================== Helpers =====================
+
+var student = models.User{ID: "user-1", Name: "Student", Email: "student@example.com", Type: "student"}
+
+func newTestHandler(sessionRepo *mockSessionRepo) *UserHandler {
+	return &UserHandler{
+		logger:      slog.New(slog.NewTextHandler(os.Stdout, nil)),
+		repo:        &mockUserRepo{users: []*models.User{&student}},
+		sessionRepo: sessionRepo,
+		planRepo:    &mockPlanRepo{},
+		resetRepo:   &mockResetRepo{sessions: sessionRepo},
+		mailer:      &mockMailer{resets: make(chan sentReset, 16)},
+		attempts:    repository.NewMemoryLoginAttemptRepository(),
+	}
+}
+
+func contextWithUserID(userID string) context.Context {
+	return context.WithValue(context.Background(), models.UserIDContextKey, userID)
+}
+
+// signedIn stores a session of user with the given refresh token, seen at
+// lastSeen
+func signedIn(sessionRepo *mockSessionRepo, user models.User, refreshToken string, lastSeen time.Time) *models.Session {
+	session := &models.Session{
+		UserID:           user.ID,
+		User:             user,
+		AccessTokenHash:  auth.HashToken("access-" + refreshToken),
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		ExpiresAt:        time.Now().Add(15 * time.Minute),
+		RefreshExpiresAt: time.Now().Add(24 * time.Hour),
+		LastSeenAt:       lastSeen,
+	}
+	_ = sessionRepo.CreateSession(context.Background(), session)
+	return session
+}
+
+func refresh(h *UserHandler, refreshToken string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	req, _ := http.NewRequest("POST", "/api/auth/refresh", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.RefreshToken(rr, req)
+	return rr
+}
+
+// ===================== Refresh Token Tests =====================
+
+func TestRefreshToken_Rotates(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	sessionRepo := &mockSessionRepo{}
+	first := signedIn(sessionRepo, student, "refresh-1", time.Now())
+	h := newTestHandler(sessionRepo)
+
+	rr := refresh(h, "refresh-1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var tokens map[string]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if tokens["access_token"] == "" || tokens["refresh_token"] == "" || tokens["refresh_token"] == "refresh-1" {
+		t.Fatalf("expected a new token pair, got %v", tokens)
+	}
+
+	sessions := sessionRepo.userSessions("user-1")
+	if len(sessions) != 2 {
+		t.Fatalf("expected the old and the new session, got %d", len(sessions))
+	}
+	if first.RotatedAt == nil {
+		t.Error("expected the old session to be rotated")
+	}
+	next := sessions[1]
+	if next.FamilyID != first.FamilyID || next.RefreshTokenHash != auth.HashToken(tokens["refresh_token"]) {
+		t.Errorf("expected the new session to hold the new refresh token in the same family, got %+v", next)
+	}
+
+	if rr := refresh(h, tokens["refresh_token"]); rr.Code != http.StatusOK {
+		t.Errorf("expected the new refresh token to rotate again, got %d", rr.Code)
+	}
+}
+
+func TestRefreshToken_ReuseRevokesFamily(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	sessionRepo := &mockSessionRepo{}
+	stolen := signedIn(sessionRepo, student, "refresh-1", time.Now())
+	other := signedIn(sessionRepo, student, "refresh-other", time.Now())
+	h := newTestHandler(sessionRepo)
+
+	rr := refresh(h, "refresh-1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the first refresh to succeed, got %d", rr.Code)
+	}
+	var tokens map[string]string
+	_ = json.Unmarshal(rr.Body.Bytes(), &tokens)
+
+	if rr := refresh(h, "refresh-1"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a reused refresh token, got %d", rr.Code)
+	}
+	for _, s := range sessionRepo.userSessions("user-1") {
+		if s.FamilyID == stolen.FamilyID && s.RevokedAt == nil {
+			t.Errorf("expected every session of the family to be revoked, %s is not", s.ID)
+		}
+	}
+	if other.RevokedAt != nil {
+		t.Error("expected the user's other device to stay signed in")
+	}
+	if rr := refresh(h, tokens["refresh_token"]); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the rotated-to token to be revoked too, got %d", rr.Code)
+	}
+}
+
+func TestRefreshToken_Expired(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	sessionRepo := &mockSessionRepo{}
+	session := signedIn(sessionRepo, student, "refresh-1", time.Now().Add(-48*time.Hour))
+	session.RefreshExpiresAt = time.Now().Add(-time.Hour)
+	h := newTestHandler(sessionRepo)
+
+	if rr := refresh(h, "refresh-1"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an expired refresh token, got %d", rr.Code)
+	}
+	if sessions := sessionRepo.userSessions("user-1"); len(sessions) != 1 || session.RotatedAt != nil {
+		t.Errorf("expected no rotation of an expired session, got %d sessions", len(sessions))
+	}
+}
+
+func TestRefreshToken_Unknown(t *testing.T) {
+	h := newTestHandler(&mockSessionRepo{})
+	if rr := refresh(h, "never-issued"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an unknown refresh token, got %d", rr.Code)
+	}
+}
+
+// ===================== Session Tests =====================
+
+func TestEnforceSessionLimit_SignsOutLeastRecentlySeen(t *testing.T) {
+	sessionRepo := &mockSessionRepo{}
+	now := time.Now()
+	oldest := signedIn(sessionRepo, student, "refresh-1", now.Add(-3*time.Hour))
+	middle := signedIn(sessionRepo, student, "refresh-2", now.Add(-2*time.Hour))
+	newest := signedIn(sessionRepo, student, "refresh-3", now.Add(-time.Hour))
+	h := newTestHandler(sessionRepo)
+	h.planRepo = &mockPlanRepo{sessionLimit: 2}
+
+	h.enforceSessionLimit(context.Background(), &student)
+
+	var families []string
+	for _, s := range sessionRepo.userSessions("user-1") {
+		families = append(families, s.FamilyID)
+	}
+	if len(families) != 2 || slices.Contains(families, oldest.FamilyID) ||
+		!slices.Contains(families, middle.FamilyID) || !slices.Contains(families, newest.FamilyID) {
+		t.Errorf("expected only the least recently seen device signed out, left %v", families)
+	}
+}
+
+func TestEnforceSessionLimit_NoLimit(t *testing.T) {
+	sessionRepo := &mockSessionRepo{}
+	for i := range 5 {
+		signedIn(sessionRepo, student, fmt.Sprintf("refresh-%d", i), time.Now())
+	}
+	h := newTestHandler(sessionRepo)
+
+	h.enforceSessionLimit(context.Background(), &student)
+
+	if got := len(sessionRepo.userSessions("user-1")); got != 5 {
+		t.Errorf("expected every device to stay signed in without a limit, got %d", got)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	sessionRepo := &mockSessionRepo{}
+	mine := signedIn(sessionRepo, student, "refresh-1", time.Now())
+	theirs := signedIn(sessionRepo, models.User{ID: "user-2"}, "refresh-2", time.Now())
+	h := newTestHandler(sessionRepo)
+
+	revoke := func(familyID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequestWithContext(contextWithUserID("user-1"), "DELETE", "/api/auth/sessions/"+familyID, nil)
+		req = mux.SetURLVars(req, map[string]string{"id": familyID})
+		rr := httptest.NewRecorder()
+		h.RevokeSession(rr, req)
+		return rr
+	}
+
+	if rr := revoke(theirs.FamilyID); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for another user's session, got %d", rr.Code)
+	}
+	if len(sessionRepo.userSessions("user-2")) != 1 {
+		t.Error("expected another user's session to be kept")
+	}
+
+	if rr := revoke(mine.FamilyID); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if len(sessionRepo.userSessions("user-1")) != 0 {
+		t.Error("expected the session to be removed")
+	}
+	if rr := refresh(h, "refresh-1"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the revoked session's refresh token to stop working, got %d", rr.Code)
+	}
+}
+
+// ===================== Password Reset Tests =====================
+
+func forgotPassword(h *UserHandler, email, ip string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"email": email})
+	req, _ := http.NewRequest("POST", "/api/auth/forgot-password", bytes.NewBuffer(body))
+	req.RemoteAddr = ip + ":1234"
+	rr := httptest.NewRecorder()
+	h.ForgotPassword(rr, req)
+	return rr
+}
+
+func TestForgotPassword_SameResponseForUnknownEmail(t *testing.T) {
+	h := newTestHandler(&mockSessionRepo{})
+	mailer := h.mailer.(*mockMailer)
+
+	unknown := forgotPassword(h, "nobody@example.com", "203.0.113.1")
+	known := forgotPassword(h, "student@example.com", "203.0.113.1")
+
+	if unknown.Code != http.StatusAccepted || known.Code != unknown.Code {
+		t.Errorf("expected 202 for both, got %d for a known email and %d for an unknown one", known.Code, unknown.Code)
+	}
+	if known.Body.String() != unknown.Body.String() {
+		t.Errorf("expected identical bodies, got %q and %q", known.Body.String(), unknown.Body.String())
+	}
+
+	select {
+	case sent := <-mailer.resets:
+		if sent.to != "student@example.com" {
+			t.Errorf("expected the reset link to go to the account's email, got %s", sent.to)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a reset email for the known account")
+	}
+}
+
+func TestForgotPassword_LimitsRequestsPerIP(t *testing.T) {
+	h := newTestHandler(&mockSessionRepo{})
+
+	for i := range passwordResetsPerIP {
+		if rr := forgotPassword(h, fmt.Sprintf("nobody-%d@example.com", i), "203.0.113.1"); rr.Code != http.StatusAccepted {
+			t.Fatalf("request %d: expected 202, got %d", i+1, rr.Code)
+		}
+	}
+	if rr := forgotPassword(h, "nobody@example.com", "203.0.113.1"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 over the per-IP limit, got %d", rr.Code)
+	}
+	if rr := forgotPassword(h, "nobody@example.com", "198.51.100.7"); rr.Code != http.StatusAccepted {
+		t.Errorf("expected another IP to be unaffected, got %d", rr.Code)
+	}
+}
+
+func resetPassword(h *UserHandler, token, password string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"token": token, "password": password})
+	req, _ := http.NewRequest("POST", "/api/auth/reset-password", bytes.NewBuffer(body))
+	rr := httptest.NewRecorder()
+	h.ResetPassword(rr, req)
+	return rr
+}
+
+func TestResetPassword_SignsOutEverywhere(t *testing.T) {
+	sessionRepo := &mockSessionRepo{}
+	signedIn(sessionRepo, student, "refresh-1", time.Now())
+	signedIn(sessionRepo, student, "refresh-2", time.Now())
+	signedIn(sessionRepo, models.User{ID: "user-2"}, "refresh-3", time.Now())
+	h := newTestHandler(sessionRepo)
+	resetRepo := h.resetRepo.(*mockResetRepo)
+	resetRepo.resets = []*models.PasswordReset{
+		{UserID: "user-1", TokenHash: auth.HashToken("reset-token"), ExpiresAt: time.Now().Add(time.Hour)},
+	}
+
+	rr := resetPassword(h, "reset-token", "new-password")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if err := auth.ComparePassword(resetRepo.password["user-1"], "new-password"); err != nil {
+		t.Errorf("expected the new password to be stored hashed: %v", err)
+	}
+	if got := len(sessionRepo.userSessions("user-1")); got != 0 {
+		t.Errorf("expected every session of the user to be removed, %d left", got)
+	}
+	if got := len(sessionRepo.userSessions("user-2")); got != 1 {
+		t.Errorf("expected other users to stay signed in, got %d sessions", got)
+	}
+	if rr := refresh(h, "refresh-1"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected old refresh tokens to stop working, got %d", rr.Code)
+	}
+
+	if rr := resetPassword(h, "reset-token", "another-password"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a used reset link to be refused, got %d", rr.Code)
+	}
+}
+
+func TestResetPassword_ExpiredToken(t *testing.T) {
+	sessionRepo := &mockSessionRepo{}
+	signedIn(sessionRepo, student, "refresh-1", time.Now())
+	h := newTestHandler(sessionRepo)
+	h.resetRepo.(*mockResetRepo).resets = []*models.PasswordReset{
+		{UserID: "user-1", TokenHash: auth.HashToken("reset-token"), ExpiresAt: time.Now().Add(-time.Minute)},
+	}
+
+	if rr := resetPassword(h, "reset-token", "new-password"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an expired reset link, got %d", rr.Code)
+	}
+	if got := len(sessionRepo.userSessions("user-1")); got != 1 {
+		t.Errorf("expected sessions to be kept when the reset fails, got %d", got)
+	}
+}
@@ -0,0 +1,55 @@
+package User
+
+import (
+	"errors"
+	"net/http"
+	"services/internal/api"
+	"services/internal/auth"
+	"services/internal/models"
+	"services/internal/repository"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// dummyPasswordHash is compared against when there is no real hash, so that
+// failing for an unknown email costs as much as a wrong password
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := auth.HashPassword("not-a-real-password")
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// UnlockAccount lifts the login lockout of a user and clears their failed
+// logins. Lockouts of the IPs guesses came from are left to expire.
+func (uh *UserHandler) UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	adminID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID := mux.Vars(r)["id"]
+	user, err := uh.repo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "User not found")
+			return
+		}
+		uh.logger.ErrorContext(ctx, "Error getting user", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	if err := uh.loginGuard.Unlock(ctx, user.Email, adminID, api.ClientIP(r)); err != nil {
+		uh.logger.ErrorContext(ctx, "Error unlocking account", "user_id", user.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to unlock account")
+		return
+	}
+
+	uh.logger.InfoContext(ctx, "Unlocked account", "user_id", user.ID, "admin_id", adminID)
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Account unlocked"})
+}
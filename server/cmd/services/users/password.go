@@ -0,0 +1,163 @@
+package User
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"services/internal/api"
+	"services/internal/auth"
+	"services/internal/models"
+	"services/internal/paypal"
+	"services/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Password reset requests are throttled per account and per client IP. An
+// account gets at most one email per passwordResetInterval. An IP can ask
+// for passwordResetsPerIP links per passwordResetIPWindow, enough for a
+// shared office but not for mailing every account.
+const (
+	passwordResetInterval = 5 * time.Minute
+	passwordResetsPerIP   = 10
+	passwordResetIPWindow = time.Hour
+)
+
+// passwordResetIPKey is the login_attempts key counting an IP's reset
+// requests
+func passwordResetIPKey(ip string) string {
+	return "password-reset-ip:" + ip
+}
+
+// ForgotPassword emails a password reset link to the account with the given
+// email. The response is the same whether or not there is one, and the
+// lookup happens after responding so its timing gives nothing away either.
+// An IP over its request limit gets 429, which says nothing about accounts.
+func (uh *UserHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "Email is required")
+		return
+	}
+
+	requests, err := uh.attempts.RecordFailure(ctx, passwordResetIPKey(api.ClientIP(r)), time.Now(), passwordResetIPWindow)
+	if err != nil {
+		uh.logger.ErrorContext(ctx, "Error counting password reset requests", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+	if requests.Failures > passwordResetsPerIP {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(passwordResetIPWindow.Seconds()))))
+		api.RespondWithError(w, http.StatusTooManyRequests, "Too many password reset requests, please try again later")
+		return
+	}
+
+	go uh.sendPasswordReset(context.WithoutCancel(ctx), email)
+
+	api.RespondWithJSON(w, http.StatusAccepted, map[string]string{
+		"message": "If an account exists for that email, a password reset link has been sent",
+	})
+}
+
+// sendPasswordReset issues a reset token for the user with the given email,
+// if there is one, and emails them the link. Nothing is sent if a link went
+// out less than passwordResetInterval ago.
+func (uh *UserHandler) sendPasswordReset(ctx context.Context, email string) {
+	user, err := uh.repo.FindByEmail(ctx, email)
+	if err != nil {
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			uh.logger.ErrorContext(ctx, "Error finding user for password reset", "error", err)
+		}
+		return
+	}
+
+	claimed, err := uh.repo.ClaimPasswordResetEmail(ctx, user.ID, time.Now(), passwordResetInterval)
+	if err != nil {
+		uh.logger.ErrorContext(ctx, "Error claiming password reset email", "user_id", user.ID, "error", err)
+		return
+	}
+	if !claimed {
+		uh.logger.InfoContext(ctx, "Password reset requested again too soon, not sent", "user_id", user.ID)
+		return
+	}
+
+	token, err := auth.GeneratePasswordResetToken()
+	if err != nil {
+		uh.logger.ErrorContext(ctx, "Error generating password reset token", "user_id", user.ID, "error", err)
+		return
+	}
+	validFor := auth.PasswordResetTTL()
+	reset := &models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(validFor),
+	}
+	if err := uh.resetRepo.Create(ctx, reset); err != nil {
+		uh.logger.ErrorContext(ctx, "Error storing password reset", "user_id", user.ID, "error", err)
+		return
+	}
+
+	if err := uh.mailer.SendPasswordReset(ctx, user.Email, user.Name, passwordResetLink(token), validFor); err != nil {
+		uh.logger.ErrorContext(ctx, "Failed to send password reset email", "user_id", user.ID, "error", err)
+		return
+	}
+	uh.logger.InfoContext(ctx, "Sent password reset link", "user_id", user.ID, "reset_id", reset.ID)
+}
+
+// ResetPassword sets a new password with the token from a reset link and
+// signs the user out everywhere
+func (uh *UserHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "Token and password are required")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		uh.logger.ErrorContext(ctx, "Error hashing password", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	userID, err := uh.resetRepo.ResetPassword(ctx, req.Token, hashedPassword)
+	if err != nil {
+		if errors.Is(err, repository.ErrPasswordResetInvalid) {
+			api.RespondWithError(w, http.StatusBadRequest, "Reset link is invalid or has expired")
+			return
+		}
+		uh.logger.ErrorContext(ctx, "Error resetting password", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	uh.logger.InfoContext(ctx, "Password reset, signed out all sessions", "user_id", userID)
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Password has been reset"})
+}
+
+// passwordResetLink is the frontend page that takes a reset token
+func passwordResetLink(token string) string {
+	return paypal.FrontendURL() + "/reset-password?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,253 @@
+package User
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"services/internal/api"
+	"services/internal/auth"
+	"services/internal/models"
+	"services/internal/repository"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxUserAgent caps the user agent stored with a session
+const maxUserAgent = 512
+
+// tokenPair is what a client is given for a session. The session itself only
+// keeps their hashes.
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// DeviceSession is a device the user is signed in on. Its ID is the session
+// family, which stays the same across refreshes.
+type DeviceSession struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// newSession issues a fresh token pair for user and the session that holds
+// them, recording the device the request came from. The session is not
+// stored and has no family yet.
+func newSession(r *http.Request, user *models.User) (*models.Session, *tokenPair, error) {
+	accessToken, err := auth.GenerateAccessToken(user.ID, user.Email, user.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	refreshToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:           user.ID,
+		AccessTokenHash:  auth.HashToken(accessToken),
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		ExpiresAt:        now.Add(auth.AccessTokenTTL()),
+		RefreshExpiresAt: now.Add(auth.RefreshTokenTTL()),
+		UserAgent:        userAgent,
+		IPAddress:        api.ClientIP(r),
+		LastSeenAt:       now,
+	}
+	return session, &tokenPair{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// signIn starts a new session family for user on the device the request came
+// from. The user is alerted when the device is new to their account, and
+// their least recently seen devices over their plan's limit are signed out.
+func (uh *UserHandler) signIn(r *http.Request, user *models.User) (*tokenPair, error) {
+	ctx := r.Context()
+	session, tokens, err := newSession(r, user)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failed lookup only costs the alert
+	knownAgents, err := uh.sessionRepo.FindUserAgents(ctx, user.ID)
+	if err != nil {
+		uh.logger.WarnContext(ctx, "Error finding known devices", "user_id", user.ID, "error", err)
+	}
+
+	if err := uh.sessionRepo.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+
+	// The first sign-in of an account is not worth an alert
+	if len(knownAgents) > 0 && !slices.Contains(knownAgents, session.UserAgent) {
+		go uh.alertNewDevice(context.WithoutCancel(ctx), user, session)
+	}
+	uh.enforceSessionLimit(ctx, user)
+	return tokens, nil
+}
+
+// alertNewDevice emails the user about a sign-in from a new device
+func (uh *UserHandler) alertNewDevice(ctx context.Context, user *models.User, session *models.Session) {
+	device := deviceName(session.UserAgent)
+	if err := uh.mailer.SendNewDeviceAlert(ctx, user.Email, user.Name, device, session.IPAddress, session.CreatedAt); err != nil {
+		uh.logger.WarnContext(ctx, "Failed to send new device alert", "user_id", user.ID, "error", err)
+	}
+}
+
+// enforceSessionLimit signs the user out of their least recently seen
+// devices until they are within the session limit of the payment plans they
+// bought. The strictest plan wins, so buying another course does not lift it.
+func (uh *UserHandler) enforceSessionLimit(ctx context.Context, user *models.User) {
+	limit, err := uh.planRepo.FindSessionLimit(ctx, user.ID)
+	if err != nil {
+		uh.logger.WarnContext(ctx, "Error reading session limit", "user_id", user.ID, "error", err)
+		return
+	}
+	if limit <= 0 {
+		return
+	}
+
+	sessions, err := uh.sessionRepo.FindUserSessions(ctx, user.ID)
+	if err != nil {
+		uh.logger.ErrorContext(ctx, "Error finding sessions to limit", "user_id", user.ID, "error", err)
+		return
+	}
+	if len(sessions) <= limit {
+		return
+	}
+	for _, session := range sessions[limit:] {
+		if err := uh.sessionRepo.DeleteFamily(ctx, user.ID, session.FamilyID); err != nil && !errors.Is(err, repository.ErrSessionNotFound) {
+			uh.logger.ErrorContext(ctx, "Error signing out device over the session limit", "user_id", user.ID, "family_id", session.FamilyID, "error", err)
+		}
+	}
+	uh.logger.InfoContext(ctx, "Signed out devices over the session limit", "user_id", user.ID, "limit", limit, "signed_out", len(sessions)-limit)
+}
+
+// ListSessions returns the devices the caller is signed in on, most recently
+// seen first
+func (uh *UserHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	currentFamily, _ := ctx.Value(models.SessionFamilyContextKey).(string)
+
+	sessions, err := uh.sessionRepo.FindUserSessions(ctx, userID)
+	if err != nil {
+		uh.logger.ErrorContext(ctx, "Error listing sessions", "user_id", userID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to list sessions")
+		return
+	}
+
+	devices := make([]DeviceSession, 0, len(sessions))
+	for _, session := range sessions {
+		devices = append(devices, DeviceSession{
+			ID:         session.FamilyID,
+			Device:     deviceName(session.UserAgent),
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.FamilyID == currentFamily,
+		})
+	}
+	api.RespondWithJSON(w, http.StatusOK, devices)
+}
+
+// RevokeSession signs the caller out of one of their devices
+func (uh *UserHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	familyID := mux.Vars(r)["id"]
+	if err := uh.sessionRepo.DeleteFamily(ctx, userID, familyID); err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			api.RespondWithError(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		uh.logger.ErrorContext(ctx, "Error revoking session", "user_id", userID, "family_id", familyID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	uh.logger.InfoContext(ctx, "Revoked session", "user_id", userID, "family_id", familyID)
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Session revoked"})
+}
+
+// LogoutEverywhere signs the caller out of every device, including the one
+// making the request
+func (uh *UserHandler) LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := uh.sessionRepo.DeleteUserSessions(ctx, userID); err != nil {
+		uh.logger.ErrorContext(ctx, "Error deleting user sessions", "user_id", userID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to logout")
+		return
+	}
+
+	uh.logger.InfoContext(ctx, "Logged out everywhere", "user_id", userID)
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Logged out everywhere"})
+}
+
+// deviceName describes a user agent as "Browser on OS" for people to
+// recognize their devices by
+func deviceName(userAgent string) string {
+	var browser, os string
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"), strings.Contains(userAgent, "FxiOS/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"), strings.Contains(userAgent, "CriOS/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+	switch {
+	case strings.Contains(userAgent, "iPhone"):
+		os = "iPhone"
+	case strings.Contains(userAgent, "iPad"):
+		os = "iPad"
+	case strings.Contains(userAgent, "Android"):
+		os = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		os = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		os = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		os = "Linux"
+	}
+
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	default:
+		return "Unknown device"
+	}
+}
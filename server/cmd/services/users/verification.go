@@ -0,0 +1,138 @@
+package User
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/url"
+	"services/internal/api"
+	"services/internal/auth"
+	"services/internal/models"
+	"services/internal/paypal"
+	"services/internal/repository"
+	"strconv"
+	"time"
+)
+
+// verificationResendInterval is how long a user has to wait between
+// verification emails
+const verificationResendInterval = 5 * time.Minute
+
+// sendVerificationEmail emails an unverified user a link that verifies their
+// address, unless one went out less than verificationResendInterval ago. It
+// reports whether an email is being sent.
+func (uh *UserHandler) sendVerificationEmail(ctx context.Context, user *models.User) (bool, error) {
+	now := time.Now()
+	claimed, err := uh.repo.ClaimVerificationEmail(ctx, user.ID, now, verificationResendInterval)
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	validFor := auth.EmailVerificationTTL()
+	token, err := auth.GenerateEmailVerificationToken(user.ID, user.Email, now.Add(validFor))
+	if err != nil {
+		return false, err
+	}
+
+	go func(ctx context.Context) {
+		if err := uh.mailer.SendEmailVerification(ctx, user.Email, user.Name, emailVerificationLink(token), validFor); err != nil {
+			uh.logger.ErrorContext(ctx, "Failed to send verification email", "user_id", user.ID, "error", err)
+		}
+	}(context.WithoutCancel(ctx))
+	return true, nil
+}
+
+// VerifyEmail marks the user of a verification link's token as owning their
+// email address
+func (uh *UserHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	userID, email, err := auth.ValidateEmailVerificationToken(req.Token)
+	if err != nil {
+		if errors.Is(err, auth.ErrTokenExpired) {
+			api.RespondWithError(w, http.StatusBadRequest, "Verification link has expired")
+			return
+		}
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid verification link")
+		return
+	}
+
+	user, err := uh.repo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			api.RespondWithError(w, http.StatusBadRequest, "Invalid verification link")
+			return
+		}
+		uh.logger.ErrorContext(ctx, "Error finding user to verify", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+	// The link only vouches for the address it was sent to
+	if user.Email != email {
+		api.RespondWithError(w, http.StatusBadRequest, "Invalid verification link")
+		return
+	}
+
+	if err := uh.repo.MarkEmailVerified(ctx, user.ID, time.Now()); err != nil {
+		uh.logger.ErrorContext(ctx, "Error marking email verified", "user_id", user.ID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	uh.logger.InfoContext(ctx, "Verified email", "user_id", user.ID)
+	api.RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Email verified"})
+}
+
+// ResendVerification emails the caller a new verification link, at most once
+// every verificationResendInterval
+func (uh *UserHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := ctx.Value(models.UserIDContextKey).(string)
+	if !ok {
+		api.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	user, err := uh.repo.FindByID(ctx, userID)
+	if err != nil {
+		uh.logger.ErrorContext(ctx, "Error getting user", "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		api.RespondWithError(w, http.StatusConflict, "Email is already verified")
+		return
+	}
+
+	sent, err := uh.sendVerificationEmail(ctx, user)
+	if err != nil {
+		uh.logger.ErrorContext(ctx, "Error sending verification email", "user_id", userID, "error", err)
+		api.RespondWithError(w, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+	if !sent {
+		if user.VerificationSentAt != nil {
+			wait := time.Until(user.VerificationSentAt.Add(verificationResendInterval))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(wait, time.Second).Seconds()))))
+		}
+		api.RespondWithError(w, http.StatusTooManyRequests, "A verification email was sent recently, please wait before asking for another")
+		return
+	}
+
+	api.RespondWithJSON(w, http.StatusAccepted, map[string]string{"message": "Verification email sent"})
+}
+
+// emailVerificationLink is the frontend page that takes a verification token
+func emailVerificationLink(token string) string {
+	return paypal.FrontendURL() + "/verify-email?token=" + url.QueryEscape(token)
+}
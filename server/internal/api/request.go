@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ClientIP returns the address a request came from. X-Forwarded-For is only
+// read when the peer is a proxy listed in TRUSTED_PROXIES (comma separated
+// IPs or CIDRs such as 10.0.0.0/8). The client is then the rightmost entry
+// that is not a trusted proxy, since a client can put anything to the left of
+// it. Without trusted proxies it is always the peer address.
+func ClientIP(r *http.Request) string {
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client = host
+	}
+
+	proxies := trustedProxies()
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0 && isTrusted(proxies, client); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+	}
+	return client
+}
+
+// trustedProxies parses TRUSTED_PROXIES, skipping entries that are not an IP
+// or a CIDR
+func trustedProxies() []netip.Prefix {
+	var proxies []netip.Prefix
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			proxies = append(proxies, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			proxies = append(proxies, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		}
+	}
+	return proxies
+}
+
+func isTrusted(proxies []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range proxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   string
+		peer      string
+		forwarded []string
+		want      string
+	}{
+		{name: "no proxy", peer: "203.0.113.7:5123", want: "203.0.113.7"},
+		{name: "forwarded header from an untrusted peer", peer: "203.0.113.7:5123", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "trusted proxy", trusted: "10.0.0.0/8", peer: "10.1.2.3:443", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forged entry left of the real client", trusted: "10.0.0.0/8", peer: "10.1.2.3:443", forwarded: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", trusted: "10.0.0.0/8, 192.0.2.10", peer: "10.1.2.3:443", forwarded: []string{"198.51.100.1, 192.0.2.10", "10.9.9.9"}, want: "198.51.100.1"},
+		{name: "trusted proxy without header", trusted: "10.0.0.0/8", peer: "10.1.2.3:443", want: "10.1.2.3"},
+		{name: "garbage entry", trusted: "10.0.0.0/8", peer: "10.1.2.3:443", forwarded: []string{"198.51.100.1, not-an-ip"}, want: "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXIES", tt.trusted)
+			req, _ := http.NewRequest("GET", "/api/login", nil)
+			req.RemoteAddr = tt.peer
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
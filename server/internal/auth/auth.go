@@ -3,7 +3,9 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"os"
@@ -20,6 +22,18 @@ var (
 	ErrInvalidToken       = errors.New("invalid token")
 )
 
+const (
+	// DefaultAccessTokenTTL is how long an access token is valid, overridden by
+	// ACCESS_TOKEN_TTL
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultRefreshTokenTTL is how long a refresh token can be exchanged,
+	// overridden by REFRESH_TOKEN_TTL
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+	// DefaultPasswordResetTTL is how long a password reset link works,
+	// overridden by PASSWORD_RESET_TTL
+	DefaultPasswordResetTTL = time.Hour
+)
+
 type GoogleTokenInfo struct {
 	Sub           string `json:"sub"` // Google User ID
 	Email         string `json:"email"`
@@ -29,8 +43,10 @@ type GoogleTokenInfo struct {
 }
 
 type JWTClaims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID string   `json:"user_id"`
+	Email  string   `json:"email"`
+	Role   string   `json:"role"`
+	Scopes []string `json:"scopes"`
 	jwt.RegisteredClaims
 }
 
@@ -60,18 +76,22 @@ func VerifyGoogleToken(ctx context.Context, token string) (*GoogleTokenInfo, err
 	}, nil
 }
 
-// GenerateAccessToken creates a short-lived JWT access token
-func GenerateAccessToken(userID, email string) (string, error) {
+// GenerateAccessToken creates a short-lived JWT access token carrying the
+// user's role and the scopes it grants
+func GenerateAccessToken(userID, email, userType string) (string, error) {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		return "", errors.New("JWT_SECRET not set")
 	}
 
+	role := NormalizeRole(userType)
 	claims := JWTClaims{
 		UserID: userID,
 		Email:  email,
+		Role:   role,
+		Scopes: ScopesForRole(role),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)), // 15 minutes
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL())),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
@@ -82,13 +102,65 @@ func GenerateAccessToken(userID, email string) (string, error) {
 
 // GenerateRefreshToken creates a long-lived random refresh token
 func GenerateRefreshToken() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// GeneratePasswordResetToken creates the random token of a password reset
+// link
+func GeneratePasswordResetToken() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate password reset token: %w", err)
+	}
+	return token, nil
+}
+
+// randomToken returns 32 random bytes encoded to be safe in URLs
+func randomToken() (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
-		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+		return "", err
 	}
 	return base64.URLEncoding.EncodeToString(b), nil
 }
 
+// HashToken returns the SHA-256 hex digest sessions store in place of a token,
+// so a leaked sessions table cannot be replayed
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AccessTokenTTL is the lifetime of an access token
+func AccessTokenTTL() time.Duration {
+	return durationFromEnv("ACCESS_TOKEN_TTL", DefaultAccessTokenTTL)
+}
+
+// RefreshTokenTTL is the lifetime of a refresh token. Every refresh issues a
+// new one, so a session lasts as long as it is used at least this often.
+func RefreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", DefaultRefreshTokenTTL)
+}
+
+// PasswordResetTTL is how long a password reset link works
+func PasswordResetTTL() time.Duration {
+	return durationFromEnv("PASSWORD_RESET_TTL", DefaultPasswordResetTTL)
+}
+
+// durationFromEnv parses a Go duration from the environment, falling back when
+// it is unset or not a positive duration
+func durationFromEnv(key string, fallback time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return fallback
+	}
+	return d
+}
+
 // ValidateAccessToken validates and parses a JWT access token
 func ValidateAccessToken(tokenString string) (*JWTClaims, error) {
 	secret := os.Getenv("JWT_SECRET")
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"services/internal/models"
+)
+
+// Scopes gate mutating and back-office routes. They are derived from the
+// user's role at token issue time and carried in JWTClaims.
+const (
+	ScopeCoursesWrite         = "courses:write"
+	ScopePaymentPlansWrite    = "payment_plans:write"
+	ScopePaymentsRead         = "payments:read"
+	ScopePaymentsWrite        = "payments:write"
+	ScopeReviewsModerate      = "reviews:moderate"
+	ScopeLeadsRead            = "leads:read"
+	ScopeRefundsWrite         = "refunds:write"
+	ScopeOrdersRead           = "orders:read"
+	ScopeCouponsWrite         = "coupons:write"
+	ScopeTaxRatesWrite        = "tax_rates:write"
+	ScopeEnrollmentCodesWrite = "enrollment_codes:write"
+	ScopeUsersUnlock          = "users:unlock"
+)
+
+var roleScopes = map[string][]string{
+	models.UserTypeStudent: {},
+	models.UserTypeInstructor: {
+		ScopeCoursesWrite,
+	},
+	models.UserTypeStaff: {
+		ScopeCoursesWrite,
+		ScopePaymentPlansWrite,
+		ScopePaymentsRead,
+		ScopeReviewsModerate,
+		ScopeLeadsRead,
+		ScopeOrdersRead,
+		ScopeCouponsWrite,
+		ScopeEnrollmentCodesWrite,
+	},
+	models.UserTypeAdmin: {
+		ScopeCoursesWrite,
+		ScopePaymentPlansWrite,
+		ScopePaymentsRead,
+		ScopePaymentsWrite,
+		ScopeReviewsModerate,
+		ScopeLeadsRead,
+		ScopeRefundsWrite,
+		ScopeOrdersRead,
+		ScopeCouponsWrite,
+		ScopeTaxRatesWrite,
+		ScopeEnrollmentCodesWrite,
+		ScopeUsersUnlock,
+	},
+}
+
+// NormalizeRole maps a User.Type onto a known role. Unknown or empty types
+// fall back to student so they never gain extra scopes.
+func NormalizeRole(userType string) string {
+	switch userType {
+	case models.UserTypeInstructor, models.UserTypeStaff, models.UserTypeAdmin:
+		return userType
+	case "employee": // legacy type from the original schema
+		return models.UserTypeStaff
+	default:
+		return models.UserTypeStudent
+	}
+}
+
+// ScopesForRole returns the scopes granted to a role
+func ScopesForRole(role string) []string {
+	scopes := roleScopes[NormalizeRole(role)]
+	out := make([]string, len(scopes))
+	copy(out, scopes)
+	return out
+}
+
+// HasScope reports whether scope is present in granted
+func HasScope(granted []string, scope string) bool {
+	for _, s := range granted {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package coupon
+
+import (
+	"errors"
+	"fmt"
+	"services/internal/models"
+	"services/internal/money"
+	"strings"
+	"time"
+)
+
+const (
+	TypePercent = "PERCENT"
+	TypeFixed   = "FIXED"
+)
+
+var (
+	ErrInvalidCoupon   = errors.New("invalid coupon")
+	ErrInactive        = errors.New("coupon is not active")
+	ErrNotStarted      = errors.New("coupon is not valid yet")
+	ErrExpired         = errors.New("coupon has expired")
+	ErrUsageLimit      = errors.New("coupon has reached its usage limit")
+	ErrUserUsageLimit  = errors.New("coupon has already been used the maximum number of times")
+	ErrMinimumSpend    = errors.New("cart does not meet the coupon's minimum spend")
+	ErrNoEligibleItems = errors.New("coupon does not apply to any course in the cart")
+	ErrNotStackable    = errors.New("coupon cannot be combined with other coupons")
+)
+
+// NormalizeCode returns the canonical form codes are stored and looked up in
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Validate checks a coupon definition before it is saved
+func Validate(c *models.Coupon) error {
+	switch {
+	case NormalizeCode(c.Code) == "":
+		return fmt.Errorf("%w: code is required", ErrInvalidCoupon)
+	case c.Type != TypePercent && c.Type != TypeFixed:
+		return fmt.Errorf("%w: type must be %s or %s", ErrInvalidCoupon, TypePercent, TypeFixed)
+	case c.Type == TypePercent && (c.PercentOff <= 0 || c.PercentOff > 100):
+		return fmt.Errorf("%w: percent_off must be between 0 and 100", ErrInvalidCoupon)
+	case c.Type == TypeFixed && c.AmountOff <= 0:
+		return fmt.Errorf("%w: amount_off must be positive", ErrInvalidCoupon)
+	case c.MinSubtotal < 0:
+		return fmt.Errorf("%w: min_subtotal cannot be negative", ErrInvalidCoupon)
+	case c.MaxRedemptions < 0 || c.MaxRedemptionsPerUser < 0:
+		return fmt.Errorf("%w: usage limits cannot be negative", ErrInvalidCoupon)
+	case c.StartsAt != nil && c.EndsAt != nil && !c.EndsAt.After(*c.StartsAt):
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidCoupon)
+	}
+	return nil
+}
+
+// Usage is how many times a coupon has been redeemed, overall and by the
+// user whose cart is being priced
+type Usage struct {
+	Total  int64
+	ByUser int64
+}
+
+// Candidate is a coupon applied to a cart, with its redemption counts
+type Candidate struct {
+	Coupon *models.Coupon
+	Usage  Usage
+}
+
+// Line is the outcome of one coupon on a cart. Amount is set when the coupon
+// applied; Err explains why it did not.
+type Line struct {
+	CouponID string       `json:"coupon_id"`
+	Code     string       `json:"code"`
+	Amount   money.Amount `json:"amount"`
+	Reason   string       `json:"reason,omitempty"`
+	Err      error        `json:"-"`
+}
+
+// Quote is a priced cart
+type Quote struct {
+	Subtotal money.Amount `json:"subtotal"`
+	Discount money.Amount `json:"discount"`
+	Total    money.Amount `json:"total"`
+	Applied  []Line       `json:"coupons"`
+	Rejected []Line       `json:"rejected_coupons,omitempty"`
+	// ItemDiscounts is each cart item's share of the discount, by item ID
+	ItemDiscounts map[string]money.Amount `json:"-"`
+}
+
+// Rejection returns the first coupon that no longer applies, if any
+func (q *Quote) Rejection() *Line {
+	if len(q.Rejected) == 0 {
+		return nil
+	}
+	return &q.Rejected[0]
+}
+
+// Check reports whether c can be used at now by a user with the given usage,
+// regardless of what is in the cart
+func Check(c *models.Coupon, usage Usage, now time.Time) error {
+	switch {
+	case !c.Active:
+		return ErrInactive
+	case c.StartsAt != nil && now.Before(*c.StartsAt):
+		return ErrNotStarted
+	case c.EndsAt != nil && !now.Before(*c.EndsAt):
+		return ErrExpired
+	case c.MaxRedemptions > 0 && usage.Total >= int64(c.MaxRedemptions):
+		return ErrUsageLimit
+	case c.MaxRedemptionsPerUser > 0 && usage.ByUser >= int64(c.MaxRedemptionsPerUser):
+		return ErrUserUsageLimit
+	}
+	return nil
+}
+
+// Price applies coupons to cart items in the order they were added to the
+// cart. Each coupon discounts what is left of its eligible items after the
+// coupons before it, so the total never goes below zero.
+//
+// A coupon that is not stackable only applies on its own: it is rejected if
+// another coupon already applied, and blocks every coupon after it.
+func Price(items []models.CartItem, coupons []Candidate, now time.Time) Quote {
+	quote := Quote{ItemDiscounts: make(map[string]money.Amount, len(items))}
+	for _, item := range items {
+		quote.Subtotal += item.Price
+	}
+
+	exclusive := false
+	for _, cand := range coupons {
+		c := cand.Coupon
+		line := Line{CouponID: c.ID, Code: c.Code}
+
+		err := Check(c, cand.Usage, now)
+		if err == nil && quote.Subtotal < c.MinSubtotal {
+			err = ErrMinimumSpend
+		}
+		if err == nil && (exclusive || (!c.Stackable && len(quote.Applied) > 0)) {
+			err = ErrNotStackable
+		}
+		var eligible []models.CartItem
+		if err == nil {
+			eligible = eligibleItems(c, items)
+			if len(eligible) == 0 {
+				err = ErrNoEligibleItems
+			}
+		}
+		if err != nil {
+			line.Err = err
+			line.Reason = err.Error()
+			quote.Rejected = append(quote.Rejected, line)
+			continue
+		}
+
+		line.Amount = allocate(c, eligible, quote.ItemDiscounts)
+		quote.Discount += line.Amount
+		quote.Applied = append(quote.Applied, line)
+		if !c.Stackable {
+			exclusive = true
+		}
+	}
+
+	quote.Total = quote.Subtotal - quote.Discount
+	return quote
+}
+
+func eligibleItems(c *models.Coupon, items []models.CartItem) []models.CartItem {
+	if len(c.CourseIDs) == 0 {
+		return items
+	}
+	var eligible []models.CartItem
+	for _, item := range items {
+		for _, id := range c.CourseIDs {
+			if item.CourseID == id {
+				eligible = append(eligible, item)
+				break
+			}
+		}
+	}
+	return eligible
+}
+
+// allocate works out c's discount on the eligible items, records each item's
+// share in discounts and returns the coupon's total
+func allocate(c *models.Coupon, eligible []models.CartItem, discounts map[string]money.Amount) money.Amount {
+	var total money.Amount
+	switch c.Type {
+	case TypePercent:
+		for _, item := range eligible {
+			remaining := item.Price - discounts[item.ID]
+			off := remaining - remaining.PercentOff(c.PercentOff)
+			discounts[item.ID] += off
+			total += off
+		}
+	case TypeFixed:
+		var remaining money.Amount
+		for _, item := range eligible {
+			remaining += item.Price - discounts[item.ID]
+		}
+		total = min(c.AmountOff, remaining)
+		if total <= 0 {
+			return 0
+		}
+		// Spread the amount in proportion to each item's remaining price,
+		// with the rounding left over going to the last item
+		left := total
+		for i, item := range eligible {
+			share := left
+			if i < len(eligible)-1 {
+				share = total * (item.Price - discounts[item.ID]) / remaining
+			}
+			discounts[item.ID] += share
+			left -= share
+		}
+	}
+	return total
+}
@@ -0,0 +1,145 @@
+package coupon
+
+import (
+	"errors"
+	"services/internal/models"
+	"services/internal/money"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func items() []models.CartItem {
+	return []models.CartItem{
+		{ID: "item-1", CourseID: "course-1", Price: 10000},
+		{ID: "item-2", CourseID: "course-2", Price: 4999},
+	}
+}
+
+func percent(code string, pct float64, stackable bool) *models.Coupon {
+	return &models.Coupon{ID: code, Code: code, Type: TypePercent, PercentOff: pct, Stackable: stackable, Active: true}
+}
+
+func fixed(code string, amount money.Amount, stackable bool) *models.Coupon {
+	return &models.Coupon{ID: code, Code: code, Type: TypeFixed, AmountOff: amount, Stackable: stackable, Active: true}
+}
+
+func TestPrice_Percent(t *testing.T) {
+	q := Price(items(), []Candidate{{Coupon: percent("TEN", 10, false)}}, now)
+
+	// 10% of 100.00 is 10.00 and 10% of 49.99 rounds to 5.00
+	if q.Subtotal != 14999 || q.Discount != 1500 || q.Total != 13499 {
+		t.Errorf("got %s - %s = %s", q.Subtotal, q.Discount, q.Total)
+	}
+	if q.ItemDiscounts["item-1"] != 1000 || q.ItemDiscounts["item-2"] != 500 {
+		t.Errorf("unexpected item discounts %v", q.ItemDiscounts)
+	}
+}
+
+func TestPrice_FixedIsSpreadAndCapped(t *testing.T) {
+	q := Price(items(), []Candidate{{Coupon: fixed("TWENTY", 2000, false)}}, now)
+	if q.Discount != 2000 || q.ItemDiscounts["item-1"]+q.ItemDiscounts["item-2"] != 2000 {
+		t.Errorf("expected 20.00 spread over the items, got %s as %v", q.Discount, q.ItemDiscounts)
+	}
+
+	q = Price(items(), []Candidate{{Coupon: fixed("HUGE", 100000, false)}}, now)
+	if q.Total != 0 || q.Discount != q.Subtotal {
+		t.Errorf("expected the discount to be capped at the subtotal, got %s - %s = %s", q.Subtotal, q.Discount, q.Total)
+	}
+}
+
+func TestPrice_CourseRestriction(t *testing.T) {
+	c := percent("COURSE2", 50, false)
+	c.CourseIDs = []string{"course-2"}
+	q := Price(items(), []Candidate{{Coupon: c}}, now)
+	if q.ItemDiscounts["item-1"] != 0 || q.ItemDiscounts["item-2"] != 2500 {
+		t.Errorf("expected only course-2 to be discounted, got %v", q.ItemDiscounts)
+	}
+
+	c.CourseIDs = []string{"course-3"}
+	q = Price(items(), []Candidate{{Coupon: c}}, now)
+	if len(q.Rejected) != 1 || !errors.Is(q.Rejected[0].Err, ErrNoEligibleItems) {
+		t.Errorf("expected ErrNoEligibleItems, got %+v", q.Rejected)
+	}
+}
+
+func TestPrice_Stacking(t *testing.T) {
+	q := Price(items(), []Candidate{
+		{Coupon: percent("TEN", 10, true)},
+		{Coupon: fixed("FIVE", 500, true)},
+	}, now)
+	if len(q.Applied) != 2 || q.Discount != 2000 {
+		t.Errorf("expected stackable coupons to combine to 20.00, got %s from %+v", q.Discount, q.Applied)
+	}
+
+	q = Price(items(), []Candidate{
+		{Coupon: percent("TEN", 10, true)},
+		{Coupon: percent("SOLO", 50, false)},
+	}, now)
+	if len(q.Applied) != 1 || len(q.Rejected) != 1 || !errors.Is(q.Rejected[0].Err, ErrNotStackable) {
+		t.Errorf("expected the non-stackable coupon to be rejected, got applied %+v rejected %+v", q.Applied, q.Rejected)
+	}
+
+	q = Price(items(), []Candidate{
+		{Coupon: percent("SOLO", 50, false)},
+		{Coupon: percent("TEN", 10, true)},
+	}, now)
+	if len(q.Applied) != 1 || q.Applied[0].Code != "SOLO" || len(q.Rejected) != 1 {
+		t.Errorf("expected a non-stackable coupon to block later ones, got applied %+v rejected %+v", q.Applied, q.Rejected)
+	}
+}
+
+func TestPrice_MinimumSpend(t *testing.T) {
+	c := percent("BIG", 10, false)
+	c.MinSubtotal = 15000
+	q := Price(items(), []Candidate{{Coupon: c}}, now)
+	if len(q.Rejected) != 1 || !errors.Is(q.Rejected[0].Err, ErrMinimumSpend) || q.Total != q.Subtotal {
+		t.Errorf("expected ErrMinimumSpend and no discount, got %+v", q)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name   string
+		coupon models.Coupon
+		usage  Usage
+		want   error
+	}{
+		{name: "valid", coupon: models.Coupon{Active: true, StartsAt: &past, EndsAt: &future}},
+		{name: "inactive", coupon: models.Coupon{}, want: ErrInactive},
+		{name: "not started", coupon: models.Coupon{Active: true, StartsAt: &future}, want: ErrNotStarted},
+		{name: "expired", coupon: models.Coupon{Active: true, EndsAt: &past}, want: ErrExpired},
+		{name: "global limit", coupon: models.Coupon{Active: true, MaxRedemptions: 3}, usage: Usage{Total: 3}, want: ErrUsageLimit},
+		{name: "user limit", coupon: models.Coupon{Active: true, MaxRedemptionsPerUser: 1}, usage: Usage{Total: 1, ByUser: 1}, want: ErrUserUsageLimit},
+		{name: "under limits", coupon: models.Coupon{Active: true, MaxRedemptions: 3, MaxRedemptionsPerUser: 1}, usage: Usage{Total: 2}},
+	}
+	for _, tt := range tests {
+		if err := Check(&tt.coupon, tt.usage, now); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := []*models.Coupon{percent("TEN", 10, false), fixed("FIVE", 500, false)}
+	for _, c := range valid {
+		if err := Validate(c); err != nil {
+			t.Errorf("Validate(%s): %v", c.Code, err)
+		}
+	}
+
+	invalid := []*models.Coupon{
+		percent("", 10, false),
+		percent("ZERO", 0, false),
+		percent("OVER", 150, false),
+		fixed("NEG", -100, false),
+		{Code: "TYPE", Type: "BOGUS"},
+	}
+	for _, c := range invalid {
+		if err := Validate(c); !errors.Is(err, ErrInvalidCoupon) {
+			t.Errorf("Validate(%q) = %v, want ErrInvalidCoupon", c.Code, err)
+		}
+	}
+}
@@ -48,6 +48,14 @@ func MigrateDatabase(ctx context.Context, db_client *gorm.DB, logger *slog.Logge
 
 	logger.Info("Starting database migration")
 
+	// Sessions used to hold plaintext tokens. They cannot be hashed into
+	// families after the fact, so drop them and sign everyone out before
+	// AutoMigrate adds the new NOT NULL columns.
+	plaintextSessionsSQL := `DO $$ BEGIN IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='sessions' AND column_name='refresh_token') THEN DELETE FROM sessions; ALTER TABLE sessions DROP COLUMN access_token; ALTER TABLE sessions DROP COLUMN refresh_token; END IF; END $$;`
+	if err := db_client.Exec(plaintextSessionsSQL).Error; err != nil {
+		logger.Warn("Could not drop plaintext session tokens", "error", err)
+	}
+
 	if err := db_client.AutoMigrate(models.AllModels...); err != nil {
 		return fmt.Errorf("failed to auto-migrate database: %w", err)
 	}
@@ -74,6 +82,44 @@ func MigrateDatabase(ctx context.Context, db_client *gorm.DB, logger *slog.Logge
 		logger.Info("Dropped quantity column from cart_items")
 	}
 
+	// Money used to be stored as float dollars; backfill the integer minor-unit
+	// columns from the legacy ones and drop them so nothing reads stale floats.
+	moneyColumns := []struct{ table, legacy, minor string }{
+		{"courses", "price", "price_minor"},
+		{"cart_items", "price", "price_minor"},
+		{"orders", "total_amount", "total_amount_minor"},
+		{"order_items", "price", "price_minor"},
+		{"payments", "transaction_amount", "transaction_amount_minor"},
+		{"payment_plans", "amount", "amount_minor"},
+		{"payment_attempts", "amount", "amount_minor"},
+	}
+	for _, c := range moneyColumns {
+		sql := fmt.Sprintf(
+			`DO $$ BEGIN IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='%s' AND column_name='%s') THEN UPDATE %s SET %s = ROUND(COALESCE(%s, 0) * 100); ALTER TABLE %s DROP COLUMN %s; END IF; END $$;`,
+			c.table, c.legacy, c.table, c.minor, c.legacy, c.table, c.legacy,
+		)
+		if err := db_client.Exec(sql).Error; err != nil {
+			logger.Warn("Could not convert money column", "table", c.table, "column", c.legacy, "error", err)
+		} else {
+			logger.Info("Converted money column to minor units", "table", c.table, "column", c.minor)
+		}
+	}
+
+	// Payments used to be PayPal only; move their capture IDs to the provider
+	// neutral column and drop the old one
+	paymentProviderSQL := `DO $$ BEGIN IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='payments' AND column_name='paypal_transaction_id') THEN UPDATE payments SET provider_transaction_id = paypal_transaction_id WHERE COALESCE(provider_transaction_id, '') = ''; ALTER TABLE payments DROP COLUMN paypal_transaction_id; END IF; END $$;`
+	if err := db_client.Exec(paymentProviderSQL).Error; err != nil {
+		logger.Warn("Could not move PayPal transaction IDs", "error", err)
+	} else {
+		logger.Info("Moved PayPal transaction IDs to provider_transaction_id")
+	}
+
+	// Orders placed before coupons were never discounted, so their subtotal is their total
+	orderSubtotalSQL := `UPDATE orders SET subtotal_minor = total_amount_minor WHERE subtotal_minor = 0 AND discount_minor = 0`
+	if err := db_client.Exec(orderSubtotalSQL).Error; err != nil {
+		logger.Warn("Could not backfill order subtotals", "error", err)
+	}
+
 	reviewTestimonialColumnsSQL := `
 		ALTER TABLE reviews ADD COLUMN IF NOT EXISTS testimonial_tag VARCHAR(255) NOT NULL DEFAULT '';
 		ALTER TABLE reviews ADD COLUMN IF NOT EXISTS testimonial_role VARCHAR(255) NOT NULL DEFAULT '';
@@ -140,6 +186,10 @@ func MigrateDatabase(ctx context.Context, db_client *gorm.DB, logger *slog.Logge
 		{Key: "contact_recipient_email", Value: "hello@a1frenchclasses.ca", Description: "Email where contact submissions are sent"},
 		{Key: "contact_whatsapp_number", Value: "+1234567890", Description: "WhatsApp number for automated notifications"},
 		{Key: "google_spreadsheet_id", Value: "", Description: "ID of the Google Sheet for lead storage"},
+		{Key: "invoice_seller_name", Value: "A1 French Classes", Description: "Seller name printed on receipts"},
+		{Key: "invoice_seller_address", Value: "", Description: "Seller address printed on receipts, one line per row"},
+		{Key: "invoice_seller_email", Value: "hello@a1frenchclasses.ca", Description: "Seller email printed on receipts"},
+		{Key: "invoice_seller_tax_id", Value: "", Description: "GST/HST registration number printed on receipts"},
 	}
 
 	homepageSettings := []models.AppSetting{
@@ -176,6 +226,10 @@ func MigrateDatabase(ctx context.Context, db_client *gorm.DB, logger *slog.Logge
 	}
 	logger.Info("Default app settings seeded")
 
+	if err := seedTaxRates(db_client, logger); err != nil {
+		logger.Warn("Could not seed tax rates", "error", err)
+	}
+
 	if err := seedTestimonialReviews(db_client, logger); err != nil {
 		logger.Warn("Could not seed testimonial reviews", "error", err)
 	} else {
@@ -367,6 +421,43 @@ func buildTestimonialSeeds() []testimonialSeed {
 	}
 }
 
+// seedTaxRates loads the Canadian sales tax rates on first run only. After
+// that they are edited through the admin API and never overwritten here.
+func seedTaxRates(db_client *gorm.DB, logger *slog.Logger) error {
+	var count int64
+	if err := db_client.Model(&models.TaxRate{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count tax rates: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	rates := []models.TaxRate{
+		{Province: "AB", Name: "GST", Rate: 5},
+		{Province: "BC", Name: "GST", Rate: 5},
+		{Province: "BC", Name: "PST", Rate: 7},
+		{Province: "MB", Name: "GST", Rate: 5},
+		{Province: "MB", Name: "RST", Rate: 7},
+		{Province: "NB", Name: "HST", Rate: 15},
+		{Province: "NL", Name: "HST", Rate: 15},
+		{Province: "NS", Name: "HST", Rate: 14},
+		{Province: "NT", Name: "GST", Rate: 5},
+		{Province: "NU", Name: "GST", Rate: 5},
+		{Province: "ON", Name: "HST", Rate: 13},
+		{Province: "PE", Name: "HST", Rate: 15},
+		{Province: "QC", Name: "GST", Rate: 5},
+		{Province: "QC", Name: "QST", Rate: 9.975},
+		{Province: "SK", Name: "GST", Rate: 5},
+		{Province: "SK", Name: "PST", Rate: 6},
+		{Province: "YT", Name: "GST", Rate: 5},
+	}
+	if err := db_client.Create(&rates).Error; err != nil {
+		return fmt.Errorf("failed to seed tax rates: %w", err)
+	}
+	logger.Info("Seeded default tax rates", "count", len(rates))
+	return nil
+}
+
 func seedTestimonialReviews(db_client *gorm.DB, logger *slog.Logger) error {
 	var course models.Course
 	if err := db_client.Order("created_at ASC").First(&course).Error; err != nil {
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id VARCHAR(255) PRIMARY KEY,
+    provider VARCHAR(50) NOT NULL,
+    event_type VARCHAR(255) NOT NULL,
+    resource_id VARCHAR(255),
+    payload JSONB,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_events_resource_id ON webhook_events(resource_id);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_payments_parent_payment_id;
+
+ALTER TABLE payments
+DROP COLUMN IF EXISTS parent_payment_id,
+DROP COLUMN IF EXISTS transaction_type;
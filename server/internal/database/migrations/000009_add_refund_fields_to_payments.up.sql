@@ -0,0 +1,4 @@
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS transaction_type VARCHAR(50) NOT NULL DEFAULT 'PAYMENT';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS parent_payment_id UUID REFERENCES payments(id);
+
+CREATE INDEX IF NOT EXISTS idx_payments_parent_payment_id ON payments(parent_payment_id);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS payment_attempts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    provider_order_id VARCHAR(255) NOT NULL,
+    amount DECIMAL(10, 2),
+    currency VARCHAR(3),
+    status VARCHAR(50) DEFAULT 'CREATED',
+    capture_id VARCHAR(255),
+    mismatch_reason TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    UNIQUE(provider_order_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payment_attempts_order_id ON payment_attempts(order_id);
+CREATE INDEX IF NOT EXISTS idx_payment_attempts_status ON payment_attempts(status);
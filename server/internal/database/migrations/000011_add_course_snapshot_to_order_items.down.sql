@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_orders_user_id_created_at;
+
+ALTER TABLE order_items
+DROP COLUMN IF EXISTS course_image_url,
+DROP COLUMN IF EXISTS course_name;
@@ -0,0 +1,4 @@
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS course_name VARCHAR(255);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS course_image_url TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_orders_user_id_created_at ON orders(user_id, created_at);
@@ -0,0 +1,30 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS currency;
+ALTER TABLE orders DROP COLUMN IF EXISTS currency;
+
+ALTER TABLE courses ADD COLUMN IF NOT EXISTS price DECIMAL(10, 2);
+UPDATE courses SET price = price_minor / 100.0;
+ALTER TABLE courses DROP COLUMN IF EXISTS price_minor;
+
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS price DECIMAL(10, 2);
+UPDATE cart_items SET price = price_minor / 100.0;
+ALTER TABLE cart_items DROP COLUMN IF EXISTS price_minor;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS total_amount DECIMAL(10, 2);
+UPDATE orders SET total_amount = total_amount_minor / 100.0;
+ALTER TABLE orders DROP COLUMN IF EXISTS total_amount_minor;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS price DECIMAL(10, 2);
+UPDATE order_items SET price = price_minor / 100.0;
+ALTER TABLE order_items DROP COLUMN IF EXISTS price_minor;
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS transaction_amount DECIMAL(10, 2);
+UPDATE payments SET transaction_amount = transaction_amount_minor / 100.0;
+ALTER TABLE payments DROP COLUMN IF EXISTS transaction_amount_minor;
+
+ALTER TABLE payment_plans ADD COLUMN IF NOT EXISTS amount DECIMAL(10, 2);
+UPDATE payment_plans SET amount = amount_minor / 100.0;
+ALTER TABLE payment_plans DROP COLUMN IF EXISTS amount_minor;
+
+ALTER TABLE payment_attempts ADD COLUMN IF NOT EXISTS amount DECIMAL(10, 2);
+UPDATE payment_attempts SET amount = amount_minor / 100.0;
+ALTER TABLE payment_attempts DROP COLUMN IF EXISTS amount_minor;
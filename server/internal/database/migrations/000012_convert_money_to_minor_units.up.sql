@@ -0,0 +1,65 @@
+ALTER TABLE courses ADD COLUMN IF NOT EXISTS price_minor BIGINT NOT NULL DEFAULT 0;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='courses' AND column_name='price') THEN
+        UPDATE courses SET price_minor = ROUND(COALESCE(price, 0) * 100);
+        ALTER TABLE courses DROP COLUMN price;
+    END IF;
+END $$;
+
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS price_minor BIGINT NOT NULL DEFAULT 0;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='cart_items' AND column_name='price') THEN
+        UPDATE cart_items SET price_minor = ROUND(COALESCE(price, 0) * 100);
+        ALTER TABLE cart_items DROP COLUMN price;
+    END IF;
+END $$;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS total_amount_minor BIGINT NOT NULL DEFAULT 0;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='orders' AND column_name='total_amount') THEN
+        UPDATE orders SET total_amount_minor = ROUND(COALESCE(total_amount, 0) * 100);
+        ALTER TABLE orders DROP COLUMN total_amount;
+    END IF;
+END $$;
+
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS price_minor BIGINT NOT NULL DEFAULT 0;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='order_items' AND column_name='price') THEN
+        UPDATE order_items SET price_minor = ROUND(COALESCE(price, 0) * 100);
+        ALTER TABLE order_items DROP COLUMN price;
+    END IF;
+END $$;
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS transaction_amount_minor BIGINT NOT NULL DEFAULT 0;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='payments' AND column_name='transaction_amount') THEN
+        UPDATE payments SET transaction_amount_minor = ROUND(COALESCE(transaction_amount, 0) * 100);
+        ALTER TABLE payments DROP COLUMN transaction_amount;
+    END IF;
+END $$;
+
+ALTER TABLE payment_plans ADD COLUMN IF NOT EXISTS amount_minor BIGINT NOT NULL DEFAULT 0;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='payment_plans' AND column_name='amount') THEN
+        UPDATE payment_plans SET amount_minor = ROUND(COALESCE(amount, 0) * 100);
+        ALTER TABLE payment_plans DROP COLUMN amount;
+    END IF;
+END $$;
+
+ALTER TABLE payment_attempts ADD COLUMN IF NOT EXISTS amount_minor BIGINT NOT NULL DEFAULT 0;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name='payment_attempts' AND column_name='amount') THEN
+        UPDATE payment_attempts SET amount_minor = ROUND(COALESCE(amount, 0) * 100);
+        ALTER TABLE payment_attempts DROP COLUMN amount;
+    END IF;
+END $$;
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD';
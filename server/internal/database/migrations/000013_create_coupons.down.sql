@@ -0,0 +1,10 @@
+ALTER TABLE order_items DROP COLUMN IF EXISTS discount_minor;
+
+ALTER TABLE orders
+DROP COLUMN IF EXISTS discount_minor,
+DROP COLUMN IF EXISTS subtotal_minor;
+
+DROP TABLE IF EXISTS order_discounts;
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS cart_coupons;
+DROP TABLE IF EXISTS coupons;
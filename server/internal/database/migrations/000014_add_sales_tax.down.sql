@@ -0,0 +1,9 @@
+ALTER TABLE users DROP COLUMN IF EXISTS province;
+ALTER TABLE order_items DROP COLUMN IF EXISTS tax_minor;
+
+ALTER TABLE orders
+DROP COLUMN IF EXISTS tax_province,
+DROP COLUMN IF EXISTS tax_total_minor;
+
+DROP TABLE IF EXISTS order_taxes;
+DROP TABLE IF EXISTS tax_rates;
@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS tax_rates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    province VARCHAR(2) NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    rate DECIMAL(7, 4) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_tax_rates_province ON tax_rates(province);
+
+CREATE TABLE IF NOT EXISTS order_taxes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL,
+    name VARCHAR(50),
+    rate DECIMAL(7, 4),
+    amount_minor BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_taxes_order_id ON order_taxes(order_id);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_total_minor BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_province VARCHAR(2);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_minor BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS province VARCHAR(2);
+
+-- Default rates; edit them through PUT /api/admin/tax-rates/{province}
+INSERT INTO tax_rates (province, name, rate)
+SELECT v.province, v.name, v.rate
+FROM (VALUES
+    ('AB', 'GST', 5), ('BC', 'GST', 5), ('BC', 'PST', 7), ('MB', 'GST', 5), ('MB', 'RST', 7),
+    ('NB', 'HST', 15), ('NL', 'HST', 15), ('NS', 'HST', 14), ('NT', 'GST', 5), ('NU', 'GST', 5),
+    ('ON', 'HST', 13), ('PE', 'HST', 15), ('QC', 'GST', 5), ('QC', 'QST', 9.975),
+    ('SK', 'GST', 5), ('SK', 'PST', 6), ('YT', 'GST', 5)
+) AS v(province, name, rate)
+WHERE NOT EXISTS (SELECT 1 FROM tax_rates);
@@ -0,0 +1,11 @@
+ALTER TABLE user_courses DROP COLUMN IF EXISTS suspended_at;
+ALTER TABLE payment_attempts DROP COLUMN IF EXISTS installment_id;
+
+ALTER TABLE order_items
+DROP COLUMN IF EXISTS installments,
+DROP COLUMN IF EXISTS payment_plan_id;
+
+ALTER TABLE cart_items DROP COLUMN IF EXISTS payment_plan_id;
+ALTER TABLE payment_plans DROP COLUMN IF EXISTS installments;
+
+DROP TABLE IF EXISTS installments;
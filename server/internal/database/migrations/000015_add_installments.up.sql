@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS installments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL,
+    sequence INT NOT NULL,
+    amount_minor BIGINT NOT NULL DEFAULT 0,
+    due_at TIMESTAMP NOT NULL,
+    status VARCHAR(20) DEFAULT 'SCHEDULED',
+    paid_at TIMESTAMP,
+    payment_id UUID,
+    failed_attempts INT NOT NULL DEFAULT 0,
+    last_failure TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_installments_order_id ON installments(order_id);
+CREATE INDEX IF NOT EXISTS idx_installments_due_at ON installments(due_at);
+
+ALTER TABLE payment_plans ADD COLUMN IF NOT EXISTS installments INT NOT NULL DEFAULT 1;
+ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS payment_plan_id INT;
+
+ALTER TABLE order_items
+ADD COLUMN IF NOT EXISTS payment_plan_id INT,
+ADD COLUMN IF NOT EXISTS installments INT NOT NULL DEFAULT 1;
+
+ALTER TABLE payment_attempts ADD COLUMN IF NOT EXISTS installment_id UUID;
+ALTER TABLE user_courses ADD COLUMN IF NOT EXISTS suspended_at TIMESTAMP;
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS invoice_counters (
+    name VARCHAR(50) PRIMARY KEY,
+    value BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS invoices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    order_id UUID NOT NULL,
+    sequence BIGINT NOT NULL,
+    number VARCHAR(50) NOT NULL,
+    issued_at TIMESTAMP NOT NULL,
+    seller_name TEXT,
+    seller_address TEXT,
+    seller_email TEXT,
+    seller_tax_id TEXT,
+    buyer_name TEXT,
+    buyer_email TEXT,
+    buyer_province VARCHAR(2),
+    currency VARCHAR(3) NOT NULL,
+    subtotal_minor BIGINT NOT NULL DEFAULT 0,
+    discount_minor BIGINT NOT NULL DEFAULT 0,
+    tax_total_minor BIGINT NOT NULL DEFAULT 0,
+    total_minor BIGINT NOT NULL DEFAULT 0,
+    paid_minor BIGINT NOT NULL DEFAULT 0,
+    lines JSONB,
+    taxes JSONB,
+    payment_method TEXT,
+    payment_reference TEXT,
+    emailed_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_order_id ON invoices(order_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_sequence ON invoices(sequence);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_number ON invoices(number);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_orders_status_created_at;
+
+ALTER TABLE carts DROP COLUMN IF EXISTS reminder_sent_at;
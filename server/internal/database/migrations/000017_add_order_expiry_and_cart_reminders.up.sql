@@ -0,0 +1,3 @@
+ALTER TABLE carts ADD COLUMN IF NOT EXISTS reminder_sent_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_orders_status_created_at ON orders(status, created_at);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_payments_provider_transaction_id;
+
+ALTER TABLE payments
+DROP COLUMN IF EXISTS provider_session_id,
+DROP COLUMN IF EXISTS provider;
+
+ALTER TABLE payments RENAME COLUMN provider_transaction_id TO paypal_transaction_id;
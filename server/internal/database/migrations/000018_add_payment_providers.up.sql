@@ -0,0 +1,7 @@
+ALTER TABLE payments RENAME COLUMN paypal_transaction_id TO provider_transaction_id;
+
+ALTER TABLE payments
+ADD COLUMN IF NOT EXISTS provider VARCHAR(20) NOT NULL DEFAULT 'PAYPAL',
+ADD COLUMN IF NOT EXISTS provider_session_id TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_payments_provider_transaction_id ON payments(provider_transaction_id);
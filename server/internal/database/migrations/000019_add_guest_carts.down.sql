@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_carts_user_id;
+
+DELETE FROM cart_items WHERE cart_id IN (SELECT id FROM carts WHERE user_id IS NULL);
+DELETE FROM cart_coupons WHERE cart_id IN (SELECT id FROM carts WHERE user_id IS NULL);
+DELETE FROM carts WHERE user_id IS NULL;
+
+ALTER TABLE carts ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE carts ALTER COLUMN user_id DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_carts_user_id ON carts(user_id);
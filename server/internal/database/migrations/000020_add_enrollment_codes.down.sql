@@ -0,0 +1,5 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS gift_message;
+ALTER TABLE orders DROP COLUMN IF EXISTS gift_recipient_name;
+ALTER TABLE orders DROP COLUMN IF EXISTS gift_recipient_email;
+
+DROP TABLE IF EXISTS enrollment_codes;
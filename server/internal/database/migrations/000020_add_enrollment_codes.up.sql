@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS enrollment_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(32) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    course_id UUID NOT NULL,
+    issued_by_id UUID NOT NULL,
+    order_id UUID,
+    order_item_id UUID,
+    recipient_email TEXT,
+    recipient_name TEXT,
+    message TEXT,
+    redeemed_by_id UUID,
+    redeemed_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    UNIQUE(code)
+);
+
+CREATE INDEX IF NOT EXISTS idx_enrollment_codes_issued_by_id ON enrollment_codes(issued_by_id);
+CREATE INDEX IF NOT EXISTS idx_enrollment_codes_order_id ON enrollment_codes(order_id);
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS gift_recipient_email TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS gift_recipient_name TEXT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS gift_message TEXT;
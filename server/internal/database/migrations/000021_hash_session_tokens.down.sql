@@ -0,0 +1,18 @@
+DELETE FROM sessions;
+
+DROP INDEX IF EXISTS idx_sessions_refresh_token_hash;
+DROP INDEX IF EXISTS idx_sessions_access_token_hash;
+DROP INDEX IF EXISTS idx_sessions_refresh_expires_at;
+DROP INDEX IF EXISTS idx_sessions_family_id;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS revoked_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS refresh_expires_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS refresh_token_hash;
+ALTER TABLE sessions DROP COLUMN IF EXISTS access_token_hash;
+ALTER TABLE sessions DROP COLUMN IF EXISTS family_id;
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS access_token TEXT NOT NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_token TEXT NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_access_token ON sessions(access_token);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_token ON sessions(refresh_token);
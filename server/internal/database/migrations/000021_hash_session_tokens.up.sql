@@ -0,0 +1,17 @@
+-- Plaintext tokens cannot be kept, so every existing session is signed out
+DELETE FROM sessions;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS access_token;
+ALTER TABLE sessions DROP COLUMN IF EXISTS refresh_token;
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS family_id UUID NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS access_token_hash TEXT NOT NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_token_hash TEXT NOT NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS refresh_expires_at TIMESTAMP NOT NULL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_family_id ON sessions(family_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_refresh_expires_at ON sessions(refresh_expires_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_access_token_hash ON sessions(access_token_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_token_hash ON sessions(refresh_token_hash);
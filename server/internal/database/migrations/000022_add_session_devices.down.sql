@@ -0,0 +1,5 @@
+DELETE FROM app_settings WHERE key IN ('session_limit_student', 'session_limit_instructor', 'session_limit_staff', 'session_limit_admin');
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_seen_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
@@ -0,0 +1,12 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+
+UPDATE sessions SET last_seen_at = created_at WHERE last_seen_at IS NULL;
+
+INSERT INTO app_settings (key, value, description) VALUES
+    ('session_limit_student', '', 'Most devices a student can be signed in on at once, empty for no limit'),
+    ('session_limit_instructor', '', 'Most devices an instructor can be signed in on at once, empty for no limit'),
+    ('session_limit_staff', '', 'Most devices a staff member can be signed in on at once, empty for no limit'),
+    ('session_limit_admin', '', 'Most devices an admin can be signed in on at once, empty for no limit')
+ON CONFLICT (key) DO NOTHING;
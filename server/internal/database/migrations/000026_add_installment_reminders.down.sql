@@ -0,0 +1 @@
+ALTER TABLE installments DROP COLUMN IF EXISTS reminded_at;
@@ -0,0 +1 @@
+ALTER TABLE installments ADD COLUMN IF NOT EXISTS reminded_at TIMESTAMP;
@@ -0,0 +1 @@
+ALTER TABLE installments DROP COLUMN IF EXISTS last_charge_at;
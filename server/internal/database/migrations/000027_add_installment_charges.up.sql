@@ -0,0 +1 @@
+ALTER TABLE installments ADD COLUMN IF NOT EXISTS last_charge_at TIMESTAMP;
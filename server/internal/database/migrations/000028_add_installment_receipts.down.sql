@@ -0,0 +1,7 @@
+DELETE FROM invoices WHERE installment <> 0;
+
+DROP INDEX IF EXISTS idx_invoices_order_installment;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_order_id ON invoices(order_id);
+
+ALTER TABLE invoices DROP COLUMN IF EXISTS balance_minor;
+ALTER TABLE invoices DROP COLUMN IF EXISTS installment;
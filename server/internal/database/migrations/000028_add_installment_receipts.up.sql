@@ -0,0 +1,7 @@
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS installment INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS balance_minor BIGINT NOT NULL DEFAULT 0;
+
+UPDATE invoices SET balance_minor = total_minor - paid_minor WHERE balance_minor = 0;
+
+DROP INDEX IF EXISTS idx_invoices_order_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_order_installment ON invoices(order_id, installment);
@@ -0,0 +1,8 @@
+INSERT INTO app_settings (key, value, description) VALUES
+    ('session_limit_student', '', 'Most devices a student can be signed in on at once, empty for no limit'),
+    ('session_limit_instructor', '', 'Most devices an instructor can be signed in on at once, empty for no limit'),
+    ('session_limit_staff', '', 'Most devices a staff member can be signed in on at once, empty for no limit'),
+    ('session_limit_admin', '', 'Most devices an admin can be signed in on at once, empty for no limit')
+ON CONFLICT (key) DO NOTHING;
+
+ALTER TABLE payment_plans DROP COLUMN IF EXISTS max_sessions;
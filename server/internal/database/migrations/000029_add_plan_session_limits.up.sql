@@ -0,0 +1,4 @@
+ALTER TABLE payment_plans ADD COLUMN IF NOT EXISTS max_sessions INTEGER;
+
+-- Session limits are set per payment plan instead of per role
+DELETE FROM app_settings WHERE key IN ('session_limit_student', 'session_limit_instructor', 'session_limit_staff', 'session_limit_admin');
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_coupon_redemptions_coupon_order;
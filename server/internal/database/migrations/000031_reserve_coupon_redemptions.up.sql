@@ -0,0 +1,6 @@
+-- An order redeems each coupon once, checkout reserves it and payment keeps it
+DELETE FROM coupon_redemptions a
+    USING coupon_redemptions b
+    WHERE a.coupon_id = b.coupon_id AND a.order_id = b.order_id AND (a.created_at, a.id) > (b.created_at, b.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_coupon_redemptions_coupon_order ON coupon_redemptions(coupon_id, order_id);
@@ -0,0 +1 @@
+ALTER TABLE installments DROP COLUMN IF EXISTS tax_minor;
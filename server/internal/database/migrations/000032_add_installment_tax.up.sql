@@ -0,0 +1 @@
+ALTER TABLE installments ADD COLUMN IF NOT EXISTS tax_minor BIGINT NOT NULL DEFAULT 0;
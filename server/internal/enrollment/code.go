@@ -0,0 +1,72 @@
+package enrollment
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"services/internal/paypal"
+	"strings"
+)
+
+// codeAlphabet leaves out 0, 1, I, L, O and U so codes survive being read
+// out loud or typed from an email
+const codeAlphabet = "23456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Codes are codeGroups groups of codeGroupSize characters, about 78 bits of
+// randomness, so they cannot be guessed
+const (
+	codeGroups    = 4
+	codeGroupSize = 4
+)
+
+// NewCode returns a random redemption code such as 7KQX-M2VD-9HTR-C4PW
+func NewCode() (string, error) {
+	raw := make([]byte, codeGroups*codeGroupSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate enrollment code: %w", err)
+	}
+	// 256 is not a multiple of the alphabet, so bytes past the last full
+	// multiple are drawn again to keep every character equally likely
+	limit := byte(256 - 256%len(codeAlphabet))
+	for i := range raw {
+		for raw[i] >= limit {
+			var b [1]byte
+			if _, err := rand.Read(b[:]); err != nil {
+				return "", fmt.Errorf("failed to generate enrollment code: %w", err)
+			}
+			raw[i] = b[0]
+		}
+		raw[i] = codeAlphabet[int(raw[i])%len(codeAlphabet)]
+	}
+	return group(string(raw)), nil
+}
+
+// NormalizeCode returns the canonical form codes are stored and looked up in.
+// Case, spaces and dashes typed by the redeemer are ignored.
+func NormalizeCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if r == '-' || r == ' ' || r == '\t' {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return group(b.String())
+}
+
+// group splits a code into dash separated groups of codeGroupSize
+func group(code string) string {
+	var b strings.Builder
+	for i, r := range code {
+		if i > 0 && i%codeGroupSize == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// RedeemLink is the page of the client where a code is redeemed
+func RedeemLink(code string) string {
+	return paypal.FrontendURL() + "/redeem?code=" + url.QueryEscape(code)
+}
@@ -0,0 +1,44 @@
+package enrollment
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for range 1000 {
+		code, err := NewCode()
+		if err != nil {
+			t.Fatalf("NewCode() = %v", err)
+		}
+		if len(code) != 19 || strings.Count(code, "-") != 3 {
+			t.Fatalf("unexpected code format %q", code)
+		}
+		for _, r := range strings.ReplaceAll(code, "-", "") {
+			if !strings.ContainsRune(codeAlphabet, r) {
+				t.Fatalf("code %q has character %q outside the alphabet", code, r)
+			}
+		}
+		if seen[code] {
+			t.Fatalf("duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"7KQX-M2VD-9HTR-C4PW", "7KQX-M2VD-9HTR-C4PW"},
+		{"7kqx m2vd 9htr c4pw", "7KQX-M2VD-9HTR-C4PW"},
+		{" 7KQXM2VD9HTRC4PW ", "7KQX-M2VD-9HTR-C4PW"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeCode(tt.in); got != tt.want {
+			t.Errorf("NormalizeCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package installment
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"services/internal/models"
+	"services/internal/money"
+	"strconv"
+	"time"
+)
+
+const (
+	StatusScheduled = "SCHEDULED" // Not due yet
+	StatusOverdue   = "OVERDUE"   // Past due, still within the grace period
+	StatusDefaulted = "DEFAULTED" // Grace period over, access is suspended until paid
+	StatusPaid      = "PAID"
+)
+
+// DefaultGraceDays is how long an installment can stay unpaid past its due
+// date before the plan is in default
+const DefaultGraceDays = 7
+
+// DefaultReminderDays is how long before its due date the buyer is reminded
+// of an installment
+const DefaultReminderDays = 3
+
+var ErrInvalidPlan = errors.New("invalid payment plan")
+
+// Count is how many installments a plan is paid in. Plans created before
+// installments were supported are a single payment.
+func Count(plan *models.PaymentPlan) int {
+	if plan.Installments < 1 {
+		return 1
+	}
+	return plan.Installments
+}
+
+// Price is what a course costs on a plan: Amount per installment, less the
+// plan's percentage discount
+func Price(plan *models.PaymentPlan) money.Amount {
+	return (plan.Amount * money.Amount(Count(plan))).PercentOff(plan.Discount)
+}
+
+// Validate checks a plan can be used to buy its course
+func Validate(plan *models.PaymentPlan) error {
+	switch {
+	case plan.CourseID == "":
+		return fmt.Errorf("%w: course_id is required", ErrInvalidPlan)
+	case plan.Amount <= 0:
+		return fmt.Errorf("%w: amount must be positive", ErrInvalidPlan)
+	case plan.Installments < 0:
+		return fmt.Errorf("%w: installments cannot be negative", ErrInvalidPlan)
+	case plan.Discount < 0 || plan.Discount >= 100:
+		return fmt.Errorf("%w: discount must be between 0 and 100", ErrInvalidPlan)
+	case plan.MaxSessions != nil && *plan.MaxSessions <= 0:
+		return fmt.Errorf("%w: max_sessions must be positive", ErrInvalidPlan)
+	}
+	return nil
+}
+
+// Line is what one order item costs after discounts and tax, and how many
+// monthly installments it is paid over
+type Line struct {
+	Amount       money.Amount
+	Installments int
+}
+
+// Payment is one installment of a schedule
+type Payment struct {
+	Sequence int
+	Amount   money.Amount
+	DueAt    time.Time
+}
+
+// Schedule splits lines into monthly payments, the first due at start and
+// the rest on the same day of the following months, or on the last day of
+// months too short for it. Each line is divided evenly with the leftover
+// cents on its first installment, so the payments always add up to the
+// lines. Lines paid in one installment are due in full with the first
+// payment.
+func Schedule(lines []Line, start time.Time) []Payment {
+	var payments []Payment
+	for _, line := range lines {
+		n := max(line.Installments, 1)
+		share := line.Amount / money.Amount(n)
+		for i := 0; i < n; i++ {
+			if i == len(payments) {
+				payments = append(payments, Payment{Sequence: i + 1, DueAt: addMonths(start, i)})
+			}
+			payments[i].Amount += share
+		}
+		payments[0].Amount += line.Amount - share*money.Amount(n)
+	}
+	return payments
+}
+
+// addMonths moves t forward by months, keeping its day of the month unless
+// the target month is shorter. AddDate would roll over into the month after.
+func addMonths(t time.Time, months int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
+
+// GracePeriod is read from INSTALLMENT_GRACE_DAYS, defaulting to
+// DefaultGraceDays
+func GracePeriod() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("INSTALLMENT_GRACE_DAYS"))
+	if err != nil || days < 0 {
+		days = DefaultGraceDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ReminderLead is read from INSTALLMENT_REMINDER_DAYS, defaulting to
+// DefaultReminderDays
+func ReminderLead() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("INSTALLMENT_REMINDER_DAYS"))
+	if err != nil || days < 0 {
+		days = DefaultReminderDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// Status is the status an installment should have at now
+func Status(inst *models.Installment, now time.Time, grace time.Duration) string {
+	switch {
+	case inst.Status == StatusPaid:
+		return StatusPaid
+	case now.Before(inst.DueAt):
+		return StatusScheduled
+	case now.Before(inst.DueAt.Add(grace)):
+		return StatusOverdue
+	}
+	return StatusDefaulted
+}
@@ -0,0 +1,106 @@
+package installment
+
+import (
+	"errors"
+	"services/internal/models"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+
+func TestSchedule(t *testing.T) {
+	payments := Schedule([]Line{
+		{Amount: 10000, Installments: 3},
+		{Amount: 4999, Installments: 1},
+	}, start)
+
+	if len(payments) != 3 {
+		t.Fatalf("expected 3 payments, got %d", len(payments))
+	}
+	// 100.00 over 3 is 33.33 with the extra cent up front, plus 49.99 in full
+	if payments[0].Amount != 8333 || payments[1].Amount != 3333 || payments[2].Amount != 3333 {
+		t.Errorf("unexpected amounts %s, %s, %s", payments[0].Amount, payments[1].Amount, payments[2].Amount)
+	}
+	if !payments[0].DueAt.Equal(start) || payments[1].DueAt.Month() != time.February || payments[2].Sequence != 3 {
+		t.Errorf("unexpected schedule %+v", payments)
+	}
+}
+
+func TestSchedule_MonthEnd(t *testing.T) {
+	payments := Schedule([]Line{{Amount: 4000, Installments: 4}}, start)
+
+	// Starting on January 31st, each payment falls on the last day of shorter
+	// months without drifting into the month after
+	want := []time.Time{
+		start,
+		time.Date(2026, 2, 28, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 31, 12, 0, 0, 0, time.UTC),
+		time.Date(2026, 4, 30, 12, 0, 0, 0, time.UTC),
+	}
+	for i, payment := range payments {
+		if !payment.DueAt.Equal(want[i]) {
+			t.Errorf("payment %d: due %s, want %s", payment.Sequence, payment.DueAt.Format(time.DateOnly), want[i].Format(time.DateOnly))
+		}
+	}
+}
+
+func TestSchedule_MixedPlans(t *testing.T) {
+	payments := Schedule([]Line{
+		{Amount: 1000, Installments: 2},
+		{Amount: 900, Installments: 3},
+	}, start)
+
+	if len(payments) != 3 || payments[0].Amount != 800 || payments[1].Amount != 800 || payments[2].Amount != 300 {
+		t.Errorf("unexpected schedule %+v", payments)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	grace := 7 * 24 * time.Hour
+	due := models.Installment{DueAt: start, Status: StatusScheduled}
+	tests := []struct {
+		name string
+		inst models.Installment
+		now  time.Time
+		want string
+	}{
+		{name: "not due", inst: due, now: start.Add(-time.Hour), want: StatusScheduled},
+		{name: "in grace", inst: due, now: start.Add(6 * 24 * time.Hour), want: StatusOverdue},
+		{name: "defaulted", inst: due, now: start.Add(grace), want: StatusDefaulted},
+		{name: "paid", inst: models.Installment{DueAt: start, Status: StatusPaid}, now: start.Add(30 * 24 * time.Hour), want: StatusPaid},
+	}
+	for _, tt := range tests {
+		if got := Status(&tt.inst, tt.now, grace); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPriceAndValidate(t *testing.T) {
+	plan := &models.PaymentPlan{CourseID: "course-1", Amount: 3000, Installments: 9, Discount: 10}
+	if got := Price(plan); got != 24300 {
+		t.Errorf("Price() = %s, want 243.00", got)
+	}
+	if err := Validate(plan); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	legacy := &models.PaymentPlan{CourseID: "course-1", Amount: 19900}
+	if Count(legacy) != 1 || Price(legacy) != 19900 {
+		t.Errorf("expected a plan without installments to be one payment, got %d of %s", Count(legacy), Price(legacy))
+	}
+
+	invalid := []*models.PaymentPlan{
+		{Amount: 3000},
+		{CourseID: "course-1"},
+		{CourseID: "course-1", Amount: 3000, Installments: -1},
+		{CourseID: "course-1", Amount: 3000, Discount: 100},
+		{CourseID: "course-1", Amount: 3000, MaxSessions: new(int)},
+	}
+	for _, p := range invalid {
+		if err := Validate(p); !errors.Is(err, ErrInvalidPlan) {
+			t.Errorf("Validate(%+v) = %v, want ErrInvalidPlan", p, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package invoice
+
+import (
+	"fmt"
+	"services/internal/models"
+	"time"
+)
+
+// App settings the seller details are read from
+const (
+	SettingSellerName    = "invoice_seller_name"
+	SettingSellerAddress = "invoice_seller_address"
+	SettingSellerEmail   = "invoice_seller_email"
+	SettingSellerTaxID   = "invoice_seller_tax_id"
+)
+
+// Seller is who the invoice is issued by
+type Seller struct {
+	Name    string
+	Address string
+	Email   string
+	TaxID   string
+}
+
+// Number formats an invoice sequence the way it is printed
+func Number(sequence int64) string {
+	return fmt.Sprintf("INV-%06d", sequence)
+}
+
+// Build snapshots a paid order into an invoice. payment is the payment taken
+// at fulfillment, or nil when coupons covered the whole order; the number is
+// assigned by the caller. The receipt of a later installment is built the
+// same way, with its installment and balance set by the caller.
+func Build(order *models.Order, buyer *models.User, seller Seller, payment *models.Payment, issuedAt time.Time) *models.Invoice {
+	inv := &models.Invoice{
+		OrderID:       order.ID,
+		IssuedAt:      issuedAt,
+		SellerName:    seller.Name,
+		SellerAddress: seller.Address,
+		SellerEmail:   seller.Email,
+		SellerTaxID:   seller.TaxID,
+		BuyerName:     buyer.Name,
+		BuyerEmail:    buyer.Email,
+		BuyerProvince: order.TaxProvince,
+		Currency:      order.Currency,
+		Subtotal:      order.Subtotal,
+		Discount:      order.Discount,
+		TaxTotal:      order.TaxTotal,
+		Total:         order.TotalAmount,
+		Lines:         make([]models.InvoiceLine, 0, len(order.Items)),
+		Taxes:         make([]models.InvoiceTax, 0, len(order.Taxes)),
+	}
+	if payment != nil {
+		inv.Paid = payment.TransactionAmount
+		inv.PaymentMethod = payment.TransactionMethod
+		inv.PaymentReference = payment.ProviderTransactionID
+	}
+	inv.Balance = inv.Total - inv.Paid
+	if inv.BuyerProvince == "" {
+		inv.BuyerProvince = buyer.Province
+	}
+
+	for _, item := range order.Items {
+		line := models.InvoiceLine{
+			CourseID:    item.CourseID,
+			Description: item.CourseName,
+			Price:       item.Price,
+			Discount:    item.Discount,
+			Tax:         item.Tax,
+		}
+		if line.Description == "" {
+			line.Description = item.Course.Name
+		}
+		if item.Installments > 1 {
+			line.Installments = item.Installments
+		}
+		inv.Lines = append(inv.Lines, line)
+	}
+	for _, t := range order.Taxes {
+		inv.Taxes = append(inv.Taxes, models.InvoiceTax{Name: t.Name, Rate: t.Rate, Amount: t.Amount})
+	}
+	return inv
+}
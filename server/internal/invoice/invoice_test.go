@@ -0,0 +1,79 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"services/internal/models"
+	"testing"
+	"time"
+)
+
+func TestBuild(t *testing.T) {
+	order := &models.Order{
+		ID: "order-1", Subtotal: 30000, Discount: 3000, TaxTotal: 3510, TotalAmount: 30510, Currency: "CAD", TaxProvince: "ON",
+		Items: []models.OrderItem{
+			{CourseID: "course-1", CourseName: "Français A1", Price: 27000, Discount: 2700, Tax: 3159, Installments: 9},
+			{CourseID: "course-2", Course: models.Course{Name: "Grammar"}, Price: 3000, Discount: 300, Tax: 351, Installments: 1},
+		},
+		Taxes: []models.OrderTax{{Name: "HST", Rate: 13, Amount: 3510}},
+	}
+	buyer := &models.User{Name: "Jo", Email: "jo@example.com", Province: "QC"}
+	payment := &models.Payment{TransactionAmount: 6320, TransactionMethod: "PAYPAL", ProviderTransactionID: "CAPTURE-1"}
+
+	inv := Build(order, buyer, Seller{Name: "A1 French Classes", TaxID: "123"}, payment, time.Now())
+
+	if inv.BuyerProvince != "ON" || inv.Total != 30510 || inv.Paid != 6320 || inv.Balance != 24190 || inv.PaymentReference != "CAPTURE-1" {
+		t.Errorf("unexpected invoice %+v", inv)
+	}
+	if inv.Lines[0].Installments != 9 || inv.Lines[1].Installments != 0 || inv.Lines[1].Description != "Grammar" {
+		t.Errorf("unexpected lines %+v", inv.Lines)
+	}
+	if len(inv.Taxes) != 1 || inv.Taxes[0].Amount != 3510 {
+		t.Errorf("unexpected taxes %+v", inv.Taxes)
+	}
+}
+
+func TestNumber(t *testing.T) {
+	if got := Number(42); got != "INV-000042" {
+		t.Errorf("Number(42) = %q", got)
+	}
+}
+
+func TestPDF(t *testing.T) {
+	inv := &models.Invoice{
+		Number: "INV-000001", Currency: "CAD", SellerName: "A1 French Classes", BuyerName: "Zoé (student)",
+		Lines: []models.InvoiceLine{{Description: "Français A1", Price: 10000}},
+		Total: 10000, Paid: 10000,
+	}
+
+	pdf := PDF(inv)
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF document: %.40q", pdf)
+	}
+	// Accents are written as WinAnsi octal escapes and parentheses escaped
+	if !bytes.Contains(pdf, []byte(`(Zo\351 \(student\))`)) || !bytes.Contains(pdf, []byte(`(Fran\347ais A1)`)) {
+		t.Errorf("expected escaped buyer and course names in %s", pdf)
+	}
+
+	// The xref table must point at each object
+	start := bytes.LastIndex(pdf, []byte("startxref\n"))
+	var xref int
+	if _, err := fmt.Sscanf(string(pdf[start+len("startxref\n"):]), "%d", &xref); err != nil || !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Errorf("startxref does not point at the xref table")
+	}
+	if i := bytes.Index(pdf, []byte("3 0 obj")); !bytes.Contains(pdf, []byte(fmt.Sprintf("%010d 00000 n", i))) {
+		t.Errorf("xref is missing the offset of object 3")
+	}
+}
+
+func TestPDF_ManyLinesPaginate(t *testing.T) {
+	inv := &models.Invoice{Number: "INV-000002", Currency: "CAD"}
+	for i := 0; i < 60; i++ {
+		inv.Lines = append(inv.Lines, models.InvoiceLine{Description: fmt.Sprintf("Course %d", i), Price: 1000})
+	}
+
+	if pdf := PDF(inv); !bytes.Contains(pdf, []byte("/Count 2")) {
+		t.Errorf("expected the receipt to run over two pages")
+	}
+}
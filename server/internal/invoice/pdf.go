@@ -0,0 +1,268 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"services/internal/models"
+	"services/internal/money"
+	"strconv"
+	"strings"
+)
+
+// Letter size page, in points
+const (
+	pageWidth  = 612
+	pageHeight = 792
+	margin     = 50
+)
+
+// Columns of the line item table; amounts are right-aligned on x
+const (
+	colDescription = margin
+	colPrice       = 360
+	colDiscount    = 425
+	colTax         = 490
+	colAmount      = pageWidth - margin
+)
+
+// PDF renders an invoice as a PDF receipt. It only uses the standard
+// Helvetica fonts, so no font files are embedded.
+func PDF(inv *models.Invoice) []byte {
+	d := &document{}
+	d.newPage()
+
+	d.text(margin, d.y, fontBold, 20, "Receipt")
+	d.right(colAmount, d.y, fontBold, 12, inv.Number)
+	d.y -= 18
+	d.right(colAmount, d.y, fontRegular, 10, "Issued "+inv.IssuedAt.Format("January 2, 2006"))
+	d.y -= 30
+
+	top := d.y
+	d.block(margin, "From", inv.SellerName, inv.SellerAddress, inv.SellerEmail, taxIDLine(inv.SellerTaxID))
+	sellerBottom := d.y
+	d.y = top
+	d.block(pageWidth/2, "Billed to", inv.BuyerName, inv.BuyerEmail, inv.BuyerProvince)
+	d.y = min(d.y, sellerBottom) - 20
+
+	d.text(margin, d.y, fontRegular, 9, "Order "+inv.OrderID)
+	d.y -= 24
+
+	d.tableHeader()
+	for _, line := range inv.Lines {
+		if d.y < margin+60 {
+			d.newPage()
+			d.tableHeader()
+		}
+		description := line.Description
+		if line.Installments > 1 {
+			description += fmt.Sprintf(" (%d installments)", line.Installments)
+		}
+		d.text(colDescription, d.y, fontRegular, 10, truncate(description, 52))
+		d.right(colPrice, d.y, fontRegular, 10, line.Price.String())
+		d.right(colDiscount, d.y, fontRegular, 10, discountString(line.Discount))
+		d.right(colTax, d.y, fontRegular, 10, line.Tax.String())
+		d.right(colAmount, d.y, fontRegular, 10, (line.Price - line.Discount + line.Tax).String())
+		d.y -= 16
+	}
+	d.rule(d.y + 10)
+	d.y -= 8
+
+	if d.y < margin+140 {
+		d.newPage()
+	}
+	d.total("Subtotal", inv.Subtotal.String(), fontRegular)
+	if inv.Discount > 0 {
+		d.total("Discount", discountString(inv.Discount), fontRegular)
+	}
+	for _, t := range inv.Taxes {
+		d.total(fmt.Sprintf("%s (%s%%)", t.Name, strconv.FormatFloat(t.Rate, 'f', -1, 64)), t.Amount.String(), fontRegular)
+	}
+	d.total("Total", money.New(inv.Total, inv.Currency).String(), fontBold)
+	paid := "Paid"
+	if inv.Installment > 0 {
+		paid = fmt.Sprintf("Paid, installment %d", inv.Installment)
+	}
+	d.total(paid, money.New(inv.Paid, inv.Currency).String(), fontRegular)
+	if inv.Balance > 0 {
+		d.total("Balance due in installments", money.New(inv.Balance, inv.Currency).String(), fontRegular)
+	}
+	d.y -= 20
+
+	payment := "Paid"
+	if inv.PaymentMethod != "" {
+		payment += " by " + inv.PaymentMethod
+	}
+	if inv.PaymentReference != "" {
+		payment += ", reference " + inv.PaymentReference
+	}
+	d.text(margin, d.y, fontRegular, 9, payment)
+
+	return d.bytes()
+}
+
+func taxIDLine(taxID string) string {
+	if taxID == "" {
+		return ""
+	}
+	return "GST/HST " + taxID
+}
+
+func discountString(a money.Amount) string {
+	if a == 0 {
+		return a.String()
+	}
+	return "-" + a.String()
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
+
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+)
+
+// document lays out text top to bottom over as many pages as it needs
+type document struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func (d *document) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pageHeight - margin - 20
+}
+
+func (d *document) page() *bytes.Buffer {
+	return d.pages[len(d.pages)-1]
+}
+
+func (d *document) text(x, y float64, font string, size float64, s string) {
+	if s == "" {
+		return
+	}
+	fmt.Fprintf(d.page(), "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// right writes s so that it ends at x
+func (d *document) right(x, y float64, font string, size float64, s string) {
+	d.text(x-textWidth(s, size), y, font, size, s)
+}
+
+func (d *document) rule(y float64) {
+	fmt.Fprintf(d.page(), "0.5 w %d %.2f m %d %.2f l S\n", margin, y, pageWidth-margin, y)
+}
+
+// block writes a heading and the non-empty lines under it
+func (d *document) block(x float64, heading string, lines ...string) {
+	d.text(x, d.y, fontBold, 10, heading)
+	d.y -= 14
+	for _, line := range lines {
+		for _, part := range strings.Split(line, "\n") {
+			if part = strings.TrimSpace(part); part != "" {
+				d.text(x, d.y, fontRegular, 10, part)
+				d.y -= 13
+			}
+		}
+	}
+}
+
+func (d *document) tableHeader() {
+	d.text(colDescription, d.y, fontBold, 10, "Description")
+	d.right(colPrice, d.y, fontBold, 10, "Price")
+	d.right(colDiscount, d.y, fontBold, 10, "Discount")
+	d.right(colTax, d.y, fontBold, 10, "Tax")
+	d.right(colAmount, d.y, fontBold, 10, "Amount")
+	d.rule(d.y - 6)
+	d.y -= 22
+}
+
+func (d *document) total(label, amount, font string) {
+	d.right(colTax, d.y, font, 10, label)
+	d.right(colAmount, d.y, font, 10, amount)
+	d.y -= 16
+}
+
+func (d *document) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page is then a
+	// page object followed by its content stream
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// escape encodes s as a PDF string in WinAnsiEncoding. Latin-1 characters,
+// which covers French accents, map directly; anything else becomes '?'.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// textWidth approximates the width of s in Helvetica. Only amounts and short
+// labels are right-aligned, so average widths are close enough outside the
+// digits, which all share one width.
+func textWidth(s string, size float64) float64 {
+	var units float64
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			units += 556
+		case r == '.' || r == ',' || r == ' ' || r == '/':
+			units += 278
+		case r == '-' || r == '(' || r == ')':
+			units += 333
+		case r == '%':
+			units += 889
+		case r >= 'A' && r <= 'Z':
+			units += 667
+		default:
+			units += 520
+		}
+	}
+	return units * size / 1000
+}
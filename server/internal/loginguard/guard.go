@@ -0,0 +1,186 @@
+// Package loginguard slows down and locks out password guessing. Failed
+// logins are counted per account and per client IP, each with its own
+// Policy. A login that has to wait is refused with the time left, the
+// guard never sleeps on a request.
+package loginguard
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"services/internal/models"
+	"services/internal/repository"
+	"strings"
+	"time"
+)
+
+// Policy decides how a key's failed logins are slowed down and locked out
+type Policy struct {
+	FreeAttempts int           // Failures allowed before delays start
+	BaseDelay    time.Duration // Wait after the first failure past FreeAttempts, doubled for each one after
+	MaxDelay     time.Duration
+	LockAfter    int           // Failures that lock the key out
+	LockFor      time.Duration // How long a lockout lasts
+	Window       time.Duration // Failures older than this are forgotten
+}
+
+var (
+	// AccountPolicy guards one email address
+	AccountPolicy = Policy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second, LockAfter: 10, LockFor: 15 * time.Minute, Window: time.Hour}
+	// IPPolicy guards one client IP. It is looser since a school or office
+	// can share an address.
+	IPPolicy = Policy{FreeAttempts: 10, BaseDelay: time.Second, MaxDelay: 30 * time.Second, LockAfter: 50, LockFor: 15 * time.Minute, Window: time.Hour}
+)
+
+// Delay is how long to wait after the given number of failures before the
+// next attempt
+func (p Policy) Delay(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// wait is how long an attempt at now has to wait given the key's failures
+func (p Policy) wait(attempt *models.LoginAttempt, now time.Time) time.Duration {
+	if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+		return attempt.LockedUntil.Sub(now)
+	}
+	if attempt.Failures == 0 || attempt.LastFailureAt.Before(now.Add(-p.Window)) {
+		return 0
+	}
+	return max(attempt.LastFailureAt.Add(p.Delay(attempt.Failures)).Sub(now), 0)
+}
+
+// AccountKey is the counter key of an email address. Unknown addresses are
+// counted the same way, so the counters give away nothing about which exist.
+func AccountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey is the counter key of a client IP
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// AuditLog stores audit events so it can be mocked in tests
+type AuditLog interface {
+	Record(ctx context.Context, event *models.AuditEvent) error
+}
+
+type Guard struct {
+	logger   *slog.Logger
+	attempts repository.LoginAttemptRepository
+	audit    AuditLog
+	account  Policy
+	ip       Policy
+}
+
+func NewGuard(logger *slog.Logger, attempts repository.LoginAttemptRepository, audit AuditLog) *Guard {
+	return &Guard{
+		logger:   logger,
+		attempts: attempts,
+		audit:    audit,
+		account:  AccountPolicy,
+		ip:       IPPolicy,
+	}
+}
+
+// Reserve counts a login for email from ip as failed before its password is
+// checked, so parallel guesses cannot all get through the same wait. When
+// the login has to wait it counts nothing and returns how long; the caller
+// turns the login away rather than holding it. After a zero wait, report
+// the password check with Fail or Succeed.
+func (g *Guard) Reserve(ctx context.Context, email, ip string, now time.Time) (time.Duration, error) {
+	account := AccountKey(email)
+	_, wait, err := g.attempts.Reserve(ctx, account, now, g.account.Window, func(attempt *models.LoginAttempt) time.Duration {
+		return g.account.wait(attempt, now)
+	})
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+
+	_, wait, err = g.attempts.Reserve(ctx, IPKey(ip), now, g.ip.Window, func(attempt *models.LoginAttempt) time.Duration {
+		return g.ip.wait(attempt, now)
+	})
+	if err != nil || wait > 0 {
+		// The login never gets to the password, so it does not count
+		// against the account
+		if err := g.attempts.Release(ctx, account); err != nil {
+			g.logger.ErrorContext(ctx, "Failed to release login attempt", "key", account, "error", err)
+		}
+	}
+	return wait, err
+}
+
+// Fail locks out whichever of email and ip went over its policy's limit
+// with the attempt Reserve counted
+func (g *Guard) Fail(ctx context.Context, email, ip string, now time.Time) error {
+	if err := g.fail(ctx, AccountKey(email), g.account, ip, now); err != nil {
+		return err
+	}
+	return g.fail(ctx, IPKey(ip), g.ip, ip, now)
+}
+
+func (g *Guard) fail(ctx context.Context, key string, policy Policy, ip string, now time.Time) error {
+	attempt, err := g.attempts.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	// Parallel failures can all see the limit, only the first locks
+	if attempt.Failures < policy.LockAfter || (attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil)) {
+		return nil
+	}
+
+	until := now.Add(policy.LockFor)
+	if err := g.attempts.Lock(ctx, key, until); err != nil {
+		return err
+	}
+	g.logger.WarnContext(ctx, "Locked out login after too many failures", "key", key, "failures", attempt.Failures, "until", until, "ip", ip)
+	g.record(ctx, &models.AuditEvent{
+		Action:    models.AuditLoginLocked,
+		Subject:   key,
+		IPAddress: ip,
+		Detail:    fmt.Sprintf("%d failed logins, locked until %s", attempt.Failures, until.UTC().Format(time.RFC3339)),
+	})
+	return nil
+}
+
+// Succeed clears the account's failures after a correct password and takes
+// back the attempt Reserve counted against ip. The IP's earlier failures are
+// kept, or an attacker could clear them by logging in to their own account
+// between guesses.
+func (g *Guard) Succeed(ctx context.Context, email, ip string) error {
+	if err := g.attempts.Reset(ctx, AccountKey(email)); err != nil {
+		return err
+	}
+	return g.attempts.Release(ctx, IPKey(ip))
+}
+
+// Unlock lifts an account's lockout and clears its failures on behalf of
+// the admin actorID
+func (g *Guard) Unlock(ctx context.Context, email, actorID, ip string) error {
+	key := AccountKey(email)
+	if err := g.attempts.Reset(ctx, key); err != nil {
+		return err
+	}
+	g.record(ctx, &models.AuditEvent{
+		Action:    models.AuditLoginUnlocked,
+		ActorID:   &actorID,
+		Subject:   key,
+		IPAddress: ip,
+	})
+	return nil
+}
+
+// record stores an audit event. A failure is logged rather than returned,
+// the action it describes has already happened.
+func (g *Guard) record(ctx context.Context, event *models.AuditEvent) {
+	if err := g.audit.Record(ctx, event); err != nil {
+		g.logger.ErrorContext(ctx, "Failed to record audit event", "action", event.Action, "subject", event.Subject, "error", err)
+	}
+}
@@ -0,0 +1,201 @@
+package loginguard
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"services/internal/models"
+	"services/internal/repository"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type mockAuditLog struct {
+	events []*models.AuditEvent
+}
+
+func (m *mockAuditLog) Record(ctx context.Context, event *models.AuditEvent) error {
+	m.events = append(m.events, event)
+	return nil
+}
+
+func newTestGuard() (*Guard, *mockAuditLog) {
+	audit := &mockAuditLog{}
+	return NewGuard(slog.New(slog.NewTextHandler(io.Discard, nil)), repository.NewMemoryLoginAttemptRepository(), audit), audit
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{9, 30 * time.Second},
+		{1000, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := AccountPolicy.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// failLogin makes a login attempt with a wrong password, which must not
+// have to wait
+func failLogin(t *testing.T, g *Guard, email, ip string, now time.Time) {
+	t.Helper()
+	wait, err := g.Reserve(context.Background(), email, ip, now)
+	if err != nil {
+		t.Fatalf("Reserve() = %v", err)
+	}
+	if wait != 0 {
+		t.Fatalf("expected the attempt at %s not to wait, got %v", now.Format(time.TimeOnly), wait)
+	}
+	if err := g.Fail(context.Background(), email, ip, now); err != nil {
+		t.Fatalf("Fail() = %v", err)
+	}
+}
+
+func TestGuard_ProgressiveDelay(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// The free attempts and the one after them go straight through
+	for range AccountPolicy.FreeAttempts + 1 {
+		failLogin(t, g, "sam@example.com", "203.0.113.7", now)
+	}
+
+	if wait, _ := g.Reserve(ctx, "SAM@example.com ", "198.51.100.1", now); wait != time.Second {
+		t.Errorf("expected the account to wait 1s from any IP, got %v", wait)
+	}
+	if wait, _ := g.Reserve(ctx, "sam@example.com", "203.0.113.7", now.Add(time.Second)); wait != 0 {
+		t.Errorf("expected no wait once the delay passed, got %v", wait)
+	}
+}
+
+func TestGuard_ReserveCountsParallelAttempts(t *testing.T) {
+	g, _ := newTestGuard()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	var passed atomic.Int32
+	for i := range 20 {
+		wg.Go(func() {
+			ip := fmt.Sprintf("203.0.113.%d", i)
+			if wait, err := g.Reserve(context.Background(), "sam@example.com", ip, now); err == nil && wait == 0 {
+				passed.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	if got, want := int(passed.Load()), AccountPolicy.FreeAttempts+1; got != want {
+		t.Errorf("expected %d parallel guesses to get through, got %d", want, got)
+	}
+}
+
+func TestGuard_LocksAccountAndAudits(t *testing.T) {
+	ctx := context.Background()
+	g, audit := newTestGuard()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := range AccountPolicy.LockAfter {
+		// Spread over IPs so only the account counter reaches its limit,
+		// and wait out each delay
+		now = now.Add(AccountPolicy.MaxDelay)
+		failLogin(t, g, "sam@example.com", fmt.Sprintf("203.0.113.%d", i), now)
+	}
+
+	wait, err := g.Reserve(ctx, "sam@example.com", "198.51.100.1", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Reserve() = %v", err)
+	}
+	if wait != AccountPolicy.LockFor-time.Minute {
+		t.Errorf("expected the lockout to have %v left, got %v", AccountPolicy.LockFor-time.Minute, wait)
+	}
+	if len(audit.events) != 1 || audit.events[0].Action != models.AuditLoginLocked || audit.events[0].Subject != "email:sam@example.com" {
+		t.Fatalf("expected one lockout event for the account, got %+v", audit.events)
+	}
+
+	if err := g.Unlock(ctx, "Sam@Example.com", "admin-1", "192.0.2.1"); err != nil {
+		t.Fatalf("Unlock() = %v", err)
+	}
+	if wait, _ := g.Reserve(ctx, "sam@example.com", "198.51.100.1", now.Add(time.Minute)); wait != 0 {
+		t.Errorf("expected no wait after unlocking, got %v", wait)
+	}
+	if last := audit.events[len(audit.events)-1]; last.Action != models.AuditLoginUnlocked || last.ActorID == nil || *last.ActorID != "admin-1" {
+		t.Errorf("expected an unlock event by the admin, got %+v", last)
+	}
+}
+
+func TestGuard_LocksIPAcrossAccounts(t *testing.T) {
+	ctx := context.Background()
+	g, audit := newTestGuard()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := range IPPolicy.LockAfter {
+		now = now.Add(IPPolicy.MaxDelay)
+		failLogin(t, g, fmt.Sprintf("user%d@example.com", i), "203.0.113.7", now)
+	}
+
+	if wait, _ := g.Reserve(ctx, "someone@example.com", "203.0.113.7", now); wait != IPPolicy.LockFor {
+		t.Errorf("expected the IP to be locked out, got %v", wait)
+	}
+	if wait, _ := g.Reserve(ctx, "someone@example.com", "198.51.100.1", now); wait != 0 {
+		t.Errorf("expected the refused attempt not to count against the account, got %v", wait)
+	}
+	if len(audit.events) != 1 || audit.events[0].Subject != "ip:203.0.113.7" {
+		t.Errorf("expected one lockout event for the IP, got %+v", audit.events)
+	}
+}
+
+func TestGuard_SucceedKeepsIPFailures(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	failLogin(t, g, "sam@example.com", "203.0.113.7", now)
+	for i := range IPPolicy.FreeAttempts {
+		now = now.Add(IPPolicy.MaxDelay)
+		failLogin(t, g, fmt.Sprintf("user%d@example.com", i), "203.0.113.7", now)
+	}
+	now = now.Add(IPPolicy.MaxDelay)
+	if wait, err := g.Reserve(ctx, "sam@example.com", "203.0.113.7", now); err != nil || wait != 0 {
+		t.Fatalf("Reserve() = %v, %v", wait, err)
+	}
+	if err := g.Succeed(ctx, "sam@example.com", "203.0.113.7"); err != nil {
+		t.Fatalf("Succeed() = %v", err)
+	}
+
+	if wait, _ := g.Reserve(ctx, "sam@example.com", "198.51.100.1", now); wait != 0 {
+		t.Errorf("expected the account to be cleared, got %v", wait)
+	}
+	if wait, _ := g.Reserve(ctx, "someone@example.com", "203.0.113.7", now); wait != IPPolicy.Delay(IPPolicy.FreeAttempts+1) {
+		t.Errorf("expected the IP to still wait for its failures, got %v", wait)
+	}
+}
+
+func TestGuard_ForgetsOldFailures(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGuard()
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for range AccountPolicy.LockAfter - 1 {
+		now = now.Add(AccountPolicy.MaxDelay)
+		failLogin(t, g, "sam@example.com", "203.0.113.7", now)
+	}
+	later := now.Add(AccountPolicy.Window + time.Minute)
+	failLogin(t, g, "sam@example.com", "203.0.113.7", later)
+
+	if wait, _ := g.Reserve(ctx, "sam@example.com", "203.0.113.7", later); wait != 0 {
+		t.Errorf("expected the count to start over after the window, got %v", wait)
+	}
+}
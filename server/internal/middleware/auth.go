@@ -8,8 +8,13 @@ import (
 	"services/internal/models"
 	"services/internal/repository"
 	"strings"
+	"time"
 )
 
+// lastSeenInterval is how stale a session's last seen time may get before a
+// request updates it, so that not every request writes to the database
+const lastSeenInterval = 5 * time.Minute
+
 type AuthMiddleware struct {
 	sessionRepo repository.SessionRepository
 }
@@ -35,50 +40,103 @@ func sendJSONError(w http.ResponseWriter, message string, code int) {
 // Authenticate validates the JWT token and loads the session
 func (m *AuthMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract token from Authorization header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			sendJSONError(w, "Missing authorization header", http.StatusUnauthorized)
+		ctx, ok := m.authenticate(w, r)
+		if !ok {
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		// Check for Bearer token
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			sendJSONError(w, "Invalid authorization header format", http.StatusUnauthorized)
+// OptionalAuthenticate lets anonymous requests through without a user in
+// the context. A request that does send a token must still send a valid one.
+func (m *AuthMiddleware) OptionalAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
 			return
 		}
-
-		tokenString := parts[1]
-
-		// Validate JWT token
-		claims, err := auth.ValidateAccessToken(tokenString)
-		if err != nil {
-			sendJSONError(w, "Invalid or expired token", http.StatusUnauthorized)
+		ctx, ok := m.authenticate(w, r)
+		if !ok {
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-		// Verify session exists in database
-		session, err := m.sessionRepo.FindByAccessToken(r.Context(), tokenString)
-		if err != nil {
-			sendJSONError(w, "Invalid session", http.StatusUnauthorized)
-			return
-		}
+// authenticate loads the caller's session into the request context. It
+// writes the error response and returns false when the request has no valid
+// token.
+func (m *AuthMiddleware) authenticate(w http.ResponseWriter, r *http.Request) (context.Context, bool) {
+	// Extract token from Authorization header
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		sendJSONError(w, "Missing authorization header", http.StatusUnauthorized)
+		return nil, false
+	}
 
-		// Add user to context
-		ctx := context.WithValue(r.Context(), models.UserContextKey, session.User)
-		ctx = context.WithValue(ctx, models.UserIDContextKey, claims.UserID)
+	// Check for Bearer token
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		sendJSONError(w, "Invalid authorization header format", http.StatusUnauthorized)
+		return nil, false
+	}
 
-		next.ServeHTTP(w, r.WithContext(ctx))
+	tokenString := parts[1]
+
+	// Validate JWT token
+	claims, err := auth.ValidateAccessToken(tokenString)
+	if err != nil {
+		sendJSONError(w, "Invalid or expired token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// Verify session exists in database
+	session, err := m.sessionRepo.FindByAccessToken(r.Context(), tokenString)
+	if err != nil {
+		sendJSONError(w, "Invalid session", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	// Only shown to the user, so a failed update is not worth failing the request for
+	if now := time.Now(); now.Sub(session.LastSeenAt) > lastSeenInterval {
+		_ = m.sessionRepo.TouchSession(r.Context(), session.ID, now)
+	}
+
+	// Add user to context
+	ctx := context.WithValue(r.Context(), models.UserContextKey, session.User)
+	ctx = context.WithValue(ctx, models.UserIDContextKey, claims.UserID)
+	ctx = context.WithValue(ctx, models.RoleContextKey, claims.Role)
+	ctx = context.WithValue(ctx, models.ScopesContextKey, claims.Scopes)
+	ctx = context.WithValue(ctx, models.SessionFamilyContextKey, session.FamilyID)
+	return ctx, true
+}
+
+// RequireVerifiedEmail rejects requests from users who have not verified
+// their email address. It must run after Authenticate.
+func (m *AuthMiddleware) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := r.Context().Value(models.UserContextKey).(models.User)
+		if !ok || user.EmailVerifiedAt == nil {
+			sendJSONError(w, "Email address is not verified", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
-// RequireScope is a placeholder for future scope-based authorization
+// RequireScope rejects requests whose access token does not carry every one
+// of the given scopes. It must run after Authenticate.
 func (m *AuthMiddleware) RequireScope(scopes ...string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// TODO: Implement scope checking when scopes are defined
-			// For now, just pass through
+			granted, _ := r.Context().Value(models.ScopesContextKey).([]string)
+			for _, scope := range scopes {
+				if !auth.HasScope(granted, scope) {
+					sendJSONError(w, "Forbidden: missing required scope "+scope, http.StatusForbidden)
+					return
+				}
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"services/internal/auth"
+	"services/internal/models"
+	"services/internal/repository"
+	"testing"
+	"time"
+)
+
+func requestWithScopes(scopes []string) *http.Request {
+	req, _ := http.NewRequest("GET", "/api/leads", nil)
+	ctx := context.WithValue(req.Context(), models.ScopesContextKey, scopes)
+	return req.WithContext(ctx)
+}
+
+func TestRequireScope_Missing(t *testing.T) {
+	m := NewAuthMiddleware(nil)
+	called := false
+	h := m.RequireScope(auth.ScopeLeadsRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, requestWithScopes(auth.ScopesForRole(models.UserTypeStudent)))
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for student, got %d", rr.Code)
+	}
+	if called {
+		t.Error("expected handler not to be called")
+	}
+}
+
+func TestRequireScope_Granted(t *testing.T) {
+	m := NewAuthMiddleware(nil)
+	h := m.RequireScope(auth.ScopeLeadsRead)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, requestWithScopes(auth.ScopesForRole(models.UserTypeStaff)))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 for staff, got %d", rr.Code)
+	}
+}
+
+func TestRequireScope_NoClaimsInContext(t *testing.T) {
+	m := NewAuthMiddleware(nil)
+	h := m.RequireScope(auth.ScopeCoursesWrite)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req, _ := http.NewRequest("POST", "/api/courses", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without scopes, got %d", rr.Code)
+	}
+}
+
+func TestOptionalAuthenticate_Anonymous(t *testing.T) {
+	m := NewAuthMiddleware(nil)
+	h := m.OptionalAuthenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(models.UserIDContextKey).(string); ok {
+			t.Error("expected no user in the context")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req, _ := http.NewRequest("GET", "/api/cart", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected anonymous requests through, got %d", rr.Code)
+	}
+}
+
+func TestOptionalAuthenticate_InvalidToken(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	m := NewAuthMiddleware(nil)
+	h := m.OptionalAuthenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected the handler not to be called")
+	}))
+
+	req, _ := http.NewRequest("GET", "/api/cart", nil)
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for an invalid token, got %d", rr.Code)
+	}
+}
+
+// mockSessionRepo serves one session for any access token and records touches
+type mockSessionRepo struct {
+	repository.SessionRepository
+	session *models.Session
+	touched []string
+}
+
+func (m *mockSessionRepo) FindByAccessToken(ctx context.Context, token string) (*models.Session, error) {
+	return m.session, nil
+}
+func (m *mockSessionRepo) TouchSession(ctx context.Context, id string, at time.Time) error {
+	m.touched = append(m.touched, id)
+	return nil
+}
+
+func authenticatedRequest(t *testing.T) *http.Request {
+	t.Setenv("JWT_SECRET", "test-secret")
+	token, err := auth.GenerateAccessToken("user-1", "user@example.com", models.UserTypeStudent)
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+	req, _ := http.NewRequest("GET", "/api/user/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func TestAuthenticate_TouchesStaleSession(t *testing.T) {
+	tests := []struct {
+		name     string
+		lastSeen time.Duration
+		touched  bool
+	}{
+		{name: "seen recently", lastSeen: time.Minute, touched: false},
+		{name: "stale", lastSeen: time.Hour, touched: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mockSessionRepo{session: &models.Session{
+				ID:         "session-1",
+				UserID:     "user-1",
+				FamilyID:   "family-1",
+				LastSeenAt: time.Now().Add(-tt.lastSeen),
+			}}
+			m := NewAuthMiddleware(repo)
+			h := m.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if family, _ := r.Context().Value(models.SessionFamilyContextKey).(string); family != "family-1" {
+					t.Errorf("expected the session family in the context, got %q", family)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, authenticatedRequest(t))
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", rr.Code)
+			}
+			if touched := len(repo.touched) == 1; touched != tt.touched {
+				t.Errorf("expected touched %v, got %v", tt.touched, repo.touched)
+			}
+		})
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	verifiedAt := time.Now()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want int
+	}{
+		{name: "verified", ctx: context.WithValue(context.Background(), models.UserContextKey, models.User{EmailVerifiedAt: &verifiedAt}), want: http.StatusOK},
+		{name: "unverified", ctx: context.WithValue(context.Background(), models.UserContextKey, models.User{}), want: http.StatusForbidden},
+		{name: "no user in context", ctx: context.Background(), want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewAuthMiddleware(nil)
+			h := m.RequireVerifiedEmail(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req, _ := http.NewRequestWithContext(tt.ctx, "POST", "/api/checkout", nil)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, rr.Code)
+			}
+		})
+	}
+}
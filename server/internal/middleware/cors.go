@@ -9,7 +9,8 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all origins for now to fix the issue, or specify exact ones
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Cart-Token")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Cart-Token") // New guest carts hand their token back here
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
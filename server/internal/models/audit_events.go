@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audit event actions
+const (
+	AuditLoginLocked   = "login.locked"   // Too many failed logins for an account or IP
+	AuditLoginUnlocked = "login.unlocked" // An admin lifted an account's lockout
+)
+
+// AuditEvent records a security relevant action for later review
+type AuditEvent struct {
+	*gorm.Model
+	ID        string  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Action    string  `json:"action" db:"action" gorm:"not null;index"`
+	ActorID   *string `json:"actor_id,omitempty" db:"actor_id" gorm:"type:uuid"` // Nil when the system acted
+	Subject   string  `json:"subject" db:"subject" gorm:"index"`                 // What it was done to, such as a login attempt key
+	IPAddress string  `json:"ip_address" db:"ip_address" gorm:"type:varchar(45)"`
+	Detail    string  `json:"detail" db:"detail"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
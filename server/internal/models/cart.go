@@ -1,31 +1,40 @@
 package models
 
 import (
+	"services/internal/money"
 	"time"
 
 	"gorm.io/gorm"
 )
 
-// Cart represents a user's shopping cart
+// Cart represents a user's shopping cart, or a visitor's before they sign in
 type Cart struct {
 	*gorm.Model
-	ID        string     `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	UserID    string     `json:"user_id" db:"user_id" gorm:"type:uuid;not null"`
-	User      User       `json:"user,omitempty" gorm:"foreignKey:UserID;references:ID"`
-	Items     []CartItem `json:"items,omitempty" gorm:"foreignKey:CartID"`
-	CreatedAt time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time  `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+	ID        string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID    *string      `json:"user_id" db:"user_id" gorm:"type:uuid;index"` // Nil for guest carts
+	User      User         `json:"user,omitempty" gorm:"foreignKey:UserID;references:ID"`
+	Items     []CartItem   `json:"items,omitempty" gorm:"foreignKey:CartID"`
+	Coupons   []CartCoupon `json:"coupons,omitempty" gorm:"foreignKey:CartID"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time    `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+
+	// Last abandoned cart reminder, at most one per idle period
+	ReminderSentAt *time.Time `json:"-" db:"reminder_sent_at"`
 }
 
 // CartItem represents an item in the shopping cart
 type CartItem struct {
 	*gorm.Model
-	ID        string    `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	CartID    string    `json:"cart_id" db:"cart_id" gorm:"type:uuid;not null"`
-	Cart      Cart      `json:"cart,omitempty" gorm:"foreignKey:CartID;references:ID"`
-	CourseID  string    `json:"course_id" db:"course_id" gorm:"type:uuid;not null"`
-	Course    Course    `json:"course,omitempty" gorm:"foreignKey:CourseID;references:ID"`
-	Price     float64   `json:"price" db:"price"`
-	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+	ID        string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CartID    string       `json:"cart_id" db:"cart_id" gorm:"type:uuid;not null"`
+	Cart      Cart         `json:"cart,omitempty" gorm:"foreignKey:CartID;references:ID"`
+	CourseID  string       `json:"course_id" db:"course_id" gorm:"type:uuid;not null"`
+	Course    Course       `json:"course,omitempty" gorm:"foreignKey:CourseID;references:ID"`
+	Price     money.Amount `json:"price" db:"price_minor" gorm:"column:price_minor;not null;default:0"` // Plan price when PaymentPlanID is set
+	CreatedAt time.Time    `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time    `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+
+	// Set when the course is bought on a payment plan instead of in full
+	PaymentPlanID *uint        `json:"payment_plan_id,omitempty" db:"payment_plan_id"`
+	PaymentPlan   *PaymentPlan `json:"payment_plan,omitempty" gorm:"foreignKey:PaymentPlanID"`
 }
@@ -3,8 +3,11 @@ package models
 type ContextKey string
 
 const (
-	UserIDContextKey ContextKey = "user_id"
-	UserContextKey   ContextKey = "user"
+	UserIDContextKey        ContextKey = "user_id"
+	UserContextKey          ContextKey = "user"
+	RoleContextKey          ContextKey = "role"
+	ScopesContextKey        ContextKey = "scopes"
+	SessionFamilyContextKey ContextKey = "session_family"
 )
 
 const (
@@ -13,3 +16,11 @@ const (
 	OrderStatusFailed    = "failed"
 	OrderStatusCancelled = "cancelled"
 )
+
+// User types stored in User.Type. These double as authorization roles.
+const (
+	UserTypeStudent    = "student"
+	UserTypeInstructor = "instructor"
+	UserTypeStaff      = "staff"
+	UserTypeAdmin      = "admin"
+)
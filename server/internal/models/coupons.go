@@ -0,0 +1,65 @@
+package models
+
+import (
+	"services/internal/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Coupon is a promo code that discounts a cart without touching course prices
+type Coupon struct {
+	*gorm.Model
+	ID          string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Code        string       `json:"code" db:"code" gorm:"not null;uniqueIndex"` // Stored upper-case
+	Description string       `json:"description" db:"description"`
+	Type        string       `json:"type" db:"type" gorm:"not null;default:'PERCENT'"`                                   // PERCENT, FIXED
+	PercentOff  float64      `json:"percent_off" db:"percent_off"`                                                       // PERCENT coupons only
+	AmountOff   money.Amount `json:"amount_off" db:"amount_off_minor" gorm:"column:amount_off_minor;not null;default:0"` // FIXED coupons only, in the store currency
+	CourseIDs   []string     `json:"course_ids" db:"course_ids" gorm:"column:course_ids;type:jsonb;serializer:json"`     // Empty applies to every course
+	MinSubtotal money.Amount `json:"min_subtotal" db:"min_subtotal_minor" gorm:"column:min_subtotal_minor;not null;default:0"`
+	StartsAt    *time.Time   `json:"starts_at,omitempty" db:"starts_at"`
+	EndsAt      *time.Time   `json:"ends_at,omitempty" db:"ends_at"`
+	// Usage limits, zero means unlimited
+	MaxRedemptions        int       `json:"max_redemptions" db:"max_redemptions"`
+	MaxRedemptionsPerUser int       `json:"max_redemptions_per_user" db:"max_redemptions_per_user"`
+	Stackable             bool      `json:"stackable" db:"stackable"` // Whether it can be combined with other coupons
+	Active                bool      `json:"active" db:"active"`
+	CreatedAt             time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt             time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// CartCoupon is a coupon applied to a cart, kept in the order it was applied
+type CartCoupon struct {
+	*gorm.Model
+	ID        string    `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CartID    string    `json:"cart_id" db:"cart_id" gorm:"type:uuid;not null;index"`
+	CouponID  string    `json:"coupon_id" db:"coupon_id" gorm:"type:uuid;not null"`
+	Coupon    Coupon    `json:"coupon" gorm:"foreignKey:CouponID;references:ID"`
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+}
+
+// CouponRedemption records a coupon used by an order. It is reserved when
+// the order is placed and released if the order fails or expires unpaid.
+// Usage limits are counted from these rows.
+type CouponRedemption struct {
+	*gorm.Model
+	ID        string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	CouponID  string       `json:"coupon_id" db:"coupon_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_coupon_redemptions_coupon_order"`
+	UserID    string       `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index"`
+	OrderID   string       `json:"order_id" db:"order_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_coupon_redemptions_coupon_order"`
+	Amount    money.Amount `json:"amount" db:"amount_minor" gorm:"column:amount_minor;not null;default:0"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+}
+
+// OrderDiscount is a discount line on an order. The code and amount are
+// copied at checkout so reporting does not depend on the coupon row.
+type OrderDiscount struct {
+	*gorm.Model
+	ID        string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	OrderID   string       `json:"order_id" db:"order_id" gorm:"type:uuid;not null;index"`
+	CouponID  string       `json:"coupon_id" db:"coupon_id" gorm:"type:uuid;not null"`
+	Code      string       `json:"code" db:"code"`
+	Amount    money.Amount `json:"amount" db:"amount_minor" gorm:"column:amount_minor;not null;default:0"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+}
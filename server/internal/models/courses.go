@@ -1,6 +1,7 @@
 package models
 
 import (
+	"services/internal/money"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,27 +9,27 @@ import (
 
 type Course struct {
 	*gorm.Model
-	ID           string     `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	Name         string     `json:"name" db:"name"`
-	Description  string     `json:"description" db:"description"`
-	Duration     string     `json:"duration" db:"duration"`
-	Rating       float64    `json:"rating" db:"rating"`
-	ImageURL     string     `json:"image_url" db:"image_url"`
-	Difficulty   string     `json:"difficulty" db:"difficulty"`
-	CourseURL    string     `json:"course_url" db:"course_url"`
-	InstructorID string     `json:"instructor_id" db:"instructor_id" gorm:"type:uuid"`
-	Instructor   User       `json:"instructor" gorm:"foreignKey:InstructorID;references:ID"`
-	Price        float64    `json:"price" db:"price"`
-	Discount     float64    `json:"discount" db:"discount"`
-	NumLectures  int        `json:"num_lectures" db:"num_lectures"`
-	StartDate    *time.Time `json:"start_date,omitempty" db:"start_date"`
-	EndDate      *time.Time `json:"end_date,omitempty" db:"end_date"`
-	ClassTiming  string     `json:"class_timing" db:"class_timing"`
-	ThisIncludes []string   `json:"this_includes" db:"this_includes" gorm:"column:this_includes;type:jsonb;serializer:json"`
-	Reviews      []Review   `json:"reviews,omitempty" gorm:"foreignKey:CourseID"`
-	CreatedAt    time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
-	EnrolledAt   *time.Time `json:"enrolled_at,omitempty" gorm:"->"` // Virtual field for enrollment date
+	ID           string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Name         string       `json:"name" db:"name"`
+	Description  string       `json:"description" db:"description"`
+	Duration     string       `json:"duration" db:"duration"`
+	Rating       float64      `json:"rating" db:"rating"`
+	ImageURL     string       `json:"image_url" db:"image_url"`
+	Difficulty   string       `json:"difficulty" db:"difficulty"`
+	CourseURL    string       `json:"course_url" db:"course_url"`
+	InstructorID string       `json:"instructor_id" db:"instructor_id" gorm:"type:uuid"`
+	Instructor   User         `json:"instructor" gorm:"foreignKey:InstructorID;references:ID"`
+	Price        money.Amount `json:"price" db:"price_minor" gorm:"column:price_minor;not null;default:0"`
+	Discount     float64      `json:"discount" db:"discount"` // Percentage off Price
+	NumLectures  int          `json:"num_lectures" db:"num_lectures"`
+	StartDate    *time.Time   `json:"start_date,omitempty" db:"start_date"`
+	EndDate      *time.Time   `json:"end_date,omitempty" db:"end_date"`
+	ClassTiming  string       `json:"class_timing" db:"class_timing"`
+	ThisIncludes []string     `json:"this_includes" db:"this_includes" gorm:"column:this_includes;type:jsonb;serializer:json"`
+	Reviews      []Review     `json:"reviews,omitempty" gorm:"foreignKey:CourseID"`
+	CreatedAt    time.Time    `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt    time.Time    `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+	EnrolledAt   *time.Time   `json:"enrolled_at,omitempty" gorm:"->"` // Virtual field for enrollment date
 }
 
 type UserCourses struct {
@@ -39,4 +40,7 @@ type UserCourses struct {
 	Course    Course    `json:"course" gorm:"foreignKey:CourseID;references:ID"`
 	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+
+	// Set while an installment plan for the course is in default
+	SuspendedAt *time.Time `json:"suspended_at,omitempty" db:"suspended_at"`
 }
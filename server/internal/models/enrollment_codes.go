@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Kinds of enrollment code
+const (
+	EnrollmentCodeGift          = "GIFT"          // Minted when a gift order is paid
+	EnrollmentCodeComplimentary = "COMPLIMENTARY" // Issued by staff for free
+)
+
+// EnrollmentCode is a single-use code that enrolls whoever redeems it in a
+// course. Gift codes point back at the order and item that paid for them.
+type EnrollmentCode struct {
+	*gorm.Model
+	ID             string  `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Code           string  `json:"code" db:"code" gorm:"not null;uniqueIndex"` // Stored normalized, see enrollment.NormalizeCode
+	Kind           string  `json:"kind" db:"kind" gorm:"not null"`             // GIFT, COMPLIMENTARY
+	CourseID       string  `json:"course_id" db:"course_id" gorm:"type:uuid;not null"`
+	Course         Course  `json:"course,omitempty" gorm:"foreignKey:CourseID;references:ID"`
+	IssuedByID     string  `json:"issued_by_id" db:"issued_by_id" gorm:"type:uuid;not null;index"` // Buyer of a gift, or the staff member
+	OrderID        *string `json:"order_id,omitempty" db:"order_id" gorm:"type:uuid;index"`
+	OrderItemID    *string `json:"order_item_id,omitempty" db:"order_item_id" gorm:"type:uuid"`
+	RecipientEmail string  `json:"recipient_email,omitempty" db:"recipient_email"`
+	RecipientName  string  `json:"recipient_name,omitempty" db:"recipient_name"`
+	Message        string  `json:"message,omitempty" db:"message"`
+
+	RedeemedByID *string    `json:"redeemed_by_id,omitempty" db:"redeemed_by_id" gorm:"type:uuid"`
+	RedeemedAt   *time.Time `json:"redeemed_at,omitempty" db:"redeemed_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty" db:"revoked_at"` // Set when the gift order is refunded before redemption
+
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"services/internal/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Installment is one scheduled payment of an order bought on a payment plan.
+// The first installment is paid at checkout, the rest monthly after that.
+type Installment struct {
+	*gorm.Model
+	ID             string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	OrderID        string       `json:"order_id" db:"order_id" gorm:"type:uuid;not null;index"`
+	Sequence       int          `json:"sequence" db:"sequence" gorm:"not null"` // 1 is paid at checkout
+	Amount         money.Amount `json:"amount" db:"amount_minor" gorm:"column:amount_minor;not null;default:0"`
+	Tax            money.Amount `json:"tax" db:"tax_minor" gorm:"column:tax_minor;not null;default:0"` // sales tax included in Amount
+	DueAt          time.Time    `json:"due_at" db:"due_at" gorm:"not null;index"`
+	Status         string       `json:"status" db:"status" gorm:"default:'SCHEDULED'"` // SCHEDULED, OVERDUE, DEFAULTED, PAID
+	PaidAt         *time.Time   `json:"paid_at,omitempty" db:"paid_at"`
+	PaymentID      *string      `json:"payment_id,omitempty" db:"payment_id" gorm:"type:uuid"`
+	FailedAttempts int          `json:"failed_attempts" db:"failed_attempts"`
+	LastFailure    string       `json:"last_failure,omitempty" db:"last_failure"`
+	RemindedAt     *time.Time   `json:"-" db:"reminded_at"`    // when the buyer was told it is coming due
+	LastChargeAt   *time.Time   `json:"-" db:"last_charge_at"` // last automatic charge of a saved payment method
+	CreatedAt      time.Time    `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time    `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
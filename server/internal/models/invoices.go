@@ -0,0 +1,72 @@
+package models
+
+import (
+	"services/internal/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invoice is the receipt of a paid order. Everything printed on it is copied
+// when the order is fulfilled, so it reads the same after the seller, buyer
+// or courses change. Orders on a payment plan get one more receipt for each
+// installment paid after checkout.
+type Invoice struct {
+	*gorm.Model
+	ID          string    `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	OrderID     string    `json:"order_id" db:"order_id" gorm:"type:uuid;not null;uniqueIndex:idx_invoices_order_installment"`
+	Installment int       `json:"installment,omitempty" db:"installment" gorm:"not null;default:0;uniqueIndex:idx_invoices_order_installment"` // Installment paid, 0 for the invoice issued at checkout
+	Sequence    int64     `json:"sequence" db:"sequence" gorm:"not null;uniqueIndex"`                                                          // Gap-free, from invoice_counters
+	Number      string    `json:"number" db:"number" gorm:"not null;uniqueIndex"`                                                              // Sequence as printed, e.g. INV-000042
+	IssuedAt    time.Time `json:"issued_at" db:"issued_at" gorm:"not null"`
+	// Seller details from app settings
+	SellerName    string `json:"seller_name" db:"seller_name"`
+	SellerAddress string `json:"seller_address" db:"seller_address"`
+	SellerEmail   string `json:"seller_email" db:"seller_email"`
+	SellerTaxID   string `json:"seller_tax_id" db:"seller_tax_id"` // GST/HST registration number
+	// Buyer details from the user's profile
+	BuyerName     string `json:"buyer_name" db:"buyer_name"`
+	BuyerEmail    string `json:"buyer_email" db:"buyer_email"`
+	BuyerProvince string `json:"buyer_province" db:"buyer_province" gorm:"type:varchar(2)"`
+	// Amounts from the order
+	Currency string        `json:"currency" db:"currency" gorm:"type:varchar(3);not null"`
+	Subtotal money.Amount  `json:"subtotal" db:"subtotal_minor" gorm:"column:subtotal_minor;not null;default:0"`
+	Discount money.Amount  `json:"discount" db:"discount_minor" gorm:"column:discount_minor;not null;default:0"`
+	TaxTotal money.Amount  `json:"tax_total" db:"tax_total_minor" gorm:"column:tax_total_minor;not null;default:0"`
+	Total    money.Amount  `json:"total" db:"total_minor" gorm:"column:total_minor;not null;default:0"`
+	Paid     money.Amount  `json:"paid" db:"paid_minor" gorm:"column:paid_minor;not null;default:0"`          // Amount of the payment the invoice was issued for
+	Balance  money.Amount  `json:"balance" db:"balance_minor" gorm:"column:balance_minor;not null;default:0"` // Left to pay in installments afterwards
+	Lines    []InvoiceLine `json:"lines" db:"lines" gorm:"type:jsonb;serializer:json"`
+	Taxes    []InvoiceTax  `json:"taxes" db:"taxes" gorm:"type:jsonb;serializer:json"`
+	// Payment the invoice was issued for
+	PaymentMethod    string     `json:"payment_method" db:"payment_method"`
+	PaymentReference string     `json:"payment_reference" db:"payment_reference"` // PayPal capture ID
+	EmailedAt        *time.Time `json:"emailed_at,omitempty" db:"emailed_at"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// InvoiceLine is an order item as printed on an invoice
+type InvoiceLine struct {
+	CourseID     string       `json:"course_id"`
+	Description  string       `json:"description"`
+	Price        money.Amount `json:"price"`
+	Discount     money.Amount `json:"discount"`
+	Tax          money.Amount `json:"tax"`
+	Installments int          `json:"installments,omitempty"` // Set when bought on a payment plan
+}
+
+// InvoiceTax is a tax line as printed on an invoice
+type InvoiceTax struct {
+	Name   string       `json:"name"`
+	Rate   float64      `json:"rate"`
+	Amount money.Amount `json:"amount"`
+}
+
+// InvoiceCounter holds the last invoice number handed out. Numbers are taken
+// from it inside the fulfillment transaction, so a rolled back order does not
+// leave a gap.
+type InvoiceCounter struct {
+	Name  string `json:"name" db:"name" gorm:"primaryKey"`
+	Value int64  `json:"value" db:"value" gorm:"not null;default:0"`
+}
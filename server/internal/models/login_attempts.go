@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LoginAttempt counts the recent failed logins of one key, an email address
+// or a client IP. It is shared by every server replica.
+type LoginAttempt struct {
+	*gorm.Model
+	ID            string     `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Key           string     `json:"key" db:"key" gorm:"not null;uniqueIndex"` // email:<address> or ip:<address>
+	Failures      int        `json:"failures" db:"failures" gorm:"not null;default:0"`
+	LastFailureAt time.Time  `json:"last_failure_at" db:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until,omitempty" db:"locked_until"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"services/internal/money"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,26 +9,54 @@ import (
 
 type Order struct {
 	*gorm.Model
-	ID          string      `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	UserID      string      `json:"user_id" db:"user_id" gorm:"type:uuid;not null"`
-	User        User        `json:"user,omitempty" gorm:"foreignKey:UserID;references:ID"`
-	TotalAmount float64     `json:"total_amount" db:"total_amount"`
-	Status      string      `json:"status" db:"status" gorm:"default:'PENDING'"` // PENDING, COMPLETED, FAILED, CANCELLED
-	Items       []OrderItem `json:"items,omitempty" gorm:"foreignKey:OrderID;references:ID"`
-	Payments    []Payment   `json:"payments,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	ID          string           `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID      string           `json:"user_id" db:"user_id" gorm:"type:uuid;not null"`
+	User        User             `json:"user,omitempty" gorm:"foreignKey:UserID;references:ID"`
+	Subtotal    money.Amount     `json:"subtotal" db:"subtotal_minor" gorm:"column:subtotal_minor;not null;default:0"` // Before coupon discounts
+	Discount    money.Amount     `json:"discount" db:"discount_minor" gorm:"column:discount_minor;not null;default:0"`
+	TaxTotal    money.Amount     `json:"tax_total" db:"tax_total_minor" gorm:"column:tax_total_minor;not null;default:0"`
+	TaxProvince string           `json:"tax_province,omitempty" db:"tax_province" gorm:"type:varchar(2)"`
+	TotalAmount money.Amount     `json:"total_amount" db:"total_amount_minor" gorm:"column:total_amount_minor;not null;default:0"` // Subtotal - Discount + TaxTotal
+	Currency    string           `json:"currency" db:"currency" gorm:"type:varchar(3);not null;default:'USD'"`                     // Orders placed before currency support were charged in USD
+	Status      string           `json:"status" db:"status" gorm:"default:'PENDING'"`                                              // PENDING, COMPLETED, FAILED, CANCELLED, REFUNDED, PARTIALLY_REFUNDED
+	Items       []OrderItem      `json:"items,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	Payments    []Payment        `json:"payments,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	Attempts    []PaymentAttempt `json:"attempts,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	Discounts   []OrderDiscount  `json:"discounts,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	Taxes       []OrderTax       `json:"taxes,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	// Set when part of the order is bought on a payment plan
+	Installments []Installment `json:"installments,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	// Set when the order is a gift. Its courses are not given to the buyer,
+	// fulfillment mints an enrollment code per course for the recipient.
+	GiftRecipientEmail string `json:"gift_recipient_email,omitempty" db:"gift_recipient_email"`
+	GiftRecipientName  string `json:"gift_recipient_name,omitempty" db:"gift_recipient_name"`
+	GiftMessage        string `json:"gift_message,omitempty" db:"gift_message"`
 
 	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
 }
 
+// IsGift reports whether the order was bought for someone else
+func (o *Order) IsGift() bool {
+	return o.GiftRecipientEmail != ""
+}
+
 type OrderItem struct {
 	*gorm.Model
-	ID        string    `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	OrderID   string    `json:"order_id" db:"order_id" gorm:"type:uuid;not null"`
-	Order     Order     `json:"order,omitempty" gorm:"foreignKey:OrderID;references:ID"`
-	CourseID  string    `json:"course_id" db:"course_id" gorm:"type:uuid;not null"`
-	Course    Course    `json:"course,omitempty" gorm:"foreignKey:CourseID;references:ID"`
-	Price     float64   `json:"price" db:"price"`
-	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+	ID       string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	OrderID  string       `json:"order_id" db:"order_id" gorm:"type:uuid;not null"`
+	Order    Order        `json:"order,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	CourseID string       `json:"course_id" db:"course_id" gorm:"type:uuid;not null"`
+	Course   Course       `json:"course,omitempty" gorm:"foreignKey:CourseID;references:ID"`
+	Price    money.Amount `json:"price" db:"price_minor" gorm:"column:price_minor;not null;default:0"`
+	Discount money.Amount `json:"discount" db:"discount_minor" gorm:"column:discount_minor;not null;default:0"` // Share of the order's coupon discounts
+	Tax      money.Amount `json:"tax" db:"tax_minor" gorm:"column:tax_minor;not null;default:0"`                // Tax on Price - Discount
+	// Payment plan the course was bought on and how many installments it is paid in
+	PaymentPlanID *uint `json:"payment_plan_id,omitempty" db:"payment_plan_id"`
+	Installments  int   `json:"installments" db:"installments" gorm:"not null;default:1"`
+	// Snapshot of the course at purchase time, kept even if the course changes
+	CourseName     string    `json:"course_name" db:"course_name"`
+	CourseImageURL string    `json:"course_image_url" db:"course_image_url"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt      time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PasswordReset is a one-time token emailed to a user who forgot their
+// password. Only the token's hash is stored.
+type PasswordReset struct {
+	*gorm.Model
+	ID        string     `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID    string     `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index"`
+	TokenHash string     `json:"-" db:"token_hash" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"services/internal/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PaymentAttempt is one provider order created for a local order by Checkout
+// or RetryOrder. A capture is only accepted for a known attempt, and captures
+// that do not match the order are kept here for review.
+type PaymentAttempt struct {
+	*gorm.Model
+	ID              string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	OrderID         string       `json:"order_id" db:"order_id" gorm:"type:uuid;not null;index"`
+	Provider        string       `json:"provider" db:"provider" gorm:"not null"`
+	ProviderOrderID string       `json:"provider_order_id" db:"provider_order_id" gorm:"not null;uniqueIndex"`
+	Amount          money.Amount `json:"amount" db:"amount_minor" gorm:"column:amount_minor;not null;default:0"`
+	Currency        string       `json:"currency" db:"currency"`
+	Status          string       `json:"status" db:"status" gorm:"default:'CREATED'"` // CREATED, CAPTURED, MISMATCH, VOIDED
+	CaptureID       string       `json:"capture_id,omitempty" db:"capture_id"`
+	MismatchReason  string       `json:"mismatch_reason,omitempty" db:"mismatch_reason"`
+	InstallmentID   *string      `json:"installment_id,omitempty" db:"installment_id" gorm:"type:uuid;index"` // Set when the attempt pays one installment
+
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
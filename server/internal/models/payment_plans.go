@@ -1,6 +1,7 @@
 package models
 
 import (
+	"services/internal/money"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,12 +9,17 @@ import (
 
 type PaymentPlan struct {
 	*gorm.Model
-	ID       uint    `json:"id" db:"id" gorm:"primaryKey"`
-	Amount   float64 `json:"amount" db:"amount"`
-	CourseID string  `json:"course_id" db:"course_id" gorm:"type:uuid"`
-	Course   Course  `json:"course" gorm:"foreignKey:CourseID"`
-	Duration string  `json:"duration" db:"duration"`
-	Discount float64 `json:"discount" db:"discount"`
+	ID       uint         `json:"id" db:"id" gorm:"primaryKey"`
+	Amount   money.Amount `json:"amount" db:"amount_minor" gorm:"column:amount_minor;not null;default:0"`
+	CourseID string       `json:"course_id" db:"course_id" gorm:"type:uuid"`
+	Course   Course       `json:"course" gorm:"foreignKey:CourseID"`
+	Duration string       `json:"duration" db:"duration"`
+	Discount float64      `json:"discount" db:"discount"` // Percentage off Amount x Installments
+
+	// Number of monthly payments of Amount, 1 for a single payment
+	Installments int `json:"installments" db:"installments" gorm:"not null;default:1"`
+	// Most devices a buyer of the plan can be signed in on at once, nil for no limit
+	MaxSessions *int `json:"max_sessions,omitempty" db:"max_sessions"`
 
 	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
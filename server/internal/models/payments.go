@@ -1,6 +1,7 @@
 package models
 
 import (
+	"services/internal/money"
 	"time"
 
 	"gorm.io/gorm"
@@ -8,15 +9,20 @@ import (
 
 type Payment struct {
 	*gorm.Model
-	ID                  string    `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	OrderID             string    `json:"order_id" db:"order_id" gorm:"type:uuid;not null"`
-	Order               Order     `json:"order,omitempty" gorm:"foreignKey:OrderID;references:ID"`
-	TransactionAmount   float64   `json:"transaction_amount" db:"transaction_amount"`
-	TransactionDate     time.Time `json:"transaction_date" db:"transaction_date" gorm:"autoCreateTime"`
-	TransactionMethod   string    `json:"transaction_method" db:"transaction_method"`
-	TransactionStatus   string    `json:"transaction_status" db:"transaction_status" gorm:"default:'PENDING'"`
-	TransactionID       string    `json:"transaction_id" db:"transaction_id"`
-	PayPalTransactionID string    `json:"paypal_transaction_id" db:"paypal_transaction_id"`
+	ID                    string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	OrderID               string       `json:"order_id" db:"order_id" gorm:"type:uuid;not null"`
+	Order                 Order        `json:"order,omitempty" gorm:"foreignKey:OrderID;references:ID"`
+	TransactionAmount     money.Amount `json:"transaction_amount" db:"transaction_amount_minor" gorm:"column:transaction_amount_minor;not null;default:0"`
+	Currency              string       `json:"currency" db:"currency" gorm:"type:varchar(3);not null;default:'USD'"` // Payments made before currency support were in USD
+	TransactionDate       time.Time    `json:"transaction_date" db:"transaction_date" gorm:"autoCreateTime"`
+	TransactionMethod     string       `json:"transaction_method" db:"transaction_method"`
+	TransactionStatus     string       `json:"transaction_status" db:"transaction_status" gorm:"default:'PENDING'"`
+	TransactionType       string       `json:"transaction_type" db:"transaction_type" gorm:"default:'PAYMENT'"` // PAYMENT, REFUND
+	TransactionID         string       `json:"transaction_id" db:"transaction_id"`
+	Provider              string       `json:"provider" db:"provider" gorm:"not null;default:'PAYPAL'"`                   // PAYPAL, STRIPE. Payments made before Stripe support were through PayPal
+	ProviderSessionID     string       `json:"provider_session_id,omitempty" db:"provider_session_id"`                    // PayPal order or Stripe Checkout Session paid through
+	ProviderTransactionID string       `json:"provider_transaction_id" db:"provider_transaction_id" gorm:"index"`         // PayPal capture or refund, Stripe payment intent or refund
+	ParentPaymentID       *string      `json:"parent_payment_id,omitempty" db:"parent_payment_id" gorm:"type:uuid;index"` // Set on refunds, points at the refunded payment
 
 	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
@@ -16,4 +16,19 @@ var AllModels = []any{
 	&OrderItem{},
 	&Lead{},
 	&AppSetting{},
+	&WebhookEvent{},
+	&PaymentAttempt{},
+	&Coupon{},
+	&CartCoupon{},
+	&CouponRedemption{},
+	&OrderDiscount{},
+	&TaxRate{},
+	&OrderTax{},
+	&Installment{},
+	&Invoice{},
+	&InvoiceCounter{},
+	&EnrollmentCode{},
+	&PasswordReset{},
+	&LoginAttempt{},
+	&AuditEvent{},
 }
@@ -6,14 +6,26 @@ import (
 	"gorm.io/gorm"
 )
 
+// Session is one access and refresh token pair. Only hashes of the tokens are
+// stored. Refreshing rotates the session into a new one of the same family, so
+// every session descended from one login shares a FamilyID.
 type Session struct {
 	*gorm.Model
-	ID           string    `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
-	UserID       string    `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index"`
-	User         User      `json:"user" gorm:"foreignKey:UserID"`
-	AccessToken  string    `json:"access_token" db:"access_token" gorm:"uniqueIndex;not null"`
-	RefreshToken string    `json:"refresh_token" db:"refresh_token" gorm:"uniqueIndex;not null"`
-	ExpiresAt    time.Time `json:"expires_at" db:"expires_at" gorm:"not null"`
+	ID               string     `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	UserID           string     `json:"user_id" db:"user_id" gorm:"type:uuid;not null;index"`
+	User             User       `json:"user" gorm:"foreignKey:UserID"`
+	FamilyID         string     `json:"family_id" db:"family_id" gorm:"type:uuid;not null;index;default:gen_random_uuid()"`
+	AccessTokenHash  string     `json:"-" db:"access_token_hash" gorm:"uniqueIndex;not null"`
+	RefreshTokenHash string     `json:"-" db:"refresh_token_hash" gorm:"uniqueIndex;not null"`
+	ExpiresAt        time.Time  `json:"expires_at" db:"expires_at" gorm:"not null"`
+	RefreshExpiresAt time.Time  `json:"refresh_expires_at" db:"refresh_expires_at" gorm:"not null;index"`
+	RotatedAt        *time.Time `json:"rotated_at,omitempty" db:"rotated_at"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
+
+	// Where the session was signed in or last refreshed from
+	UserAgent  string    `json:"user_agent" db:"user_agent"`
+	IPAddress  string    `json:"ip_address" db:"ip_address" gorm:"type:varchar(45)"`
+	LastSeenAt time.Time `json:"last_seen_at" db:"last_seen_at"`
 
 	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"services/internal/money"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TaxRate is one sales tax charged in a province, such as GST or QST. A
+// province's rates are added together; none are compounded.
+type TaxRate struct {
+	*gorm.Model
+	ID        string    `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	Province  string    `json:"province" db:"province" gorm:"type:varchar(2);not null;index"` // ISO 3166-2:CA code without the country, e.g. ON
+	Name      string    `json:"name" db:"name" gorm:"not null"`                               // GST, HST, PST, QST
+	Rate      float64   `json:"rate" db:"rate" gorm:"type:decimal(7,4);not null"`             // Percent, e.g. 9.975
+	CreatedAt time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+}
+
+// OrderTax is one tax charged on an order, copied from the rate in force at
+// checkout
+type OrderTax struct {
+	*gorm.Model
+	ID        string       `json:"id" db:"id" gorm:"primaryKey;type:uuid;default:gen_random_uuid()"`
+	OrderID   string       `json:"order_id" db:"order_id" gorm:"type:uuid;not null;index"`
+	Name      string       `json:"name" db:"name"`
+	Rate      float64      `json:"rate" db:"rate" gorm:"type:decimal(7,4)"`
+	Amount    money.Amount `json:"amount" db:"amount_minor" gorm:"column:amount_minor;not null;default:0"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+}
@@ -16,6 +16,14 @@ type User struct {
 	Type         string    `json:"type" db:"type"`
 	MobileNumber string    `json:"mobile_number" db:"mobile_number"`
 	DateOfBirth  string    `json:"dob" db:"dob"`
+	Province     string    `json:"province" db:"province" gorm:"type:varchar(2)"` // Used for sales tax
 	CreatedAt    time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at" gorm:"autoUpdateTime"`
+
+	// Set once the user proved they own Email, by link or through Google
+	EmailVerifiedAt *time.Time `json:"email_verified_at" db:"email_verified_at"`
+	// When the last verification email went out, to throttle resends
+	VerificationSentAt *time.Time `json:"-" db:"verification_sent_at"`
+	// When the last password reset email went out, to throttle requests
+	PasswordResetSentAt *time.Time `json:"-" db:"password_reset_sent_at"`
 }
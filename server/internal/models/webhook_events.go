@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+// WebhookEvent records every provider webhook delivery we have processed so
+// redeliveries of the same event ID are ignored
+type WebhookEvent struct {
+	ID         string    `json:"id" db:"id" gorm:"primaryKey"`
+	Provider   string    `json:"provider" db:"provider" gorm:"not null"`
+	EventType  string    `json:"event_type" db:"event_type" gorm:"not null"`
+	ResourceID string    `json:"resource_id" db:"resource_id" gorm:"index"`
+	Payload    string    `json:"payload" db:"payload" gorm:"type:jsonb"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at" gorm:"autoCreateTime"`
+}
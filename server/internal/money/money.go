@@ -0,0 +1,149 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the store currency when CURRENCY is not set
+const DefaultCurrency = "CAD"
+
+var ErrInvalidAmount = errors.New("invalid money amount")
+
+// Amount is an exact monetary amount in minor units (cents). It is stored as
+// a BIGINT and encoded in JSON as a decimal number such as 49.99, the same
+// shape clients got from the float fields it replaced.
+type Amount int64
+
+// Money is an amount together with its ISO 4217 currency code
+type Money struct {
+	Amount   Amount
+	Currency string
+}
+
+// New pairs an amount with a currency
+func New(amount Amount, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Currency returns the configured store currency
+func Currency() string {
+	if c := strings.ToUpper(strings.TrimSpace(os.Getenv("CURRENCY"))); c != "" {
+		return c
+	}
+	return DefaultCurrency
+}
+
+// Parse reads a decimal string such as "49.99", "-5" or "10.5" exactly,
+// without going through float64. More than two decimal places are rejected
+// unless the extra digits are zeros.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > 2 {
+		return 0, fmt.Errorf("%w: %q has more than two decimal places", ErrInvalidAmount, s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if whole == "" {
+		whole = "0"
+	}
+
+	units, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	cents, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if units > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("%w: %q is out of range", ErrInvalidAmount, s)
+	}
+
+	amount := Amount(units*100 + cents)
+	if neg {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// String formats the amount with exactly two decimal places
+func (a Amount) String() string {
+	sign := ""
+	v := int64(a)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+// PercentOff returns the amount reduced by pct percent, rounded half up to
+// the cent. pct is a rate such as Course.Discount, not money, so it stays a
+// float; it is resolved to basis points before any arithmetic.
+func (a Amount) PercentOff(pct float64) Amount {
+	if pct <= 0 {
+		return a
+	}
+	if pct >= 100 {
+		return 0
+	}
+	bp := int64(math.Round(pct * 100))
+	discount := (int64(a)*bp + 5000) / 10000
+	return a - Amount(discount)
+}
+
+// Percent returns pct percent of the amount, rounded half up to the cent. pct
+// is resolved to a ten-thousandth of a percent, which is enough for rates such
+// as Quebec's 9.975% QST.
+func (a Amount) Percent(pct float64) Amount {
+	if pct <= 0 {
+		return 0
+	}
+	ppm := int64(math.Round(pct * 10000))
+	return Amount((int64(a)*ppm + 500000) / 1000000)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	if strings.ContainsAny(s, "eE") {
+		// Exponent notation only comes from float serialisers; resolve it to
+		// a plain decimal first
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// String formats the money as "49.99 CAD"
+func (m Money) String() string {
+	return m.Amount.String() + " " + m.Currency
+}
@@ -0,0 +1,122 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{in: "49.99", want: 4999},
+		{in: "10", want: 1000},
+		{in: "10.5", want: 1050},
+		{in: ".05", want: 5},
+		{in: "-5.25", want: -525},
+		{in: "0.10000", want: 10},
+		{in: "0.001", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "1.2.3", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.wantErr {
+			if !errors.Is(err, ErrInvalidAmount) {
+				t.Errorf("Parse(%q) error = %v, want ErrInvalidAmount", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	for amount, want := range map[Amount]string{0: "0.00", 5: "0.05", 4999: "49.99", -525: "-5.25", 100000: "1000.00"} {
+		if got := amount.String(); got != want {
+			t.Errorf("Amount(%d).String() = %s, want %s", int64(amount), got, want)
+		}
+	}
+}
+
+func TestPercentOff(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		pct    float64
+		want   Amount
+	}{
+		{amount: 9999, pct: 0, want: 9999},
+		{amount: 9999, pct: 10, want: 8999}, // 9.999 off rounds to 10.00
+		{amount: 4999, pct: 15, want: 4249}, // 7.4985 off rounds to 7.50
+		{amount: 1000, pct: 12.5, want: 875},
+		{amount: 1000, pct: 100, want: 0},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.PercentOff(tt.pct); got != tt.want {
+			t.Errorf("%s.PercentOff(%v) = %s, want %s", tt.amount, tt.pct, got, tt.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		pct    float64
+		want   Amount
+	}{
+		{amount: 10000, pct: 13, want: 1300},
+		{amount: 4999, pct: 5, want: 250},      // 2.4995 rounds up
+		{amount: 4999, pct: 9.975, want: 499},  // 4.9865 rounds up
+		{amount: 10000, pct: 9.975, want: 998}, // 9.975 rounds up
+		{amount: 10000, pct: 0, want: 0},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Percent(tt.pct); got != tt.want {
+			t.Errorf("%s.Percent(%v) = %s, want %s", tt.amount, tt.pct, got, tt.want)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var v struct {
+		Price Amount `json:"price"`
+	}
+	for in, want := range map[string]Amount{
+		`{"price": 49.99}`:   4999,
+		`{"price": "49.99"}`: 4999,
+		`{"price": 5}`:       500,
+		`{"price": 1e2}`:     10000,
+	} {
+		if err := json.Unmarshal([]byte(in), &v); err != nil || v.Price != want {
+			t.Errorf("Unmarshal(%s) = %d, %v, want %d", in, v.Price, err, want)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`{"price": 0.001}`), &v); err == nil {
+		t.Error("expected an error for sub-cent amounts")
+	}
+
+	out, _ := json.Marshal(struct {
+		Price Amount `json:"price"`
+	}{Price: 4999})
+	if string(out) != `{"price":49.99}` {
+		t.Errorf("Marshal = %s", out)
+	}
+}
+
+func TestCurrency(t *testing.T) {
+	t.Setenv("CURRENCY", "")
+	if got := Currency(); got != "CAD" {
+		t.Errorf("default currency = %s, want CAD", got)
+	}
+	t.Setenv("CURRENCY", "usd")
+	if got := Currency(); got != "USD" {
+		t.Errorf("configured currency = %s, want USD", got)
+	}
+}
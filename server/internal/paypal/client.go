@@ -2,11 +2,13 @@ package paypal
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"services/internal/money"
+	"strings"
 	"time"
 )
 
@@ -18,20 +20,31 @@ var (
 type Client struct {
 	ClientID     string
 	ClientSecret string
+	WebhookID    string
 	BaseURL      string
 	HTTPClient   *http.Client
+
+	tokens tokenCache
 }
 
 func NewClient() *Client {
 	// Default to Sandbox, allow override
 	baseURL := baseSandboxURL
-	if os.Getenv("PAYPAL_ENVIRONMENT") == "live" {
+	switch os.Getenv("PAYPAL_ENVIRONMENT") {
+	case "live":
 		baseURL = baseLiveURL
+	case "mock":
+		return newMockClient()
+	}
+	// PAYPAL_BASE_URL points the client anywhere else, e.g. a fake or proxy
+	if override := os.Getenv("PAYPAL_BASE_URL"); override != "" {
+		baseURL = strings.TrimRight(override, "/")
 	}
 
 	return &Client{
 		ClientID:     os.Getenv("PAYPAL_CLIENT_ID"),
 		ClientSecret: os.Getenv("PAYPAL_CLIENT_SECRET"),
+		WebhookID:    os.Getenv("PAYPAL_WEBHOOK_ID"),
 		BaseURL:      baseURL,
 		HTTPClient: &http.Client{
 			Timeout: 15 * time.Second,
@@ -39,44 +52,53 @@ func NewClient() *Client {
 	}
 }
 
-// getAccessToken authenticates using Client Credentials grant
-func (c *Client) getAccessToken() (string, error) {
-	req, err := http.NewRequest("POST", c.BaseURL+"/v1/oauth2/token", bytes.NewBuffer([]byte("grant_type=client_credentials")))
-	if err != nil {
-		return "", err
-	}
-	req.SetBasicAuth(c.ClientID, c.ClientSecret)
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-
-	resp, err := c.HTTPClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer func() { _ = resp.Body.Close() }()
+type PurchaseUnitRequest struct {
+	ReferenceID string      `json:"reference_id,omitempty"`
+	CustomID    string      `json:"custom_id,omitempty"`
+	Amount      OrderAmount `json:"amount"`
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to get access token, status: %d, body: %s", resp.StatusCode, string(body))
-	}
+// OrderAmount is a purchase unit's amount. When a breakdown is sent PayPal
+// requires Value to equal item_total + tax_total - discount.
+type OrderAmount struct {
+	CurrencyCode string           `json:"currency_code"`
+	Value        string           `json:"value"`
+	Breakdown    *AmountBreakdown `json:"breakdown,omitempty"`
+}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
-	}
+type AmountBreakdown struct {
+	ItemTotal Amount  `json:"item_total"`
+	TaxTotal  *Amount `json:"tax_total,omitempty"`
+	Discount  *Amount `json:"discount,omitempty"`
+}
 
-	token, ok := result["access_token"].(string)
-	if !ok {
-		return "", fmt.Errorf("access token not found in response")
-	}
+// Charge is what a PayPal order is created for, split the way PayPal shows
+// it to the buyer
+type Charge struct {
+	Currency  string
+	ItemTotal money.Amount
+	Discount  money.Amount
+	TaxTotal  money.Amount
+}
 
-	return token, nil
+// Total is what the buyer pays
+func (c Charge) Total() money.Money {
+	return money.New(c.ItemTotal-c.Discount+c.TaxTotal, c.Currency)
 }
 
-type PurchaseUnitRequest struct {
-	Amount struct {
-		CurrencyCode string `json:"currency_code"`
-		Value        string `json:"value"`
-	} `json:"amount"`
+func (c Charge) amount() OrderAmount {
+	breakdown := &AmountBreakdown{ItemTotal: Amount{CurrencyCode: c.Currency, Value: c.ItemTotal.String()}}
+	if c.TaxTotal > 0 {
+		breakdown.TaxTotal = &Amount{CurrencyCode: c.Currency, Value: c.TaxTotal.String()}
+	}
+	if c.Discount > 0 {
+		breakdown.Discount = &Amount{CurrencyCode: c.Currency, Value: c.Discount.String()}
+	}
+	return OrderAmount{
+		CurrencyCode: c.Currency,
+		Value:        c.Total().Amount.String(),
+		Breakdown:    breakdown,
+	}
 }
 
 type ApplicationContext struct {
@@ -90,18 +112,23 @@ type CreateOrderRequest struct {
 	ApplicationContext ApplicationContext    `json:"application_context"`
 }
 
+// FrontendURL is the base URL of the client app that links and provider
+// redirects point at, from FRONTEND_URL. It defaults to the local dev server.
+func FrontendURL() string {
+	if frontendURL := os.Getenv("FRONTEND_URL"); frontendURL != "" {
+		return frontendURL
+	}
+	return "http://localhost:5173"
+}
+
 // CreateOrder calls the PayPal API to create an order
-func (c *Client) CreateOrder(amount float64, orderID string) (string, string, error) {
-	token, err := c.getAccessToken()
+func (c *Client) CreateOrder(ctx context.Context, charge Charge, orderID string) (string, string, error) {
+	token, err := c.getAccessToken(ctx)
 	if err != nil {
 		return "", "", err
 	}
 
-	// Assuming the front-end runs on localhost:5173 for local dev
-	frontendURL := os.Getenv("FRONTEND_URL")
-	if frontendURL == "" {
-		frontendURL = "http://localhost:5173"
-	}
+	frontendURL := FrontendURL()
 
 	orderReq := CreateOrderRequest{
 		Intent: "CAPTURE",
@@ -110,17 +137,13 @@ func (c *Client) CreateOrder(amount float64, orderID string) (string, string, er
 			CancelURL: fmt.Sprintf("%s/checkout/cancel?order_id=%s", frontendURL, orderID),
 		},
 	}
-	// Add format helper for amount
-	amountStr := fmt.Sprintf("%.2f", amount)
 	orderReq.PurchaseUnits = []PurchaseUnitRequest{
 		{
-			Amount: struct {
-				CurrencyCode string `json:"currency_code"`
-				Value        string `json:"value"`
-			}{
-				CurrencyCode: "USD",
-				Value:        amountStr,
-			},
+			// reference_id and custom_id tie the PayPal order back to ours;
+			// custom_id is also echoed on captures so webhooks can find it
+			ReferenceID: orderID,
+			CustomID:    orderID,
+			Amount:      charge.amount(),
 		},
 	}
 
@@ -129,7 +152,7 @@ func (c *Client) CreateOrder(amount float64, orderID string) (string, string, er
 		return "", "", err
 	}
 
-	req, err := http.NewRequest("POST", c.BaseURL+"/v2/checkout/orders", bytes.NewBuffer(bodyBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v2/checkout/orders", bytes.NewBuffer(bodyBytes))
 	if err != nil {
 		return "", "", err
 	}
@@ -142,10 +165,10 @@ func (c *Client) CreateOrder(amount float64, orderID string) (string, string, er
 		return "", "", err
 	}
 	defer func() { _ = resp.Body.Close() }()
+	c.checkTokenRejected(resp)
 
 	if resp.StatusCode != http.StatusCreated {
-		respBody, _ := io.ReadAll(resp.Body)
-		return "", "", fmt.Errorf("failed to create order, status: %d, body: %s", resp.StatusCode, string(respBody))
+		return "", "", newAPIError("create order", resp)
 	}
 
 	var result struct {
@@ -172,16 +195,124 @@ func (c *Client) CreateOrder(amount float64, orderID string) (string, string, er
 	return result.ID, approveURL, nil
 }
 
+// CaptureResult is the outcome of capturing an approved order, with the
+// fields needed to check that it matches the local order
+type CaptureResult struct {
+	Status      string
+	CaptureID   string
+	ReferenceID string
+	CustomID    string
+	Amount      Amount // what was actually captured
+}
+
+// orderResponse is the order shape returned by both capture and get order
+type orderResponse struct {
+	Status        string `json:"status"`
+	PurchaseUnits []struct {
+		ReferenceID string `json:"reference_id"`
+		CustomID    string `json:"custom_id"`
+		Amount      Amount `json:"amount"`
+		Payments    struct {
+			Captures []struct {
+				ID       string `json:"id"`
+				Status   string `json:"status"`
+				CustomID string `json:"custom_id"`
+				Amount   Amount `json:"amount"`
+			} `json:"captures"`
+		} `json:"payments"`
+	} `json:"purchase_units"`
+}
+
+func (o *orderResponse) result() *CaptureResult {
+	capture := &CaptureResult{Status: o.Status}
+	if len(o.PurchaseUnits) == 0 {
+		return capture
+	}
+	unit := o.PurchaseUnits[0]
+	capture.ReferenceID = unit.ReferenceID
+	capture.CustomID = unit.CustomID
+	if len(unit.Payments.Captures) > 0 {
+		captured := unit.Payments.Captures[0]
+		capture.CaptureID = captured.ID
+		capture.Amount = captured.Amount
+		// A completed order can hold a capture that is still pending, such
+		// as an eCheck or a payment under review
+		if captured.Status == "PENDING" {
+			capture.Status = captured.Status
+		}
+		if capture.CustomID == "" {
+			capture.CustomID = captured.CustomID
+		}
+	}
+	return capture
+}
+
 // CaptureOrder captures a previously created order using its ID
-func (c *Client) CaptureOrder(orderID string) (string, error) {
-	token, err := c.getAccessToken()
+func (c *Client) CaptureOrder(ctx context.Context, orderID string) (*CaptureResult, error) {
+	return c.doOrderRequest(ctx, "capture order", "POST", "/v2/checkout/orders/"+orderID+"/capture")
+}
+
+// GetOrder fetches an order, used to inspect a capture made by an earlier call
+func (c *Client) GetOrder(ctx context.Context, orderID string) (*CaptureResult, error) {
+	return c.doOrderRequest(ctx, "get order", "GET", "/v2/checkout/orders/"+orderID)
+}
+
+// CaptureDetails is a capture as PayPal currently sees it, refunds included
+type CaptureDetails struct {
+	ID       string
+	Status   string // COMPLETED, PENDING, DECLINED, REFUNDED, PARTIALLY_REFUNDED, FAILED
+	CustomID string
+	Amount   Amount
+}
+
+// GetCapture fetches a capture by its ID
+func (c *Client) GetCapture(ctx context.Context, captureID string) (*CaptureDetails, error) {
+	token, err := c.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.BaseURL+"/v2/payments/captures/"+captureID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	c.checkTokenRejected(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError("get capture", resp)
+	}
+
+	var result struct {
+		ID       string `json:"id"`
+		Status   string `json:"status"`
+		CustomID string `json:"custom_id"`
+		Amount   Amount `json:"amount"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return &CaptureDetails{ID: result.ID, Status: result.Status, CustomID: result.CustomID, Amount: result.Amount}, nil
+}
+
+func (c *Client) doOrderRequest(ctx context.Context, op, method, path string) (*CaptureResult, error) {
+	token, err := c.getAccessToken(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", c.BaseURL+"/v2/checkout/orders/"+orderID+"/capture", nil)
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, nil)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+token)
@@ -189,22 +320,89 @@ func (c *Client) CaptureOrder(orderID string) (string, error) {
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer func() { _ = resp.Body.Close() }()
+	c.checkTokenRejected(resp)
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
-		respBody, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to capture order, status: %d, body: %s", resp.StatusCode, string(respBody))
+		return nil, newAPIError(op, resp)
+	}
+
+	var result orderResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result.result(), nil
+}
+
+type refundRequest struct {
+	Amount      *Amount `json:"amount,omitempty"`
+	CustomID    string  `json:"custom_id,omitempty"`
+	NoteToPayer string  `json:"note_to_payer,omitempty"`
+}
+
+// RefundResult is the outcome of refunding a capture
+type RefundResult struct {
+	ID     string
+	Status string
+}
+
+// RefundCapture refunds a capture. A zero amount refunds whatever remains of
+// the capture; otherwise the given amount is refunded. requestID makes the
+// call idempotent on PayPal's side so a retried request never refunds twice.
+func (c *Client) RefundCapture(ctx context.Context, captureID string, amount money.Money, orderID, note, requestID string) (*RefundResult, error) {
+	token, err := c.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	refundReq := refundRequest{
+		CustomID:    orderID,
+		NoteToPayer: note,
+	}
+	if amount.Amount > 0 {
+		refundReq.Amount = &Amount{
+			CurrencyCode: amount.Currency,
+			Value:        amount.Amount.String(),
+		}
+	}
+
+	bodyBytes, err := json.Marshal(refundReq)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v2/payments/captures/"+captureID+"/refund", bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	if requestID != "" {
+		req.Header.Set("PayPal-Request-Id", requestID)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	c.checkTokenRejected(resp)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, newAPIError("refund capture", resp)
 	}
 
 	var result struct {
+		ID     string `json:"id"`
 		Status string `json:"status"`
 	}
-
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return result.Status, nil
+	return &RefundResult{ID: result.ID, Status: result.Status}, nil
 }
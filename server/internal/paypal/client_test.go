@@ -0,0 +1,371 @@
+package paypal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakePayPal serves the OAuth token endpoint plus whatever extra routes the
+// test registers, and returns a Client pointed at it.
+func newFakePayPal(t *testing.T, routes map[string]http.HandlerFunc) *Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	if _, ok := routes["/v1/oauth2/token"]; !ok {
+		mux.HandleFunc("/v1/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "test-token", "expires_in": 3600})
+		})
+	}
+	for path, h := range routes {
+		mux.HandleFunc(path, h)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return &Client{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		WebhookID:    "WH-CONFIG",
+		BaseURL:      srv.URL,
+		HTTPClient:   srv.Client(),
+	}
+}
+
+func webhookHeaders() http.Header {
+	h := http.Header{}
+	h.Set("PAYPAL-AUTH-ALGO", "SHA256withRSA")
+	h.Set("PAYPAL-CERT-URL", "https://api.paypal.com/cert")
+	h.Set("PAYPAL-TRANSMISSION-ID", "tx-1")
+	h.Set("PAYPAL-TRANSMISSION-SIG", "sig")
+	h.Set("PAYPAL-TRANSMISSION-TIME", "2026-01-01T00:00:00Z")
+	return h
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		wantErr bool
+	}{
+		{name: "success", status: "SUCCESS"},
+		{name: "failure", status: "FAILURE", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFakePayPal(t, map[string]http.HandlerFunc{
+				"/v1/notifications/verify-webhook-signature": func(w http.ResponseWriter, r *http.Request) {
+					var req verifySignatureRequest
+					if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+						t.Fatalf("failed to decode verify request: %v", err)
+					}
+					if req.WebhookID != "WH-CONFIG" || req.TransmissionID != "tx-1" {
+						t.Errorf("unexpected verify request: %+v", req)
+					}
+					if string(req.WebhookEvent) != `{"id":"WH-1"}` {
+						t.Errorf("expected raw event to be forwarded, got %s", req.WebhookEvent)
+					}
+					_ = json.NewEncoder(w).Encode(map[string]string{"verification_status": tt.status})
+				},
+			})
+
+			err := c.VerifyWebhookSignature(context.Background(), webhookHeaders(), []byte(`{"id":"WH-1"}`))
+			if tt.wantErr && !errors.Is(err, ErrInvalidWebhookSignature) {
+				t.Errorf("expected ErrInvalidWebhookSignature, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyWebhookSignature_MissingHeaders(t *testing.T) {
+	c := newFakePayPal(t, nil)
+	err := c.VerifyWebhookSignature(context.Background(), http.Header{}, []byte(`{"id":"WH-1"}`))
+	if !errors.Is(err, ErrInvalidWebhookSignature) {
+		t.Errorf("expected ErrInvalidWebhookSignature, got %v", err)
+	}
+}
+
+func TestParentCaptureID(t *testing.T) {
+	r := CaptureResource{Links: []Link{
+		{Rel: "self", Href: "https://api.paypal.com/v2/payments/refunds/R1"},
+		{Rel: "up", Href: "https://api.paypal.com/v2/payments/captures/C1"},
+	}}
+	if got := r.ParentCaptureID(); got != "C1" {
+		t.Errorf("expected C1, got %q", got)
+	}
+}
+
+func TestFrontendURL(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "")
+	if got := FrontendURL(); got != "http://localhost:5173" {
+		t.Errorf("expected the dev server by default, got %q", got)
+	}
+	t.Setenv("FRONTEND_URL", "https://learn.example.com")
+	if got := FrontendURL(); got != "https://learn.example.com" {
+		t.Errorf("expected FRONTEND_URL, got %q", got)
+	}
+}
+
+func TestAccessToken_CachedAndShared(t *testing.T) {
+	var tokenCalls atomic.Int32
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v1/oauth2/token": func(w http.ResponseWriter, r *http.Request) {
+			tokenCalls.Add(1)
+			time.Sleep(20 * time.Millisecond) // keep the refresh in flight while others arrive
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "shared-token", "expires_in": 32400})
+		},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := c.getAccessToken(context.Background())
+			if err != nil || token != "shared-token" {
+				t.Errorf("unexpected token %q, err %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := c.getAccessToken(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := tokenCalls.Load(); got != 1 {
+		t.Errorf("expected a single token request, got %d", got)
+	}
+}
+
+func TestAccessToken_RefreshesBeforeExpiry(t *testing.T) {
+	var tokenCalls atomic.Int32
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v1/oauth2/token": func(w http.ResponseWriter, r *http.Request) {
+			n := tokenCalls.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": fmt.Sprintf("token-%d", n), "expires_in": 3600})
+		},
+	})
+
+	first, _ := c.getAccessToken(context.Background())
+	// Move past the early refresh point without waiting for it
+	c.tokens.mu.Lock()
+	c.tokens.refreshAt = time.Now().Add(-time.Second)
+	c.tokens.mu.Unlock()
+	second, _ := c.getAccessToken(context.Background())
+
+	if first == second {
+		t.Errorf("expected a refreshed token, got %q twice", first)
+	}
+}
+
+func TestAccessToken_InvalidatedOnUnauthorized(t *testing.T) {
+	var tokenCalls atomic.Int32
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v1/oauth2/token": func(w http.ResponseWriter, r *http.Request) {
+			tokenCalls.Add(1)
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "revoked", "expires_in": 32400})
+		},
+		"/v2/checkout/orders/ORDER-1/capture": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		},
+	})
+
+	_, _ = c.CaptureOrder(context.Background(), "ORDER-1")
+	_, _ = c.getAccessToken(context.Background())
+
+	if got := tokenCalls.Load(); got != 2 {
+		t.Errorf("expected the rejected token to be refetched, got %d token requests", got)
+	}
+}
+
+func TestAccessToken_CallerCancellation(t *testing.T) {
+	release := make(chan struct{})
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v1/oauth2/token": func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "late-token", "expires_in": 32400})
+		},
+	})
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.getAccessToken(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestCaptureOrder_ParsesAPIError(t *testing.T) {
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v2/checkout/orders/ORDER-1/capture": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Paypal-Debug-Id", "header-debug")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_, _ = w.Write([]byte(`{"name":"UNPROCESSABLE_ENTITY","message":"semantically incorrect","debug_id":"f3391930662f4","details":[{"issue":"ORDER_ALREADY_CAPTURED","description":"Order already captured."}]}`))
+		},
+	})
+
+	_, err := c.CaptureOrder(context.Background(), "ORDER-1")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.Name != "UNPROCESSABLE_ENTITY" {
+		t.Errorf("unexpected status/name: %d %s", apiErr.StatusCode, apiErr.Name)
+	}
+	if apiErr.DebugID != "f3391930662f4" {
+		t.Errorf("expected body debug_id to win, got %s", apiErr.DebugID)
+	}
+	if !apiErr.HasIssue("ORDER_ALREADY_CAPTURED") {
+		t.Errorf("expected ORDER_ALREADY_CAPTURED issue, got %+v", apiErr.Details)
+	}
+	if apiErr.HasIssue("DUPLICATE_INVOICE_ID") {
+		t.Error("did not expect DUPLICATE_INVOICE_ID issue")
+	}
+}
+
+func TestCaptureOrder_NonJSONErrorBody(t *testing.T) {
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v2/checkout/orders/ORDER-1/capture": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte("upstream timeout"))
+		},
+	})
+
+	_, err := c.CaptureOrder(context.Background(), "ORDER-1")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadGateway || apiErr.Message != "upstream timeout" {
+		t.Errorf("unexpected error: %+v", apiErr)
+	}
+}
+
+func TestCaptureOrder_ParsesCaptureDetails(t *testing.T) {
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v2/checkout/orders/ORDER-1/capture": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"status":"COMPLETED","purchase_units":[{"reference_id":"order-1","payments":{"captures":[{"id":"CAP-1","custom_id":"order-1","amount":{"currency_code":"USD","value":"49.99"}}]}}]}`))
+		},
+	})
+
+	capture, err := c.CaptureOrder(context.Background(), "ORDER-1")
+	if err != nil {
+		t.Fatalf("CaptureOrder: %v", err)
+	}
+	want := CaptureResult{
+		Status:      "COMPLETED",
+		CaptureID:   "CAP-1",
+		ReferenceID: "order-1",
+		CustomID:    "order-1",
+		Amount:      Amount{CurrencyCode: "USD", Value: "49.99"},
+	}
+	if *capture != want {
+		t.Errorf("got %+v, want %+v", *capture, want)
+	}
+}
+
+func TestCaptureOrder_PendingCapture(t *testing.T) {
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v2/checkout/orders/ORDER-1/capture": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"status":"COMPLETED","purchase_units":[{"reference_id":"order-1","payments":{"captures":[{"id":"CAP-1","status":"PENDING","custom_id":"order-1","amount":{"currency_code":"USD","value":"49.99"}}]}}]}`))
+		},
+	})
+
+	capture, err := c.CaptureOrder(context.Background(), "ORDER-1")
+	if err != nil {
+		t.Fatalf("CaptureOrder: %v", err)
+	}
+	if capture.Status != "PENDING" {
+		t.Errorf("expected the capture's PENDING status, got %s", capture.Status)
+	}
+}
+
+func TestGetCapture_ParsesCapture(t *testing.T) {
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v2/payments/captures/CAP-1": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				t.Errorf("expected GET, got %s", r.Method)
+			}
+			_, _ = w.Write([]byte(`{"id":"CAP-1","status":"PARTIALLY_REFUNDED","custom_id":"order-1","amount":{"currency_code":"CAD","value":"56.49"}}`))
+		},
+	})
+
+	capture, err := c.GetCapture(context.Background(), "CAP-1")
+	if err != nil {
+		t.Fatalf("GetCapture: %v", err)
+	}
+	want := CaptureDetails{
+		ID:       "CAP-1",
+		Status:   "PARTIALLY_REFUNDED",
+		CustomID: "order-1",
+		Amount:   Amount{CurrencyCode: "CAD", Value: "56.49"},
+	}
+	if *capture != want {
+		t.Errorf("got %+v, want %+v", *capture, want)
+	}
+}
+
+func TestCreateOrder_SendsExactAmountAndCurrency(t *testing.T) {
+	var got CreateOrderRequest
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v2/checkout/orders": func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+				t.Errorf("decode body: %v", err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id":"ORDER-1","links":[{"rel":"approve","href":"https://paypal.test/approve"}]}`))
+		},
+	})
+
+	id, _, err := c.CreateOrder(context.Background(), Charge{Currency: "CAD", ItemTotal: 4249}, "order-1")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if id != "ORDER-1" {
+		t.Errorf("expected PayPal order ID ORDER-1, got %q", id)
+	}
+	if len(got.PurchaseUnits) != 1 {
+		t.Fatalf("expected one purchase unit, got %d", len(got.PurchaseUnits))
+	}
+	amount := got.PurchaseUnits[0].Amount
+	if amount.Value != "42.49" || amount.CurrencyCode != "CAD" {
+		t.Errorf("expected 42.49 CAD, got %s %s", amount.Value, amount.CurrencyCode)
+	}
+}
+
+func TestCreateOrder_SendsBreakdown(t *testing.T) {
+	var got CreateOrderRequest
+	c := newFakePayPal(t, map[string]http.HandlerFunc{
+		"/v2/checkout/orders": func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"id":"ORDER-1","links":[]}`))
+		},
+	})
+
+	charge := Charge{Currency: "CAD", ItemTotal: 10000, Discount: 2000, TaxTotal: 1040}
+	if _, _, err := c.CreateOrder(context.Background(), charge, "order-1"); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	amount := got.PurchaseUnits[0].Amount
+	if amount.Value != "90.40" || amount.Breakdown == nil {
+		t.Fatalf("expected 90.40 with a breakdown, got %+v", amount)
+	}
+	b := amount.Breakdown
+	if b.ItemTotal.Value != "100.00" || b.Discount == nil || b.Discount.Value != "20.00" || b.TaxTotal == nil || b.TaxTotal.Value != "10.40" {
+		t.Errorf("unexpected breakdown %+v", b)
+	}
+}
@@ -0,0 +1,89 @@
+package paypal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrorDetail is one entry of the details array in a PayPal error body
+type ErrorDetail struct {
+	Field       string `json:"field"`
+	Value       string `json:"value"`
+	Location    string `json:"location"`
+	Issue       string `json:"issue"`
+	Description string `json:"description"`
+}
+
+// APIError is a non-2xx response from the PayPal API, decoded from PayPal's
+// error body so callers can branch on the name and issues instead of text
+type APIError struct {
+	Op         string
+	StatusCode int
+	Name       string
+	Message    string
+	DebugID    string
+	Details    []ErrorDetail
+}
+
+func (e *APIError) Error() string {
+	issues := make([]string, 0, len(e.Details))
+	for _, d := range e.Details {
+		issues = append(issues, d.Issue)
+	}
+	return fmt.Sprintf("failed to %s, status: %d, name: %s, issues: [%s], message: %s, debug_id: %s",
+		e.Op, e.StatusCode, e.Name, strings.Join(issues, ","), e.Message, e.DebugID)
+}
+
+// HasIssue reports whether PayPal reported the given issue code, either as
+// the error name or in one of the details
+func (e *APIError) HasIssue(issue string) bool {
+	if e.Name == issue {
+		return true
+	}
+	for _, d := range e.Details {
+		if d.Issue == issue {
+			return true
+		}
+	}
+	return false
+}
+
+// newAPIError builds an APIError from a failed response. Bodies that are not
+// PayPal's JSON error shape are kept verbatim in Message.
+func newAPIError(op string, resp *http.Response) *APIError {
+	apiErr := &APIError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		DebugID:    resp.Header.Get("Paypal-Debug-Id"),
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+
+	var parsed struct {
+		Name             string        `json:"name"`
+		Message          string        `json:"message"`
+		DebugID          string        `json:"debug_id"`
+		Details          []ErrorDetail `json:"details"`
+		Error            string        `json:"error"` // OAuth endpoints use error/error_description
+		ErrorDescription string        `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		apiErr.Message = string(body)
+		return apiErr
+	}
+
+	apiErr.Name = parsed.Name
+	apiErr.Message = parsed.Message
+	apiErr.Details = parsed.Details
+	if parsed.DebugID != "" {
+		apiErr.DebugID = parsed.DebugID
+	}
+	if apiErr.Name == "" {
+		apiErr.Name = parsed.Error
+		apiErr.Message = parsed.ErrorDescription
+	}
+	return apiErr
+}
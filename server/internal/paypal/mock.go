@@ -0,0 +1,73 @@
+package paypal
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"services/internal/paypal/paypaltest"
+	"sync"
+	"time"
+)
+
+const mockWebhookID = "MOCK-WEBHOOK-ID"
+
+var (
+	mockOnce   sync.Once
+	mockServer *paypaltest.Server
+)
+
+// newMockClient returns a client for the in-process fake PayPal started by
+// PAYPAL_ENVIRONMENT=mock. Every client shares the one fake, which listens on
+// PAYPAL_MOCK_ADDR and posts its webhooks to PAYPAL_MOCK_WEBHOOK_URL.
+func newMockClient() *Client {
+	mockOnce.Do(startMockServer)
+
+	clientID := os.Getenv("PAYPAL_CLIENT_ID")
+	if clientID == "" {
+		clientID = "mock-client-id"
+	}
+	webhookID := os.Getenv("PAYPAL_WEBHOOK_ID")
+	if webhookID == "" {
+		webhookID = mockWebhookID
+	}
+
+	return &Client{
+		ClientID:     clientID,
+		ClientSecret: os.Getenv("PAYPAL_CLIENT_SECRET"),
+		WebhookID:    webhookID,
+		BaseURL:      mockServer.URL,
+		HTTPClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+func startMockServer() {
+	addr := os.Getenv("PAYPAL_MOCK_ADDR")
+	if addr == "" {
+		addr = "localhost:8099"
+	}
+
+	server, err := paypaltest.Listen(addr)
+	if err != nil {
+		// Another process (e.g. the reconcile command next to a dev server)
+		// may hold the address; a private fake still works on its own
+		slog.Warn("mock PayPal address unavailable, using a random port", "addr", addr, "error", err)
+		server, err = paypaltest.Listen("localhost:0")
+		if err != nil {
+			panic("failed to start mock PayPal: " + err.Error())
+		}
+	}
+
+	server.WebhookURL = os.Getenv("PAYPAL_MOCK_WEBHOOK_URL")
+	if server.WebhookURL == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "3000"
+		}
+		server.WebhookURL = "http://localhost:" + port + "/api/webhooks/paypal"
+	}
+
+	slog.Info("mock PayPal started", "url", server.URL, "webhook_url", server.WebhookURL)
+	mockServer = server
+}
@@ -0,0 +1,657 @@
+// Package paypaltest is a fake PayPal REST API for tests and local
+// development. It keeps orders, captures and refunds in memory, issues OAuth
+// tokens, delivers webhooks it can later verify, and fails calls on request so
+// error paths can be exercised without the sandbox.
+package paypaltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"services/internal/money"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations whose outcome can be scripted
+const (
+	OpCreateOrder = "create_order"
+	OpGetOrder    = "get_order"
+	OpCapture     = "capture"
+	OpGetCapture  = "get_capture"
+	OpRefund      = "refund"
+)
+
+// Outcome is how the fake answers the next call of an operation
+type Outcome string
+
+const (
+	// OutcomeDeclined refuses a capture with INSTRUMENT_DECLINED, or a refund
+	// with TRANSACTION_REFUSED
+	OutcomeDeclined Outcome = "DECLINED"
+	// OutcomeAlreadyCaptured captures the order but answers
+	// ORDER_ALREADY_CAPTURED, as when the response to an earlier capture was
+	// lost on the way back
+	OutcomeAlreadyCaptured Outcome = "ALREADY_CAPTURED"
+	// OutcomePending leaves a capture or refund PENDING
+	OutcomePending Outcome = "PENDING"
+	// OutcomeServerError answers 503 SERVICE_UNAVAILABLE
+	OutcomeServerError Outcome = "SERVER_ERROR"
+)
+
+// Event is a webhook event the fake emitted
+type Event struct {
+	ID           string          `json:"id"`
+	EventType    string          `json:"event_type"`
+	ResourceType string          `json:"resource_type"`
+	CreateTime   string          `json:"create_time"`
+	Resource     json.RawMessage `json:"resource"`
+}
+
+type amount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+type link struct {
+	Href   string `json:"href"`
+	Rel    string `json:"rel"`
+	Method string `json:"method"`
+}
+
+type order struct {
+	ID          string
+	Status      string // CREATED, APPROVED, COMPLETED
+	ReferenceID string
+	CustomID    string
+	Amount      amount
+	ReturnURL   string
+	CancelURL   string
+	Capture     *capture
+}
+
+type capture struct {
+	ID       string
+	OrderID  string
+	Status   string // COMPLETED, PENDING, PARTIALLY_REFUNDED, REFUNDED
+	CustomID string
+	Amount   money.Money
+	Refunded money.Amount
+}
+
+type refund struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Amount amount `json:"amount"`
+}
+
+// Server is a running fake. Point a paypal.Client's BaseURL at URL.
+type Server struct {
+	URL string
+	// WebhookURL receives the events the fake emits. When empty events are
+	// only recorded.
+	WebhookURL string
+
+	srv        *http.Server
+	httpClient *http.Client
+	deliveries sync.WaitGroup
+
+	mu            sync.Mutex
+	seq           int
+	tokens        map[string]bool
+	orders        map[string]*order
+	captures      map[string]*capture
+	refunds       map[string]*refund // by PayPal-Request-Id
+	transmissions map[string]string  // transmission ID to event ID
+	events        []Event
+	script        map[string][]Outcome
+}
+
+// NewServer starts a fake on a random local port, for tests
+func NewServer() *Server {
+	s, err := Listen("127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("paypaltest: failed to listen: %v", err))
+	}
+	return s
+}
+
+// Listen starts a fake on addr, for running the app against it in development
+func Listen(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		URL:           "http://" + listener.Addr().String(),
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		tokens:        map[string]bool{},
+		orders:        map[string]*order{},
+		captures:      map[string]*capture{},
+		refunds:       map[string]*refund{},
+		transmissions: map[string]string{},
+		script:        map[string][]Outcome{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/oauth2/token", s.token)
+	mux.HandleFunc("POST /v2/checkout/orders", s.authorized(s.createOrder))
+	mux.HandleFunc("GET /v2/checkout/orders/{id}", s.authorized(s.getOrder))
+	mux.HandleFunc("POST /v2/checkout/orders/{id}/capture", s.authorized(s.captureOrder))
+	mux.HandleFunc("GET /v2/payments/captures/{id}", s.authorized(s.getCapture))
+	mux.HandleFunc("POST /v2/payments/captures/{id}/refund", s.authorized(s.refundCapture))
+	mux.HandleFunc("POST /v1/notifications/verify-webhook-signature", s.authorized(s.verifySignature))
+	mux.HandleFunc("GET /checkoutnow", s.approve)
+	mux.HandleFunc("POST /fake/outcomes", s.scriptOutcomes)
+
+	s.srv = &http.Server{Handler: mux}
+	go func() { _ = s.srv.Serve(listener) }()
+	return s, nil
+}
+
+// Close stops the fake once its webhook deliveries are done
+func (s *Server) Close() {
+	s.deliveries.Wait()
+	_ = s.srv.Close()
+}
+
+// Script queues outcomes for the next calls of an operation, one call each.
+// Calls beyond the queue succeed.
+func (s *Server) Script(op string, outcomes ...Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script[op] = append(s.script[op], outcomes...)
+}
+
+// Approve approves an order as if the buyer had done so on PayPal
+func (s *Server) Approve(orderID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[orderID]
+	if !ok {
+		return fmt.Errorf("paypaltest: unknown order %s", orderID)
+	}
+	if o.Status == "CREATED" {
+		o.Status = "APPROVED"
+	}
+	return nil
+}
+
+// RevokeTokens makes every issued access token invalid, as when PayPal
+// expires them early
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]bool{}
+}
+
+// Events returns the events emitted so far
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// WaitForDeliveries blocks until every emitted event has been posted to
+// WebhookURL
+func (s *Server) WaitForDeliveries() {
+	s.deliveries.Wait()
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := r.BasicAuth(); !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": "Client Authentication failed"})
+		return
+	}
+
+	s.mu.Lock()
+	token := s.nextID("A21AA")
+	s.tokens[token] = true
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"expires_in":   32400,
+	})
+}
+
+// authorized rejects requests without a token issued by this fake
+func (s *Server) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.Lock()
+		ok := s.tokens[token]
+		s.mu.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token", "error_description": "Token signature verification failed"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) createOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PurchaseUnits []struct {
+			ReferenceID string `json:"reference_id"`
+			CustomID    string `json:"custom_id"`
+			Amount      amount `json:"amount"`
+		} `json:"purchase_units"`
+		ApplicationContext struct {
+			ReturnURL string `json:"return_url"`
+			CancelURL string `json:"cancel_url"`
+		} `json:"application_context"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.PurchaseUnits) == 0 {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON")
+		return
+	}
+	unit := req.PurchaseUnits[0]
+	if _, err := money.Parse(unit.Amount.Value); err != nil || unit.Amount.CurrencyCode == "" {
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "INVALID_PARAMETER_VALUE")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w, OpCreateOrder) {
+		return
+	}
+
+	o := &order{
+		ID:          s.nextID("ORDER"),
+		Status:      "CREATED",
+		ReferenceID: unit.ReferenceID,
+		CustomID:    unit.CustomID,
+		Amount:      unit.Amount,
+		ReturnURL:   req.ApplicationContext.ReturnURL,
+		CancelURL:   req.ApplicationContext.CancelURL,
+	}
+	s.orders[o.ID] = o
+
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"id":     o.ID,
+		"status": o.Status,
+		"links": []link{
+			{Href: s.URL + "/v2/checkout/orders/" + o.ID, Rel: "self", Method: "GET"},
+			{Href: s.URL + "/checkoutnow?token=" + o.ID, Rel: "approve", Method: "GET"},
+			{Href: s.URL + "/v2/checkout/orders/" + o.ID + "/capture", Rel: "capture", Method: "POST"},
+		},
+	})
+}
+
+// approve stands in for PayPal's checkout page: the buyer approves at once
+// and is sent back to the return URL with the order as token
+func (s *Server) approve(w http.ResponseWriter, r *http.Request) {
+	orderID := r.URL.Query().Get("token")
+	if err := s.Approve(orderID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	returnURL := s.orders[orderID].ReturnURL
+	s.mu.Unlock()
+
+	target, err := url.Parse(returnURL)
+	if err != nil || returnURL == "" {
+		fmt.Fprintf(w, "Order %s approved\n", orderID)
+		return
+	}
+	query := target.Query()
+	query.Set("token", orderID)
+	query.Set("PayerID", "FAKEPAYER")
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w, OpGetOrder) {
+		return
+	}
+
+	o, ok := s.orders[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.orderJSON(o))
+}
+
+func (s *Server) captureOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	if o.Capture != nil {
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_ALREADY_CAPTURED")
+		return
+	}
+	if o.Status != "APPROVED" {
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_NOT_APPROVED")
+		return
+	}
+
+	outcome := s.next(OpCapture)
+	switch outcome {
+	case OutcomeServerError:
+		writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "SERVICE_UNAVAILABLE")
+		return
+	case OutcomeDeclined:
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "INSTRUMENT_DECLINED")
+		return
+	}
+
+	value, _ := money.Parse(o.Amount.Value)
+	c := &capture{
+		ID:       s.nextID("CAPTURE"),
+		OrderID:  o.ID,
+		Status:   "COMPLETED",
+		CustomID: o.CustomID,
+		Amount:   money.New(value, o.Amount.CurrencyCode),
+	}
+	if outcome == OutcomePending {
+		c.Status = "PENDING"
+	}
+	o.Status = "COMPLETED"
+	o.Capture = c
+	s.captures[c.ID] = c
+
+	eventType := "PAYMENT.CAPTURE.COMPLETED"
+	if c.Status == "PENDING" {
+		eventType = "PAYMENT.CAPTURE.PENDING"
+	}
+	s.emit(eventType, map[string]any{
+		"id":        c.ID,
+		"status":    c.Status,
+		"amount":    toAmount(c.Amount),
+		"custom_id": c.CustomID,
+		"supplementary_data": map[string]any{
+			"related_ids": map[string]string{"order_id": o.ID},
+		},
+		"links": []link{{Href: s.URL + "/v2/payments/captures/" + c.ID, Rel: "self", Method: "GET"}},
+	})
+
+	if outcome == OutcomeAlreadyCaptured {
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "ORDER_ALREADY_CAPTURED")
+		return
+	}
+	writeJSON(w, http.StatusCreated, s.orderJSON(o))
+}
+
+func (s *Server) getCapture(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w, OpGetCapture) {
+		return
+	}
+
+	c, ok := s.captures[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":        c.ID,
+		"status":    c.Status,
+		"custom_id": c.CustomID,
+		"amount":    toAmount(c.Amount),
+	})
+}
+
+// refundCapture refunds a capture. A repeated PayPal-Request-Id returns the
+// refund made the first time, as PayPal does.
+func (s *Server) refundCapture(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Amount   *amount `json:"amount"`
+		CustomID string  `json:"custom_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requestID := r.Header.Get("PayPal-Request-Id")
+	if existing, ok := s.refunds[requestID]; ok && requestID != "" {
+		writeJSON(w, http.StatusOK, existing)
+		return
+	}
+
+	c, ok := s.captures[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "RESOURCE_NOT_FOUND", "INVALID_RESOURCE_ID")
+		return
+	}
+
+	outcome := s.next(OpRefund)
+	switch outcome {
+	case OutcomeServerError:
+		writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "SERVICE_UNAVAILABLE")
+		return
+	case OutcomeDeclined:
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "TRANSACTION_REFUSED")
+		return
+	}
+
+	remaining := c.Amount.Amount - c.Refunded
+	if remaining <= 0 {
+		writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "CAPTURE_FULLY_REFUNDED")
+		return
+	}
+	value := remaining
+	if req.Amount != nil {
+		parsed, err := money.Parse(req.Amount.Value)
+		if err != nil || parsed <= 0 || req.Amount.CurrencyCode != c.Amount.Currency {
+			writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "INVALID_PARAMETER_VALUE")
+			return
+		}
+		if parsed > remaining {
+			writeError(w, http.StatusUnprocessableEntity, "UNPROCESSABLE_ENTITY", "REFUND_AMOUNT_EXCEEDED")
+			return
+		}
+		value = parsed
+	}
+
+	refunded := &refund{
+		ID:     s.nextID("REFUND"),
+		Status: "COMPLETED",
+		Amount: toAmount(money.New(value, c.Amount.Currency)),
+	}
+	if outcome == OutcomePending {
+		refunded.Status = "PENDING"
+	} else {
+		c.Refunded += value
+		c.Status = "PARTIALLY_REFUNDED"
+		if c.Refunded >= c.Amount.Amount {
+			c.Status = "REFUNDED"
+		}
+	}
+	if requestID != "" {
+		s.refunds[requestID] = refunded
+	}
+
+	customID := req.CustomID
+	if customID == "" {
+		customID = c.CustomID
+	}
+	s.emit("PAYMENT.CAPTURE.REFUNDED", map[string]any{
+		"id":        refunded.ID,
+		"status":    refunded.Status,
+		"amount":    refunded.Amount,
+		"custom_id": customID,
+		"links": []link{
+			{Href: s.URL + "/v2/payments/refunds/" + refunded.ID, Rel: "self", Method: "GET"},
+			{Href: s.URL + "/v2/payments/captures/" + c.ID, Rel: "up", Method: "GET"},
+		},
+	})
+
+	writeJSON(w, http.StatusCreated, refunded)
+}
+
+// verifySignature accepts exactly the deliveries this fake made
+func (s *Server) verifySignature(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TransmissionID string `json:"transmission_id"`
+		WebhookEvent   struct {
+			ID string `json:"id"`
+		} `json:"webhook_event"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON")
+		return
+	}
+
+	s.mu.Lock()
+	eventID, ok := s.transmissions[req.TransmissionID]
+	s.mu.Unlock()
+
+	status := "FAILURE"
+	if ok && eventID == req.WebhookEvent.ID {
+		status = "SUCCESS"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"verification_status": status})
+}
+
+// scriptOutcomes lets a developer script outcomes of the running fake, with
+// a body such as {"operation": "capture", "outcomes": ["DECLINED"]}
+func (s *Server) scriptOutcomes(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Operation string    `json:"operation"`
+		Outcomes  []Outcome `json:"outcomes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Operation == "" {
+		writeError(w, http.StatusBadRequest, "INVALID_REQUEST", "MALFORMED_REQUEST_JSON")
+		return
+	}
+	s.Script(req.Operation, req.Outcomes...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// emit records an event and posts it to WebhookURL in the background, with
+// transmission headers that verifySignature later accepts. Callers hold mu.
+func (s *Server) emit(eventType string, resource map[string]any) {
+	raw, _ := json.Marshal(resource)
+	event := Event{
+		ID:           s.nextID("WH"),
+		EventType:    eventType,
+		ResourceType: strings.ToLower(strings.Split(eventType, ".")[1]),
+		CreateTime:   time.Now().UTC().Format(time.RFC3339),
+		Resource:     raw,
+	}
+	if eventType == "PAYMENT.CAPTURE.REFUNDED" {
+		event.ResourceType = "refund"
+	}
+	s.events = append(s.events, event)
+
+	if s.WebhookURL == "" {
+		return
+	}
+	transmissionID := s.nextID("TX")
+	s.transmissions[transmissionID] = event.ID
+
+	body, _ := json.Marshal(event)
+	s.deliveries.Add(1)
+	go func() {
+		defer s.deliveries.Done()
+		req, err := http.NewRequest("POST", s.WebhookURL, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("PAYPAL-AUTH-ALGO", "SHA256withRSA")
+		req.Header.Set("PAYPAL-CERT-URL", s.URL+"/v1/notifications/certs/fake")
+		req.Header.Set("PAYPAL-TRANSMISSION-ID", transmissionID)
+		req.Header.Set("PAYPAL-TRANSMISSION-SIG", "fake-signature")
+		req.Header.Set("PAYPAL-TRANSMISSION-TIME", event.CreateTime)
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return
+		}
+		_ = resp.Body.Close()
+	}()
+}
+
+func (s *Server) orderJSON(o *order) map[string]any {
+	unit := map[string]any{
+		"reference_id": o.ReferenceID,
+		"custom_id":    o.CustomID,
+		"amount":       o.Amount,
+	}
+	if c := o.Capture; c != nil {
+		unit["payments"] = map[string]any{
+			"captures": []map[string]any{{
+				"id":        c.ID,
+				"status":    c.Status,
+				"custom_id": c.CustomID,
+				"amount":    toAmount(c.Amount),
+			}},
+		}
+	}
+	return map[string]any{
+		"id":             o.ID,
+		"status":         o.Status,
+		"purchase_units": []map[string]any{unit},
+	}
+}
+
+// next pops the scripted outcome of an operation. Callers hold mu.
+func (s *Server) next(op string) Outcome {
+	queue := s.script[op]
+	if len(queue) == 0 {
+		return ""
+	}
+	s.script[op] = queue[1:]
+	return queue[0]
+}
+
+// failed answers a scripted server error for operations that have no other
+// outcomes. Callers hold mu.
+func (s *Server) failed(w http.ResponseWriter, op string) bool {
+	if s.next(op) != OutcomeServerError {
+		return false
+	}
+	writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "SERVICE_UNAVAILABLE")
+	return true
+}
+
+// nextID returns a unique ID with the given prefix. Callers hold mu.
+func (s *Server) nextID(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s-%06d", prefix, s.seq)
+}
+
+func toAmount(m money.Money) amount {
+	return amount{CurrencyCode: m.Currency, Value: m.Amount.String()}
+}
+
+// writeError answers in PayPal's error shape with a single issue
+func writeError(w http.ResponseWriter, status int, name, issue string) {
+	w.Header().Set("Paypal-Debug-Id", "fake-debug-id")
+	writeJSON(w, status, map[string]any{
+		"name":     name,
+		"message":  "The requested action could not be performed.",
+		"debug_id": "fake-debug-id",
+		"details":  []map[string]string{{"issue": issue}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
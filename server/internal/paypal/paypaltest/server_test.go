@@ -0,0 +1,277 @@
+package paypaltest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"services/internal/money"
+	"services/internal/paypal"
+	"services/internal/paypal/paypaltest"
+	"sync"
+	"testing"
+)
+
+// newClient starts a fake and returns a real client pointed at it
+func newClient(t *testing.T) (*paypal.Client, *paypaltest.Server) {
+	t.Helper()
+	fake := paypaltest.NewServer()
+	t.Cleanup(fake.Close)
+	return &paypal.Client{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		WebhookID:    "WH-TEST",
+		BaseURL:      fake.URL,
+		HTTPClient:   &http.Client{},
+	}, fake
+}
+
+var charge = paypal.Charge{Currency: "CAD", ItemTotal: 5000, TaxTotal: 649}
+
+// approvedOrder creates a PayPal order for the charge and approves it
+func approvedOrder(t *testing.T, client *paypal.Client, fake *paypaltest.Server) string {
+	t.Helper()
+	id, approveURL, err := client.CreateOrder(context.Background(), charge, "order-1")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if approveURL == "" {
+		t.Fatal("expected an approve link")
+	}
+	if err := fake.Approve(id); err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	return id
+}
+
+func issue(t *testing.T, err error, want string) {
+	t.Helper()
+	var apiErr *paypal.APIError
+	if !errors.As(err, &apiErr) || !apiErr.HasIssue(want) {
+		t.Fatalf("expected PayPal issue %s, got %v", want, err)
+	}
+}
+
+func TestCheckoutCaptureAndRefund(t *testing.T) {
+	client, fake := newClient(t)
+	ctx := context.Background()
+	id := approvedOrder(t, client, fake)
+
+	result, err := client.CaptureOrder(ctx, id)
+	if err != nil {
+		t.Fatalf("CaptureOrder: %v", err)
+	}
+	captured, _ := result.Amount.Money()
+	if result.Status != "COMPLETED" || result.ReferenceID != "order-1" || result.CaptureID == "" || captured != money.New(5649, "CAD") {
+		t.Fatalf("unexpected capture %+v", result)
+	}
+
+	if _, err := client.CaptureOrder(ctx, id); err == nil {
+		t.Fatal("expected a second capture to fail")
+	} else {
+		issue(t, err, "ORDER_ALREADY_CAPTURED")
+	}
+
+	refund, err := client.RefundCapture(ctx, result.CaptureID, money.New(1000, "CAD"), "order-1", "", "refund-1")
+	if err != nil {
+		t.Fatalf("RefundCapture: %v", err)
+	}
+	again, err := client.RefundCapture(ctx, result.CaptureID, money.New(1000, "CAD"), "order-1", "", "refund-1")
+	if err != nil || again.ID != refund.ID {
+		t.Fatalf("expected the same refund for a repeated request ID, got %+v, %v", again, err)
+	}
+
+	details, err := client.GetCapture(ctx, result.CaptureID)
+	if err != nil || details.Status != "PARTIALLY_REFUNDED" {
+		t.Fatalf("expected a partially refunded capture, got %+v, %v", details, err)
+	}
+
+	if _, err := client.RefundCapture(ctx, result.CaptureID, money.New(5000, "CAD"), "order-1", "", "refund-2"); err == nil {
+		t.Fatal("expected refunding more than remains to fail")
+	} else {
+		issue(t, err, "REFUND_AMOUNT_EXCEEDED")
+	}
+
+	// A zero amount refunds the rest
+	if _, err := client.RefundCapture(ctx, result.CaptureID, money.Money{}, "order-1", "", "refund-3"); err != nil {
+		t.Fatalf("RefundCapture: %v", err)
+	}
+	details, _ = client.GetCapture(ctx, result.CaptureID)
+	if details.Status != "REFUNDED" {
+		t.Errorf("expected a refunded capture, got %s", details.Status)
+	}
+}
+
+func TestCapture_RequiresApproval(t *testing.T) {
+	client, _ := newClient(t)
+	id, _, err := client.CreateOrder(context.Background(), charge, "order-1")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	_, err = client.CaptureOrder(context.Background(), id)
+	issue(t, err, "ORDER_NOT_APPROVED")
+}
+
+func TestApproveLink_RedirectsToReturnURL(t *testing.T) {
+	t.Setenv("FRONTEND_URL", "http://shop.test")
+	client, _ := newClient(t)
+	id, approveURL, err := client.CreateOrder(context.Background(), charge, "order-1")
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(approveURL)
+	if err != nil {
+		t.Fatalf("GET approve link: %v", err)
+	}
+	_ = resp.Body.Close()
+	location, _ := resp.Location()
+	if resp.StatusCode != http.StatusFound || location.Host != "shop.test" || location.Query().Get("order_id") != "order-1" || location.Query().Get("token") != id {
+		t.Fatalf("unexpected redirect %d to %v", resp.StatusCode, location)
+	}
+
+	if _, err := client.CaptureOrder(context.Background(), id); err != nil {
+		t.Errorf("expected the approved order to capture, got %v", err)
+	}
+}
+
+func TestScriptedOutcomes(t *testing.T) {
+	client, fake := newClient(t)
+	ctx := context.Background()
+
+	t.Run("declined", func(t *testing.T) {
+		id := approvedOrder(t, client, fake)
+		fake.Script(paypaltest.OpCapture, paypaltest.OutcomeDeclined)
+		_, err := client.CaptureOrder(ctx, id)
+		issue(t, err, "INSTRUMENT_DECLINED")
+	})
+
+	t.Run("already captured", func(t *testing.T) {
+		id := approvedOrder(t, client, fake)
+		fake.Script(paypaltest.OpCapture, paypaltest.OutcomeAlreadyCaptured)
+		_, err := client.CaptureOrder(ctx, id)
+		issue(t, err, "ORDER_ALREADY_CAPTURED")
+
+		order, err := client.GetOrder(ctx, id)
+		if err != nil || order.Status != "COMPLETED" || order.CaptureID == "" {
+			t.Errorf("expected the order to be captured anyway, got %+v, %v", order, err)
+		}
+	})
+
+	t.Run("server error then success", func(t *testing.T) {
+		id := approvedOrder(t, client, fake)
+		fake.Script(paypaltest.OpCapture, paypaltest.OutcomeServerError)
+		_, err := client.CaptureOrder(ctx, id)
+		var apiErr *paypal.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("expected a 503, got %v", err)
+		}
+		if _, err := client.CaptureOrder(ctx, id); err != nil {
+			t.Errorf("expected the retry to succeed, got %v", err)
+		}
+	})
+
+	t.Run("pending capture", func(t *testing.T) {
+		id := approvedOrder(t, client, fake)
+		fake.Script(paypaltest.OpCapture, paypaltest.OutcomePending)
+		result, err := client.CaptureOrder(ctx, id)
+		if err != nil || result.Status != "PENDING" {
+			t.Fatalf("expected the capture to be reported pending, got %+v, %v", result, err)
+		}
+		details, _ := client.GetCapture(ctx, result.CaptureID)
+		if details.Status != "PENDING" {
+			t.Errorf("expected a pending capture, got %s", details.Status)
+		}
+	})
+}
+
+func TestRejectsUnknownTokens(t *testing.T) {
+	client, fake := newClient(t)
+	id := approvedOrder(t, client, fake)
+	fake.RevokeTokens()
+
+	_, err := client.GetOrder(context.Background(), id)
+	var apiErr *paypal.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a 401, got %v", err)
+	}
+	// The client drops the rejected token and fetches a new one
+	if _, err := client.GetOrder(context.Background(), id); err != nil {
+		t.Errorf("expected a fresh token to work, got %v", err)
+	}
+}
+
+func TestWebhooks_DeliveredAndVerifiable(t *testing.T) {
+	client, fake := newClient(t)
+
+	type delivery struct {
+		headers http.Header
+		body    []byte
+	}
+	var (
+		mu         sync.Mutex
+		deliveries []delivery
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		deliveries = append(deliveries, delivery{r.Header.Clone(), body})
+		mu.Unlock()
+	}))
+	t.Cleanup(receiver.Close)
+	fake.WebhookURL = receiver.URL
+
+	id := approvedOrder(t, client, fake)
+	result, err := client.CaptureOrder(context.Background(), id)
+	if err != nil {
+		t.Fatalf("CaptureOrder: %v", err)
+	}
+	if _, err := client.RefundCapture(context.Background(), result.CaptureID, money.Money{}, "order-1", "", "refund-1"); err != nil {
+		t.Fatalf("RefundCapture: %v", err)
+	}
+	fake.WaitForDeliveries()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deliveries) != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", len(deliveries))
+	}
+
+	types := map[string]*paypal.CaptureResource{}
+	for _, d := range deliveries {
+		if err := client.VerifyWebhookSignature(context.Background(), d.headers, d.body); err != nil {
+			t.Errorf("expected the delivery to verify, got %v", err)
+		}
+		event, err := paypal.ParseWebhookEvent(d.body)
+		if err != nil {
+			t.Fatalf("ParseWebhookEvent: %v", err)
+		}
+		resource, err := event.DecodeCapture()
+		if err != nil {
+			t.Fatalf("DecodeCapture: %v", err)
+		}
+		types[event.EventType] = resource
+	}
+
+	completed := types[paypal.EventCaptureCompleted]
+	if completed == nil || completed.ID != result.CaptureID || completed.CustomID != "order-1" || completed.SupplementaryData.RelatedIDs.OrderID != id {
+		t.Errorf("unexpected capture event %+v", completed)
+	}
+	refunded := types[paypal.EventCaptureRefunded]
+	if refunded == nil || refunded.ParentCaptureID() != result.CaptureID || refunded.Amount.Value != "56.49" {
+		t.Errorf("unexpected refund event %+v", refunded)
+	}
+
+	// A tampered delivery does not verify
+	forged, _ := json.Marshal(map[string]string{"id": "WH-FORGED", "event_type": paypal.EventCaptureCompleted})
+	if err := client.VerifyWebhookSignature(context.Background(), deliveries[0].headers, forged); !errors.Is(err, paypal.ErrInvalidWebhookSignature) {
+		t.Errorf("expected ErrInvalidWebhookSignature, got %v", err)
+	}
+	if len(fake.Events()) != 2 {
+		t.Errorf("expected 2 recorded events, got %d", len(fake.Events()))
+	}
+}
@@ -0,0 +1,127 @@
+package paypal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// tokenRefreshMargin is how long before expiry a cached token is replaced,
+// so a token never expires between being handed out and reaching PayPal
+const tokenRefreshMargin = 5 * time.Minute
+
+// tokenCache holds the OAuth access token shared by every outbound call.
+// Concurrent callers that find it stale share a single refresh.
+type tokenCache struct {
+	mu        sync.RWMutex
+	token     string
+	refreshAt time.Time
+	group     singleflight.Group
+}
+
+func (t *tokenCache) get() (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.token == "" || !time.Now().Before(t.refreshAt) {
+		return "", false
+	}
+	return t.token, true
+}
+
+func (t *tokenCache) set(token string, expiresIn time.Duration) {
+	margin := tokenRefreshMargin
+	if expiresIn <= 2*margin {
+		margin = expiresIn / 2
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+	t.refreshAt = time.Now().Add(expiresIn - margin)
+}
+
+// invalidate drops the cached token if it is still the given one
+func (t *tokenCache) invalidate(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == token {
+		t.token = ""
+		t.refreshAt = time.Time{}
+	}
+}
+
+// getAccessToken returns a cached token, refreshing it with the Client
+// Credentials grant when missing or close to expiry
+func (c *Client) getAccessToken(ctx context.Context) (string, error) {
+	if token, ok := c.tokens.get(); ok {
+		return token, nil
+	}
+
+	// The shared refresh must not be cancelled just because the caller that
+	// happened to start it gave up, so it runs without the caller's deadline
+	// (but keeps its values for tracing).
+	ch := c.tokens.group.DoChan("token", func() (any, error) {
+		return c.fetchAccessToken(context.WithoutCancel(ctx))
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return "", res.Err
+		}
+		return res.Val.(string), nil
+	}
+}
+
+func (c *Client) fetchAccessToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v1/oauth2/token", bytes.NewBuffer([]byte("grant_type=client_credentials")))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.ClientID, c.ClientSecret)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError("get access token", resp)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", fmt.Errorf("access token not found in response")
+	}
+
+	c.tokens.set(result.AccessToken, time.Duration(result.ExpiresIn)*time.Second)
+	return result.AccessToken, nil
+}
+
+// checkTokenRejected drops the cached token when PayPal answers 401, so the
+// next call fetches a fresh one instead of reusing a revoked token
+func (c *Client) checkTokenRejected(resp *http.Response) {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return
+	}
+	token := resp.Request.Header.Get("Authorization")
+	if len(token) > len("Bearer ") {
+		c.tokens.invalidate(token[len("Bearer "):])
+	}
+}
@@ -0,0 +1,174 @@
+package paypal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"services/internal/money"
+	"strings"
+)
+
+// Webhook event types handled by the server
+const (
+	EventCaptureCompleted = "PAYMENT.CAPTURE.COMPLETED"
+	EventCaptureDenied    = "PAYMENT.CAPTURE.DENIED"
+	EventCapturePending   = "PAYMENT.CAPTURE.PENDING"
+	EventCaptureRefunded  = "PAYMENT.CAPTURE.REFUNDED"
+)
+
+var ErrInvalidWebhookSignature = errors.New("invalid webhook signature")
+
+// WebhookEvent is the envelope PayPal posts to the webhook endpoint
+type WebhookEvent struct {
+	ID           string          `json:"id"`
+	EventType    string          `json:"event_type"`
+	ResourceType string          `json:"resource_type"`
+	Resource     json.RawMessage `json:"resource"`
+}
+
+type Amount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+// Money converts PayPal's amount into an exact money value
+func (a Amount) Money() (money.Money, error) {
+	amount, err := money.Parse(a.Value)
+	if err != nil {
+		return money.Money{}, err
+	}
+	return money.New(amount, a.CurrencyCode), nil
+}
+
+type Link struct {
+	Href   string `json:"href"`
+	Rel    string `json:"rel"`
+	Method string `json:"method"`
+}
+
+// CaptureResource is the resource of PAYMENT.CAPTURE.* events. For
+// PAYMENT.CAPTURE.REFUNDED the resource is the refund, whose "up" link
+// points at the refunded capture.
+type CaptureResource struct {
+	ID                string `json:"id"`
+	Status            string `json:"status"`
+	Amount            Amount `json:"amount"`
+	CustomID          string `json:"custom_id"`
+	InvoiceID         string `json:"invoice_id"`
+	SupplementaryData struct {
+		RelatedIDs struct {
+			OrderID string `json:"order_id"`
+		} `json:"related_ids"`
+	} `json:"supplementary_data"`
+	Links []Link `json:"links"`
+}
+
+// ParentCaptureID returns the capture ID referenced by the resource's "up"
+// link, which is how refunds point back at the capture they reverse
+func (r *CaptureResource) ParentCaptureID() string {
+	for _, link := range r.Links {
+		if link.Rel != "up" {
+			continue
+		}
+		idx := strings.Index(link.Href, "/captures/")
+		if idx == -1 {
+			continue
+		}
+		return strings.Trim(link.Href[idx+len("/captures/"):], "/")
+	}
+	return ""
+}
+
+// ParseWebhookEvent decodes a raw webhook body
+func ParseWebhookEvent(body []byte) (*WebhookEvent, error) {
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to decode webhook event: %w", err)
+	}
+	if event.ID == "" || event.EventType == "" {
+		return nil, errors.New("webhook event is missing id or event_type")
+	}
+	return &event, nil
+}
+
+// DecodeCapture decodes the event resource as a capture (or refund)
+func (e *WebhookEvent) DecodeCapture() (*CaptureResource, error) {
+	var resource CaptureResource
+	if err := json.Unmarshal(e.Resource, &resource); err != nil {
+		return nil, fmt.Errorf("failed to decode capture resource: %w", err)
+	}
+	return &resource, nil
+}
+
+type verifySignatureRequest struct {
+	AuthAlgo         string          `json:"auth_algo"`
+	CertURL          string          `json:"cert_url"`
+	TransmissionID   string          `json:"transmission_id"`
+	TransmissionSig  string          `json:"transmission_sig"`
+	TransmissionTime string          `json:"transmission_time"`
+	WebhookID        string          `json:"webhook_id"`
+	WebhookEvent     json.RawMessage `json:"webhook_event"`
+}
+
+// VerifyWebhookSignature asks PayPal to verify the transmission signature of
+// a webhook delivery against the configured PAYPAL_WEBHOOK_ID
+func (c *Client) VerifyWebhookSignature(ctx context.Context, headers http.Header, body []byte) error {
+	if c.WebhookID == "" {
+		return fmt.Errorf("%w: PAYPAL_WEBHOOK_ID not set", ErrInvalidWebhookSignature)
+	}
+
+	verifyReq := verifySignatureRequest{
+		AuthAlgo:         headers.Get("PAYPAL-AUTH-ALGO"),
+		CertURL:          headers.Get("PAYPAL-CERT-URL"),
+		TransmissionID:   headers.Get("PAYPAL-TRANSMISSION-ID"),
+		TransmissionSig:  headers.Get("PAYPAL-TRANSMISSION-SIG"),
+		TransmissionTime: headers.Get("PAYPAL-TRANSMISSION-TIME"),
+		WebhookID:        c.WebhookID,
+		WebhookEvent:     json.RawMessage(body),
+	}
+	if verifyReq.TransmissionID == "" || verifyReq.TransmissionSig == "" {
+		return fmt.Errorf("%w: missing transmission headers", ErrInvalidWebhookSignature)
+	}
+
+	token, err := c.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	bodyBytes, err := json.Marshal(verifyReq)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/v1/notifications/verify-webhook-signature", bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	c.checkTokenRejected(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError("verify webhook signature", resp)
+	}
+
+	var result struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.VerificationStatus != "SUCCESS" {
+		return fmt.Errorf("%w: verification status %s", ErrInvalidWebhookSignature, result.VerificationStatus)
+	}
+	return nil
+}
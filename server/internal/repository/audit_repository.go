@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"services/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type AuditRepository interface {
+	Record(ctx context.Context, event *models.AuditEvent) error
+}
+
+type PostgresAuditRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresAuditRepository(db *gorm.DB) AuditRepository {
+	return &PostgresAuditRepository{db: db}
+}
+
+func (r *PostgresAuditRepository) Record(ctx context.Context, event *models.AuditEvent) error {
+	if err := r.db.WithContext(ctx).Create(event).Error; err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
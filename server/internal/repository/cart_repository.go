@@ -4,19 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"services/internal/coupon"
 	"services/internal/models"
+	"services/internal/money"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 var (
-	ErrCartNotFound     = errors.New("cart not found")
-	ErrCartItemNotFound = errors.New("cart item not found")
+	ErrCartNotFound       = errors.New("cart not found")
+	ErrCartItemNotFound   = errors.New("cart item not found")
+	ErrCartCouponNotFound = errors.New("coupon not applied to cart")
 )
 
 type CartRepository interface {
 	// Cart operations
 	GetCartByUserID(ctx context.Context, userID string) (*models.Cart, error)
+	// GetGuestCart retrieves a cart that does not belong to a user yet
+	GetGuestCart(ctx context.Context, cartID string) (*models.Cart, error)
 	CreateCart(ctx context.Context, cart *models.Cart) error
 	ClearCart(ctx context.Context, cartID string) error
 	DeleteCart(ctx context.Context, cartID string) error
@@ -25,11 +31,36 @@ type CartRepository interface {
 	AddItemToCart(ctx context.Context, cartItem *models.CartItem) error
 	GetCartItem(ctx context.Context, cartItemID string) (*models.CartItem, error)
 	UpdateCartItem(ctx context.Context, cartItem *models.CartItem) error
+	// SetItemPlan puts an item on a payment plan at the plan's price, or back
+	// to paying in full when planID is nil
+	SetItemPlan(ctx context.Context, cartItemID string, planID *uint, price money.Amount) error
 	RemoveItemFromCart(ctx context.Context, cartItemID string) error
 	GetCartItemByCourseID(ctx context.Context, cartID, courseID string) (*models.CartItem, error)
 
+	// Coupon operations
+	AddCouponToCart(ctx context.Context, cartCoupon *models.CartCoupon) error
+	RemoveCouponFromCart(ctx context.Context, cartID, couponID string) error
+
 	// Utility operations
-	GetCartTotal(ctx context.Context, cartID string) (float64, error)
+	GetCartTotal(ctx context.Context, cartID string) (money.Amount, error)
+	GetCartQuote(ctx context.Context, cartID string) (*coupon.Quote, error)
+
+	// Abandoned cart reminders
+	// ClaimAbandoned marks carts with items that have not changed since
+	// idleSince, and have had no reminder since they last changed nor one
+	// after remindedBefore, as reminded at now and returns them. Each cart is
+	// claimed once, however many runs overlap.
+	ClaimAbandoned(ctx context.Context, idleSince, remindedBefore, now time.Time) ([]*models.Cart, error)
+
+	// Guest carts
+	// MergeGuestCart moves a guest cart's items and coupons into the user's
+	// cart and deletes the guest cart. Courses already in the user's cart or
+	// that the user is enrolled in are dropped. It returns how many items
+	// were moved.
+	MergeGuestCart(ctx context.Context, guestCartID, userID string) (int64, error)
+	// DeleteStaleGuestCarts deletes guest carts that have not changed since
+	// idleSince
+	DeleteStaleGuestCarts(ctx context.Context, idleSince time.Time) (int64, error)
 }
 
 type PostgresCartRepository struct {
@@ -46,6 +77,9 @@ func (r *PostgresCartRepository) GetCartByUserID(ctx context.Context, userID str
 	err := r.db.WithContext(ctx).
 		Preload("Items.Course.Instructor").
 		Preload("Items.Course").
+		Preload("Items.PaymentPlan").
+		Preload("Coupons", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Preload("Coupons.Coupon").
 		Where("user_id = ?", userID).
 		First(&cart).Error
 
@@ -59,7 +93,29 @@ func (r *PostgresCartRepository) GetCartByUserID(ctx context.Context, userID str
 	return &cart, nil
 }
 
-// CreateCart creates a new cart for a user
+// GetGuestCart retrieves a guest cart with all items and course details
+func (r *PostgresCartRepository) GetGuestCart(ctx context.Context, cartID string) (*models.Cart, error) {
+	var cart models.Cart
+	err := r.db.WithContext(ctx).
+		Preload("Items.Course.Instructor").
+		Preload("Items.Course").
+		Preload("Items.PaymentPlan").
+		Preload("Coupons", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Preload("Coupons.Coupon").
+		Where("id = ? AND user_id IS NULL", cartID).
+		First(&cart).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCartNotFound
+		}
+		return nil, fmt.Errorf("failed to get guest cart: %w", err)
+	}
+
+	return &cart, nil
+}
+
+// CreateCart creates a new cart for a user, or a guest cart when UserID is nil
 func (r *PostgresCartRepository) CreateCart(ctx context.Context, cart *models.Cart) error {
 	if err := r.db.WithContext(ctx).Create(cart).Error; err != nil {
 		return fmt.Errorf("failed to create cart: %w", err)
@@ -67,12 +123,16 @@ func (r *PostgresCartRepository) CreateCart(ctx context.Context, cart *models.Ca
 	return nil
 }
 
-// ClearCart removes all items from a cart
+// ClearCart removes all items and coupons from a cart
 func (r *PostgresCartRepository) ClearCart(ctx context.Context, cartID string) error {
 	result := r.db.WithContext(ctx).Where("cart_id = ?", cartID).Delete(&models.CartItem{})
 	if result.Error != nil {
 		return fmt.Errorf("failed to clear cart: %w", result.Error)
 	}
+	result = r.db.WithContext(ctx).Where("cart_id = ?", cartID).Delete(&models.CartCoupon{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to clear cart coupons: %w", result.Error)
+	}
 	return nil
 }
 
@@ -131,6 +191,20 @@ func (r *PostgresCartRepository) UpdateCartItem(ctx context.Context, cartItem *m
 	return nil
 }
 
+// SetItemPlan changes the payment plan and price of a cart item. A nil plan
+// is written too, which UpdateCartItem would skip.
+func (r *PostgresCartRepository) SetItemPlan(ctx context.Context, cartItemID string, planID *uint, price money.Amount) error {
+	result := r.db.WithContext(ctx).Model(&models.CartItem{}).Where("id = ?", cartItemID).
+		Updates(map[string]interface{}{"payment_plan_id": planID, "price_minor": price})
+	if result.Error != nil {
+		return fmt.Errorf("failed to set cart item plan: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCartItemNotFound
+	}
+	return nil
+}
+
 // RemoveItemFromCart removes an item from the cart
 func (r *PostgresCartRepository) RemoveItemFromCart(ctx context.Context, cartItemID string) error {
 	result := r.db.WithContext(ctx).Delete(&models.CartItem{}, "id = ?", cartItemID)
@@ -160,18 +234,189 @@ func (r *PostgresCartRepository) GetCartItemByCourseID(ctx context.Context, cart
 	return &cartItem, nil
 }
 
-// GetCartTotal calculates the total price of all items in the cart
-func (r *PostgresCartRepository) GetCartTotal(ctx context.Context, cartID string) (float64, error) {
-	var total float64
+// AddCouponToCart applies a coupon to the cart
+func (r *PostgresCartRepository) AddCouponToCart(ctx context.Context, cartCoupon *models.CartCoupon) error {
+	if err := r.db.WithContext(ctx).Create(cartCoupon).Error; err != nil {
+		return fmt.Errorf("failed to add coupon to cart: %w", err)
+	}
+	return nil
+}
+
+// RemoveCouponFromCart takes a coupon off the cart
+func (r *PostgresCartRepository) RemoveCouponFromCart(ctx context.Context, cartID, couponID string) error {
+	result := r.db.WithContext(ctx).Where("cart_id = ? AND coupon_id = ?", cartID, couponID).Delete(&models.CartCoupon{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove coupon from cart: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCartCouponNotFound
+	}
+	return nil
+}
+
+// ClaimAbandoned claims idle carts due a reminder and loads them with their
+// user and courses. A cart being claimed by another run is skipped once that
+// run commits, as its reminder_sent_at is then newer than its items.
+func (r *PostgresCartRepository) ClaimAbandoned(ctx context.Context, idleSince, remindedBefore, now time.Time) ([]*models.Cart, error) {
+	var ids []string
+	err := r.db.WithContext(ctx).Raw(`
+		UPDATE carts SET reminder_sent_at = ?
+		FROM (SELECT cart_id, MAX(updated_at) AS last_activity FROM cart_items WHERE deleted_at IS NULL GROUP BY cart_id) AS activity
+		WHERE activity.cart_id = carts.id AND carts.user_id IS NOT NULL AND carts.deleted_at IS NULL
+			AND activity.last_activity < ?
+			AND (carts.reminder_sent_at IS NULL OR (carts.reminder_sent_at < activity.last_activity AND carts.reminder_sent_at < ?))
+		RETURNING carts.id`,
+		now, idleSince, remindedBefore,
+	).Scan(&ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim abandoned carts: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var carts []*models.Cart
+	if err := r.db.WithContext(ctx).Preload("User").Preload("Items.Course").Find(&carts, "id IN ?", ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to load abandoned carts: %w", err)
+	}
+	return carts, nil
+}
+
+// GetCartTotal calculates what the cart costs after coupon discounts
+func (r *PostgresCartRepository) GetCartTotal(ctx context.Context, cartID string) (money.Amount, error) {
+	quote, err := r.GetCartQuote(ctx, cartID)
+	if err != nil {
+		return 0, err
+	}
+	return quote.Total, nil
+}
+
+// GetCartQuote prices the cart's items with the coupons applied to it, in the
+// order they were applied. Coupons that no longer apply are listed in
+// Quote.Rejected and do not discount the cart.
+func (r *PostgresCartRepository) GetCartQuote(ctx context.Context, cartID string) (*coupon.Quote, error) {
+	var cart models.Cart
 	err := r.db.WithContext(ctx).
-		Model(&models.CartItem{}).
-		Where("cart_id = ?", cartID).
-		Select("COALESCE(SUM(price), 0)").
-		Scan(&total).Error
+		Preload("Items").
+		Preload("Coupons", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Preload("Coupons.Coupon").
+		First(&cart, "id = ?", cartID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCartNotFound
+		}
+		return nil, fmt.Errorf("failed to get cart for pricing: %w", err)
+	}
+
+	// Guests have no redemptions of their own until they sign in
+	var userID string
+	if cart.UserID != nil {
+		userID = *cart.UserID
+	}
+	candidates := make([]coupon.Candidate, 0, len(cart.Coupons))
+	for i := range cart.Coupons {
+		c := &cart.Coupons[i].Coupon
+		if c.ID == "" {
+			continue // Coupon was deleted after it was applied
+		}
+		usage, err := countRedemptions(r.db.WithContext(ctx), c.ID, userID)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, coupon.Candidate{Coupon: c, Usage: usage})
+	}
+
+	quote := coupon.Price(cart.Items, candidates, time.Now())
+	return &quote, nil
+}
+
+// MergeGuestCart moves what is in a guest cart into the user's cart, creating
+// the user's cart if needed, in a single transaction
+func (r *PostgresCartRepository) MergeGuestCart(ctx context.Context, guestCartID, userID string) (int64, error) {
+	var moved int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var guest models.Cart
+		if err := tx.Where("id = ? AND user_id IS NULL", guestCartID).First(&guest).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrCartNotFound
+			}
+			return fmt.Errorf("failed to get guest cart: %w", err)
+		}
+
+		var cart models.Cart
+		err := tx.Where("user_id = ?", userID).First(&cart).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			cart = models.Cart{UserID: &userID}
+			err = tx.Create(&cart).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user cart: %w", err)
+		}
 
+		inCart := tx.Model(&models.CartItem{}).Select("course_id").Where("cart_id = ?", cart.ID)
+		enrolled := tx.Model(&models.UserCourses{}).Select("course_id").Where("user_id = ?", userID)
+		result := tx.Model(&models.CartItem{}).
+			Where("cart_id = ?", guest.ID).
+			Where("course_id NOT IN (?)", inCart).
+			Where("course_id NOT IN (?)", enrolled).
+			Update("cart_id", cart.ID)
+		if result.Error != nil {
+			return fmt.Errorf("failed to move guest cart items: %w", result.Error)
+		}
+		moved = result.RowsAffected
+
+		applied := tx.Model(&models.CartCoupon{}).Select("coupon_id").Where("cart_id = ?", cart.ID)
+		if err := tx.Model(&models.CartCoupon{}).
+			Where("cart_id = ?", guest.ID).
+			Where("coupon_id NOT IN (?)", applied).
+			Update("cart_id", cart.ID).Error; err != nil {
+			return fmt.Errorf("failed to move guest cart coupons: %w", err)
+		}
+
+		return deleteCarts(tx, []string{guest.ID})
+	})
 	if err != nil {
-		return 0, fmt.Errorf("failed to calculate cart total: %w", err)
+		return 0, err
 	}
+	return moved, nil
+}
+
+// DeleteStaleGuestCarts deletes guest carts, with their items and coupons,
+// when neither the cart nor any of its items changed since idleSince
+func (r *PostgresCartRepository) DeleteStaleGuestCarts(ctx context.Context, idleSince time.Time) (int64, error) {
+	var deleted int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		active := tx.Unscoped().Model(&models.CartItem{}).Select("cart_id").Where("updated_at >= ?", idleSince)
 
-	return total, nil
+		var ids []string
+		if err := tx.Model(&models.Cart{}).
+			Where("user_id IS NULL AND updated_at < ?", idleSince).
+			Where("id NOT IN (?)", active).
+			Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("failed to find stale guest carts: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		deleted = int64(len(ids))
+		return deleteCarts(tx, ids)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// deleteCarts permanently deletes carts with everything in them
+func deleteCarts(tx *gorm.DB, cartIDs []string) error {
+	if err := tx.Unscoped().Where("cart_id IN ?", cartIDs).Delete(&models.CartItem{}).Error; err != nil {
+		return fmt.Errorf("failed to delete cart items: %w", err)
+	}
+	if err := tx.Unscoped().Where("cart_id IN ?", cartIDs).Delete(&models.CartCoupon{}).Error; err != nil {
+		return fmt.Errorf("failed to delete cart coupons: %w", err)
+	}
+	if err := tx.Unscoped().Where("id IN ?", cartIDs).Delete(&models.Cart{}).Error; err != nil {
+		return fmt.Errorf("failed to delete carts: %w", err)
+	}
+	return nil
 }
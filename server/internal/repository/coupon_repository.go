@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/coupon"
+	"services/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCouponNotFound = errors.New("coupon not found")
+)
+
+type CouponRepository interface {
+	Create(ctx context.Context, c *models.Coupon) error
+	FindByID(ctx context.Context, id string) (*models.Coupon, error)
+	FindByCode(ctx context.Context, code string) (*models.Coupon, error)
+	FindAll(ctx context.Context) ([]*models.Coupon, error)
+	Update(ctx context.Context, c *models.Coupon) error
+	Delete(ctx context.Context, id string) error
+
+	// FindByIDForUpdate locks the coupon row until the transaction ends, so
+	// checkouts reserving the coupon count its usage one at a time
+	FindByIDForUpdate(ctx context.Context, id string) (*models.Coupon, error)
+
+	// Usage is counted from redemptions, which unpaid orders reserve at
+	// checkout and paid orders keep
+	CountRedemptions(ctx context.Context, couponID, userID string) (coupon.Usage, error)
+	// CreateRedemption does nothing when the order already redeemed the coupon
+	CreateRedemption(ctx context.Context, redemption *models.CouponRedemption) error
+	// ReleaseRedemptions gives back the coupon uses of an order that will
+	// not be paid
+	ReleaseRedemptions(ctx context.Context, orderID string) error
+}
+
+type PostgresCouponRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresCouponRepository(db *gorm.DB) CouponRepository {
+	return &PostgresCouponRepository{db: db}
+}
+
+func (r *PostgresCouponRepository) Create(ctx context.Context, c *models.Coupon) error {
+	if err := r.db.WithContext(ctx).Create(c).Error; err != nil {
+		return fmt.Errorf("failed to create coupon: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresCouponRepository) FindByID(ctx context.Context, id string) (*models.Coupon, error) {
+	var c models.Coupon
+	if err := r.db.WithContext(ctx).First(&c, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, fmt.Errorf("failed to find coupon: %w", err)
+	}
+	return &c, nil
+}
+
+func (r *PostgresCouponRepository) FindByIDForUpdate(ctx context.Context, id string) (*models.Coupon, error) {
+	var c models.Coupon
+	if err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&c, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, fmt.Errorf("failed to lock coupon: %w", err)
+	}
+	return &c, nil
+}
+
+func (r *PostgresCouponRepository) FindByCode(ctx context.Context, code string) (*models.Coupon, error) {
+	var c models.Coupon
+	if err := r.db.WithContext(ctx).First(&c, "code = ?", coupon.NormalizeCode(code)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, fmt.Errorf("failed to find coupon by code: %w", err)
+	}
+	return &c, nil
+}
+
+func (r *PostgresCouponRepository) FindAll(ctx context.Context) ([]*models.Coupon, error) {
+	var coupons []*models.Coupon
+	if err := r.db.WithContext(ctx).Order("created_at DESC").Find(&coupons).Error; err != nil {
+		return nil, fmt.Errorf("failed to list coupons: %w", err)
+	}
+	return coupons, nil
+}
+
+func (r *PostgresCouponRepository) Update(ctx context.Context, c *models.Coupon) error {
+	// Select the editable fields so limits and flags can be set back to zero
+	result := r.db.WithContext(ctx).Model(c).
+		Select("Code", "Description", "Type", "PercentOff", "AmountOff", "CourseIDs", "MinSubtotal",
+			"StartsAt", "EndsAt", "MaxRedemptions", "MaxRedemptionsPerUser", "Stackable", "Active").
+		Updates(c)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update coupon: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCouponNotFound
+	}
+	return nil
+}
+
+// Delete removes the coupon for good so its code can be reused. Orders keep
+// the code on their discount lines.
+func (r *PostgresCouponRepository) Delete(ctx context.Context, id string) error {
+	result := r.db.WithContext(ctx).Unscoped().Delete(&models.Coupon{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete coupon: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCouponNotFound
+	}
+	return nil
+}
+
+func (r *PostgresCouponRepository) CountRedemptions(ctx context.Context, couponID, userID string) (coupon.Usage, error) {
+	return countRedemptions(r.db.WithContext(ctx), couponID, userID)
+}
+
+func (r *PostgresCouponRepository) CreateRedemption(ctx context.Context, redemption *models.CouponRedemption) error {
+	if err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(redemption).Error; err != nil {
+		return fmt.Errorf("failed to record coupon redemption: %w", err)
+	}
+	return nil
+}
+
+// ReleaseRedemptions deletes the rows for good, a soft deleted row would
+// still hold the order's unique index
+func (r *PostgresCouponRepository) ReleaseRedemptions(ctx context.Context, orderID string) error {
+	if err := r.db.WithContext(ctx).Unscoped().Delete(&models.CouponRedemption{}, "order_id = ?", orderID).Error; err != nil {
+		return fmt.Errorf("failed to release coupon redemptions: %w", err)
+	}
+	return nil
+}
+
+// countRedemptions is shared with the cart repository, which prices carts
+func countRedemptions(db *gorm.DB, couponID, userID string) (coupon.Usage, error) {
+	var usage coupon.Usage
+	query := db.Model(&models.CouponRedemption{}).Where("coupon_id = ?", couponID)
+	if userID == "" {
+		// No user yet, so nothing is counted against them
+		query = query.Select("COUNT(*) AS total")
+	} else {
+		query = query.Select("COUNT(*) AS total, COUNT(*) FILTER (WHERE user_id = ?) AS by_user", userID)
+	}
+	err := query.Scan(&usage).Error
+	if err != nil {
+		return coupon.Usage{}, fmt.Errorf("failed to count coupon redemptions: %w", err)
+	}
+	return usage, nil
+}
@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/enrollment"
+	"services/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrEnrollmentCodeNotFound = errors.New("enrollment code not found")
+	ErrEnrollmentCodeRedeemed = errors.New("enrollment code already redeemed")
+	ErrAlreadyEnrolled        = errors.New("user is already enrolled in the course")
+)
+
+type EnrollmentCodeRepository interface {
+	// Create stores a batch of codes
+	Create(ctx context.Context, codes []*models.EnrollmentCode) error
+	FindByCode(ctx context.Context, code string) (*models.EnrollmentCode, error)
+	// FindByIssuer lists the codes of one kind a user bought or issued,
+	// newest first
+	FindByIssuer(ctx context.Context, issuedByID, kind string) ([]*models.EnrollmentCode, error)
+	FindByOrderID(ctx context.Context, orderID string) ([]*models.EnrollmentCode, error)
+	// Redeem enrolls the user in the code's course and uses the code up. A
+	// code of a course the user already has is left unused.
+	Redeem(ctx context.Context, code, userID string) (*models.EnrollmentCode, error)
+	// RevokeByOrderID withdraws the order's codes nobody has redeemed yet
+	RevokeByOrderID(ctx context.Context, orderID string, at time.Time) (int64, error)
+	// HasRedeemed reports whether the user redeemed a code for the course
+	// that still grants it, meaning its gift order was not fully refunded
+	HasRedeemed(ctx context.Context, userID, courseID string) (bool, error)
+}
+
+type PostgresEnrollmentCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresEnrollmentCodeRepository(db *gorm.DB) EnrollmentCodeRepository {
+	return &PostgresEnrollmentCodeRepository{db: db}
+}
+
+func (r *PostgresEnrollmentCodeRepository) Create(ctx context.Context, codes []*models.EnrollmentCode) error {
+	if len(codes) == 0 {
+		return nil
+	}
+	if err := r.db.WithContext(ctx).Create(codes).Error; err != nil {
+		return fmt.Errorf("failed to create enrollment codes: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresEnrollmentCodeRepository) FindByCode(ctx context.Context, code string) (*models.EnrollmentCode, error) {
+	var c models.EnrollmentCode
+	err := r.db.WithContext(ctx).Preload("Course").First(&c, "code = ?", enrollment.NormalizeCode(code)).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEnrollmentCodeNotFound
+		}
+		return nil, fmt.Errorf("failed to find enrollment code: %w", err)
+	}
+	return &c, nil
+}
+
+func (r *PostgresEnrollmentCodeRepository) FindByIssuer(ctx context.Context, issuedByID, kind string) ([]*models.EnrollmentCode, error) {
+	var codes []*models.EnrollmentCode
+	err := r.db.WithContext(ctx).
+		Preload("Course").
+		Where("issued_by_id = ? AND kind = ?", issuedByID, kind).
+		Order("created_at DESC").
+		Find(&codes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list enrollment codes: %w", err)
+	}
+	return codes, nil
+}
+
+func (r *PostgresEnrollmentCodeRepository) FindByOrderID(ctx context.Context, orderID string) ([]*models.EnrollmentCode, error) {
+	var codes []*models.EnrollmentCode
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Find(&codes).Error; err != nil {
+		return nil, fmt.Errorf("failed to list enrollment codes of order: %w", err)
+	}
+	return codes, nil
+}
+
+// Redeem locks the code so two people racing to redeem it cannot both get in
+func (r *PostgresEnrollmentCodeRepository) Redeem(ctx context.Context, code, userID string) (*models.EnrollmentCode, error) {
+	var redeemed models.EnrollmentCode
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&redeemed, "code = ? AND revoked_at IS NULL", enrollment.NormalizeCode(code)).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrEnrollmentCodeNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find enrollment code: %w", err)
+		}
+		if redeemed.RedeemedAt != nil {
+			return ErrEnrollmentCodeRedeemed
+		}
+
+		var enrolled int64
+		if err := tx.Model(&models.UserCourses{}).
+			Where("user_id = ? AND course_id = ?", userID, redeemed.CourseID).
+			Count(&enrolled).Error; err != nil {
+			return fmt.Errorf("failed to check enrollment: %w", err)
+		}
+		if enrolled > 0 {
+			return ErrAlreadyEnrolled
+		}
+
+		if err := NewPostgresUserRepository(tx).AssignCourse(ctx, userID, redeemed.CourseID); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		redeemed.RedeemedByID = &userID
+		redeemed.RedeemedAt = &now
+		if err := tx.Model(&redeemed).Updates(map[string]any{
+			"redeemed_by_id": userID,
+			"redeemed_at":    now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to redeem enrollment code: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &redeemed, nil
+}
+
+func (r *PostgresEnrollmentCodeRepository) RevokeByOrderID(ctx context.Context, orderID string, at time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&models.EnrollmentCode{}).
+		Where("order_id = ? AND redeemed_at IS NULL AND revoked_at IS NULL", orderID).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to revoke enrollment codes: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+func (r *PostgresEnrollmentCodeRepository) HasRedeemed(ctx context.Context, userID, courseID string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.EnrollmentCode{}).
+		Joins("LEFT JOIN orders ON orders.id = enrollment_codes.order_id").
+		Where("enrollment_codes.redeemed_by_id = ? AND enrollment_codes.course_id = ?", userID, courseID).
+		Where("enrollment_codes.order_id IS NULL OR orders.status <> ?", "REFUNDED").
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check redeemed enrollment codes: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInstallmentNotFound = errors.New("installment not found")
+)
+
+type InstallmentRepository interface {
+	FindByID(ctx context.Context, id string) (*models.Installment, error)
+	FindByIDForUpdate(ctx context.Context, id string) (*models.Installment, error)
+	FindByOrderID(ctx context.Context, orderID string) ([]models.Installment, error)
+	// FindUnpaidDue lists unpaid installments due at or before now on orders
+	// that have been paid for at checkout
+	FindUnpaidDue(ctx context.Context, now time.Time) ([]models.Installment, error)
+	// ClaimReminders marks the scheduled installments of paid orders that
+	// fall due after now and no later than before as reminded, and returns
+	// them. Each one is claimed once, however many runs overlap.
+	ClaimReminders(ctx context.Context, now, before time.Time) ([]models.Installment, error)
+	// ClaimCharge reserves an unpaid installment for an automatic charge at
+	// now, unless one was already made within interval. It reports whether
+	// the caller got the reservation.
+	ClaimCharge(ctx context.Context, id string, now time.Time, interval time.Duration) (bool, error)
+	Update(ctx context.Context, installment *models.Installment) error
+}
+
+type PostgresInstallmentRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresInstallmentRepository(db *gorm.DB) InstallmentRepository {
+	return &PostgresInstallmentRepository{db: db}
+}
+
+func (r *PostgresInstallmentRepository) FindByID(ctx context.Context, id string) (*models.Installment, error) {
+	var installment models.Installment
+	if err := r.db.WithContext(ctx).First(&installment, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInstallmentNotFound
+		}
+		return nil, fmt.Errorf("failed to find installment: %w", err)
+	}
+	return &installment, nil
+}
+
+// FindByIDForUpdate loads an installment and locks the row until the
+// surrounding transaction ends
+func (r *PostgresInstallmentRepository) FindByIDForUpdate(ctx context.Context, id string) (*models.Installment, error) {
+	var installment models.Installment
+	if err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&installment, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInstallmentNotFound
+		}
+		return nil, fmt.Errorf("failed to lock installment: %w", err)
+	}
+	return &installment, nil
+}
+
+func (r *PostgresInstallmentRepository) FindByOrderID(ctx context.Context, orderID string) ([]models.Installment, error) {
+	var installments []models.Installment
+	if err := r.db.WithContext(ctx).Where("order_id = ?", orderID).Order("sequence").Find(&installments).Error; err != nil {
+		return nil, fmt.Errorf("failed to find order installments: %w", err)
+	}
+	return installments, nil
+}
+
+func (r *PostgresInstallmentRepository) FindUnpaidDue(ctx context.Context, now time.Time) ([]models.Installment, error) {
+	var installments []models.Installment
+	err := r.db.WithContext(ctx).
+		Joins("JOIN orders ON orders.id = installments.order_id").
+		Where("installments.status <> ? AND installments.due_at <= ?", "PAID", now).
+		Where("orders.status IN ?", []string{"COMPLETED", "PARTIALLY_REFUNDED"}).
+		Order("installments.due_at").
+		Find(&installments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find due installments: %w", err)
+	}
+	return installments, nil
+}
+
+func (r *PostgresInstallmentRepository) ClaimReminders(ctx context.Context, now, before time.Time) ([]models.Installment, error) {
+	var installments []models.Installment
+	err := r.db.WithContext(ctx).Raw(`
+		UPDATE installments SET reminded_at = ?, updated_at = ?
+		WHERE status = ? AND reminded_at IS NULL AND due_at > ? AND due_at <= ? AND deleted_at IS NULL
+			AND order_id IN (SELECT id FROM orders WHERE status IN ? AND deleted_at IS NULL)
+		RETURNING *`,
+		now, now, "SCHEDULED", now, before, []string{"COMPLETED", "PARTIALLY_REFUNDED"},
+	).Scan(&installments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim installment reminders: %w", err)
+	}
+	return installments, nil
+}
+
+func (r *PostgresInstallmentRepository) ClaimCharge(ctx context.Context, id string, now time.Time, interval time.Duration) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.Installment{}).
+		Where("id = ? AND status <> ?", id, "PAID").
+		Where("last_charge_at IS NULL OR last_charge_at <= ?", now.Add(-interval)).
+		Update("last_charge_at", now)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim installment charge: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *PostgresInstallmentRepository) Update(ctx context.Context, installment *models.Installment) error {
+	result := r.db.WithContext(ctx).Model(installment).
+		Select("status", "paid_at", "payment_id", "failed_attempts", "last_failure").
+		Updates(installment)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update installment: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInstallmentNotFound
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvoiceNotFound = errors.New("invoice not found")
+)
+
+// invoiceCounter is the invoice_counters row invoice numbers are taken from
+const invoiceCounter = "invoice"
+
+type InvoiceRepository interface {
+	// NextSequence takes the next invoice number. The counter row stays locked
+	// until the surrounding transaction ends and is only advanced if it
+	// commits, so numbers have no gaps.
+	NextSequence(ctx context.Context) (int64, error)
+	Create(ctx context.Context, invoice *models.Invoice) error
+	// FindByOrderID finds the receipt of an installment of an order, or its
+	// checkout invoice when installment is 0
+	FindByOrderID(ctx context.Context, orderID string, installment int) (*models.Invoice, error)
+	MarkEmailed(ctx context.Context, id string, at time.Time) error
+}
+
+type PostgresInvoiceRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresInvoiceRepository(db *gorm.DB) InvoiceRepository {
+	return &PostgresInvoiceRepository{db: db}
+}
+
+func (r *PostgresInvoiceRepository) NextSequence(ctx context.Context) (int64, error) {
+	var next int64
+	err := r.db.WithContext(ctx).Raw(
+		`INSERT INTO invoice_counters (name, value) VALUES (?, 1)
+		 ON CONFLICT (name) DO UPDATE SET value = invoice_counters.value + 1
+		 RETURNING value`,
+		invoiceCounter,
+	).Scan(&next).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to take invoice number: %w", err)
+	}
+	return next, nil
+}
+
+func (r *PostgresInvoiceRepository) Create(ctx context.Context, invoice *models.Invoice) error {
+	if err := r.db.WithContext(ctx).Create(invoice).Error; err != nil {
+		return fmt.Errorf("failed to create invoice: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresInvoiceRepository) FindByOrderID(ctx context.Context, orderID string, installment int) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := r.db.WithContext(ctx).First(&invoice, "order_id = ? AND installment = ?", orderID, installment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, fmt.Errorf("failed to find invoice: %w", err)
+	}
+	return &invoice, nil
+}
+
+func (r *PostgresInvoiceRepository) MarkEmailed(ctx context.Context, id string, at time.Time) error {
+	result := r.db.WithContext(ctx).Model(&models.Invoice{}).Where("id = ?", id).Update("emailed_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark invoice emailed: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvoiceNotFound
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// JobLocker makes sure a background job runs on one replica at a time
+type JobLocker interface {
+	// TryDo runs fn while holding the lock named after the job. It reports
+	// false without running fn when another replica holds the lock.
+	TryDo(ctx context.Context, job string, fn func(ctx context.Context) error) (bool, error)
+}
+
+// PostgresJobLocker takes a Postgres session advisory lock on one pooled
+// connection. Postgres releases it if that connection is lost, so a replica
+// that dies mid-run does not block the job.
+type PostgresJobLocker struct {
+	db *gorm.DB
+}
+
+func NewPostgresJobLocker(db *gorm.DB) JobLocker {
+	return &PostgresJobLocker{db: db}
+}
+
+func (l *PostgresJobLocker) TryDo(ctx context.Context, job string, fn func(ctx context.Context) error) (bool, error) {
+	locked := false
+	err := l.db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		if err := conn.Raw(`SELECT pg_try_advisory_lock(hashtext(?))`, job).Scan(&locked).Error; err != nil {
+			return fmt.Errorf("failed to lock job %s: %w", job, err)
+		}
+		if !locked {
+			return nil
+		}
+		defer func() {
+			// Unlock even when ctx was cancelled during the run
+			_ = conn.WithContext(context.WithoutCancel(ctx)).Exec(`SELECT pg_advisory_unlock(hashtext(?))`, job).Error
+		}()
+		return fn(ctx)
+	})
+	return locked, err
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"services/internal/models"
+	"sync"
+	"time"
+)
+
+// MemoryLoginAttemptRepository keeps login counters in process. It is for
+// tests: counters are not shared between replicas and are lost on restart.
+type MemoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempt
+}
+
+func NewMemoryLoginAttemptRepository() *MemoryLoginAttemptRepository {
+	return &MemoryLoginAttemptRepository{attempts: make(map[string]models.LoginAttempt)}
+}
+
+func (r *MemoryLoginAttemptRepository) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempt, ok := r.attempts[key]
+	if !ok {
+		attempt = models.LoginAttempt{Key: key}
+	}
+	return &attempt, nil
+}
+
+func (r *MemoryLoginAttemptRepository) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempt := r.attempts[key]
+	attempt.Key = key
+	if attempt.LastFailureAt.Before(now.Add(-window)) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	r.attempts[key] = attempt
+	return &attempt, nil
+}
+
+func (r *MemoryLoginAttemptRepository) Reserve(ctx context.Context, key string, now time.Time, window time.Duration, wait func(*models.LoginAttempt) time.Duration) (*models.LoginAttempt, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attempt := r.attempts[key]
+	attempt.Key = key
+	if delay := wait(&attempt); delay > 0 {
+		return &attempt, delay, nil
+	}
+	if attempt.LastFailureAt.Before(now.Add(-window)) {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	r.attempts[key] = attempt
+	return &attempt, 0, nil
+}
+
+func (r *MemoryLoginAttemptRepository) Release(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempt, ok := r.attempts[key]; ok && attempt.Failures > 0 {
+		attempt.Failures--
+		r.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (r *MemoryLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempt, ok := r.attempts[key]; ok {
+		attempt.LockedUntil = &until
+		r.attempts[key] = attempt
+	}
+	return nil
+}
+
+func (r *MemoryLoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if attempt, ok := r.attempts[key]; ok {
+		attempt.Failures = 0
+		attempt.LockedUntil = nil
+		r.attempts[key] = attempt
+	}
+	return nil
+}
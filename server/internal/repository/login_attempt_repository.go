@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttemptRepository keeps failed login counters by key
+type LoginAttemptRepository interface {
+	// Get returns the counter of a key, with no failures if it has none
+	Get(ctx context.Context, key string) (*models.LoginAttempt, error)
+	// RecordFailure counts a failed login. Failures older than window are
+	// forgotten, so the count starts over at one.
+	RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error)
+	// Reserve counts an attempt as a failure before its password is checked.
+	// wait sees the key's counter while no other attempt can change it and
+	// returns how long this one has to wait; the attempt is only counted
+	// when that is zero.
+	Reserve(ctx context.Context, key string, now time.Time, window time.Duration, wait func(*models.LoginAttempt) time.Duration) (*models.LoginAttempt, time.Duration, error)
+	// Release takes back one reserved attempt
+	Release(ctx context.Context, key string) error
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset clears a key's failures and lockout
+	Reset(ctx context.Context, key string) error
+}
+
+type PostgresLoginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresLoginAttemptRepository(db *gorm.DB) LoginAttemptRepository {
+	return &PostgresLoginAttemptRepository{db: db}
+}
+
+func (r *PostgresLoginAttemptRepository) Get(ctx context.Context, key string) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	if err := r.db.WithContext(ctx).Where("key = ?", key).First(&attempt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &models.LoginAttempt{Key: key}, nil
+		}
+		return nil, fmt.Errorf("failed to get login attempts: %w", err)
+	}
+	return &attempt, nil
+}
+
+// RecordFailure increments the counter in one upsert so replicas counting
+// the same key at once do not lose failures
+func (r *PostgresLoginAttemptRepository) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (*models.LoginAttempt, error) {
+	var attempt models.LoginAttempt
+	err := r.db.WithContext(ctx).Raw(`
+		INSERT INTO login_attempts (key, failures, last_failure_at, created_at, updated_at)
+		VALUES (?, 1, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = EXCLUDED.last_failure_at,
+			updated_at = EXCLUDED.updated_at
+		RETURNING *`,
+		key, now, now, now, now.Add(-window),
+	).Scan(&attempt).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return &attempt, nil
+}
+
+// Reserve locks the key's row for the check, so parallel attempts line up
+// behind it and each sees the ones before it counted
+func (r *PostgresLoginAttemptRepository) Reserve(ctx context.Context, key string, now time.Time, window time.Duration, wait func(*models.LoginAttempt) time.Duration) (*models.LoginAttempt, time.Duration, error) {
+	var attempt models.LoginAttempt
+	var delay time.Duration
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// A key seen for the first time needs a row to lock
+		if err := tx.Exec(`
+			INSERT INTO login_attempts (key, failures, last_failure_at, created_at, updated_at)
+			VALUES (?, 0, ?, ?, ?)
+			ON CONFLICT (key) DO NOTHING`,
+			key, time.Time{}, now, now,
+		).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&attempt).Error; err != nil {
+			return err
+		}
+
+		if delay = wait(&attempt); delay > 0 {
+			return nil
+		}
+		if attempt.LastFailureAt.Before(now.Add(-window)) {
+			attempt.Failures = 0
+		}
+		attempt.Failures++
+		attempt.LastFailureAt = now
+		return tx.Model(&models.LoginAttempt{}).Where("key = ?", key).
+			Updates(map[string]any{"failures": attempt.Failures, "last_failure_at": now}).Error
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to reserve login attempt: %w", err)
+	}
+	return &attempt, delay, nil
+}
+
+func (r *PostgresLoginAttemptRepository) Release(ctx context.Context, key string) error {
+	if err := r.db.WithContext(ctx).Model(&models.LoginAttempt{}).Where("key = ? AND failures > 0", key).
+		Update("failures", gorm.Expr("failures - 1")).Error; err != nil {
+		return fmt.Errorf("failed to release login attempt: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresLoginAttemptRepository) Lock(ctx context.Context, key string, until time.Time) error {
+	if err := r.db.WithContext(ctx).Model(&models.LoginAttempt{}).Where("key = ?", key).Update("locked_until", until).Error; err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+	return nil
+}
+
+// Reset zeroes the row rather than deleting it, a soft deleted row would
+// still hold the key's unique index
+func (r *PostgresLoginAttemptRepository) Reset(ctx context.Context, key string) error {
+	if err := r.db.WithContext(ctx).Model(&models.LoginAttempt{}).Where("key = ?", key).
+		Updates(map[string]any{"failures": 0, "locked_until": nil}).Error; err != nil {
+		return fmt.Errorf("failed to reset login attempts: %w", err)
+	}
+	return nil
+}
@@ -5,19 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"services/internal/models"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
 	ErrOrderNotFound = errors.New("order not found")
 )
 
+// OrderFilter narrows an order search. Zero values are ignored.
+type OrderFilter struct {
+	UserID string
+	Status string
+	From   *time.Time // created at or after
+	To     *time.Time // created before
+	Limit  int        // 0 returns every match
+	Offset int
+}
+
 type OrderRepository interface {
 	Create(ctx context.Context, order *models.Order) error
 	FindByID(ctx context.Context, id string) (*models.Order, error)
+	FindByIDForUpdate(ctx context.Context, id string) (*models.Order, error)
 	FindAll(ctx context.Context) ([]*models.Order, error)
 	FindByUserID(ctx context.Context, userID string) ([]*models.Order, error)
+	FindByFilter(ctx context.Context, filter OrderFilter) ([]*models.Order, error)
 	Update(ctx context.Context, order *models.Order) error
 	UpdateStatus(ctx context.Context, id string, status string) error
 	Delete(ctx context.Context, id string) error
@@ -31,6 +45,11 @@ func NewPostgresOrderRepository(db *gorm.DB) OrderRepository {
 	return &PostgresOrderRepository{db: db}
 }
 
+// bySequence orders preloaded installments by when they are due
+func bySequence(db *gorm.DB) *gorm.DB {
+	return db.Order("sequence")
+}
+
 func (r *PostgresOrderRepository) Create(ctx context.Context, order *models.Order) error {
 	if err := r.db.WithContext(ctx).Create(order).Error; err != nil {
 		return fmt.Errorf("failed to create order: %w", err)
@@ -40,7 +59,7 @@ func (r *PostgresOrderRepository) Create(ctx context.Context, order *models.Orde
 
 func (r *PostgresOrderRepository) FindByID(ctx context.Context, id string) (*models.Order, error) {
 	var order models.Order
-	if err := r.db.WithContext(ctx).Preload("Items").Preload("Items.Course").Preload("Payments").First(&order, "id = ?", id).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("Items").Preload("Items.Course").Preload("Payments").Preload("Attempts").Preload("Discounts").Preload("Taxes").Preload("Installments", bySequence).First(&order, "id = ?", id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrOrderNotFound
 		}
@@ -49,6 +68,19 @@ func (r *PostgresOrderRepository) FindByID(ctx context.Context, id string) (*mod
 	return &order, nil
 }
 
+// FindByIDForUpdate loads an order without its associations and locks the row
+// until the surrounding transaction ends
+func (r *PostgresOrderRepository) FindByIDForUpdate(ctx context.Context, id string) (*models.Order, error) {
+	var order models.Order
+	if err := r.db.WithContext(ctx).Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, fmt.Errorf("failed to lock order: %w", err)
+	}
+	return &order, nil
+}
+
 func (r *PostgresOrderRepository) FindAll(ctx context.Context) ([]*models.Order, error) {
 	var orders []*models.Order
 	if err := r.db.WithContext(ctx).Preload("Items").Preload("Payments").Find(&orders).Error; err != nil {
@@ -59,12 +91,43 @@ func (r *PostgresOrderRepository) FindAll(ctx context.Context) ([]*models.Order,
 
 func (r *PostgresOrderRepository) FindByUserID(ctx context.Context, userID string) ([]*models.Order, error) {
 	var orders []*models.Order
-	if err := r.db.WithContext(ctx).Preload("Items").Preload("Payments").Where("user_id = ?", userID).Find(&orders).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("Items").Preload("Items.Course").Preload("Payments").Preload("Attempts").Preload("Discounts").Preload("Taxes").Preload("Installments", bySequence).
+		Where("user_id = ?", userID).Order("created_at DESC").Find(&orders).Error; err != nil {
 		return nil, fmt.Errorf("failed to find user orders: %w", err)
 	}
 	return orders, nil
 }
 
+func (r *PostgresOrderRepository) FindByFilter(ctx context.Context, filter OrderFilter) ([]*models.Order, error) {
+	query := r.db.WithContext(ctx).Preload("Items").Preload("Payments").Preload("Attempts").Preload("Discounts").Preload("Taxes").Preload("Installments", bySequence)
+	if filter.UserID != "" {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.From != nil {
+		query = query.Where("created_at >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("created_at < ?", *filter.To)
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if filter.Offset > 0 {
+		query = query.Offset(filter.Offset)
+	}
+
+	// The ID breaks ties so pages do not overlap when orders share a timestamp
+	var orders []*models.Order
+	if err := query.Order("created_at DESC").Order("id DESC").Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("failed to search orders: %w", err)
+	}
+	return orders, nil
+}
+
 func (r *PostgresOrderRepository) Update(ctx context.Context, order *models.Order) error {
 	result := r.db.WithContext(ctx).Model(order).Updates(order)
 	if result.Error != nil {
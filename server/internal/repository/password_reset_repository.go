@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/auth"
+	"services/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrPasswordResetInvalid = errors.New("password reset token is invalid or expired")
+
+type PasswordResetRepository interface {
+	Create(ctx context.Context, reset *models.PasswordReset) error
+	// ResetPassword uses up a reset token and sets its user's password. Every
+	// other reset token and every session of the user stop working.
+	ResetPassword(ctx context.Context, token, passwordHash string) (string, error)
+}
+
+type PostgresPasswordResetRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresPasswordResetRepository(db *gorm.DB) PasswordResetRepository {
+	return &PostgresPasswordResetRepository{db: db}
+}
+
+func (r *PostgresPasswordResetRepository) Create(ctx context.Context, reset *models.PasswordReset) error {
+	if err := r.db.WithContext(ctx).Create(reset).Error; err != nil {
+		return fmt.Errorf("failed to create password reset: %w", err)
+	}
+	return nil
+}
+
+// ResetPassword returns the ID of the user whose password was reset
+func (r *PostgresPasswordResetRepository) ResetPassword(ctx context.Context, token, passwordHash string) (string, error) {
+	var reset models.PasswordReset
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&reset, "token_hash = ?", auth.HashToken(token)).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrPasswordResetInvalid
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find password reset: %w", err)
+		}
+
+		now := time.Now()
+		if reset.UsedAt != nil || reset.ExpiresAt.Before(now) {
+			return ErrPasswordResetInvalid
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", reset.UserID).Update("password", passwordHash).Error; err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		if err := tx.Model(&models.PasswordReset{}).
+			Where("user_id = ? AND used_at IS NULL", reset.UserID).
+			Update("used_at", now).Error; err != nil {
+			return fmt.Errorf("failed to use up password resets: %w", err)
+		}
+		if err := tx.Where("user_id = ?", reset.UserID).Delete(&models.Session{}).Error; err != nil {
+			return fmt.Errorf("failed to delete user sessions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return reset.UserID, nil
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"services/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPaymentAttemptNotFound = errors.New("payment attempt not found")
+)
+
+type PaymentAttemptRepository interface {
+	Create(ctx context.Context, attempt *models.PaymentAttempt) error
+	FindByProviderOrderID(ctx context.Context, providerOrderID string) (*models.PaymentAttempt, error)
+	FindByStatus(ctx context.Context, status string) ([]*models.PaymentAttempt, error)
+	Update(ctx context.Context, attempt *models.PaymentAttempt) error
+}
+
+type PostgresPaymentAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresPaymentAttemptRepository(db *gorm.DB) PaymentAttemptRepository {
+	return &PostgresPaymentAttemptRepository{db: db}
+}
+
+func (r *PostgresPaymentAttemptRepository) Create(ctx context.Context, attempt *models.PaymentAttempt) error {
+	if err := r.db.WithContext(ctx).Create(attempt).Error; err != nil {
+		return fmt.Errorf("failed to create payment attempt: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresPaymentAttemptRepository) FindByProviderOrderID(ctx context.Context, providerOrderID string) (*models.PaymentAttempt, error) {
+	var attempt models.PaymentAttempt
+	if err := r.db.WithContext(ctx).First(&attempt, "provider_order_id = ?", providerOrderID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentAttemptNotFound
+		}
+		return nil, fmt.Errorf("failed to find payment attempt: %w", err)
+	}
+	return &attempt, nil
+}
+
+func (r *PostgresPaymentAttemptRepository) FindByStatus(ctx context.Context, status string) ([]*models.PaymentAttempt, error) {
+	var attempts []*models.PaymentAttempt
+	if err := r.db.WithContext(ctx).Where("status = ?", status).Order("created_at DESC").Find(&attempts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list payment attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+func (r *PostgresPaymentAttemptRepository) Update(ctx context.Context, attempt *models.PaymentAttempt) error {
+	result := r.db.WithContext(ctx).Model(attempt).Updates(attempt)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update payment attempt: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrPaymentAttemptNotFound
+	}
+	return nil
+}
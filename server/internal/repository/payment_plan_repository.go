@@ -19,6 +19,9 @@ type PaymentPlanRepository interface {
 	FindAll(ctx context.Context) ([]*models.PaymentPlan, error)
 	Update(ctx context.Context, plan *models.PaymentPlan) error
 	Delete(ctx context.Context, id string) error
+	// FindSessionLimit returns the strictest session limit of the plans a
+	// user has paid for, or 0 when none of them has one
+	FindSessionLimit(ctx context.Context, userID string) (int, error)
 }
 
 type PostgresPaymentPlanRepository struct {
@@ -76,3 +79,21 @@ func (r *PostgresPaymentPlanRepository) Delete(ctx context.Context, id string) e
 	}
 	return nil
 }
+
+func (r *PostgresPaymentPlanRepository) FindSessionLimit(ctx context.Context, userID string) (int, error) {
+	var limit *int
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT MIN(payment_plans.max_sessions) FROM order_items
+		JOIN orders ON orders.id = order_items.order_id AND orders.deleted_at IS NULL
+		JOIN payment_plans ON payment_plans.id = order_items.payment_plan_id AND payment_plans.deleted_at IS NULL
+		WHERE orders.user_id = ? AND orders.status IN ? AND order_items.deleted_at IS NULL`,
+		userID, []string{"COMPLETED", "PARTIALLY_REFUNDED"},
+	).Scan(&limit).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find session limit: %w", err)
+	}
+	if limit == nil {
+		return 0, nil
+	}
+	return *limit, nil
+}
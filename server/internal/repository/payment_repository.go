@@ -17,6 +17,7 @@ type PaymentRepository interface {
 	Create(ctx context.Context, payment *models.Payment) error
 	FindByID(ctx context.Context, id string) (*models.Payment, error)
 	FindAll(ctx context.Context) ([]*models.Payment, error)
+	FindByProviderTransactionID(ctx context.Context, transactionID string) (*models.Payment, error)
 	Update(ctx context.Context, payment *models.Payment) error
 	Delete(ctx context.Context, id string) error
 }
@@ -55,6 +56,17 @@ func (r *PostgresPaymentRepository) FindAll(ctx context.Context) ([]*models.Paym
 	return payments, nil
 }
 
+func (r *PostgresPaymentRepository) FindByProviderTransactionID(ctx context.Context, transactionID string) (*models.Payment, error) {
+	var payment models.Payment
+	if err := r.db.WithContext(ctx).First(&payment, "provider_transaction_id = ?", transactionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("failed to find payment: %w", err)
+	}
+	return &payment, nil
+}
+
 func (r *PostgresPaymentRepository) Update(ctx context.Context, payment *models.Payment) error {
 	result := r.db.WithContext(ctx).Model(payment).Updates(payment)
 	if result.Error != nil {
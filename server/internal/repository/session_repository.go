@@ -6,21 +6,33 @@ import (
 	"fmt"
 	"time"
 
+	"services/internal/auth"
 	"services/internal/models"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
 	ErrSessionNotFound = errors.New("session not found")
 	ErrInvalidToken    = errors.New("invalid token")
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again. Its whole session family has been revoked.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
 )
 
+// SessionRepository stores sessions by the hashes of their tokens. Methods
+// taking a token take it as the client sent it.
 type SessionRepository interface {
 	CreateSession(ctx context.Context, session *models.Session) error
 	FindByAccessToken(ctx context.Context, token string) (*models.Session, error)
 	FindByRefreshToken(ctx context.Context, token string) (*models.Session, error)
+	RotateSession(ctx context.Context, currentID string, next *models.Session) error
+	FindUserSessions(ctx context.Context, userID string) ([]*models.Session, error)
+	FindUserAgents(ctx context.Context, userID string) ([]string, error)
+	TouchSession(ctx context.Context, id string, at time.Time) error
 	DeleteSession(ctx context.Context, token string) error
+	DeleteFamily(ctx context.Context, userID, familyID string) error
 	DeleteExpiredSessions(ctx context.Context) error
 	DeleteUserSessions(ctx context.Context, userID string) error
 }
@@ -33,6 +45,7 @@ func NewPostgresSessionRepository(db *gorm.DB) SessionRepository {
 	return &PostgresSessionRepository{db: db}
 }
 
+// CreateSession stores a session. Without a FamilyID it starts a new family.
 func (r *PostgresSessionRepository) CreateSession(ctx context.Context, session *models.Session) error {
 	if err := r.db.WithContext(ctx).Create(session).Error; err != nil {
 		return fmt.Errorf("failed to create session: %w", err)
@@ -40,26 +53,30 @@ func (r *PostgresSessionRepository) CreateSession(ctx context.Context, session *
 	return nil
 }
 
+// FindByAccessToken returns the session of an access token that has neither
+// expired nor been revoked
 func (r *PostgresSessionRepository) FindByAccessToken(ctx context.Context, token string) (*models.Session, error) {
 	var session models.Session
-	if err := r.db.WithContext(ctx).Preload("User").Where("access_token = ?", token).First(&session).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("User").Where("access_token_hash = ?", auth.HashToken(token)).First(&session).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrSessionNotFound
 		}
 		return nil, fmt.Errorf("failed to find session: %w", err)
 	}
 
-	// Check if session is expired
-	if session.ExpiresAt.Before(time.Now()) {
+	// Check if session is expired or revoked
+	if session.ExpiresAt.Before(time.Now()) || session.RevokedAt != nil {
 		return nil, ErrInvalidToken
 	}
 
 	return &session, nil
 }
 
+// FindByRefreshToken returns the session of a refresh token whatever its
+// state, so that RotateSession can tell a reused token from an unknown one
 func (r *PostgresSessionRepository) FindByRefreshToken(ctx context.Context, token string) (*models.Session, error) {
 	var session models.Session
-	if err := r.db.WithContext(ctx).Preload("User").Where("refresh_token = ?", token).First(&session).Error; err != nil {
+	if err := r.db.WithContext(ctx).Preload("User").Where("refresh_token_hash = ?", auth.HashToken(token)).First(&session).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, ErrSessionNotFound
 		}
@@ -68,8 +85,104 @@ func (r *PostgresSessionRepository) FindByRefreshToken(ctx context.Context, toke
 	return &session, nil
 }
 
+// RotateSession retires the session currentID and stores next in its family.
+// A session can only be rotated once: rotating it again revokes every session
+// of the family and returns ErrRefreshTokenReused. Revoked sessions and expired
+// refresh tokens return ErrInvalidToken.
+func (r *PostgresSessionRepository) RotateSession(ctx context.Context, currentID string, next *models.Session) error {
+	reused := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.Session
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, "id = ?", currentID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find session: %w", err)
+		}
+
+		now := time.Now()
+		if current.RevokedAt != nil {
+			return ErrInvalidToken
+		}
+		if current.RotatedAt != nil {
+			// Committed rather than rolled back, the error is returned after
+			reused = true
+			return revokeFamily(tx, current.FamilyID, now)
+		}
+		if current.RefreshExpiresAt.Before(now) {
+			return ErrInvalidToken
+		}
+
+		if err := tx.Model(&models.Session{}).Where("id = ?", current.ID).Update("rotated_at", now).Error; err != nil {
+			return fmt.Errorf("failed to rotate session: %w", err)
+		}
+		next.UserID = current.UserID
+		next.FamilyID = current.FamilyID
+		if err := tx.Create(next).Error; err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if reused {
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// revokeFamily revokes every session of a family that is not revoked yet
+func revokeFamily(tx *gorm.DB, familyID string, at time.Time) error {
+	if err := tx.Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", at).Error; err != nil {
+		return fmt.Errorf("failed to revoke session family: %w", err)
+	}
+	return nil
+}
+
+// FindUserSessions returns the user's signed in sessions, one per family,
+// most recently seen first
+func (r *PostgresSessionRepository) FindUserSessions(ctx context.Context, userID string) ([]*models.Session, error) {
+	var sessions []*models.Session
+	if err := r.db.WithContext(ctx).
+		Where("user_id = ? AND rotated_at IS NULL AND revoked_at IS NULL AND refresh_expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to find user sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// FindUserAgents returns every user agent the user has a session from,
+// including rotated and revoked ones
+func (r *PostgresSessionRepository) FindUserAgents(ctx context.Context, userID string) ([]string, error) {
+	var agents []string
+	if err := r.db.WithContext(ctx).Model(&models.Session{}).
+		Where("user_id = ?", userID).
+		Distinct().Pluck("user_agent", &agents).Error; err != nil {
+		return nil, fmt.Errorf("failed to find user agents: %w", err)
+	}
+	return agents, nil
+}
+
+// TouchSession records that a session was used at the given time
+func (r *PostgresSessionRepository) TouchSession(ctx context.Context, id string, at time.Time) error {
+	if err := r.db.WithContext(ctx).Model(&models.Session{}).Where("id = ?", id).Update("last_seen_at", at).Error; err != nil {
+		return fmt.Errorf("failed to touch session: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession ends the session of an access or refresh token along with the
+// rest of its family
 func (r *PostgresSessionRepository) DeleteSession(ctx context.Context, token string) error {
-	result := r.db.WithContext(ctx).Where("access_token = ? OR refresh_token = ?", token, token).Delete(&models.Session{})
+	hash := auth.HashToken(token)
+	family := r.db.Model(&models.Session{}).Select("family_id").
+		Where("access_token_hash = ? OR refresh_token_hash = ?", hash, hash)
+	result := r.db.WithContext(ctx).Where("family_id IN (?)", family).Delete(&models.Session{})
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete session: %w", result.Error)
 	}
@@ -79,8 +192,22 @@ func (r *PostgresSessionRepository) DeleteSession(ctx context.Context, token str
 	return nil
 }
 
+// DeleteFamily ends one of the user's sessions along with the rest of its
+// family
+func (r *PostgresSessionRepository) DeleteFamily(ctx context.Context, userID, familyID string) error {
+	result := r.db.WithContext(ctx).Where("user_id = ? AND family_id = ?", userID, familyID).Delete(&models.Session{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete session family: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredSessions deletes sessions whose refresh token has expired
 func (r *PostgresSessionRepository) DeleteExpiredSessions(ctx context.Context) error {
-	if err := r.db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&models.Session{}).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("refresh_expires_at < ?", time.Now()).Delete(&models.Session{}).Error; err != nil {
 		return fmt.Errorf("failed to delete expired sessions: %w", err)
 	}
 	return nil
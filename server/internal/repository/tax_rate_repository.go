@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"services/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type TaxRateRepository interface {
+	FindByProvince(ctx context.Context, province string) ([]models.TaxRate, error)
+	FindAll(ctx context.Context) ([]models.TaxRate, error)
+	// ReplaceProvince swaps a province's rates for rates in one transaction
+	ReplaceProvince(ctx context.Context, province string, rates []models.TaxRate) error
+}
+
+type PostgresTaxRateRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresTaxRateRepository(db *gorm.DB) TaxRateRepository {
+	return &PostgresTaxRateRepository{db: db}
+}
+
+func (r *PostgresTaxRateRepository) FindByProvince(ctx context.Context, province string) ([]models.TaxRate, error) {
+	var rates []models.TaxRate
+	if err := r.db.WithContext(ctx).Where("province = ?", province).Order("name").Find(&rates).Error; err != nil {
+		return nil, fmt.Errorf("failed to find tax rates: %w", err)
+	}
+	return rates, nil
+}
+
+func (r *PostgresTaxRateRepository) FindAll(ctx context.Context) ([]models.TaxRate, error) {
+	var rates []models.TaxRate
+	if err := r.db.WithContext(ctx).Order("province, name").Find(&rates).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tax rates: %w", err)
+	}
+	return rates, nil
+}
+
+func (r *PostgresTaxRateRepository) ReplaceProvince(ctx context.Context, province string, rates []models.TaxRate) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("province = ?", province).Delete(&models.TaxRate{}).Error; err != nil {
+			return fmt.Errorf("failed to clear tax rates: %w", err)
+		}
+		if len(rates) == 0 {
+			return nil
+		}
+		for i := range rates {
+			rates[i].Province = province
+		}
+		if err := tx.Create(&rates).Error; err != nil {
+			return fmt.Errorf("failed to create tax rates: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Repositories is the set of repositories bound to a single unit of work
+type Repositories struct {
+	Orders       OrderRepository
+	Payments     PaymentRepository
+	Users        UserRepository
+	Carts        CartRepository
+	Coupons      CouponRepository
+	Installments InstallmentRepository
+	Invoices     InvoiceRepository
+	Codes        EnrollmentCodeRepository
+}
+
+// UnitOfWork runs a function against repositories that share one database
+// transaction. The transaction commits when fn returns nil and is rolled back
+// when it returns an error or panics.
+type UnitOfWork interface {
+	Do(ctx context.Context, fn func(repos Repositories) error) error
+}
+
+type PostgresUnitOfWork struct {
+	db *gorm.DB
+}
+
+func NewPostgresUnitOfWork(db *gorm.DB) UnitOfWork {
+	return &PostgresUnitOfWork{db: db}
+}
+
+func (u *PostgresUnitOfWork) Do(ctx context.Context, fn func(repos Repositories) error) error {
+	return u.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(Repositories{
+			Orders:       NewPostgresOrderRepository(tx),
+			Payments:     NewPostgresPaymentRepository(tx),
+			Users:        NewPostgresUserRepository(tx),
+			Carts:        NewPostgresCartRepository(tx),
+			Coupons:      NewPostgresCouponRepository(tx),
+			Installments: NewPostgresInstallmentRepository(tx),
+			Invoices:     NewPostgresInvoiceRepository(tx),
+			Codes:        NewPostgresEnrollmentCodeRepository(tx),
+		})
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"services/internal/models"
 
@@ -25,7 +26,24 @@ type UserRepository interface {
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id string) error
 	GetPurchasedCourses(ctx context.Context, userID string) ([]*models.Course, error)
+	// GetEnrolledCourseIDs lists every course the user is enrolled in,
+	// suspended ones included
+	GetEnrolledCourseIDs(ctx context.Context, userID string) ([]string, error)
 	AssignCourse(ctx context.Context, userID string, courseID string) error
+	RevokeCourse(ctx context.Context, userID string, courseID string) error
+	SuspendCourse(ctx context.Context, userID string, courseID string) error
+	RestoreCourse(ctx context.Context, userID string, courseID string) error
+	// MarkEmailVerified records that the user owns their email address. An
+	// address that was already verified keeps its first verification time.
+	MarkEmailVerified(ctx context.Context, userID string, at time.Time) error
+	// ClaimVerificationEmail records that a verification email is being sent
+	// to an unverified user. It returns false, and records nothing, when the
+	// last one went out less than interval ago.
+	ClaimVerificationEmail(ctx context.Context, userID string, now time.Time, interval time.Duration) (bool, error)
+	// ClaimPasswordResetEmail records that a password reset email is being
+	// sent to a user. It returns false, and records nothing, when the last
+	// one went out less than interval ago.
+	ClaimPasswordResetEmail(ctx context.Context, userID string, now time.Time, interval time.Duration) (bool, error)
 }
 
 // PostgresUserRepository implements UserRepository using PostgreSQL and GORM
@@ -124,14 +142,15 @@ func (r *PostgresUserRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
-// GetPurchasedCourses retrieves all courses purchased by a specific user
+// GetPurchasedCourses retrieves all courses purchased by a specific user,
+// leaving out courses whose access is suspended
 func (r *PostgresUserRepository) GetPurchasedCourses(ctx context.Context, userID string) ([]*models.Course, error) {
 	var courses []*models.Course
 	err := r.db.WithContext(ctx).
 		Table("courses").
 		Select("courses.*, user_courses.created_at as enrolled_at").
 		Joins("JOIN user_courses ON user_courses.course_id = courses.id").
-		Where("user_courses.user_id = ?", userID).
+		Where("user_courses.user_id = ? AND user_courses.suspended_at IS NULL", userID).
 		Find(&courses).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to get purchased courses: %w", err)
@@ -139,13 +158,96 @@ func (r *PostgresUserRepository) GetPurchasedCourses(ctx context.Context, userID
 	return courses, nil
 }
 
+// GetEnrolledCourseIDs retrieves the IDs of the courses a user is enrolled
+// in, whether or not their access is suspended
+func (r *PostgresUserRepository) GetEnrolledCourseIDs(ctx context.Context, userID string) ([]string, error) {
+	var courseIDs []string
+	err := r.db.WithContext(ctx).
+		Model(&models.UserCourses{}).
+		Where("user_id = ?", userID).
+		Pluck("course_id", &courseIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get enrolled courses: %w", err)
+	}
+	return courseIDs, nil
+}
+
 func (r *PostgresUserRepository) AssignCourse(ctx context.Context, userID string, courseID string) error {
 	userCourse := models.UserCourses{
 		UserID:   userID,
 		CourseID: courseID,
 	}
-	if err := r.db.WithContext(ctx).Create(&userCourse).Error; err != nil {
+	// FirstOrCreate keeps re-running fulfillment from enrolling the user twice
+	if err := r.db.WithContext(ctx).Where("user_id = ? AND course_id = ?", userID, courseID).FirstOrCreate(&userCourse).Error; err != nil {
 		return fmt.Errorf("failed to assign course to user: %w", err)
 	}
 	return nil
 }
+
+// RevokeCourse removes a user's enrollment in a course
+func (r *PostgresUserRepository) RevokeCourse(ctx context.Context, userID string, courseID string) error {
+	result := r.db.WithContext(ctx).Unscoped().
+		Where("user_id = ? AND course_id = ?", userID, courseID).
+		Delete(&models.UserCourses{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke course from user: %w", result.Error)
+	}
+	return nil
+}
+
+// SuspendCourse keeps a user's enrollment but hides the course from them
+// until RestoreCourse is called
+func (r *PostgresUserRepository) SuspendCourse(ctx context.Context, userID string, courseID string) error {
+	result := r.db.WithContext(ctx).Model(&models.UserCourses{}).
+		Where("user_id = ? AND course_id = ? AND suspended_at IS NULL", userID, courseID).
+		Update("suspended_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to suspend course for user: %w", result.Error)
+	}
+	return nil
+}
+
+// RestoreCourse lifts a suspension placed by SuspendCourse
+func (r *PostgresUserRepository) RestoreCourse(ctx context.Context, userID string, courseID string) error {
+	result := r.db.WithContext(ctx).Model(&models.UserCourses{}).
+		Where("user_id = ? AND course_id = ?", userID, courseID).
+		Update("suspended_at", nil)
+	if result.Error != nil {
+		return fmt.Errorf("failed to restore course for user: %w", result.Error)
+	}
+	return nil
+}
+
+// MarkEmailVerified sets the user's email_verified_at unless it is already set
+func (r *PostgresUserRepository) MarkEmailVerified(ctx context.Context, userID string, at time.Time) error {
+	if err := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL", userID).
+		Update("email_verified_at", at).Error; err != nil {
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	return nil
+}
+
+// ClaimVerificationEmail updates verification_sent_at in one statement so
+// concurrent resends cannot both get through
+func (r *PostgresUserRepository) ClaimVerificationEmail(ctx context.Context, userID string, now time.Time, interval time.Duration) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND email_verified_at IS NULL AND (verification_sent_at IS NULL OR verification_sent_at <= ?)", userID, now.Add(-interval)).
+		Update("verification_sent_at", now)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim verification email: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ClaimPasswordResetEmail updates password_reset_sent_at in one statement so
+// concurrent requests cannot both get through
+func (r *PostgresUserRepository) ClaimPasswordResetEmail(ctx context.Context, userID string, now time.Time, interval time.Duration) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND (password_reset_sent_at IS NULL OR password_reset_sent_at <= ?)", userID, now.Add(-interval)).
+		Update("password_reset_sent_at", now)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim password reset email: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"services/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WebhookEventRepository interface {
+	// Record stores the event and reports whether it was new. A false result
+	// means the event ID was already recorded and should not be processed again.
+	Record(ctx context.Context, event *models.WebhookEvent) (bool, error)
+	Delete(ctx context.Context, id string) error
+}
+
+type PostgresWebhookEventRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresWebhookEventRepository(db *gorm.DB) WebhookEventRepository {
+	return &PostgresWebhookEventRepository{db: db}
+}
+
+func (r *PostgresWebhookEventRepository) Record(ctx context.Context, event *models.WebhookEvent) (bool, error) {
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(event)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to record webhook event: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *PostgresWebhookEventRepository) Delete(ctx context.Context, id string) error {
+	if err := r.db.WithContext(ctx).Delete(&models.WebhookEvent{}, "id = ?", id).Error; err != nil {
+		return fmt.Errorf("failed to delete webhook event: %w", err)
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,6 +12,7 @@ import (
 	"net/url"
 	"os"
 	"services/internal/models"
+	"services/internal/money"
 	"services/internal/repository"
 	"strings"
 	"time"
@@ -84,6 +87,255 @@ func (s *NotificationService) sendEmail(recipient string, lead models.Lead) {
 	}
 }
 
+// ErrSMTPNotConfigured is returned when an email cannot be sent because the
+// SMTP_* variables are not set
+var ErrSMTPNotConfigured = errors.New("SMTP credentials not configured")
+
+// SendReceipt emails a receipt PDF to a buyer as an attachment
+func (s *NotificationService) SendReceipt(ctx context.Context, to, number string, pdf []byte) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	pass := os.Getenv("SMTP_PASS")
+	from := os.Getenv("SMTP_FROM")
+
+	if host == "" || user == "" || pass == "" {
+		return ErrSMTPNotConfigured
+	}
+
+	boundary := "receipt-" + number
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "To: %s\r\nFrom: %s\r\nSubject: Your receipt %s\r\n", to, from, number)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%q\r\n\r\n", boundary)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n", boundary)
+	fmt.Fprintf(&msg, "Thank you for your purchase. Your receipt %s is attached.\r\n\r\n", number)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: application/pdf; name=\"%s.pdf\"\r\n", boundary, number)
+	fmt.Fprintf(&msg, "Content-Disposition: attachment; filename=\"%s.pdf\"\r\nContent-Transfer-Encoding: base64\r\n\r\n", number)
+	encoded := base64.StdEncoding.EncodeToString(pdf)
+	for len(encoded) > 76 {
+		msg.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(&msg, "%s\r\n--%s--\r\n", encoded, boundary)
+
+	auth := smtp.PlainAuth("", user, pass, host)
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, []byte(msg.String())); err != nil {
+		return fmt.Errorf("failed to send receipt email: %w", err)
+	}
+	s.logger.InfoContext(ctx, "Receipt email sent", "to", to, "number", number)
+	return nil
+}
+
+// SendCartReminder emails a buyer who left courses in their cart, with a link
+// back to checkout
+func (s *NotificationService) SendCartReminder(ctx context.Context, to, name, link string, courses []string) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	pass := os.Getenv("SMTP_PASS")
+	from := os.Getenv("SMTP_FROM")
+
+	if host == "" || user == "" || pass == "" {
+		return ErrSMTPNotConfigured
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\r\n\r\nYou left these courses in your cart:\r\n\r\n", name)
+	for _, course := range courses {
+		fmt.Fprintf(&body, "- %s\r\n", course)
+	}
+	fmt.Fprintf(&body, "\r\nPick up where you left off: %s\r\n", link)
+
+	msg := []byte(fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: Your courses are waiting in your cart\r\n\r\n%s",
+		to, from, body.String()))
+
+	auth := smtp.PlainAuth("", user, pass, host)
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send cart reminder: %w", err)
+	}
+	s.logger.InfoContext(ctx, "Cart reminder sent", "to", to)
+	return nil
+}
+
+// GiftedCourse is a course in a gift email with the code that redeems it
+type GiftedCourse struct {
+	Course string
+	Code   string
+	Link   string
+}
+
+// SendGift emails enrollment codes to the person they were bought or issued
+// for. from is the buyer's name, or empty for complimentary codes.
+func (s *NotificationService) SendGift(ctx context.Context, to, name, from, message string, courses []GiftedCourse) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	pass := os.Getenv("SMTP_PASS")
+	sender := os.Getenv("SMTP_FROM")
+
+	if host == "" || user == "" || pass == "" {
+		return ErrSMTPNotConfigured
+	}
+
+	if name == "" {
+		name = "there"
+	}
+	giver := "A1 French Classes"
+	if from != "" {
+		giver = from
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\r\n\r\n%s gave you access to:\r\n\r\n", name, giver)
+	for _, course := range courses {
+		fmt.Fprintf(&body, "- %s\r\n  Code: %s\r\n  Redeem: %s\r\n", course.Course, course.Code, course.Link)
+	}
+	if message != "" {
+		fmt.Fprintf(&body, "\r\nTheir message:\r\n%s\r\n", message)
+	}
+	body.WriteString("\r\nSign in or create an account to redeem your code. Each code can be used once.\r\n")
+
+	msg := []byte(fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: %s sent you a course\r\n\r\n%s",
+		to, sender, giver, body.String()))
+
+	auth := smtp.PlainAuth("", user, pass, host)
+	if err := smtp.SendMail(host+":"+port, auth, sender, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send gift email: %w", err)
+	}
+	s.logger.InfoContext(ctx, "Gift email sent", "to", to, "courses", len(courses))
+	return nil
+}
+
+// SendInstallmentReminder tells a buyer an installment of their payment plan
+// is coming due, with a link to pay it
+func (s *NotificationService) SendInstallmentReminder(ctx context.Context, to, name string, amount money.Money, dueAt time.Time, link string) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	pass := os.Getenv("SMTP_PASS")
+	from := os.Getenv("SMTP_FROM")
+
+	if host == "" || user == "" || pass == "" {
+		return ErrSMTPNotConfigured
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\r\n\r\nThe next installment of your payment plan, %s, is due on %s.\r\n\r\n", name, amount, dueAt.UTC().Format("January 2, 2006"))
+	fmt.Fprintf(&body, "If you paid the first installment by card, that card is charged automatically on the due date. Otherwise, or to pay another way, pay it here: %s\r\n", link)
+	body.WriteString("\r\nIf it is still unpaid after the grace period, access to the course is paused until it is paid.\r\n")
+
+	msg := []byte(fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: Your next installment is coming due\r\n\r\n%s",
+		to, from, body.String()))
+
+	auth := smtp.PlainAuth("", user, pass, host)
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send installment reminder: %w", err)
+	}
+	s.logger.InfoContext(ctx, "Installment reminder sent", "to", to)
+	return nil
+}
+
+// SendNewDeviceAlert tells a user their account was signed in to from a
+// device it had not been used on before
+func (s *NotificationService) SendNewDeviceAlert(ctx context.Context, to, name, device, ip string, at time.Time) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	pass := os.Getenv("SMTP_PASS")
+	from := os.Getenv("SMTP_FROM")
+
+	if host == "" || user == "" || pass == "" {
+		return ErrSMTPNotConfigured
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\r\n\r\nYour account was just signed in to from a new device:\r\n\r\n", name)
+	fmt.Fprintf(&body, "- Device: %s\r\n- IP address: %s\r\n- Time: %s\r\n", device, ip, at.UTC().Format("2006-01-02 15:04 MST"))
+	body.WriteString("\r\nIf this was you, there is nothing to do. If not, change your password and sign out of your other sessions from your account page.\r\n")
+
+	msg := []byte(fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: New sign-in to your account\r\n\r\n%s",
+		to, from, body.String()))
+
+	auth := smtp.PlainAuth("", user, pass, host)
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send new device alert: %w", err)
+	}
+	s.logger.InfoContext(ctx, "New device alert sent", "to", to)
+	return nil
+}
+
+// SendPasswordReset emails a user the link that sets a new password. validFor
+// is how long the link works.
+func (s *NotificationService) SendPasswordReset(ctx context.Context, to, name, link string, validFor time.Duration) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	pass := os.Getenv("SMTP_PASS")
+	from := os.Getenv("SMTP_FROM")
+
+	if host == "" || user == "" || pass == "" {
+		return ErrSMTPNotConfigured
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\r\n\r\nWe received a request to reset your password. Choose a new one here:\r\n\r\n%s\r\n\r\n", name, link)
+	fmt.Fprintf(&body, "The link works once and expires in %s. Resetting your password signs you out on every device.\r\n", readableDuration(validFor))
+	body.WriteString("\r\nIf you did not ask for this, you can ignore this email. Your password has not been changed.\r\n")
+
+	msg := []byte(fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: Reset your password\r\n\r\n%s",
+		to, from, body.String()))
+
+	auth := smtp.PlainAuth("", user, pass, host)
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send password reset email: %w", err)
+	}
+	s.logger.InfoContext(ctx, "Password reset email sent", "to", to)
+	return nil
+}
+
+// SendEmailVerification emails a new user the link that confirms they own
+// their email address. validFor is how long the link works.
+func (s *NotificationService) SendEmailVerification(ctx context.Context, to, name, link string, validFor time.Duration) error {
+	host := os.Getenv("SMTP_HOST")
+	port := os.Getenv("SMTP_PORT")
+	user := os.Getenv("SMTP_USER")
+	pass := os.Getenv("SMTP_PASS")
+	from := os.Getenv("SMTP_FROM")
+
+	if host == "" || user == "" || pass == "" {
+		return ErrSMTPNotConfigured
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\r\n\r\nPlease confirm this is your email address:\r\n\r\n%s\r\n\r\n", name, link)
+	fmt.Fprintf(&body, "The link expires in %s.\r\n", readableDuration(validFor))
+	body.WriteString("\r\nIf you did not create an account, you can ignore this email.\r\n")
+
+	msg := []byte(fmt.Sprintf("To: %s\r\nFrom: %s\r\nSubject: Confirm your email address\r\n\r\n%s",
+		to, from, body.String()))
+
+	auth := smtp.PlainAuth("", user, pass, host)
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+	s.logger.InfoContext(ctx, "Verification email sent", "to", to)
+	return nil
+}
+
+// readableDuration writes a link lifetime in whole hours or minutes
+func readableDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if hours := int(d.Hours()); hours != 1 {
+			return fmt.Sprintf("%d hours", hours)
+		}
+		return "1 hour"
+	}
+	if minutes := int(d.Minutes()); minutes != 1 {
+		return fmt.Sprintf("%d minutes", minutes)
+	}
+	return "1 minute"
+}
+
 func (s *NotificationService) sendWhatsApp(number string, lead models.Lead) {
 	s.logger.Info("Sending WhatsApp notification", "to", number, "lead", lead.Name)
 
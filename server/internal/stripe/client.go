@@ -0,0 +1,248 @@
+package stripe
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"services/internal/money"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var baseURL = "https://api.stripe.com"
+
+// Client talks to the Stripe API with a secret key. Requests are form
+// encoded and amounts are integers in minor units, like money.Amount.
+type Client struct {
+	SecretKey     string
+	WebhookSecret string
+	BaseURL       string
+	HTTPClient    *http.Client
+}
+
+func NewClient() *Client {
+	return &Client{
+		SecretKey:     os.Getenv("STRIPE_SECRET_KEY"),
+		WebhookSecret: os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		BaseURL:       baseURL,
+		HTTPClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+// Configured reports whether a secret key is set. Without one Stripe is not
+// offered at checkout.
+func (c *Client) Configured() bool {
+	return c.SecretKey != ""
+}
+
+// SessionParams is what a Checkout Session is created for. The buyer pays
+// Amount as a single line named Description. SaveCard keeps the card on a
+// new Stripe customer so later payments can be charged without the buyer.
+type SessionParams struct {
+	OrderID     string
+	Description string
+	Amount      money.Money
+	SuccessURL  string
+	CancelURL   string
+	SaveCard    bool
+}
+
+// Session is a Stripe Checkout Session
+type Session struct {
+	ID                string            `json:"id"`
+	URL               string            `json:"url"`
+	Status            string            `json:"status"`         // open, complete, expired
+	PaymentStatus     string            `json:"payment_status"` // paid, unpaid, no_payment_required
+	ClientReferenceID string            `json:"client_reference_id"`
+	AmountTotal       int64             `json:"amount_total"`
+	Currency          string            `json:"currency"`
+	PaymentIntent     string            `json:"payment_intent"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+// Money is what the session charges
+func (s *Session) Money() money.Money {
+	return money.New(money.Amount(s.AmountTotal), strings.ToUpper(s.Currency))
+}
+
+// PaymentIntent is the payment behind a completed Checkout Session. Its ID is
+// what refunds are issued against.
+type PaymentIntent struct {
+	ID             string            `json:"id"`
+	Status         string            `json:"status"` // succeeded, processing, requires_payment_method, canceled, ...
+	Amount         int64             `json:"amount"`
+	AmountReceived int64             `json:"amount_received"`
+	Currency       string            `json:"currency"`
+	Metadata       map[string]string `json:"metadata"`
+	Customer       string            `json:"customer"`
+	PaymentMethod  string            `json:"payment_method"`
+	LatestCharge   *Charge           `json:"latest_charge"` // always expanded by GetPaymentIntent
+}
+
+// Money is what was received for the payment
+func (p *PaymentIntent) Money() money.Money {
+	return money.New(money.Amount(p.AmountReceived), strings.ToUpper(p.Currency))
+}
+
+// Charge is the card charge of a payment, which tracks its refunds
+type Charge struct {
+	ID             string `json:"id"`
+	Status         string `json:"status"`
+	Amount         int64  `json:"amount"`
+	AmountRefunded int64  `json:"amount_refunded"`
+	Refunded       bool   `json:"refunded"`
+}
+
+// Refund is a refund of all or part of a payment
+type Refund struct {
+	ID            string            `json:"id"`
+	Status        string            `json:"status"` // pending, requires_action, succeeded, failed, canceled
+	Amount        int64             `json:"amount"`
+	Currency      string            `json:"currency"`
+	PaymentIntent string            `json:"payment_intent"`
+	Metadata      map[string]string `json:"metadata"`
+}
+
+// Money is the refunded amount
+func (r *Refund) Money() money.Money {
+	return money.New(money.Amount(r.Amount), strings.ToUpper(r.Currency))
+}
+
+// CreateCheckoutSession creates a hosted checkout page for an order. The
+// order ID is set as client_reference_id and in the metadata of the session
+// and its payment, so webhooks and refunds can be traced back to it.
+func (c *Client) CreateCheckoutSession(ctx context.Context, params SessionParams) (*Session, error) {
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("success_url", params.SuccessURL)
+	form.Set("cancel_url", params.CancelURL)
+	form.Set("client_reference_id", params.OrderID)
+	form.Set("metadata[order_id]", params.OrderID)
+	form.Set("payment_intent_data[metadata][order_id]", params.OrderID)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", strings.ToLower(params.Amount.Currency))
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(int64(params.Amount.Amount), 10))
+	form.Set("line_items[0][price_data][product_data][name]", params.Description)
+	if params.SaveCard {
+		form.Set("customer_creation", "always")
+		form.Set("payment_intent_data[setup_future_usage]", "off_session")
+	}
+
+	var session Session
+	if err := c.do(ctx, "create checkout session", "POST", "/v1/checkout/sessions", form, "", &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetCheckoutSession fetches a Checkout Session
+func (c *Client) GetCheckoutSession(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, "get checkout session", "GET", "/v1/checkout/sessions/"+url.PathEscape(id), nil, "", &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ExpireCheckoutSession expires an open Checkout Session so it can no longer
+// be paid. Stripe refuses to expire a session that is already complete.
+func (c *Client) ExpireCheckoutSession(ctx context.Context, id string) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, "expire checkout session", "POST", "/v1/checkout/sessions/"+url.PathEscape(id)+"/expire", url.Values{}, "", &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetPaymentIntent fetches a payment with its latest charge, which carries
+// the refunds made against it
+func (c *Client) GetPaymentIntent(ctx context.Context, id string) (*PaymentIntent, error) {
+	query := url.Values{}
+	query.Set("expand[]", "latest_charge")
+	var intent PaymentIntent
+	if err := c.do(ctx, "get payment intent", "GET", "/v1/payment_intents/"+url.PathEscape(id)+"?"+query.Encode(), nil, "", &intent); err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// ChargeSavedCard charges a card saved by an earlier payment while the buyer
+// is not present. The order ID is set in the metadata like on checkout
+// payments. idempotencyKey makes a retried request charge only once.
+func (c *Client) ChargeSavedCard(ctx context.Context, customer, paymentMethod string, amount money.Money, orderID, idempotencyKey string) (*PaymentIntent, error) {
+	form := url.Values{}
+	form.Set("customer", customer)
+	form.Set("payment_method", paymentMethod)
+	form.Set("amount", strconv.FormatInt(int64(amount.Amount), 10))
+	form.Set("currency", strings.ToLower(amount.Currency))
+	form.Set("off_session", "true")
+	form.Set("confirm", "true")
+	form.Set("metadata[order_id]", orderID)
+
+	var intent PaymentIntent
+	if err := c.do(ctx, "charge saved card", "POST", "/v1/payment_intents", form, idempotencyKey, &intent); err != nil {
+		return nil, err
+	}
+	return &intent, nil
+}
+
+// CreateRefund refunds a payment. A zero amount refunds whatever remains of
+// it; otherwise the given amount is refunded. Stripe has no note to the
+// payer, so note is only kept in the metadata. idempotencyKey makes the call
+// idempotent on Stripe's side so a retried request never refunds twice.
+func (c *Client) CreateRefund(ctx context.Context, paymentIntentID string, amount money.Amount, orderID, note, idempotencyKey string) (*Refund, error) {
+	form := url.Values{}
+	form.Set("payment_intent", paymentIntentID)
+	form.Set("metadata[order_id]", orderID)
+	if note != "" {
+		form.Set("metadata[note]", note)
+	}
+	if amount > 0 {
+		form.Set("amount", strconv.FormatInt(int64(amount), 10))
+	}
+
+	var refund Refund
+	if err := c.do(ctx, "create refund", "POST", "/v1/refunds", form, idempotencyKey, &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+// do sends a request and decodes a 200 response into out
+func (c *Client) do(ctx context.Context, op, method, path string, form url.Values, idempotencyKey string, out any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(c.SecretKey, "")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(op, resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
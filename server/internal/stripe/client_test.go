@@ -0,0 +1,204 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"services/internal/money"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newFakeStripe serves the routes the test registers and returns a Client
+// pointed at it
+func newFakeStripe(t *testing.T, routes map[string]http.HandlerFunc) *Client {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, h := range routes {
+		mux.HandleFunc(path, h)
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return &Client{
+		SecretKey:     "sk_test_123",
+		WebhookSecret: "whsec_test",
+		BaseURL:       srv.URL,
+		HTTPClient:    srv.Client(),
+	}
+}
+
+func TestCreateCheckoutSession_SendsOrderAndAmount(t *testing.T) {
+	c := newFakeStripe(t, map[string]http.HandlerFunc{
+		"/v1/checkout/sessions": func(w http.ResponseWriter, r *http.Request) {
+			if user, _, _ := r.BasicAuth(); user != "sk_test_123" {
+				t.Errorf("expected the secret key as basic auth user, got %q", user)
+			}
+			if err := r.ParseForm(); err != nil {
+				t.Fatalf("ParseForm: %v", err)
+			}
+			want := [][2]string{
+				{"mode", "payment"},
+				{"client_reference_id", "order-1"},
+				{"metadata[order_id]", "order-1"},
+				{"payment_intent_data[metadata][order_id]", "order-1"},
+				{"line_items[0][price_data][currency]", "cad"},
+				{"line_items[0][price_data][unit_amount]", "5649"},
+				{"line_items[0][price_data][product_data][name]", "French A1"},
+				{"success_url", "https://example.com/success"},
+			}
+			for _, field := range want {
+				if got := r.PostForm.Get(field[0]); got != field[1] {
+					t.Errorf("%s = %q, want %q", field[0], got, field[1])
+				}
+			}
+			_, _ = w.Write([]byte(`{"id":"cs_test_1","url":"https://checkout.stripe.com/c/pay/cs_test_1","status":"open","payment_status":"unpaid"}`))
+		},
+	})
+
+	session, err := c.CreateCheckoutSession(context.Background(), SessionParams{
+		OrderID:     "order-1",
+		Description: "French A1",
+		Amount:      money.New(5649, "CAD"),
+		SuccessURL:  "https://example.com/success",
+		CancelURL:   "https://example.com/cancel",
+	})
+	if err != nil {
+		t.Fatalf("CreateCheckoutSession: %v", err)
+	}
+	if session.ID != "cs_test_1" || session.URL != "https://checkout.stripe.com/c/pay/cs_test_1" {
+		t.Errorf("unexpected session %+v", session)
+	}
+}
+
+func TestGetPaymentIntent_ExpandsLatestCharge(t *testing.T) {
+	c := newFakeStripe(t, map[string]http.HandlerFunc{
+		"/v1/payment_intents/pi_1": func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("expand[]"); got != "latest_charge" {
+				t.Errorf("expected latest_charge to be expanded, got %q", got)
+			}
+			_, _ = w.Write([]byte(`{"id":"pi_1","status":"succeeded","amount":5649,"amount_received":5649,"currency":"cad","latest_charge":{"id":"ch_1","amount":5649,"amount_refunded":1000,"refunded":false}}`))
+		},
+	})
+
+	intent, err := c.GetPaymentIntent(context.Background(), "pi_1")
+	if err != nil {
+		t.Fatalf("GetPaymentIntent: %v", err)
+	}
+	if intent.Money() != money.New(5649, "CAD") || intent.LatestCharge == nil || intent.LatestCharge.AmountRefunded != 1000 {
+		t.Errorf("unexpected payment intent %+v", intent)
+	}
+}
+
+func TestChargeSavedCard_ChargesOffSession(t *testing.T) {
+	c := newFakeStripe(t, map[string]http.HandlerFunc{
+		"/v1/payment_intents": func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Idempotency-Key") != "installment-1-0" {
+				t.Errorf("expected the idempotency key to be sent, got %q", r.Header.Get("Idempotency-Key"))
+			}
+			_ = r.ParseForm()
+			want := [][2]string{
+				{"customer", "cus_1"},
+				{"payment_method", "pm_1"},
+				{"amount", "3000"},
+				{"currency", "cad"},
+				{"off_session", "true"},
+				{"confirm", "true"},
+				{"metadata[order_id]", "order-1"},
+			}
+			for _, field := range want {
+				if got := r.PostForm.Get(field[0]); got != field[1] {
+					t.Errorf("%s = %q, want %q", field[0], got, field[1])
+				}
+			}
+			_, _ = w.Write([]byte(`{"id":"pi_2","status":"succeeded","amount":3000,"amount_received":3000,"currency":"cad","metadata":{"order_id":"order-1"}}`))
+		},
+	})
+
+	intent, err := c.ChargeSavedCard(context.Background(), "cus_1", "pm_1", money.New(3000, "CAD"), "order-1", "installment-1-0")
+	if err != nil {
+		t.Fatalf("ChargeSavedCard: %v", err)
+	}
+	if intent.ID != "pi_2" || intent.Money() != money.New(3000, "CAD") {
+		t.Errorf("unexpected payment intent %+v", intent)
+	}
+}
+
+func TestCreateRefund_SendsIdempotencyKey(t *testing.T) {
+	c := newFakeStripe(t, map[string]http.HandlerFunc{
+		"/v1/refunds": func(w http.ResponseWriter, r *http.Request) {
+			if got := r.Header.Get("Idempotency-Key"); got != "refund-order-1-0" {
+				t.Errorf("Idempotency-Key = %q", got)
+			}
+			_ = r.ParseForm()
+			if r.PostForm.Get("payment_intent") != "pi_1" || r.PostForm.Get("amount") != "1000" || r.PostForm.Get("metadata[note]") != "Duplicate purchase" {
+				t.Errorf("unexpected refund form %v", r.PostForm)
+			}
+			_, _ = w.Write([]byte(`{"id":"re_1","status":"succeeded","amount":1000,"currency":"cad","payment_intent":"pi_1"}`))
+		},
+	})
+
+	refund, err := c.CreateRefund(context.Background(), "pi_1", 1000, "order-1", "Duplicate purchase", "refund-order-1-0")
+	if err != nil {
+		t.Fatalf("CreateRefund: %v", err)
+	}
+	if refund.ID != "re_1" || refund.Money() != money.New(1000, "CAD") {
+		t.Errorf("unexpected refund %+v", refund)
+	}
+}
+
+func TestGetCheckoutSession_ParsesAPIError(t *testing.T) {
+	c := newFakeStripe(t, map[string]http.HandlerFunc{
+		"/v1/checkout/sessions/cs_missing": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Request-Id", "req_123")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"type":"invalid_request_error","code":"resource_missing","message":"No such checkout.session"}}`))
+		},
+	})
+
+	_, err := c.GetCheckoutSession(context.Background(), "cs_missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Type != ErrTypeInvalidRequest || apiErr.Code != ErrCodeResourceMissing || apiErr.RequestID != "req_123" {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	c := &Client{WebhookSecret: "whsec_test"}
+	body := []byte(`{"id":"evt_1","type":"checkout.session.completed"}`)
+	now := time.Unix(1767225600, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	valid := fmt.Sprintf("t=%s,v1=%s", timestamp, Sign("whsec_test", timestamp, body))
+
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+		now    time.Time
+		ok     bool
+	}{
+		{"valid", valid, body, now, true},
+		{"second signature matches", fmt.Sprintf("t=%s,v1=deadbeef,v1=%s", timestamp, Sign("whsec_test", timestamp, body)), body, now, true},
+		{"tampered body", valid, []byte(`{"id":"evt_2"}`), now, false},
+		{"wrong secret", fmt.Sprintf("t=%s,v1=%s", timestamp, Sign("whsec_other", timestamp, body)), body, now, false},
+		{"stale", valid, body, now.Add(SignatureTolerance + time.Second), false},
+		{"missing header", "", body, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.VerifyWebhookSignature(tt.header, tt.body, tt.now)
+			if tt.ok && err != nil {
+				t.Errorf("expected a valid signature, got %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidWebhookSignature) {
+				t.Errorf("expected ErrInvalidWebhookSignature, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package stripe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Error types Stripe reports
+const (
+	ErrTypeCard           = "card_error"
+	ErrTypeInvalidRequest = "invalid_request_error"
+	ErrTypeAPI            = "api_error"
+	ErrTypeIdempotency    = "idempotency_error"
+)
+
+// Error codes the server branches on
+const (
+	ErrCodeResourceMissing       = "resource_missing"
+	ErrCodeChargeAlreadyRefunded = "charge_already_refunded"
+)
+
+// APIError is a non-2xx response from the Stripe API, decoded from Stripe's
+// error body so callers can branch on the type and code instead of text
+type APIError struct {
+	Op          string
+	StatusCode  int
+	Type        string
+	Code        string
+	DeclineCode string
+	Message     string
+	RequestID   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("failed to %s, status: %d, type: %s, code: %s, decline_code: %s, message: %s, request_id: %s",
+		e.Op, e.StatusCode, e.Type, e.Code, e.DeclineCode, e.Message, e.RequestID)
+}
+
+// newAPIError builds an APIError from a failed response. Bodies that are not
+// Stripe's JSON error shape are kept verbatim in Message.
+func newAPIError(op string, resp *http.Response) *APIError {
+	apiErr := &APIError{
+		Op:         op,
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("Request-Id"),
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+
+	var parsed struct {
+		Error struct {
+			Type        string `json:"type"`
+			Code        string `json:"code"`
+			DeclineCode string `json:"decline_code"`
+			Message     string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil || parsed.Error.Type == "" {
+		apiErr.Message = string(body)
+		return apiErr
+	}
+
+	apiErr.Type = parsed.Error.Type
+	apiErr.Code = parsed.Error.Code
+	apiErr.DeclineCode = parsed.Error.DeclineCode
+	apiErr.Message = parsed.Error.Message
+	return apiErr
+}
@@ -0,0 +1,63 @@
+package tax
+
+import (
+	"errors"
+	"services/internal/models"
+	"services/internal/money"
+	"strings"
+)
+
+var ErrUnknownProvince = errors.New("unknown province")
+
+// provinces are the Canadian provinces and territories tax is charged for
+var provinces = map[string]bool{
+	"AB": true, "BC": true, "MB": true, "NB": true, "NL": true, "NS": true, "NT": true,
+	"NU": true, "ON": true, "PE": true, "QC": true, "SK": true, "YT": true,
+}
+
+// NormalizeProvince returns the two-letter code for a province, or
+// ErrUnknownProvince if it is not one
+func NormalizeProvince(province string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(province))
+	if !provinces[code] {
+		return "", ErrUnknownProvince
+	}
+	return code, nil
+}
+
+// Line is a taxable amount, keyed by the caller's line ID
+type Line struct {
+	ID     string
+	Amount money.Amount
+}
+
+// Component is the total charged for one tax across all lines
+type Component struct {
+	Name   string
+	Rate   float64
+	Amount money.Amount
+}
+
+// Result is the tax on a set of lines
+type Result struct {
+	Lines      map[string]money.Amount // Tax per line ID
+	Components []Component
+	Total      money.Amount
+}
+
+// Calculate applies every rate to every line. Each tax is rounded per line,
+// so line taxes always add up to the component and order totals.
+func Calculate(rates []models.TaxRate, lines []Line) Result {
+	result := Result{Lines: make(map[string]money.Amount, len(lines))}
+	for _, rate := range rates {
+		component := Component{Name: rate.Name, Rate: rate.Rate}
+		for _, line := range lines {
+			t := line.Amount.Percent(rate.Rate)
+			result.Lines[line.ID] += t
+			component.Amount += t
+		}
+		result.Components = append(result.Components, component)
+		result.Total += component.Amount
+	}
+	return result
+}
@@ -0,0 +1,44 @@
+package tax
+
+import (
+	"errors"
+	"services/internal/models"
+	"testing"
+)
+
+func TestCalculate_Quebec(t *testing.T) {
+	rates := []models.TaxRate{
+		{Province: "QC", Name: "GST", Rate: 5},
+		{Province: "QC", Name: "QST", Rate: 9.975},
+	}
+	lines := []Line{{ID: "item-1", Amount: 10000}, {ID: "item-2", Amount: 4999}}
+
+	got := Calculate(rates, lines)
+
+	// GST: 5.00 + 2.50, QST: 9.98 + 4.99
+	if got.Components[0].Amount != 750 || got.Components[1].Amount != 1497 {
+		t.Errorf("unexpected components %+v", got.Components)
+	}
+	if got.Lines["item-1"] != 1498 || got.Lines["item-2"] != 749 {
+		t.Errorf("unexpected line taxes %v", got.Lines)
+	}
+	if got.Total != 2247 || got.Lines["item-1"]+got.Lines["item-2"] != got.Total {
+		t.Errorf("expected line taxes to add up to a total of 22.47, got %s", got.Total)
+	}
+}
+
+func TestCalculate_NoRates(t *testing.T) {
+	got := Calculate(nil, []Line{{ID: "item-1", Amount: 10000}})
+	if got.Total != 0 || got.Lines["item-1"] != 0 {
+		t.Errorf("expected no tax, got %+v", got)
+	}
+}
+
+func TestNormalizeProvince(t *testing.T) {
+	if got, err := NormalizeProvince(" on "); err != nil || got != "ON" {
+		t.Errorf("NormalizeProvince(on) = %q, %v", got, err)
+	}
+	if _, err := NormalizeProvince("Ontario"); !errors.Is(err, ErrUnknownProvince) {
+		t.Errorf("expected ErrUnknownProvince, got %v", err)
+	}
+}